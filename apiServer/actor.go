@@ -0,0 +1,20 @@
+package apiServer
+
+import (
+	"github.com/labstack/echo"
+
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// ActorMiddleware puts the actor of the request in the context of the request, so the changes it makes can be recorded
+// The request id comes from the RequestID middleware, the actor stays anonymous until an authentication replaces it
+func ActorMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		actor := xeroHelper.Actor{
+			Name:      xeroHelper.AnonymousActor,
+			RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+		}
+		c.SetRequest(c.Request().WithContext(xeroHelper.WithActor(c.Request().Context(), actor)))
+		return next(c)
+	}
+}
@@ -1,10 +1,16 @@
 package apiServer
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/spf13/viper"
 	"go.elastic.co/apm/module/apmecho"
 
@@ -14,6 +20,9 @@ import (
 
 const (
 	environmentProd = "prod"
+
+	// Time given to the in-flight requests to complete when the server is stopped
+	defaultShutdownTimeout = 15 * time.Second
 )
 
 var Debug bool
@@ -23,6 +32,21 @@ type APIServer struct {
 	errorHandler  func(err error, c echo.Context)
 	Logger        debugcore.Logger
 	appConfig     *viper.Viper
+
+	// Metrics is the registry exposed on /metrics, on the API port or on the admin port when enabled
+	Metrics     *prometheus.Registry
+	AdminServer *http.Server
+
+	// authenticators of the routes using Authorize, none when the auth is disabled
+	authenticators []Authenticator
+	// policy of the roles, nil when there is no rbac config file
+	policy *Policy
+	// tenancy of the routes using ResolveTenant
+	tenancy TenancyConfig
+
+	healthLock      sync.RWMutex
+	readinessChecks []namedHealthCheck
+	draining        int32
 }
 
 func NewRestAPI(env string, appConfig *viper.Viper, logger debugcore.Logger) *APIServer {
@@ -37,45 +61,128 @@ func NewRestAPI(env string, appConfig *viper.Viper, logger debugcore.Logger) *AP
 	// Inject a Random requestID to track every request
 	echoFramework.Use(middleware.RequestID())
 
+	// Record who makes the changes of every request, along with its requestID
+	echoFramework.Use(ActorMiddleware)
+
+	// Prometheus metrics of the process and the requests, independent of the APM
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	echoFramework.Use(newHTTPMetrics(registry).Middleware)
+
 	// Use the APM (Application performance monitoring)
 	echoFramework.Use(apmecho.Middleware())
 
-	return &APIServer{
+	// Errors returned by the handlers are rendered by the xeroErrors aware handler
+	echoFramework.HTTPErrorHandler = HTTPErrorHandler
+
+	s := &APIServer{
 		EchoFramework: echoFramework,
 		errorHandler:  HTTPErrorHandler,
 		Logger:        logger,
 		appConfig:     appConfig,
+		Metrics:       registry,
+		AdminServer:   &http.Server{},
+	}
+
+	// Probes of the load balancer and the orchestrator
+	echoFramework.GET("/healthz", s.Healthz)
+	echoFramework.GET("/readyz", s.Readyz)
+
+	// The metrics are served on the admin port when enabled, so they are not exposed with the API
+	if s.AdminEnabled() {
+		adminMux := http.NewServeMux()
+		adminMux.Handle(metricsPath, s.MetricsHandler())
+		s.AdminServer.Handler = adminMux
+	} else {
+		echoFramework.GET(metricsPath, echo.WrapHandler(s.MetricsHandler()))
 	}
 
+	return s
+
 }
 
-func (s *APIServer) StartServer() {
+// StartServer serves until the server is shutdown, an error is returned only when the server fails
+func (s *APIServer) StartServer() error {
 
 	server := s.appConfig.GetString("app.service.host") + ":" + s.appConfig.GetString("app.service.port")
 
 	err := s.EchoFramework.Start(server)
-	if err != nil {
+	if err != nil && err != http.ErrServerClosed {
 		s.Logger.Error("Cannot start the Server", "error", err)
+		return err
 	}
 
+	return nil
 }
 
-func (s *APIServer) StartTLSServer() {
+// StartTLSServer serves until the server is shutdown, an error is returned only when the server fails
+func (s *APIServer) StartTLSServer() error {
 
 	tlsServer := s.appConfig.GetString("app.service.tls.host") + ":" + s.appConfig.GetString("app.service.tls.port")
 
 	err := s.EchoFramework.StartTLS(tlsServer, s.appConfig.GetString("app.service.tls.certificate"), s.appConfig.GetString("app.service.tls.key"))
-	if err != nil {
+	if err != nil && err != http.ErrServerClosed {
 		s.Logger.Error("Cannot start the TLS Server", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// DrainDelay is the time between the readiness flipping to failing and the listeners being stopped,
+// from app.service.drain_delay (eg: "5s"), it lets the probes notice the drain before the connections are refused
+func (s *APIServer) DrainDelay() time.Duration {
+	return s.appConfig.GetDuration("app.service.drain_delay")
+}
+
+// ShutdownTimeout is the drain timeout of the server, from app.service.shutdown_timeout (eg: "30s")
+func (s *APIServer) ShutdownTimeout() time.Duration {
+
+	timeout := s.appConfig.GetDuration("app.service.shutdown_timeout")
+	if timeout <= 0 {
+		return defaultShutdownTimeout
 	}
+
+	return timeout
+}
+
+// Shutdown stops all the listeners together and waits for the in-flight requests until ctx is done
+// When ctx is done before the requests complete, the remaining connections are closed
+func (s *APIServer) Shutdown(ctx context.Context) error {
+
+	s.Drain()
+
+	servers := []*http.Server{s.EchoFramework.Server, s.EchoFramework.TLSServer, s.AdminServer}
+
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			errs <- server.Shutdown(ctx)
+		}(server)
+	}
+
+	var err error
+	for range servers {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+
+	if err != nil {
+		s.Logger.Warn("In-flight requests not completed, closing the connections", "error", err)
+		s.EchoFramework.Close()
+		s.AdminServer.Close()
+	}
+
+	return err
 }
 
 // HTTPErrorHandler handles the error response and sends a valid response to the frontend
+// Every error is sent as an RFC 7807 application/problem+json, along with the request id
 // If the Debug is set to prod, then the traceback value is not sent to front-end
 func HTTPErrorHandler(err error, c echo.Context) {
 
 	var e xError.Error
-	code := http.StatusBadRequest
 
 	switch v := err.(type) {
 	case xError.Error:
@@ -88,15 +195,22 @@ func HTTPErrorHandler(err error, c echo.Context) {
 		}
 
 	case *echo.HTTPError:
-		e = xError.New(v.Code, v.Error(), xError.Failed)
-	case error:
-		e = xError.New(code, v.Error(), xError.Failed)
+		e = xError.New(v.Code, http.StatusText(v.Code), xError.Failed, v.Message)
+	default:
+		e = xError.XeroBadRequestError(nil, err)
+	}
 
+	// The error was already sent by the handler
+	if c.Response().Committed {
+		return
 	}
 
+	e.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+	problem := e.Problem(c.Request().URL.RequestURI())
 	if !Debug {
-		e.Traceback = nil //traceback
+		problem.Traceback = nil //traceback
 	}
 
-	c.JSON(e.Code, e)
+	body, _ := json.Marshal(problem)
+	c.Blob(problem.Status, xError.ProblemContentType, body)
 }
@@ -0,0 +1,361 @@
+package apiServer
+
+import (
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo"
+
+	xError "github.com/techievee/xero/xeroErrors"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+const (
+	// HeaderAPIKey carries the static API keys
+	HeaderAPIKey = "X-API-Key"
+
+	bearerScheme = "Bearer "
+
+	defaultTenantClaim = "tenant"
+)
+
+// ErrNoCredentials is returned by an authenticator when the request does not carry its credentials, the next authenticator is tried
+var ErrNoCredentials = errors.New("no credentials")
+
+// Authenticator authenticates the requests carrying its kind of credentials
+// It returns ErrNoCredentials when the request does not carry them, and any other error when they are not valid
+type Authenticator interface {
+	Authenticate(r *http.Request) (xeroHelper.Principal, error)
+}
+
+// APIKey is a static key of app.auth.api_keys, Name is the actor of the changes made with the key
+// A key with a Tenant only acts for that tenant, a key without one may act for any tenant
+type APIKey struct {
+	Name   string   `mapstructure:"name"`
+	Key    string   `mapstructure:"key"`
+	Scopes []string `mapstructure:"scopes"`
+	Roles  []string `mapstructure:"roles"`
+	Tenant string   `mapstructure:"tenant"`
+}
+
+// APIKeyAuthenticator authenticates the requests with the X-API-Key header
+type APIKeyAuthenticator struct {
+	keys []APIKey
+}
+
+// NewAPIKeyAuthenticator returns the authenticator of the keys, every key needs a name and a value
+func NewAPIKeyAuthenticator(keys []APIKey) (*APIKeyAuthenticator, error) {
+	for i, key := range keys {
+		if key.Name == "" || key.Key == "" {
+			return nil, fmt.Errorf("the API key %d needs a name and a key", i)
+		}
+		if key.Tenant != "" && !xeroHelper.ValidateTenantID(key.Tenant) {
+			return nil, fmt.Errorf("invalid tenant %q of the API key %s", key.Tenant, key.Name)
+		}
+	}
+	return &APIKeyAuthenticator{keys: keys}, nil
+}
+
+// Authenticate compares the key with every configured key in constant time
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (xeroHelper.Principal, error) {
+
+	value := r.Header.Get(HeaderAPIKey)
+	if value == "" {
+		return xeroHelper.Principal{}, ErrNoCredentials
+	}
+
+	var found *APIKey
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(value), []byte(a.keys[i].Key)) == 1 {
+			found = &a.keys[i]
+		}
+	}
+	if found == nil {
+		return xeroHelper.Principal{}, errors.New("unknown API key")
+	}
+	return xeroHelper.Principal{Subject: found.Name, Scopes: found.Scopes, Roles: found.Roles, Tenant: found.Tenant}, nil
+}
+
+// JWTConfig is the verification of the bearer tokens, from app.auth.jwt
+// The tokens are signed with HS256 and the secret, or with RS256 and one of the keys of the JWKS file
+// TenantClaim is the claim binding the token to a tenant, defaultTenantClaim when empty
+type JWTConfig struct {
+	Secret      string `mapstructure:"secret"`
+	JWKSFile    string `mapstructure:"jwks_file"`
+	Issuer      string `mapstructure:"issuer"`
+	Audience    string `mapstructure:"audience"`
+	TenantClaim string `mapstructure:"tenant_claim"`
+}
+
+// JWTAuthenticator authenticates the requests with a bearer token, the subject of the token is the actor
+// The scopes are the space separated scope claim, or the scp claim, the roles are the roles claim
+// A token with the tenant claim only acts for that tenant
+type JWTAuthenticator struct {
+	config JWTConfig
+	keys   map[string]*rsa.PublicKey
+	parser *jwt.Parser
+}
+
+// NewJWTAuthenticator returns the authenticator of the tokens, it reads the RSA keys of the JWKS file when there is one
+func NewJWTAuthenticator(config JWTConfig) (*JWTAuthenticator, error) {
+
+	if config.TenantClaim == "" {
+		config.TenantClaim = defaultTenantClaim
+	}
+	a := &JWTAuthenticator{config: config, parser: &jwt.Parser{}}
+	if config.Secret != "" {
+		a.parser.ValidMethods = append(a.parser.ValidMethods, jwt.SigningMethodHS256.Alg())
+	}
+	if config.JWKSFile != "" {
+		keys, err := readJWKS(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = keys
+		a.parser.ValidMethods = append(a.parser.ValidMethods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(a.parser.ValidMethods) == 0 {
+		return nil, errors.New("the JWT needs a secret or a JWKS file")
+	}
+	return a, nil
+}
+
+// Authenticate verifies the signature, the expiry and the issuer and the audience when they are configured
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (xeroHelper.Principal, error) {
+
+	header := r.Header.Get(echo.HeaderAuthorization)
+	if !strings.HasPrefix(header, bearerScheme) {
+		return xeroHelper.Principal{}, ErrNoCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(strings.TrimPrefix(header, bearerScheme), claims, a.key); err != nil {
+		return xeroHelper.Principal{}, err
+	}
+
+	// The claims are only checked when present, a token has to expire
+	if _, ok := claims["exp"]; !ok {
+		return xeroHelper.Principal{}, errors.New("token without expiry")
+	}
+	if a.config.Issuer != "" && !claims.VerifyIssuer(a.config.Issuer, true) {
+		return xeroHelper.Principal{}, errors.New("token of another issuer")
+	}
+	if a.config.Audience != "" && !claims.VerifyAudience(a.config.Audience, true) {
+		return xeroHelper.Principal{}, errors.New("token of another audience")
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return xeroHelper.Principal{}, errors.New("token without subject")
+	}
+
+	tenant, _ := claims[a.config.TenantClaim].(string)
+	if tenant != "" && !xeroHelper.ValidateTenantID(tenant) {
+		return xeroHelper.Principal{}, errors.New("token of an invalid tenant")
+	}
+
+	return xeroHelper.Principal{Subject: subject, Scopes: tokenScopes(claims), Roles: claimValues(claims["roles"]), Tenant: tenant}, nil
+}
+
+// key returns the key of the signing method of the token, the RSA key is looked up by the kid of the token
+// A JWKS of a single key also verifies the tokens without kid
+func (a *JWTAuthenticator) key(token *jwt.Token) (interface{}, error) {
+
+	if token.Method == jwt.SigningMethodHS256 {
+		return []byte(a.config.Secret), nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func tokenScopes(claims jwt.MapClaims) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	return claimValues(claims["scp"])
+}
+
+// claimValues returns the values of a claim holding a space separated string or an array of strings
+func claimValues(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// jwk is a key of a JWKS file, only the RSA signing keys are used
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// readJWKS returns the RSA keys of the JWKS file by kid
+func readJWKS(path string) (map[string]*rsa.PublicKey, error) {
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS file %s: %v", path, err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(key.N, "="))
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of the key %q: %v", key.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(key.E, "="))
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent of the key %q: %v", key.Kid, err)
+		}
+		keys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no RSA signing key in the JWKS file %s", path)
+	}
+	return keys, nil
+}
+
+// LoadAuth sets up the authenticators of app.auth and the policy of the rbac config file when there is one
+// The routes stay anonymous when app.auth.enabled is false
+// The service should not start when the config is not valid, the routes would refuse every request
+func (s *APIServer) LoadAuth() error {
+
+	if !s.appConfig.GetBool("app.auth.enabled") {
+		return nil
+	}
+
+	var keys []APIKey
+	if err := s.appConfig.UnmarshalKey("app.auth.api_keys", &keys); err != nil {
+		return err
+	}
+	if len(keys) != 0 {
+		authenticator, err := NewAPIKeyAuthenticator(keys)
+		if err != nil {
+			return err
+		}
+		s.AddAuthenticator(authenticator)
+	}
+
+	var config JWTConfig
+	if err := s.appConfig.UnmarshalKey("app.auth.jwt", &config); err != nil {
+		return err
+	}
+	if config.Secret != "" || config.JWKSFile != "" {
+		authenticator, err := NewJWTAuthenticator(config)
+		if err != nil {
+			return err
+		}
+		s.AddAuthenticator(authenticator)
+	}
+
+	if len(s.authenticators) == 0 {
+		return errors.New("the auth is enabled without API keys nor JWT")
+	}
+
+	policy, err := s.loadPolicy()
+	if err != nil {
+		return fmt.Errorf("invalid rbac policy: %v", err)
+	}
+	s.policy = policy
+	return nil
+}
+
+// AddAuthenticator enables the authentication of the routes, the authenticators are tried in their order
+// It is called before the server starts
+func (s *APIServer) AddAuthenticator(authenticator Authenticator) {
+	s.authenticators = append(s.authenticators, authenticator)
+}
+
+// Authorize authenticates the requests of the routes and checks their scope, readScope for GET and HEAD and writeScope for the changes
+// With a policy, one of the roles of the principal must allow the route, and the principal carries the fields its roles may change
+// The subject of the credentials becomes the actor of the changes
+// Without authenticator the routes are anonymous
+func (s *APIServer) Authorize(readScope string, writeScope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+
+			if len(s.authenticators) == 0 {
+				return next(c)
+			}
+
+			principal, err := s.authenticate(c.Request())
+			if err != nil {
+				s.Logger.Info("Request not authenticated", "error", err, "path", c.Request().URL.Path)
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="xero"`)
+				return xError.XeroUnauthorizedError()
+			}
+
+			scope := writeScope
+			if method := c.Request().Method; method == http.MethodGet || method == http.MethodHead {
+				scope = readScope
+			}
+			if !principal.HasScope(scope) {
+				s.Logger.Info("Request denied", "subject", principal.Subject, "scope", scope, "path", c.Request().URL.Path)
+				return xError.XeroForbiddenError()
+			}
+
+			if s.policy != nil {
+				if !s.policy.AllowRoute(principal.Roles, c.Request().Method, c.Path()) {
+					s.Logger.Info("Request denied", "subject", principal.Subject, "roles", principal.Roles, "route", c.Request().Method+" "+c.Path())
+					return xError.XeroForbiddenError()
+				}
+				principal.Fields = s.policy.Fields(principal.Roles)
+			}
+
+			ctx := xeroHelper.WithPrincipal(c.Request().Context(), principal)
+			ctx = xeroHelper.WithActor(ctx, xeroHelper.Actor{Name: principal.Subject, RequestID: xeroHelper.ActorFrom(ctx).RequestID})
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// authenticate returns the principal of the first authenticator finding its credentials in the request
+func (s *APIServer) authenticate(r *http.Request) (xeroHelper.Principal, error) {
+	for _, authenticator := range s.authenticators {
+		principal, err := authenticator.Authenticate(r)
+		if err == ErrNoCredentials {
+			continue
+		}
+		return principal, err
+	}
+	return xeroHelper.Principal{}, ErrNoCredentials
+}
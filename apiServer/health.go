@@ -0,0 +1,118 @@
+package apiServer
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	healthStatusOK       = "ok"
+	healthStatusFailing  = "failing"
+	healthStatusDraining = "draining"
+
+	// Time given to every readiness check, a dependency slower than this is failing
+	defaultReadinessTimeout = 2 * time.Second
+)
+
+// HealthCheck checks one dependency of the service, it returns an error when the dependency is not usable
+type HealthCheck func(ctx context.Context) error
+
+type namedHealthCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// HealthStatus is the response of the health endpoints
+type HealthStatus struct {
+	Status string             `json:"Status"`
+	Checks []DependencyStatus `json:"Checks"`
+}
+
+// DependencyStatus is the result of the check of one dependency
+type DependencyStatus struct {
+	Name      string  `json:"Name"`
+	Status    string  `json:"Status"`
+	LatencyMs float64 `json:"LatencyMs"`
+	Error     string  `json:"Error,omitempty"`
+}
+
+// AddReadinessCheck registers the check of a dependency, /readyz fails as long as one of the checks fails
+func (s *APIServer) AddReadinessCheck(name string, check HealthCheck) {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+	s.readinessChecks = append(s.readinessChecks, namedHealthCheck{name: name, check: check})
+}
+
+// Drain flips the readiness to failing, so the load balancers stop sending new requests
+// It is called by Shutdown, and can be called ahead of it to give the probes the time to notice
+func (s *APIServer) Drain() {
+	atomic.StoreInt32(&s.draining, 1)
+}
+
+// Draining tells whether the server is being stopped
+func (s *APIServer) Draining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// Healthz tells that the process is alive, it never checks the dependencies
+func (s *APIServer) Healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, HealthStatus{Status: healthStatusOK, Checks: []DependencyStatus{}})
+}
+
+// Readyz runs all the readiness checks concurrently and reports the status and latency of every dependency
+// Returns 503 when a check fails or when the server is draining
+func (s *APIServer) Readyz(c echo.Context) error {
+
+	s.healthLock.RLock()
+	checks := append([]namedHealthCheck{}, s.readinessChecks...)
+	s.healthLock.RUnlock()
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), defaultReadinessTimeout)
+	defer cancel()
+
+	result := HealthStatus{Status: healthStatusOK, Checks: make([]DependencyStatus, len(checks))}
+
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := checks[i].check(ctx)
+
+			status := DependencyStatus{
+				Name:      checks[i].name,
+				Status:    healthStatusOK,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				status.Status = healthStatusFailing
+				status.Error = err.Error()
+			}
+			result.Checks[i] = status
+		}(i)
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	for _, check := range result.Checks {
+		if check.Status != healthStatusOK {
+			result.Status = healthStatusFailing
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	// The dependencies are still reported while draining, but the server must not receive new requests
+	if s.Draining() {
+		result.Status = healthStatusDraining
+		code = http.StatusServiceUnavailable
+	}
+
+	return c.JSON(code, result)
+}
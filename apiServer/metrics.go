@@ -0,0 +1,99 @@
+package apiServer
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	metricsNamespace = "xero"
+	metricsPath      = "/metrics"
+
+	// Route label of the requests matching no route, so the unknown paths do not create new series
+	unmatchedRoute = "unmatched"
+)
+
+// httpMetrics are the metrics of the requests served by the echo framework
+type httpMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func newHTTPMetrics(registry prometheus.Registerer) *httpMetrics {
+
+	m := &httpMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by route and status.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of the HTTP requests by route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+	}
+	registry.MustRegister(m.requests, m.duration)
+
+	return m
+}
+
+// Middleware records the count and the latency of every request, labelled by the route template
+func (m *httpMetrics) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+
+		start := time.Now()
+
+		// The error is rendered here, so the recorded status is the one sent to the client
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+
+		route := c.Path()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := strconv.Itoa(c.Response().Status)
+
+		m.requests.WithLabelValues(c.Request().Method, route, status).Inc()
+		m.duration.WithLabelValues(c.Request().Method, route, status).Observe(time.Since(start).Seconds())
+
+		return nil
+	}
+}
+
+// RegisterMetrics adds the collectors to the registry exposed on /metrics
+func (s *APIServer) RegisterMetrics(collectors ...prometheus.Collector) {
+	s.Metrics.MustRegister(collectors...)
+}
+
+// MetricsHandler serves the metrics of the registry in the Prometheus text format
+func (s *APIServer) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(s.Metrics, promhttp.HandlerOpts{})
+}
+
+// AdminEnabled tells whether the metrics are served on the admin port instead of the API port
+func (s *APIServer) AdminEnabled() bool {
+	return s.appConfig.GetBool("app.admin.enabled")
+}
+
+// StartAdminServer serves /metrics on the admin port until the server is shutdown
+// An error is returned only when the server fails
+func (s *APIServer) StartAdminServer() error {
+
+	s.AdminServer.Addr = s.appConfig.GetString("app.admin.host") + ":" + s.appConfig.GetString("app.admin.port")
+
+	err := s.AdminServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		s.Logger.Error("Cannot start the Admin Server", "error", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package apiServer
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Policy decides the routes the roles may call and the fields they may change, from the rbac config file
+// A principal with several roles may do what any of its roles may do, a principal without a known role may do nothing
+type Policy struct {
+	Roles map[string]Role `mapstructure:"roles"`
+}
+
+// Role lists its routes as "METHOD /path", the path is the template of the route and may end with * to match its prefix
+// "*" is any method or any route, Fields lists the fields of every type of resource the role may change
+type Role struct {
+	Routes []string            `mapstructure:"routes"`
+	Fields map[string][]string `mapstructure:"fields"`
+}
+
+var policyMethods = map[string]bool{
+	"*": true, http.MethodGet: true, http.MethodHead: true, http.MethodPost: true,
+	http.MethodPut: true, http.MethodPatch: true, http.MethodDelete: true,
+}
+
+// Validate checks the routes of the roles, so a typo does not silently deny a route
+func (p *Policy) Validate() error {
+
+	if len(p.Roles) == 0 {
+		return fmt.Errorf("the policy has no role")
+	}
+	for name, role := range p.Roles {
+		for _, route := range role.Routes {
+			if route == "*" {
+				continue
+			}
+			parts := strings.Fields(route)
+			if len(parts) != 2 || !policyMethods[strings.ToUpper(parts[0])] || !strings.HasPrefix(parts[1], "/") {
+				return fmt.Errorf("invalid route %q of the role %s, expected \"METHOD /path\"", route, name)
+			}
+		}
+	}
+	return nil
+}
+
+// AllowRoute tells if one of the roles may call the route, path is the template of the route
+func (p *Policy) AllowRoute(roles []string, method string, path string) bool {
+	for _, name := range roles {
+		for _, route := range p.Roles[strings.ToLower(name)].Routes {
+			if matchRoute(route, method, path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Fields returns the fields the roles may change by type of resource, never nil so the fields of the principal are restricted
+func (p *Policy) Fields(roles []string) map[string][]string {
+	fields := map[string][]string{}
+	for _, name := range roles {
+		for resourceType, f := range p.Roles[strings.ToLower(name)].Fields {
+			fields[resourceType] = append(fields[resourceType], f...)
+		}
+	}
+	return fields
+}
+
+func matchRoute(route string, method string, path string) bool {
+
+	if route == "*" {
+		return true
+	}
+	parts := strings.Fields(route)
+	if len(parts) != 2 || (parts[0] != "*" && !strings.EqualFold(parts[0], method)) {
+		return false
+	}
+	if strings.HasSuffix(parts[1], "*") {
+		return strings.HasPrefix(path, strings.TrimSuffix(parts[1], "*"))
+	}
+	return parts[1] == path
+}
+
+// loadPolicy reads the rbac config file, nil when there is none
+func (s *APIServer) loadPolicy() (*Policy, error) {
+
+	if !s.appConfig.IsSet("rbac") {
+		return nil, nil
+	}
+	policy := &Policy{}
+	if err := s.appConfig.UnmarshalKey("rbac", policy); err != nil {
+		return nil, err
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
@@ -0,0 +1,129 @@
+package apiServer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/labstack/echo"
+
+	xError "github.com/techievee/xero/xeroErrors"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// HeaderTenant names the tenant of the request unless app.tenancy.header names another header
+const HeaderTenant = "X-Tenant-ID"
+
+// TenancyConfig is the resolution of the tenant of the requests, from app.tenancy
+// The tenant is the header, or the subdomain of Domain when the request has no header
+// Without tenancy every request acts for the default tenant, unless its principal is bound to another tenant
+// With tenancy the principals not bound to a tenant need the scope of the platform to choose one
+type TenancyConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Header  string `mapstructure:"header"`
+	Domain  string `mapstructure:"domain"`
+}
+
+// LoadTenancy reads the config of app.tenancy, it is called after LoadAuth before the server starts
+// The tenancy needs the auth, an anonymous request could otherwise choose the tenant it reads
+func (s *APIServer) LoadTenancy() error {
+
+	var config TenancyConfig
+	if err := s.appConfig.UnmarshalKey("app.tenancy", &config); err != nil {
+		return err
+	}
+	if config.Header == "" {
+		config.Header = HeaderTenant
+	}
+	config.Domain = strings.ToLower(strings.Trim(config.Domain, "."))
+	if config.Enabled && len(s.authenticators) == 0 {
+		return errors.New("the tenancy is enabled without the auth")
+	}
+	s.tenancy = config
+	return nil
+}
+
+// ResolveTenant sets the tenant of the requests of the routes, it runs after Authorize so the principal is known
+// A principal bound to a tenant acts for its tenant and is denied any other tenant, whatever the header or the subdomain
+// With the tenancy, only the principals granted platformScope may choose the tenant, the other principals are denied
+// known tells if the tenant exists, the requests of an unknown tenant are refused before they reach the catalogue
+func (s *APIServer) ResolveTenant(platformScope string, known func(ctx context.Context, id string) (bool, error)) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+
+			requested := ""
+			if s.tenancy.Enabled {
+				requested = strings.ToLower(strings.TrimSpace(c.Request().Header.Get(s.tenancy.Header)))
+				if requested == "" {
+					requested = s.subdomain(c.Request().Host)
+				}
+			}
+
+			tenant := requested
+			principal, ok := xeroHelper.PrincipalFrom(c.Request().Context())
+			switch {
+			case ok && principal.Tenant != "":
+				if requested != "" && requested != principal.Tenant {
+					s.Logger.Info("Request denied", "subject", principal.Subject, "tenant", principal.Tenant, "requested_tenant", requested)
+					return xError.XeroForbiddenError()
+				}
+				tenant = principal.Tenant
+			case s.tenancy.Enabled && (!ok || !principal.HasScope(platformScope)):
+				s.Logger.Info("Request denied", "subject", principal.Subject, "scope", platformScope, "requested_tenant", requested)
+				return xError.XeroForbiddenError()
+			}
+
+			switch {
+			case tenant == "" && s.tenancy.Enabled:
+				return xError.XeroBadRequestError("missing_tenant", "The tenant is required, in the "+s.tenancy.Header+" header or the subdomain")
+			case tenant == "":
+				tenant = xeroHelper.DefaultTenant
+			case !xeroHelper.ValidateTenantID(tenant):
+				return xError.XeroInvalidIDError("tenant")
+			}
+
+			exists, err := known(c.Request().Context(), tenant)
+			if err != nil {
+				s.Logger.Error("Error while looking up the tenant", "error", err)
+				return xError.NewUnexpectedGenericError(err)
+			}
+			if !exists {
+				return xError.XeroUnknownIDError("tenant")
+			}
+
+			c.SetRequest(c.Request().WithContext(xeroHelper.WithTenant(c.Request().Context(), tenant)))
+			return next(c)
+		}
+	}
+}
+
+// PlatformOnly refuses the anonymous requests and the principals bound to a tenant, for the routes managing every tenant
+// It runs after Authorize, without the auth these routes are never served
+func (s *APIServer) PlatformOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		principal, ok := xeroHelper.PrincipalFrom(c.Request().Context())
+		if !ok || principal.Tenant != "" {
+			s.Logger.Info("Request denied", "subject", principal.Subject, "tenant", principal.Tenant, "path", c.Request().URL.Path)
+			return xError.XeroForbiddenError()
+		}
+		return next(c)
+	}
+}
+
+// subdomain returns the label of the host right under the domain, empty when the host is not a subdomain of the domain
+func (s *APIServer) subdomain(host string) string {
+
+	if s.tenancy.Domain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	label := strings.TrimSuffix(host, "."+s.tenancy.Domain)
+	if label == host || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
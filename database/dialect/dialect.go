@@ -0,0 +1,188 @@
+package dialect
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Names of the supported dialects, they match the name of the database/sql drivers
+const (
+	SQLiteName   = "sqlite3"
+	PostgresName = "postgres"
+	MySQLName    = "mysql"
+)
+
+// Dialect translates the statements of the application in to the SQL of a database
+//
+// Statements are written once, in the SQLite syntax used all over the application:
+//   - `?` placeholders
+//   - `Column=? COLLATE NOCASE` for the case insensitive equality
+//   - `Column like ? COLLATE NOCASE` for the case insensitive pattern match
+//
+// Rewrite translates these constructs, everything else must be standard SQL
+// The case insensitive equality cannot use the index of the column on Postgres, the ids stored in lower case are compared with a plain `Id=?`
+type Dialect interface {
+	// Name of the dialect, same as the driver name
+	Name() string
+	// Rewrite translates a statement written in the application syntax in to the dialect
+	Rewrite(query string) string
+	// Upsert returns the statement inserting a row, or updating its non key columns when the keys already exists
+	Upsert(table string, columns []string, keys []string) string
+	// InsertIfAbsent returns the statement inserting a row, it inserts nothing and affects no row when the keys already exist
+	InsertIfAbsent(table string, columns []string, keys []string) string
+}
+
+var (
+	equalFoldExpr = regexp.MustCompile(`(?i)([\w.]+)\s*=\s*\?\s+COLLATE\s+NOCASE`)
+	likeFoldExpr  = regexp.MustCompile(`(?i)([\w.]+)\s+like\s+\?\s+COLLATE\s+NOCASE`)
+)
+
+var (
+	// SQLite is the reference syntax, statements are used as they are
+	SQLite Dialect = &sqlDialect{
+		name:       SQLiteName,
+		upsertTmpl: "INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		excluded:   "%[1]s=excluded.%[1]s",
+		absentTmpl: "INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO NOTHING",
+		kept:       "%[1]s",
+	}
+
+	// Postgres has numbered placeholders and case sensitive comparisons
+	Postgres Dialect = &sqlDialect{
+		name:       PostgresName,
+		equalFold:  "LOWER($1) = LOWER(?)",
+		likeFold:   "$1 ILIKE ?",
+		numbered:   true,
+		upsertTmpl: "INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		excluded:   "%[1]s=EXCLUDED.%[1]s",
+		absentTmpl: "INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO NOTHING",
+		kept:       "%[1]s",
+	}
+
+	// MySQL compares case insensitively with the default collations
+	// Setting the keys to their value changes nothing, MySQL then reports no affected row
+	MySQL Dialect = &sqlDialect{
+		name:       MySQLName,
+		equalFold:  "$1 = ?",
+		likeFold:   "$1 LIKE ?",
+		upsertTmpl: "INSERT INTO %s (%s) VALUES (%s) ON DUPLICATE KEY UPDATE %[5]s",
+		excluded:   "%[1]s=VALUES(%[1]s)",
+		absentTmpl: "INSERT INTO %s (%s) VALUES (%s) ON DUPLICATE KEY UPDATE %s",
+		kept:       "%[1]s=%[1]s",
+	}
+)
+
+// For returns the dialect of the database/sql driver
+func For(driver string) (Dialect, error) {
+	switch driver {
+	case SQLiteName:
+		return SQLite, nil
+	case PostgresName:
+		return Postgres, nil
+	case MySQLName:
+		return MySQL, nil
+	}
+	return nil, fmt.Errorf("dialect: unsupported driver %q", driver)
+}
+
+type sqlDialect struct {
+	name       string
+	equalFold  string
+	likeFold   string
+	numbered   bool
+	upsertTmpl string
+	excluded   string
+	absentTmpl string
+	kept       string
+
+	// Statements are constants, so their translation is computed once
+	cache sync.Map
+}
+
+func (d *sqlDialect) Name() string {
+	return d.name
+}
+
+func (d *sqlDialect) Rewrite(query string) string {
+
+	if d.name == SQLiteName {
+		return query
+	}
+	if rewritten, ok := d.cache.Load(query); ok {
+		return rewritten.(string)
+	}
+
+	rewritten := likeFoldExpr.ReplaceAllString(query, d.likeFold)
+	rewritten = equalFoldExpr.ReplaceAllString(rewritten, d.equalFold)
+	if d.numbered {
+		rewritten = numberPlaceholders(rewritten)
+	}
+
+	d.cache.Store(query, rewritten)
+	return rewritten
+}
+
+func (d *sqlDialect) Upsert(table string, columns []string, keys []string) string {
+
+	isKey := map[string]bool{}
+	for _, k := range keys {
+		isKey[k] = true
+	}
+
+	var placeholders, updates []string
+	for _, col := range columns {
+		placeholders = append(placeholders, "?")
+		if !isKey[col] {
+			updates = append(updates, fmt.Sprintf(d.excluded, col))
+		}
+	}
+
+	stmt := fmt.Sprintf(d.upsertTmpl,
+		table, strings.Join(columns, ", "), strings.Join(placeholders, ","), strings.Join(keys, ", "),
+		strings.Join(updates, ", "))
+
+	return d.Rewrite(stmt)
+}
+
+func (d *sqlDialect) InsertIfAbsent(table string, columns []string, keys []string) string {
+
+	placeholders := make([]string, len(columns))
+	for i := range columns {
+		placeholders[i] = "?"
+	}
+	kept := make([]string, len(keys))
+	for i, k := range keys {
+		kept[i] = fmt.Sprintf(d.kept, k)
+	}
+
+	stmt := fmt.Sprintf(d.absentTmpl, table, strings.Join(columns, ", "), strings.Join(placeholders, ","), strings.Join(kept, ", "))
+	return d.Rewrite(stmt)
+}
+
+// Replaces the `?` placeholders by `$1`, `$2`... skipping the quoted strings and identifiers
+func numberPlaceholders(query string) string {
+
+	var b strings.Builder
+	var quote rune
+	n := 0
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package dialect
+
+import (
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+
+	tests := []struct {
+		dialect Dialect
+		query   string
+		want    string
+	}{
+		{SQLite, "SELECT Id FROM Products WHERE Id=? COLLATE NOCASE", "SELECT Id FROM Products WHERE Id=? COLLATE NOCASE"},
+		{Postgres, "SELECT Id FROM Products WHERE Id=? COLLATE NOCASE", "SELECT Id FROM Products WHERE LOWER(Id) = LOWER($1)"},
+		{MySQL, "SELECT Id FROM Products WHERE Id=? COLLATE NOCASE", "SELECT Id FROM Products WHERE Id = ?"},
+		{Postgres, "SELECT Id FROM Products WHERE Name like ? COLLATE NOCASE AND Price >= ? LIMIT ? OFFSET ?",
+			"SELECT Id FROM Products WHERE Name ILIKE $1 AND Price >= $2 LIMIT $3 OFFSET $4"},
+		{MySQL, "SELECT Id FROM Products WHERE Name like ? COLLATE NOCASE", "SELECT Id FROM Products WHERE Name LIKE ?"},
+		{Postgres, "UPDATE ProductOptions SET Name=?, Description=? WHERE Id=? COLLATE NOCASE and ProductId=? COLLATE NOCASE",
+			"UPDATE ProductOptions SET Name=$1, Description=$2 WHERE LOWER(Id) = LOWER($3) and LOWER(ProductId) = LOWER($4)"},
+		// Quoted question marks are not placeholders
+		{Postgres, `SELECT '?' AS "a?" FROM Products WHERE Id=?`, `SELECT '?' AS "a?" FROM Products WHERE Id=$1`},
+	}
+
+	for _, tt := range tests {
+		if got := tt.dialect.Rewrite(tt.query); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.dialect.Name(), tt.want, got)
+		}
+	}
+}
+
+func TestUpsert(t *testing.T) {
+
+	columns := []string{"Id", "Name", "Price"}
+	keys := []string{"Id"}
+
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{SQLite, "INSERT INTO Products (Id, Name, Price) VALUES (?,?,?) ON CONFLICT (Id) DO UPDATE SET Name=excluded.Name, Price=excluded.Price"},
+		{Postgres, "INSERT INTO Products (Id, Name, Price) VALUES ($1,$2,$3) ON CONFLICT (Id) DO UPDATE SET Name=EXCLUDED.Name, Price=EXCLUDED.Price"},
+		{MySQL, "INSERT INTO Products (Id, Name, Price) VALUES (?,?,?) ON DUPLICATE KEY UPDATE Name=VALUES(Name), Price=VALUES(Price)"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.dialect.Upsert("Products", columns, keys); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.dialect.Name(), tt.want, got)
+		}
+	}
+}
+
+func TestInsertIfAbsent(t *testing.T) {
+
+	columns := []string{"Id", "Name", "Price"}
+	keys := []string{"Id"}
+
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{SQLite, "INSERT INTO Products (Id, Name, Price) VALUES (?,?,?) ON CONFLICT (Id) DO NOTHING"},
+		{Postgres, "INSERT INTO Products (Id, Name, Price) VALUES ($1,$2,$3) ON CONFLICT (Id) DO NOTHING"},
+		{MySQL, "INSERT INTO Products (Id, Name, Price) VALUES (?,?,?) ON DUPLICATE KEY UPDATE Id=Id"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.dialect.InsertIfAbsent("Products", columns, keys); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.dialect.Name(), tt.want, got)
+		}
+	}
+}
+
+func TestFor(t *testing.T) {
+
+	for _, name := range []string{SQLiteName, PostgresName, MySQLName} {
+		if d, err := For(name); err != nil || d.Name() != name {
+			t.Errorf("Expected the %s dialect, got %v", name, err)
+		}
+	}
+	if _, err := For("oracle"); err == nil {
+		t.Errorf("Expected an unsupported driver error")
+	}
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Ping checks the connection pool of the label once
+// Unlike RW and RO, which retry for up to 10 seconds and then panic, the error is returned right away
+func (d *DB) Ping(ctx context.Context, label string) error {
+
+	pool, err := d.pool(label)
+	if err != nil {
+		return err
+	}
+
+	return pool.PingContext(ctx)
+}
+
+// CheckTables checks that the tables of the schema exist, reading them through the pool of the label
+func (d *DB) CheckTables(ctx context.Context, label string) error {
+
+	pool, err := d.pool(label)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range schemaTables {
+		// Reads no row, it only fails when the table does not exist
+		rows, err := pool.QueryContext(ctx, "SELECT 1 FROM "+table+" WHERE 1=0")
+		if err != nil {
+			return fmt.Errorf("table %s: %w", table, err)
+		}
+		rows.Close()
+	}
+
+	return nil
+}
+
+// Returns the connection pool of the label, opening it when it was not used yet
+func (d *DB) pool(label string) (*sql.DB, error) {
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	conn, ok := dbConnections[label]
+	if !ok {
+		return nil, fmt.Errorf("unknown database connection %q", label)
+	}
+
+	if conn.db == nil {
+		pool, err := openPool(conn.config)
+		if err != nil {
+			return nil, err
+		}
+		conn.db = pool
+	}
+
+	return conn.db, nil
+}
@@ -0,0 +1,315 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/techievee/xero/database/dialect"
+	"github.com/techievee/xero/xeroLog/debugcore"
+)
+
+const (
+	stmtCreateMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	checksum VARCHAR(64) NOT NULL,
+	applied_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(version)
+	)`
+
+	stmtAppliedMigrations = "SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version"
+	stmtInsertMigration   = "INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?,?,?,?)"
+	stmtDeleteMigration   = "DELETE FROM schema_migrations WHERE version=?"
+)
+
+var (
+	// ErrPendingMigrations is returned when the schema is behind the migrations known to the application
+	ErrPendingMigrations = errors.New("migrations: pending migrations")
+	// ErrChecksumMismatch is returned when an applied migration was modified after it was applied
+	ErrChecksumMismatch = errors.New("migrations: checksum mismatch")
+	// ErrUnknownMigration is returned when the schema is ahead of the migrations known to the application
+	ErrUnknownMigration = errors.New("migrations: unknown migration applied")
+)
+
+// Migration is one versioned step of the schema
+// Up and Down are executed in order, each statement is executed on its own
+// Dialects overrides Up and Down for the databases whose DDL differs, keyed by the dialect name
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       []string
+	Down     []string
+	Dialects map[string]Statements
+}
+
+// Statements are the up and down statements of a migration for one dialect
+type Statements struct {
+	Up   []string
+	Down []string
+}
+
+// For returns the migration with the statements of the dialect
+func (m Migration) For(dialectName string) Migration {
+	if override, ok := m.Dialects[dialectName]; ok {
+		m.Up = override.Up
+		m.Down = override.Down
+	}
+	m.Dialects = nil
+	return m
+}
+
+// Checksum identifies the content of the up statements, it is recorded when the migration is applied
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(strings.Join(m.Up, "\n;\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// AppliedMigration is a row of the schema_migrations table
+type AppliedMigration struct {
+	Version   int64
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Status of one migration, as reported by the migrator
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies the ordered migrations on the database and records them in schema_migrations
+// On MySQL the DDL statements commit implicitly, so a failed migration may be partially applied
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect.Dialect
+	migrations []Migration
+	Logger     debugcore.Logger
+}
+
+// NewMigrator returns a migrator for the migrations in the statements of the dialect, they are sorted by version
+// Duplicated versions are rejected as the order of the schema would be ambiguous
+func NewMigrator(db *sql.DB, d dialect.Dialect, migrations []Migration, logger debugcore.Logger) (*Migrator, error) {
+
+	sorted := make([]Migration, 0, len(migrations))
+	for _, m := range migrations {
+		sorted = append(sorted, m.For(d.Name()))
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	for i := range sorted {
+		if sorted[i].Version <= 0 {
+			return nil, fmt.Errorf("migrations: invalid version %d for %q", sorted[i].Version, sorted[i].Name)
+		}
+		if i > 0 && sorted[i].Version == sorted[i-1].Version {
+			return nil, fmt.Errorf("migrations: duplicated version %d", sorted[i].Version)
+		}
+	}
+
+	return &Migrator{
+		db:         db,
+		dialect:    d,
+		migrations: sorted,
+		Logger:     logger,
+	}, nil
+}
+
+// Returns the migrations recorded in schema_migrations, creating the table if it does not exist
+func (m *Migrator) applied(ctx context.Context) (map[int64]AppliedMigration, error) {
+
+	if _, err := m.db.ExecContext(ctx, stmtCreateMigrationsTable); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.QueryContext(ctx, m.dialect.Rewrite(stmtAppliedMigrations))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := map[int64]AppliedMigration{}
+	for rows.Next() {
+		a := AppliedMigration{}
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		result[a.Version] = a
+	}
+
+	return result, rows.Err()
+}
+
+// Verify checks that every applied migration is known and unmodified
+func (m *Migrator) Verify(ctx context.Context) error {
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	return m.verify(applied)
+}
+
+func (m *Migrator) verify(applied map[int64]AppliedMigration) error {
+
+	known := map[int64]Migration{}
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	for version, a := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return fmt.Errorf("%w: version %d (%s)", ErrUnknownMigration, version, a.Name)
+		}
+		if migration.Checksum() != a.Checksum {
+			return fmt.Errorf("%w: version %d (%s)", ErrChecksumMismatch, version, a.Name)
+		}
+	}
+
+	return nil
+}
+
+// Status returns every known migration along with its applied state
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Status
+	for _, migration := range m.migrations {
+		s := Status{Migration: migration}
+		if a, ok := applied[migration.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = a.AppliedAt
+		}
+		result = append(result, s)
+	}
+
+	return result, nil
+}
+
+// Pending returns the migrations not yet applied, in the order they have to be applied
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.verify(applied); err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// CheckPending returns ErrPendingMigrations when the schema is not at the latest version
+func (m *Migrator) CheckPending(ctx context.Context) error {
+
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) != 0 {
+		return fmt.Errorf("%w: %d migration(s) starting at version %d", ErrPendingMigrations, len(pending), pending[0].Version)
+	}
+
+	return nil
+}
+
+// Up applies all the pending migrations and returns the number of migrations applied
+// Every migration is applied in its own transaction along with its schema_migrations row
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, migration := range pending {
+		err := m.inTx(ctx, migration.Up, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, m.dialect.Rewrite(stmtInsertMigration), migration.Version, migration.Name, migration.Checksum(), time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			m.Logger.Error("Error while applying migration", "version", migration.Version, "name", migration.Name, "error", err)
+			return i, fmt.Errorf("migrations: version %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		m.Logger.Info("Applied migration", "version", migration.Version, "name", migration.Name)
+	}
+
+	return len(pending), nil
+}
+
+// Down reverts the last `steps` applied migrations and returns the number of migrations reverted
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := m.verify(applied); err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		err := m.inTx(ctx, migration.Down, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, m.dialect.Rewrite(stmtDeleteMigration), migration.Version)
+			return err
+		})
+		if err != nil {
+			m.Logger.Error("Error while reverting migration", "version", migration.Version, "name", migration.Name, "error", err)
+			return reverted, fmt.Errorf("migrations: version %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		m.Logger.Info("Reverted migration", "version", migration.Version, "name", migration.Name)
+		reverted++
+	}
+
+	return reverted, nil
+}
+
+// Executes the statements and the bookkeeping in one transaction
+func (m *Migrator) inTx(ctx context.Context, statements []string, record func(tx *sql.Tx) error) error {
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,161 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"go.elastic.co/apm/module/apmsql"
+	_ "go.elastic.co/apm/module/apmsql/sqlite3"
+
+	"github.com/techievee/xero/database/dialect"
+	"github.com/techievee/xero/xeroLog/debugcore"
+)
+
+var testMigrations = []Migration{
+	{
+		Version: 2,
+		Name:    "create_options",
+		Up:      []string{"CREATE TABLE Options (Id varchar(36), ItemId varchar(36))"},
+		Down:    []string{"DROP TABLE Options"},
+	},
+	{
+		Version: 1,
+		Name:    "create_items",
+		Up:      []string{"CREATE TABLE Items (Id varchar(36))", "CREATE INDEX items_index ON Items (Id)"},
+		Down:    []string{"DROP INDEX items_index", "DROP TABLE Items"},
+	},
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := apmsql.Open("sqlite3", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection of an in memory database is a new database
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+func TestMigrator(t *testing.T) {
+
+	ctx := context.Background()
+	db := openTestDB(t)
+	defer db.Close()
+
+	m, err := NewMigrator(db, dialect.SQLite, testMigrations, &debugcore.NoOpsLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.CheckPending(ctx); !errors.Is(err, ErrPendingMigrations) {
+		t.Errorf("Expected pending migrations, got %v", err)
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil || applied != 2 {
+		t.Fatalf("Expected 2 migrations applied, got %d: %v", applied, err)
+	}
+	if err := m.CheckPending(ctx); err != nil {
+		t.Errorf("Expected no pending migration, got %v", err)
+	}
+
+	// Applying again is a no-op
+	if applied, err := m.Up(ctx); err != nil || applied != 0 {
+		t.Errorf("Expected no migration applied, got %d: %v", applied, err)
+	}
+
+	// Reverting the last migration only drops the options
+	if reverted, err := m.Down(ctx, 1); err != nil || reverted != 1 {
+		t.Fatalf("Expected 1 migration reverted, got %d: %v", reverted, err)
+	}
+	if _, err := db.ExecContext(ctx, "SELECT * FROM Options"); err == nil {
+		t.Errorf("Expected the options table to be dropped")
+	}
+	if _, err := db.ExecContext(ctx, "SELECT * FROM Items"); err != nil {
+		t.Errorf("Expected the items table to exist: %v", err)
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 2 || !status[0].Applied || status[1].Applied {
+		t.Errorf("Wrong migration status %+v", status)
+	}
+}
+
+func TestMigratorChecksum(t *testing.T) {
+
+	ctx := context.Background()
+	db := openTestDB(t)
+	defer db.Close()
+
+	m, _ := NewMigrator(db, dialect.SQLite, testMigrations[1:], &debugcore.NoOpsLogger{})
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The applied migration was edited afterwards
+	edited := testMigrations[1]
+	edited.Up = []string{"CREATE TABLE Items (Id varchar(64))"}
+	m, _ = NewMigrator(db, dialect.SQLite, []Migration{edited}, &debugcore.NoOpsLogger{})
+	if _, err := m.Up(ctx); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected checksum mismatch, got %v", err)
+	}
+
+	// The schema is ahead of the application
+	m, _ = NewMigrator(db, dialect.SQLite, nil, &debugcore.NoOpsLogger{})
+	if err := m.Verify(ctx); !errors.Is(err, ErrUnknownMigration) {
+		t.Errorf("Expected unknown migration, got %v", err)
+	}
+}
+
+func TestMigratorFailedMigration(t *testing.T) {
+
+	ctx := context.Background()
+	db := openTestDB(t)
+	defer db.Close()
+
+	broken := []Migration{
+		testMigrations[1],
+		{Version: 2, Name: "broken", Up: []string{"CREATE TABLE Broken (Id varchar(36))", "CREATE TABLE Items (Id int)"}},
+	}
+	m, _ := NewMigrator(db, dialect.SQLite, broken, &debugcore.NoOpsLogger{})
+
+	applied, err := m.Up(ctx)
+	if err == nil || applied != 1 {
+		t.Fatalf("Expected the second migration to fail, got %d: %v", applied, err)
+	}
+
+	// The failed migration is rolled back as a whole
+	if _, err := db.ExecContext(ctx, "SELECT * FROM Broken"); err == nil {
+		t.Errorf("Expected the failed migration to be rolled back")
+	}
+	if pending, _ := m.Pending(ctx); len(pending) != 1 {
+		t.Errorf("Expected 1 pending migration, got %d", len(pending))
+	}
+}
+
+func TestMigrationForDialect(t *testing.T) {
+
+	m := Migration{
+		Version:  1,
+		Up:       []string{`CREATE INDEX IF NOT EXISTS "items_index" ON "Items" ("Id")`},
+		Dialects: map[string]Statements{dialect.MySQLName: {Up: []string{"CREATE INDEX items_index ON Items (Id)"}}},
+	}
+
+	if got := m.For(dialect.SQLiteName); got.Up[0] != m.Up[0] {
+		t.Errorf("Expected the default statements, got %v", got.Up)
+	}
+	if got := m.For(dialect.MySQLName); got.Up[0] != "CREATE INDEX items_index ON Items (Id)" || got.Checksum() == m.Checksum() {
+		t.Errorf("Expected the mysql statements, got %v", got.Up)
+	}
+}
+
+func TestNewMigratorDuplicatedVersion(t *testing.T) {
+	if _, err := NewMigrator(nil, dialect.SQLite, append(testMigrations, testMigrations[0]), &debugcore.NoOpsLogger{}); err == nil {
+		t.Errorf("Expected duplicated version error")
+	}
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestConnectionOpenString(t *testing.T) {
+
+	tests := []struct {
+		name string
+		cfg  DBCfg
+		want string
+	}{
+		{
+			name: "sqlite3",
+			cfg:  DBCfg{Driver: "sqlite3", FilePath: "./", Database: "products", Options: map[string]interface{}{"mode": "ro"}},
+			want: "file:./products.db?mode=ro",
+		},
+		{
+			name: "postgres",
+			cfg: DBCfg{Driver: "postgres", Host: "db", Port: 5433, User: "xero", Password: "p@ss/word", Database: "products",
+				SSLMode: "disable", Options: map[string]interface{}{"connect_timeout": 5}},
+			want: "postgres://xero:p%40ss%2Fword@db:5433/products?connect_timeout=5&sslmode=disable",
+		},
+		{
+			name: "postgres defaults",
+			cfg:  DBCfg{Driver: "postgres", Database: "products"},
+			want: "postgres://localhost:5432/products",
+		},
+		{
+			name: "mysql",
+			cfg: DBCfg{Driver: "mysql", Host: "db", User: "xero", Password: "secret", Database: "products",
+				Options: map[string]interface{}{"charset": "utf8mb4"}},
+			want: "xero:secret@tcp(db:3306)/products?parseTime=true&charset=utf8mb4",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.cfg.ConnectionOpenString(); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
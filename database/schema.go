@@ -0,0 +1,820 @@
+package database
+
+import (
+	"github.com/techievee/xero/database/dialect"
+	"github.com/techievee/xero/database/migrations"
+)
+
+// schemaTables are the tables the service needs to serve, the readiness check verifies they exist
+// A migration creating a table the service depends on has to add it here
+var schemaTables = []string{"Products", "ProductOptions", "ProductPrices", "StockReservations", "Categories", "ProductCategories", "AuditLog", "Tenants"}
+
+// schemaMigrations is the ordered list of the schema changes of the product database
+// Applied migrations must never be edited, their checksum is verified at every startup
+// Any schema change has to be added as a new migration at the end of the list
+// The statements are written for SQLite, the other databases override them when their DDL differs
+var schemaMigrations = []migrations.Migration{
+	{
+		Version: 1,
+		Name:    "create_products",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS "Products" (
+	"Id"	varchar(36) DEFAULT NULL,
+	"Name"	varchar(17) DEFAULT NULL,
+	"Description"	varchar(35) DEFAULT NULL,
+	"Price"	decimal(6 , 2) DEFAULT NULL,
+	"DeliveryPrice"	decimal(4 , 2) DEFAULT NULL,
+	PRIMARY KEY("Id")
+	)`,
+			`CREATE INDEX IF NOT EXISTS "product_id_index" ON "Products" (
+	"Name"	ASC
+	)`,
+			`CREATE TABLE IF NOT EXISTS  "ProductOptions" (
+	"Id"	varchar(36) DEFAULT NULL,
+	"ProductId"	varchar(36) DEFAULT NULL,
+	"Name"	varchar(9) DEFAULT NULL,
+	"Description"	varchar(23) DEFAULT NULL,
+	PRIMARY KEY("Id"),
+	FOREIGN KEY("ProductId") REFERENCES "Products"("Id") ON DELETE CASCADE
+	)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS "ProductOptions"`,
+			`DROP INDEX IF EXISTS "product_id_index"`,
+			`DROP TABLE IF EXISTS "Products"`,
+		},
+		Dialects: map[string]migrations.Statements{
+			dialect.PostgresName: {
+				Up: []string{
+					`CREATE TABLE IF NOT EXISTS Products (
+	Id	varchar(36) NOT NULL,
+	Name	varchar(17) DEFAULT NULL,
+	Description	varchar(35) DEFAULT NULL,
+	Price	decimal(6 , 2) DEFAULT NULL,
+	DeliveryPrice	decimal(4 , 2) DEFAULT NULL,
+	PRIMARY KEY(Id)
+	)`,
+					`CREATE INDEX IF NOT EXISTS product_id_index ON Products (
+	Name	ASC
+	)`,
+					`CREATE TABLE IF NOT EXISTS ProductOptions (
+	Id	varchar(36) NOT NULL,
+	ProductId	varchar(36) DEFAULT NULL,
+	Name	varchar(9) DEFAULT NULL,
+	Description	varchar(23) DEFAULT NULL,
+	PRIMARY KEY(Id),
+	FOREIGN KEY(ProductId) REFERENCES Products(Id) ON DELETE CASCADE
+	)`,
+				},
+				Down: []string{
+					`DROP TABLE IF EXISTS ProductOptions`,
+					`DROP INDEX IF EXISTS product_id_index`,
+					`DROP TABLE IF EXISTS Products`,
+				},
+			},
+			// MySQL has no CREATE INDEX IF NOT EXISTS, the index is declared with the table
+			dialect.MySQLName: {
+				Up: []string{
+					`CREATE TABLE IF NOT EXISTS Products (
+	Id	varchar(36) NOT NULL,
+	Name	varchar(17) DEFAULT NULL,
+	Description	varchar(35) DEFAULT NULL,
+	Price	decimal(6 , 2) DEFAULT NULL,
+	DeliveryPrice	decimal(4 , 2) DEFAULT NULL,
+	PRIMARY KEY(Id),
+	INDEX product_id_index (Name ASC)
+	)`,
+					`CREATE TABLE IF NOT EXISTS ProductOptions (
+	Id	varchar(36) NOT NULL,
+	ProductId	varchar(36) DEFAULT NULL,
+	Name	varchar(9) DEFAULT NULL,
+	Description	varchar(23) DEFAULT NULL,
+	PRIMARY KEY(Id),
+	FOREIGN KEY(ProductId) REFERENCES Products(Id) ON DELETE CASCADE
+	)`,
+				},
+				Down: []string{
+					`DROP TABLE IF EXISTS ProductOptions`,
+					`DROP TABLE IF EXISTS Products`,
+				},
+			},
+		},
+	},
+	// The prices are stored as integer minor units along with their currency, the decimal prices were rounded by the float conversions
+	// The existing prices are converted to cents in the default currency
+	// SQLite cannot drop a column, the table is rebuilt instead
+	{
+		Version: 2,
+		Name:    "money_minor_units",
+		Up: []string{
+			`CREATE TABLE "Products_money" (
+	"Id"	varchar(36) DEFAULT NULL,
+	"Name"	varchar(17) DEFAULT NULL,
+	"Description"	varchar(35) DEFAULT NULL,
+	"PriceMinor"	integer NOT NULL DEFAULT 0,
+	"DeliveryPriceMinor"	integer NOT NULL DEFAULT 0,
+	"Currency"	varchar(3) NOT NULL DEFAULT 'NZD',
+	PRIMARY KEY("Id")
+	)`,
+			`INSERT INTO "Products_money" ("Id", "Name", "Description", "PriceMinor", "DeliveryPriceMinor")
+	SELECT "Id", "Name", "Description", CAST(ROUND(COALESCE("Price", 0) * 100) AS INTEGER), CAST(ROUND(COALESCE("DeliveryPrice", 0) * 100) AS INTEGER)
+	FROM "Products"`,
+			`DROP INDEX IF EXISTS "product_id_index"`,
+			`DROP TABLE "Products"`,
+			`ALTER TABLE "Products_money" RENAME TO "Products"`,
+			`CREATE INDEX IF NOT EXISTS "product_id_index" ON "Products" (
+	"Name"	ASC
+	)`,
+		},
+		Down: []string{
+			`CREATE TABLE "Products_decimal" (
+	"Id"	varchar(36) DEFAULT NULL,
+	"Name"	varchar(17) DEFAULT NULL,
+	"Description"	varchar(35) DEFAULT NULL,
+	"Price"	decimal(6 , 2) DEFAULT NULL,
+	"DeliveryPrice"	decimal(4 , 2) DEFAULT NULL,
+	PRIMARY KEY("Id")
+	)`,
+			`INSERT INTO "Products_decimal" ("Id", "Name", "Description", "Price", "DeliveryPrice")
+	SELECT "Id", "Name", "Description", "PriceMinor" / 100.0, "DeliveryPriceMinor" / 100.0
+	FROM "Products"`,
+			`DROP INDEX IF EXISTS "product_id_index"`,
+			`DROP TABLE "Products"`,
+			`ALTER TABLE "Products_decimal" RENAME TO "Products"`,
+			`CREATE INDEX IF NOT EXISTS "product_id_index" ON "Products" (
+	"Name"	ASC
+	)`,
+		},
+		Dialects: map[string]migrations.Statements{
+			dialect.PostgresName: {
+				Up: []string{
+					`ALTER TABLE Products ADD COLUMN PriceMinor bigint NOT NULL DEFAULT 0`,
+					`ALTER TABLE Products ADD COLUMN DeliveryPriceMinor bigint NOT NULL DEFAULT 0`,
+					`ALTER TABLE Products ADD COLUMN Currency varchar(3) NOT NULL DEFAULT 'NZD'`,
+					`UPDATE Products SET PriceMinor = ROUND(COALESCE(Price, 0) * 100), DeliveryPriceMinor = ROUND(COALESCE(DeliveryPrice, 0) * 100)`,
+					`ALTER TABLE Products DROP COLUMN Price`,
+					`ALTER TABLE Products DROP COLUMN DeliveryPrice`,
+				},
+				Down: []string{
+					`ALTER TABLE Products ADD COLUMN Price decimal(6 , 2) DEFAULT NULL`,
+					`ALTER TABLE Products ADD COLUMN DeliveryPrice decimal(4 , 2) DEFAULT NULL`,
+					`UPDATE Products SET Price = PriceMinor / 100.0, DeliveryPrice = DeliveryPriceMinor / 100.0`,
+					`ALTER TABLE Products DROP COLUMN PriceMinor`,
+					`ALTER TABLE Products DROP COLUMN DeliveryPriceMinor`,
+					`ALTER TABLE Products DROP COLUMN Currency`,
+				},
+			},
+			dialect.MySQLName: {
+				Up: []string{
+					`ALTER TABLE Products ADD COLUMN PriceMinor bigint NOT NULL DEFAULT 0, ADD COLUMN DeliveryPriceMinor bigint NOT NULL DEFAULT 0, ADD COLUMN Currency varchar(3) NOT NULL DEFAULT 'NZD'`,
+					`UPDATE Products SET PriceMinor = ROUND(COALESCE(Price, 0) * 100), DeliveryPriceMinor = ROUND(COALESCE(DeliveryPrice, 0) * 100)`,
+					`ALTER TABLE Products DROP COLUMN Price, DROP COLUMN DeliveryPrice`,
+				},
+				Down: []string{
+					`ALTER TABLE Products ADD COLUMN Price decimal(6 , 2) DEFAULT NULL, ADD COLUMN DeliveryPrice decimal(4 , 2) DEFAULT NULL`,
+					`UPDATE Products SET Price = PriceMinor / 100.0, DeliveryPrice = DeliveryPriceMinor / 100.0`,
+					`ALTER TABLE Products DROP COLUMN PriceMinor, DROP COLUMN DeliveryPriceMinor, DROP COLUMN Currency`,
+				},
+			},
+		},
+	},
+	// The prices of the products in the other currencies than their base currency
+	// The statements are the same for all the databases
+	{
+		Version: 3,
+		Name:    "create_product_prices",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS ProductPrices (
+	ProductId	varchar(36) NOT NULL,
+	Currency	varchar(3) NOT NULL,
+	PriceMinor	bigint NOT NULL,
+	DeliveryPriceMinor	bigint NOT NULL DEFAULT 0,
+	PRIMARY KEY(ProductId, Currency),
+	FOREIGN KEY(ProductId) REFERENCES Products(Id) ON DELETE CASCADE
+	)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS ProductPrices`,
+		},
+	},
+	// The stock of the product options, StockVersion is bumped at every change so the concurrent changes are detected
+	// The reservations hold a quantity of an option until they are committed or released
+	// SQLite cannot drop a column, the options table is rebuilt when reverting
+	{
+		Version: 4,
+		Name:    "option_stock",
+		Up: []string{
+			`ALTER TABLE ProductOptions ADD COLUMN StockOnHand bigint NOT NULL DEFAULT 0`,
+			`ALTER TABLE ProductOptions ADD COLUMN StockReserved bigint NOT NULL DEFAULT 0`,
+			`ALTER TABLE ProductOptions ADD COLUMN StockVersion bigint NOT NULL DEFAULT 0`,
+			`CREATE TABLE IF NOT EXISTS StockReservations (
+	Id	varchar(36) NOT NULL,
+	ProductOptionId	varchar(36) NOT NULL,
+	Quantity	bigint NOT NULL,
+	Status	varchar(9) NOT NULL,
+	PRIMARY KEY(Id),
+	FOREIGN KEY(ProductOptionId) REFERENCES ProductOptions(Id) ON DELETE CASCADE
+	)`,
+			`CREATE INDEX IF NOT EXISTS stock_reservation_option_index ON StockReservations (
+	ProductOptionId	ASC
+	)`,
+		},
+		Down: []string{
+			`DROP INDEX IF EXISTS stock_reservation_option_index`,
+			`DROP TABLE IF EXISTS StockReservations`,
+			`CREATE TABLE "ProductOptions_plain" (
+	"Id"	varchar(36) DEFAULT NULL,
+	"ProductId"	varchar(36) DEFAULT NULL,
+	"Name"	varchar(9) DEFAULT NULL,
+	"Description"	varchar(23) DEFAULT NULL,
+	PRIMARY KEY("Id"),
+	FOREIGN KEY("ProductId") REFERENCES "Products"("Id") ON DELETE CASCADE
+	)`,
+			`INSERT INTO "ProductOptions_plain" ("Id", "ProductId", "Name", "Description")
+	SELECT "Id", "ProductId", "Name", "Description" FROM "ProductOptions"`,
+			`DROP TABLE "ProductOptions"`,
+			`ALTER TABLE "ProductOptions_plain" RENAME TO "ProductOptions"`,
+		},
+		Dialects: map[string]migrations.Statements{
+			dialect.PostgresName: {
+				Up: []string{
+					`ALTER TABLE ProductOptions ADD COLUMN StockOnHand bigint NOT NULL DEFAULT 0`,
+					`ALTER TABLE ProductOptions ADD COLUMN StockReserved bigint NOT NULL DEFAULT 0`,
+					`ALTER TABLE ProductOptions ADD COLUMN StockVersion bigint NOT NULL DEFAULT 0`,
+					`CREATE TABLE IF NOT EXISTS StockReservations (
+	Id	varchar(36) NOT NULL,
+	ProductOptionId	varchar(36) NOT NULL,
+	Quantity	bigint NOT NULL,
+	Status	varchar(9) NOT NULL,
+	PRIMARY KEY(Id),
+	FOREIGN KEY(ProductOptionId) REFERENCES ProductOptions(Id) ON DELETE CASCADE
+	)`,
+					`CREATE INDEX IF NOT EXISTS stock_reservation_option_index ON StockReservations (
+	ProductOptionId	ASC
+	)`,
+				},
+				Down: []string{
+					`DROP TABLE IF EXISTS StockReservations`,
+					`ALTER TABLE ProductOptions DROP COLUMN StockOnHand`,
+					`ALTER TABLE ProductOptions DROP COLUMN StockReserved`,
+					`ALTER TABLE ProductOptions DROP COLUMN StockVersion`,
+				},
+			},
+			// MySQL indexes the foreign key by itself
+			dialect.MySQLName: {
+				Up: []string{
+					`ALTER TABLE ProductOptions ADD COLUMN StockOnHand bigint NOT NULL DEFAULT 0, ADD COLUMN StockReserved bigint NOT NULL DEFAULT 0, ADD COLUMN StockVersion bigint NOT NULL DEFAULT 0`,
+					`CREATE TABLE IF NOT EXISTS StockReservations (
+	Id	varchar(36) NOT NULL,
+	ProductOptionId	varchar(36) NOT NULL,
+	Quantity	bigint NOT NULL,
+	Status	varchar(9) NOT NULL,
+	PRIMARY KEY(Id),
+	FOREIGN KEY(ProductOptionId) REFERENCES ProductOptions(Id) ON DELETE CASCADE
+	)`,
+				},
+				Down: []string{
+					`DROP TABLE IF EXISTS StockReservations`,
+					`ALTER TABLE ProductOptions DROP COLUMN StockOnHand, DROP COLUMN StockReserved, DROP COLUMN StockVersion`,
+				},
+			},
+		},
+	},
+	// The taxonomy of the products, a tree of categories linked to the products many to many
+	// The root categories have no parent, the slug identifies a category in the urls
+	{
+		Version: 5,
+		Name:    "create_categories",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS Categories (
+	Id	varchar(36) NOT NULL,
+	ParentId	varchar(36) DEFAULT NULL,
+	Slug	varchar(64) NOT NULL,
+	Name	varchar(35) NOT NULL,
+	SortOrder	bigint NOT NULL DEFAULT 0,
+	PRIMARY KEY(Id),
+	UNIQUE(Slug),
+	FOREIGN KEY(ParentId) REFERENCES Categories(Id)
+	)`,
+			`CREATE INDEX IF NOT EXISTS category_parent_index ON Categories (
+	ParentId	ASC,
+	SortOrder	ASC
+	)`,
+			`CREATE TABLE IF NOT EXISTS ProductCategories (
+	ProductId	varchar(36) NOT NULL,
+	CategoryId	varchar(36) NOT NULL,
+	PRIMARY KEY(ProductId, CategoryId),
+	FOREIGN KEY(ProductId) REFERENCES Products(Id) ON DELETE CASCADE,
+	FOREIGN KEY(CategoryId) REFERENCES Categories(Id) ON DELETE CASCADE
+	)`,
+			`CREATE INDEX IF NOT EXISTS product_category_index ON ProductCategories (
+	CategoryId	ASC
+	)`,
+		},
+		Down: []string{
+			`DROP INDEX IF EXISTS product_category_index`,
+			`DROP TABLE IF EXISTS ProductCategories`,
+			`DROP INDEX IF EXISTS category_parent_index`,
+			`DROP TABLE IF EXISTS Categories`,
+		},
+		Dialects: map[string]migrations.Statements{
+			// MySQL has no CREATE INDEX IF NOT EXISTS, the indexes are declared with the tables
+			dialect.MySQLName: {
+				Up: []string{
+					`CREATE TABLE IF NOT EXISTS Categories (
+	Id	varchar(36) NOT NULL,
+	ParentId	varchar(36) DEFAULT NULL,
+	Slug	varchar(64) NOT NULL,
+	Name	varchar(35) NOT NULL,
+	SortOrder	bigint NOT NULL DEFAULT 0,
+	PRIMARY KEY(Id),
+	UNIQUE(Slug),
+	INDEX category_parent_index (ParentId ASC, SortOrder ASC),
+	FOREIGN KEY(ParentId) REFERENCES Categories(Id)
+	)`,
+					`CREATE TABLE IF NOT EXISTS ProductCategories (
+	ProductId	varchar(36) NOT NULL,
+	CategoryId	varchar(36) NOT NULL,
+	PRIMARY KEY(ProductId, CategoryId),
+	INDEX product_category_index (CategoryId ASC),
+	FOREIGN KEY(ProductId) REFERENCES Products(Id) ON DELETE CASCADE,
+	FOREIGN KEY(CategoryId) REFERENCES Categories(Id) ON DELETE CASCADE
+	)`,
+				},
+				Down: []string{
+					`DROP TABLE IF EXISTS ProductCategories`,
+					`DROP TABLE IF EXISTS Categories`,
+				},
+			},
+		},
+	},
+	// The deleted products and options are kept in the trash until they are purged, DeletedAt is the time of the deletion in milliseconds
+	// The rows with DeletedAt set are hidden from all the reads
+	// SQLite cannot drop a column, the tables are rebuilt when reverting, the rows still in the trash are dropped with the column
+	{
+		Version: 6,
+		Name:    "soft_delete",
+		Up: []string{
+			`ALTER TABLE Products ADD COLUMN DeletedAt bigint DEFAULT NULL`,
+			`ALTER TABLE ProductOptions ADD COLUMN DeletedAt bigint DEFAULT NULL`,
+			`CREATE INDEX IF NOT EXISTS product_deleted_index ON Products (
+	DeletedAt	ASC
+	)`,
+			`CREATE INDEX IF NOT EXISTS product_option_deleted_index ON ProductOptions (
+	DeletedAt	ASC
+	)`,
+		},
+		Down: []string{
+			`DROP INDEX IF EXISTS product_option_deleted_index`,
+			`DROP INDEX IF EXISTS product_deleted_index`,
+			`CREATE TABLE "Products_live" (
+	"Id"	varchar(36) DEFAULT NULL,
+	"Name"	varchar(17) DEFAULT NULL,
+	"Description"	varchar(35) DEFAULT NULL,
+	"PriceMinor"	integer NOT NULL DEFAULT 0,
+	"DeliveryPriceMinor"	integer NOT NULL DEFAULT 0,
+	"Currency"	varchar(3) NOT NULL DEFAULT 'NZD',
+	PRIMARY KEY("Id")
+	)`,
+			`INSERT INTO "Products_live" ("Id", "Name", "Description", "PriceMinor", "DeliveryPriceMinor", "Currency")
+	SELECT "Id", "Name", "Description", "PriceMinor", "DeliveryPriceMinor", "Currency" FROM "Products" WHERE "DeletedAt" IS NULL`,
+			`DROP INDEX IF EXISTS "product_id_index"`,
+			`DROP TABLE "Products"`,
+			`ALTER TABLE "Products_live" RENAME TO "Products"`,
+			`CREATE INDEX IF NOT EXISTS "product_id_index" ON "Products" (
+	"Name"	ASC
+	)`,
+			`CREATE TABLE "ProductOptions_live" (
+	"Id"	varchar(36) DEFAULT NULL,
+	"ProductId"	varchar(36) DEFAULT NULL,
+	"Name"	varchar(9) DEFAULT NULL,
+	"Description"	varchar(23) DEFAULT NULL,
+	"StockOnHand"	bigint NOT NULL DEFAULT 0,
+	"StockReserved"	bigint NOT NULL DEFAULT 0,
+	"StockVersion"	bigint NOT NULL DEFAULT 0,
+	PRIMARY KEY("Id"),
+	FOREIGN KEY("ProductId") REFERENCES "Products"("Id") ON DELETE CASCADE
+	)`,
+			`INSERT INTO "ProductOptions_live" ("Id", "ProductId", "Name", "Description", "StockOnHand", "StockReserved", "StockVersion")
+	SELECT "Id", "ProductId", "Name", "Description", "StockOnHand", "StockReserved", "StockVersion" FROM "ProductOptions" WHERE "DeletedAt" IS NULL`,
+			`DROP TABLE "ProductOptions"`,
+			`ALTER TABLE "ProductOptions_live" RENAME TO "ProductOptions"`,
+		},
+		Dialects: map[string]migrations.Statements{
+			dialect.PostgresName: {
+				Up: []string{
+					`ALTER TABLE Products ADD COLUMN DeletedAt bigint DEFAULT NULL`,
+					`ALTER TABLE ProductOptions ADD COLUMN DeletedAt bigint DEFAULT NULL`,
+					`CREATE INDEX IF NOT EXISTS product_deleted_index ON Products (
+	DeletedAt	ASC
+	)`,
+					`CREATE INDEX IF NOT EXISTS product_option_deleted_index ON ProductOptions (
+	DeletedAt	ASC
+	)`,
+				},
+				Down: []string{
+					`DELETE FROM ProductOptions WHERE DeletedAt IS NOT NULL`,
+					`DELETE FROM Products WHERE DeletedAt IS NOT NULL`,
+					`ALTER TABLE ProductOptions DROP COLUMN DeletedAt`,
+					`ALTER TABLE Products DROP COLUMN DeletedAt`,
+				},
+			},
+			// MySQL has no CREATE INDEX IF NOT EXISTS, the indexes are added with the columns
+			dialect.MySQLName: {
+				Up: []string{
+					`ALTER TABLE Products ADD COLUMN DeletedAt bigint DEFAULT NULL, ADD INDEX product_deleted_index (DeletedAt ASC)`,
+					`ALTER TABLE ProductOptions ADD COLUMN DeletedAt bigint DEFAULT NULL, ADD INDEX product_option_deleted_index (DeletedAt ASC)`,
+				},
+				Down: []string{
+					`DELETE FROM ProductOptions WHERE DeletedAt IS NOT NULL`,
+					`DELETE FROM Products WHERE DeletedAt IS NOT NULL`,
+					`ALTER TABLE ProductOptions DROP INDEX product_option_deleted_index, DROP COLUMN DeletedAt`,
+					`ALTER TABLE Products DROP INDEX product_deleted_index, DROP COLUMN DeletedAt`,
+				},
+			},
+		},
+	},
+	// Every change of the products is recorded in the audit log, in the transaction of the change
+	// The entries are kept when the product is purged, ProductId is not a foreign key
+	// StateBefore and StateAfter are the JSON states of the changed row, CreatedAt is the time of the change in milliseconds
+	{
+		Version: 7,
+		Name:    "create_audit_log",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS AuditLog (
+	Id	varchar(36) NOT NULL,
+	ProductId	varchar(36) NOT NULL,
+	Entity	varchar(16) NOT NULL,
+	EntityId	varchar(36) NOT NULL,
+	Action	varchar(16) NOT NULL,
+	Actor	varchar(255) NOT NULL,
+	RequestId	varchar(255) NOT NULL DEFAULT '',
+	StateBefore	text DEFAULT NULL,
+	StateAfter	text DEFAULT NULL,
+	CreatedAt	bigint NOT NULL,
+	PRIMARY KEY(Id)
+	)`,
+			`CREATE INDEX IF NOT EXISTS audit_product_index ON AuditLog (
+	ProductId	ASC,
+	CreatedAt	DESC
+	)`,
+		},
+		Down: []string{
+			`DROP INDEX IF EXISTS audit_product_index`,
+			`DROP TABLE IF EXISTS AuditLog`,
+		},
+		Dialects: map[string]migrations.Statements{
+			// MySQL has no CREATE INDEX IF NOT EXISTS, the index is declared with the table
+			dialect.MySQLName: {
+				Up: []string{
+					`CREATE TABLE IF NOT EXISTS AuditLog (
+	Id	varchar(36) NOT NULL,
+	ProductId	varchar(36) NOT NULL,
+	Entity	varchar(16) NOT NULL,
+	EntityId	varchar(36) NOT NULL,
+	Action	varchar(16) NOT NULL,
+	Actor	varchar(255) NOT NULL,
+	RequestId	varchar(255) NOT NULL DEFAULT '',
+	StateBefore	text DEFAULT NULL,
+	StateAfter	text DEFAULT NULL,
+	CreatedAt	bigint NOT NULL,
+	PRIMARY KEY(Id),
+	INDEX audit_product_index (ProductId ASC, CreatedAt DESC)
+	)`,
+				},
+				Down: []string{
+					`DROP TABLE IF EXISTS AuditLog`,
+				},
+			},
+		},
+	},
+	// Version is incremented at every change of the row, the API sends it as the ETag and checks it against If-Match
+	// The prices in the other currencies are part of the representation of the product, setting them increments its Version
+	// SQLite cannot drop a column, the tables are rebuilt when reverting
+	{
+		Version: 8,
+		Name:    "row_version",
+		Up: []string{
+			`ALTER TABLE Products ADD COLUMN Version bigint NOT NULL DEFAULT 1`,
+			`ALTER TABLE ProductOptions ADD COLUMN Version bigint NOT NULL DEFAULT 1`,
+		},
+		Down: []string{
+			`CREATE TABLE "Products_unversioned" (
+	"Id"	varchar(36) DEFAULT NULL,
+	"Name"	varchar(17) DEFAULT NULL,
+	"Description"	varchar(35) DEFAULT NULL,
+	"PriceMinor"	integer NOT NULL DEFAULT 0,
+	"DeliveryPriceMinor"	integer NOT NULL DEFAULT 0,
+	"Currency"	varchar(3) NOT NULL DEFAULT 'NZD',
+	"DeletedAt"	bigint DEFAULT NULL,
+	PRIMARY KEY("Id")
+	)`,
+			`INSERT INTO "Products_unversioned" ("Id", "Name", "Description", "PriceMinor", "DeliveryPriceMinor", "Currency", "DeletedAt")
+	SELECT "Id", "Name", "Description", "PriceMinor", "DeliveryPriceMinor", "Currency", "DeletedAt" FROM "Products"`,
+			`DROP INDEX IF EXISTS "product_id_index"`,
+			`DROP INDEX IF EXISTS "product_deleted_index"`,
+			`DROP TABLE "Products"`,
+			`ALTER TABLE "Products_unversioned" RENAME TO "Products"`,
+			`CREATE INDEX IF NOT EXISTS "product_id_index" ON "Products" (
+	"Name"	ASC
+	)`,
+			`CREATE INDEX IF NOT EXISTS product_deleted_index ON Products (
+	DeletedAt	ASC
+	)`,
+			`CREATE TABLE "ProductOptions_unversioned" (
+	"Id"	varchar(36) DEFAULT NULL,
+	"ProductId"	varchar(36) DEFAULT NULL,
+	"Name"	varchar(9) DEFAULT NULL,
+	"Description"	varchar(23) DEFAULT NULL,
+	"StockOnHand"	bigint NOT NULL DEFAULT 0,
+	"StockReserved"	bigint NOT NULL DEFAULT 0,
+	"StockVersion"	bigint NOT NULL DEFAULT 0,
+	"DeletedAt"	bigint DEFAULT NULL,
+	PRIMARY KEY("Id"),
+	FOREIGN KEY("ProductId") REFERENCES "Products"("Id") ON DELETE CASCADE
+	)`,
+			`INSERT INTO "ProductOptions_unversioned" ("Id", "ProductId", "Name", "Description", "StockOnHand", "StockReserved", "StockVersion", "DeletedAt")
+	SELECT "Id", "ProductId", "Name", "Description", "StockOnHand", "StockReserved", "StockVersion", "DeletedAt" FROM "ProductOptions"`,
+			`DROP INDEX IF EXISTS "product_option_deleted_index"`,
+			`DROP TABLE "ProductOptions"`,
+			`ALTER TABLE "ProductOptions_unversioned" RENAME TO "ProductOptions"`,
+			`CREATE INDEX IF NOT EXISTS product_option_deleted_index ON ProductOptions (
+	DeletedAt	ASC
+	)`,
+		},
+		Dialects: map[string]migrations.Statements{
+			dialect.PostgresName: {
+				Up: []string{
+					`ALTER TABLE Products ADD COLUMN Version bigint NOT NULL DEFAULT 1`,
+					`ALTER TABLE ProductOptions ADD COLUMN Version bigint NOT NULL DEFAULT 1`,
+				},
+				Down: []string{
+					`ALTER TABLE ProductOptions DROP COLUMN Version`,
+					`ALTER TABLE Products DROP COLUMN Version`,
+				},
+			},
+			dialect.MySQLName: {
+				Up: []string{
+					`ALTER TABLE Products ADD COLUMN Version bigint NOT NULL DEFAULT 1`,
+					`ALTER TABLE ProductOptions ADD COLUMN Version bigint NOT NULL DEFAULT 1`,
+				},
+				Down: []string{
+					`ALTER TABLE ProductOptions DROP COLUMN Version`,
+					`ALTER TABLE Products DROP COLUMN Version`,
+				},
+			},
+		},
+	},
+	// Every product, option, category and audit entry belongs to a tenant, the rows created before the tenancy belong to the default tenant
+	// The prices, the reservations and the links of the categories belong to the tenant of their product, option or category
+	// The slugs are unique in a tenant, MaxProducts and MaxOptions are the quotas of the tenant, 0 when unlimited
+	// Reverting drops the rows of the other tenants, SQLite cannot drop a column, the tables are rebuilt
+	{
+		Version: 9,
+		Name:    "tenants",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS Tenants (
+	Id	varchar(64) NOT NULL,
+	Name	varchar(255) NOT NULL,
+	MaxProducts	bigint NOT NULL DEFAULT 0,
+	MaxOptions	bigint NOT NULL DEFAULT 0,
+	CreatedAt	bigint NOT NULL,
+	PRIMARY KEY(Id)
+	)`,
+			`INSERT INTO Tenants (Id, Name, CreatedAt) VALUES ('default', 'Default', 0)`,
+			`ALTER TABLE Products ADD COLUMN TenantId varchar(64) NOT NULL DEFAULT 'default'`,
+			`ALTER TABLE ProductOptions ADD COLUMN TenantId varchar(64) NOT NULL DEFAULT 'default'`,
+			`ALTER TABLE AuditLog ADD COLUMN TenantId varchar(64) NOT NULL DEFAULT 'default'`,
+			`CREATE INDEX IF NOT EXISTS product_tenant_index ON Products (
+	TenantId	ASC,
+	Name	ASC
+	)`,
+			`CREATE INDEX IF NOT EXISTS product_option_tenant_index ON ProductOptions (
+	TenantId	ASC
+	)`,
+			`CREATE TABLE "Categories_tenant" (
+	"Id"	varchar(36) NOT NULL,
+	"ParentId"	varchar(36) DEFAULT NULL,
+	"Slug"	varchar(64) NOT NULL,
+	"Name"	varchar(35) NOT NULL,
+	"SortOrder"	bigint NOT NULL DEFAULT 0,
+	"TenantId"	varchar(64) NOT NULL DEFAULT 'default',
+	PRIMARY KEY("Id"),
+	UNIQUE("TenantId", "Slug"),
+	FOREIGN KEY("ParentId") REFERENCES "Categories"("Id")
+	)`,
+			`INSERT INTO "Categories_tenant" ("Id", "ParentId", "Slug", "Name", "SortOrder")
+	SELECT "Id", "ParentId", "Slug", "Name", "SortOrder" FROM "Categories"`,
+			`DROP INDEX IF EXISTS "category_parent_index"`,
+			`DROP TABLE "Categories"`,
+			`ALTER TABLE "Categories_tenant" RENAME TO "Categories"`,
+			`CREATE INDEX IF NOT EXISTS category_parent_index ON Categories (
+	ParentId	ASC,
+	SortOrder	ASC
+	)`,
+		},
+		Down: []string{
+			`UPDATE Categories SET ParentId = NULL WHERE TenantId <> 'default'`,
+			`DELETE FROM StockReservations WHERE ProductOptionId IN (SELECT Id FROM ProductOptions WHERE TenantId <> 'default')`,
+			`DELETE FROM ProductPrices WHERE ProductId IN (SELECT Id FROM Products WHERE TenantId <> 'default')`,
+			`DELETE FROM ProductCategories WHERE ProductId IN (SELECT Id FROM Products WHERE TenantId <> 'default') OR CategoryId IN (SELECT Id FROM Categories WHERE TenantId <> 'default')`,
+			`DELETE FROM ProductOptions WHERE TenantId <> 'default'`,
+			`DELETE FROM Products WHERE TenantId <> 'default'`,
+			`DELETE FROM Categories WHERE TenantId <> 'default'`,
+			`DELETE FROM AuditLog WHERE TenantId <> 'default'`,
+			`DROP TABLE IF EXISTS Tenants`,
+			`CREATE TABLE "Products_untenanted" (
+	"Id"	varchar(36) DEFAULT NULL,
+	"Name"	varchar(17) DEFAULT NULL,
+	"Description"	varchar(35) DEFAULT NULL,
+	"PriceMinor"	integer NOT NULL DEFAULT 0,
+	"DeliveryPriceMinor"	integer NOT NULL DEFAULT 0,
+	"Currency"	varchar(3) NOT NULL DEFAULT 'NZD',
+	"DeletedAt"	bigint DEFAULT NULL,
+	"Version"	bigint NOT NULL DEFAULT 1,
+	PRIMARY KEY("Id")
+	)`,
+			`INSERT INTO "Products_untenanted" ("Id", "Name", "Description", "PriceMinor", "DeliveryPriceMinor", "Currency", "DeletedAt", "Version")
+	SELECT "Id", "Name", "Description", "PriceMinor", "DeliveryPriceMinor", "Currency", "DeletedAt", "Version" FROM "Products"`,
+			`DROP INDEX IF EXISTS "product_tenant_index"`,
+			`DROP INDEX IF EXISTS "product_id_index"`,
+			`DROP INDEX IF EXISTS "product_deleted_index"`,
+			`DROP TABLE "Products"`,
+			`ALTER TABLE "Products_untenanted" RENAME TO "Products"`,
+			`CREATE INDEX IF NOT EXISTS "product_id_index" ON "Products" (
+	"Name"	ASC
+	)`,
+			`CREATE INDEX IF NOT EXISTS product_deleted_index ON Products (
+	DeletedAt	ASC
+	)`,
+			`CREATE TABLE "ProductOptions_untenanted" (
+	"Id"	varchar(36) DEFAULT NULL,
+	"ProductId"	varchar(36) DEFAULT NULL,
+	"Name"	varchar(9) DEFAULT NULL,
+	"Description"	varchar(23) DEFAULT NULL,
+	"StockOnHand"	bigint NOT NULL DEFAULT 0,
+	"StockReserved"	bigint NOT NULL DEFAULT 0,
+	"StockVersion"	bigint NOT NULL DEFAULT 0,
+	"DeletedAt"	bigint DEFAULT NULL,
+	"Version"	bigint NOT NULL DEFAULT 1,
+	PRIMARY KEY("Id"),
+	FOREIGN KEY("ProductId") REFERENCES "Products"("Id") ON DELETE CASCADE
+	)`,
+			`INSERT INTO "ProductOptions_untenanted" ("Id", "ProductId", "Name", "Description", "StockOnHand", "StockReserved", "StockVersion", "DeletedAt", "Version")
+	SELECT "Id", "ProductId", "Name", "Description", "StockOnHand", "StockReserved", "StockVersion", "DeletedAt", "Version" FROM "ProductOptions"`,
+			`DROP INDEX IF EXISTS "product_option_tenant_index"`,
+			`DROP INDEX IF EXISTS "product_option_deleted_index"`,
+			`DROP TABLE "ProductOptions"`,
+			`ALTER TABLE "ProductOptions_untenanted" RENAME TO "ProductOptions"`,
+			`CREATE INDEX IF NOT EXISTS product_option_deleted_index ON ProductOptions (
+	DeletedAt	ASC
+	)`,
+			`CREATE TABLE "Categories_untenanted" (
+	"Id"	varchar(36) NOT NULL,
+	"ParentId"	varchar(36) DEFAULT NULL,
+	"Slug"	varchar(64) NOT NULL,
+	"Name"	varchar(35) NOT NULL,
+	"SortOrder"	bigint NOT NULL DEFAULT 0,
+	PRIMARY KEY("Id"),
+	UNIQUE("Slug"),
+	FOREIGN KEY("ParentId") REFERENCES "Categories"("Id")
+	)`,
+			`INSERT INTO "Categories_untenanted" ("Id", "ParentId", "Slug", "Name", "SortOrder")
+	SELECT "Id", "ParentId", "Slug", "Name", "SortOrder" FROM "Categories"`,
+			`DROP INDEX IF EXISTS "category_parent_index"`,
+			`DROP TABLE "Categories"`,
+			`ALTER TABLE "Categories_untenanted" RENAME TO "Categories"`,
+			`CREATE INDEX IF NOT EXISTS category_parent_index ON Categories (
+	ParentId	ASC,
+	SortOrder	ASC
+	)`,
+			`CREATE TABLE "AuditLog_untenanted" (
+	"Id"	varchar(36) NOT NULL,
+	"ProductId"	varchar(36) NOT NULL,
+	"Entity"	varchar(16) NOT NULL,
+	"EntityId"	varchar(36) NOT NULL,
+	"Action"	varchar(16) NOT NULL,
+	"Actor"	varchar(255) NOT NULL,
+	"RequestId"	varchar(255) NOT NULL DEFAULT '',
+	"StateBefore"	text DEFAULT NULL,
+	"StateAfter"	text DEFAULT NULL,
+	"CreatedAt"	bigint NOT NULL,
+	PRIMARY KEY("Id")
+	)`,
+			`INSERT INTO "AuditLog_untenanted" ("Id", "ProductId", "Entity", "EntityId", "Action", "Actor", "RequestId", "StateBefore", "StateAfter", "CreatedAt")
+	SELECT "Id", "ProductId", "Entity", "EntityId", "Action", "Actor", "RequestId", "StateBefore", "StateAfter", "CreatedAt" FROM "AuditLog"`,
+			`DROP INDEX IF EXISTS "audit_product_index"`,
+			`DROP TABLE "AuditLog"`,
+			`ALTER TABLE "AuditLog_untenanted" RENAME TO "AuditLog"`,
+			`CREATE INDEX IF NOT EXISTS audit_product_index ON AuditLog (
+	ProductId	ASC,
+	CreatedAt	DESC
+	)`,
+		},
+		Dialects: map[string]migrations.Statements{
+			// The inline unique constraint of the slug is named after the table and the column
+			dialect.PostgresName: {
+				Up: []string{
+					`CREATE TABLE IF NOT EXISTS Tenants (
+	Id	varchar(64) NOT NULL,
+	Name	varchar(255) NOT NULL,
+	MaxProducts	bigint NOT NULL DEFAULT 0,
+	MaxOptions	bigint NOT NULL DEFAULT 0,
+	CreatedAt	bigint NOT NULL,
+	PRIMARY KEY(Id)
+	)`,
+					`INSERT INTO Tenants (Id, Name, CreatedAt) VALUES ('default', 'Default', 0)`,
+					`ALTER TABLE Products ADD COLUMN TenantId varchar(64) NOT NULL DEFAULT 'default'`,
+					`ALTER TABLE ProductOptions ADD COLUMN TenantId varchar(64) NOT NULL DEFAULT 'default'`,
+					`ALTER TABLE Categories ADD COLUMN TenantId varchar(64) NOT NULL DEFAULT 'default'`,
+					`ALTER TABLE AuditLog ADD COLUMN TenantId varchar(64) NOT NULL DEFAULT 'default'`,
+					`CREATE INDEX IF NOT EXISTS product_tenant_index ON Products (
+	TenantId	ASC,
+	Name	ASC
+	)`,
+					`CREATE INDEX IF NOT EXISTS product_option_tenant_index ON ProductOptions (
+	TenantId	ASC
+	)`,
+					`ALTER TABLE Categories DROP CONSTRAINT categories_slug_key`,
+					`ALTER TABLE Categories ADD CONSTRAINT category_slug_index UNIQUE (TenantId, Slug)`,
+				},
+				Down: []string{
+					`UPDATE Categories SET ParentId = NULL WHERE TenantId <> 'default'`,
+					`DELETE FROM StockReservations WHERE ProductOptionId IN (SELECT Id FROM ProductOptions WHERE TenantId <> 'default')`,
+					`DELETE FROM ProductPrices WHERE ProductId IN (SELECT Id FROM Products WHERE TenantId <> 'default')`,
+					`DELETE FROM ProductCategories WHERE ProductId IN (SELECT Id FROM Products WHERE TenantId <> 'default') OR CategoryId IN (SELECT Id FROM Categories WHERE TenantId <> 'default')`,
+					`DELETE FROM ProductOptions WHERE TenantId <> 'default'`,
+					`DELETE FROM Products WHERE TenantId <> 'default'`,
+					`DELETE FROM Categories WHERE TenantId <> 'default'`,
+					`DELETE FROM AuditLog WHERE TenantId <> 'default'`,
+					`DROP TABLE IF EXISTS Tenants`,
+					`ALTER TABLE Categories DROP CONSTRAINT category_slug_index`,
+					`ALTER TABLE Categories ADD CONSTRAINT categories_slug_key UNIQUE (Slug)`,
+					`ALTER TABLE AuditLog DROP COLUMN TenantId`,
+					`ALTER TABLE Categories DROP COLUMN TenantId`,
+					`ALTER TABLE ProductOptions DROP COLUMN TenantId`,
+					`ALTER TABLE Products DROP COLUMN TenantId`,
+				},
+			},
+			// MySQL has no CREATE INDEX IF NOT EXISTS, the indexes are added with the columns, the unique index of the slug is named after the column
+			dialect.MySQLName: {
+				Up: []string{
+					`CREATE TABLE IF NOT EXISTS Tenants (
+	Id	varchar(64) NOT NULL,
+	Name	varchar(255) NOT NULL,
+	MaxProducts	bigint NOT NULL DEFAULT 0,
+	MaxOptions	bigint NOT NULL DEFAULT 0,
+	CreatedAt	bigint NOT NULL,
+	PRIMARY KEY(Id)
+	)`,
+					`INSERT INTO Tenants (Id, Name, CreatedAt) VALUES ('default', 'Default', 0)`,
+					`ALTER TABLE Products ADD COLUMN TenantId varchar(64) NOT NULL DEFAULT 'default', ADD INDEX product_tenant_index (TenantId ASC, Name ASC)`,
+					`ALTER TABLE ProductOptions ADD COLUMN TenantId varchar(64) NOT NULL DEFAULT 'default', ADD INDEX product_option_tenant_index (TenantId ASC)`,
+					`ALTER TABLE Categories ADD COLUMN TenantId varchar(64) NOT NULL DEFAULT 'default', DROP INDEX Slug, ADD UNIQUE category_slug_index (TenantId, Slug)`,
+					`ALTER TABLE AuditLog ADD COLUMN TenantId varchar(64) NOT NULL DEFAULT 'default'`,
+				},
+				Down: []string{
+					`UPDATE Categories SET ParentId = NULL WHERE TenantId <> 'default'`,
+					`DELETE FROM StockReservations WHERE ProductOptionId IN (SELECT Id FROM ProductOptions WHERE TenantId <> 'default')`,
+					`DELETE FROM ProductPrices WHERE ProductId IN (SELECT Id FROM Products WHERE TenantId <> 'default')`,
+					`DELETE FROM ProductCategories WHERE ProductId IN (SELECT Id FROM Products WHERE TenantId <> 'default') OR CategoryId IN (SELECT Id FROM Categories WHERE TenantId <> 'default')`,
+					`DELETE FROM ProductOptions WHERE TenantId <> 'default'`,
+					`DELETE FROM Products WHERE TenantId <> 'default'`,
+					`DELETE FROM Categories WHERE TenantId <> 'default'`,
+					`DELETE FROM AuditLog WHERE TenantId <> 'default'`,
+					`DROP TABLE IF EXISTS Tenants`,
+					`ALTER TABLE AuditLog DROP COLUMN TenantId`,
+					`ALTER TABLE Categories DROP INDEX category_slug_index, ADD UNIQUE Slug (Slug), DROP COLUMN TenantId`,
+					`ALTER TABLE ProductOptions DROP INDEX product_option_tenant_index, DROP COLUMN TenantId`,
+					`ALTER TABLE Products DROP INDEX product_tenant_index, DROP COLUMN TenantId`,
+				},
+			},
+		},
+	},
+	// The pages of the products are read in the order of the name and the id within the tenant, the index serves the order and the keyset of the cursor
+	{
+		Version: 10,
+		Name:    "products_keyset_index",
+		Up: []string{
+			`CREATE INDEX IF NOT EXISTS product_keyset_index ON Products (
+	TenantId	ASC,
+	Name	ASC,
+	Id	ASC
+	)`,
+		},
+		Down: []string{
+			`DROP INDEX IF EXISTS product_keyset_index`,
+		},
+		Dialects: map[string]migrations.Statements{
+			// MySQL has no CREATE INDEX IF NOT EXISTS
+			dialect.MySQLName: {
+				Up: []string{
+					`ALTER TABLE Products ADD INDEX product_keyset_index (TenantId ASC, Name ASC, Id ASC)`,
+				},
+				Down: []string{
+					`ALTER TABLE Products DROP INDEX product_keyset_index`,
+				},
+			},
+		},
+	},
+}
@@ -0,0 +1,238 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.elastic.co/apm/module/apmsql"
+	_ "go.elastic.co/apm/module/apmsql/sqlite3"
+
+	"github.com/techievee/xero/database/dialect"
+	"github.com/techievee/xero/database/migrations"
+	"github.com/techievee/xero/xeroLog/debugcore"
+)
+
+// Test that the decimal prices are converted to minor units and back
+func TestMoneyMigration(t *testing.T) {
+
+	ctx := context.Background()
+	db, err := apmsql.Open("sqlite3", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// Rows priced before the migration
+	m, _ := migrations.NewMigrator(db, dialect.SQLite, schemaMigrations[:1], &debugcore.NoOpsLogger{})
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO Products (Id, Name, Description, Price, DeliveryPrice) VALUES ('a', 'a', 'a', 1229.99, 1.15), ('b', 'b', 'b', 10.5, NULL)`); err != nil {
+		t.Fatal(err)
+	}
+
+	m, _ = migrations.NewMigrator(db, dialect.SQLite, schemaMigrations[:2], &debugcore.NoOpsLogger{})
+	if applied, err := m.Up(ctx); err != nil || applied != 1 {
+		t.Fatalf("Expected the money migration, got %d: %v", applied, err)
+	}
+
+	want := map[string][2]int64{"a": {122999, 115}, "b": {1050, 0}}
+	rows, err := db.Query(`SELECT Id, PriceMinor, DeliveryPriceMinor, Currency FROM Products`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var id, currency string
+		var price, delivery int64
+		rows.Scan(&id, &price, &delivery, &currency)
+		if want[id] != [2]int64{price, delivery} || currency != "NZD" {
+			t.Errorf("Wrong prices for %s: %d %d %s", id, price, delivery, currency)
+		}
+	}
+	rows.Close()
+
+	// Reverting restores the decimal prices
+	if reverted, err := m.Down(ctx, 1); err != nil || reverted != 1 {
+		t.Fatalf("Expected the money migration reverted, got %d: %v", reverted, err)
+	}
+	var price float64
+	if err := db.QueryRow(`SELECT Price FROM Products WHERE Id = 'a'`).Scan(&price); err != nil || price != 1229.99 {
+		t.Errorf("Wrong reverted price %v: %v", price, err)
+	}
+}
+
+// Test that reverting the stock keeps the options, SQLite rebuilds the table without the stock columns
+func TestOptionStockMigration(t *testing.T) {
+
+	ctx := context.Background()
+	db, err := apmsql.Open("sqlite3", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	m, _ := migrations.NewMigrator(db, dialect.SQLite, schemaMigrations[:4], &debugcore.NoOpsLogger{})
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO ProductOptions (Id, ProductId, Name, Description, StockOnHand) VALUES ('o', 'p', 'color', 'Black', 4)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO StockReservations (Id, ProductOptionId, Quantity, Status) VALUES ('r', 'o', 1, 'reserved')`); err != nil {
+		t.Fatal(err)
+	}
+
+	if reverted, err := m.Down(ctx, 1); err != nil || reverted != 1 {
+		t.Fatalf("Expected the stock migration reverted, got %d: %v", reverted, err)
+	}
+	var name string
+	if err := db.QueryRow(`SELECT Name FROM ProductOptions WHERE Id = 'o'`).Scan(&name); err != nil || name != "color" {
+		t.Errorf("Wrong reverted option %v: %v", name, err)
+	}
+	if _, err := db.Exec(`SELECT StockOnHand FROM ProductOptions`); err == nil {
+		t.Errorf("Expected the stock columns dropped")
+	}
+}
+
+// Test that reverting the soft delete keeps the live rows and drops the rows in the trash
+func TestSoftDeleteMigration(t *testing.T) {
+
+	ctx := context.Background()
+	db, err := apmsql.Open("sqlite3", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	m, _ := migrations.NewMigrator(db, dialect.SQLite, schemaMigrations[:6], &debugcore.NoOpsLogger{})
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO Products (Id, Name, PriceMinor, DeletedAt) VALUES ('a', 'live', 100, NULL), ('b', 'deleted', 100, 1)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO ProductOptions (Id, ProductId, Name, StockOnHand, DeletedAt) VALUES ('o', 'a', 'color', 4, NULL), ('d', 'a', 'size', 0, 1)`); err != nil {
+		t.Fatal(err)
+	}
+
+	if reverted, err := m.Down(ctx, 1); err != nil || reverted != 1 {
+		t.Fatalf("Expected the soft delete reverted, got %d: %v", reverted, err)
+	}
+	var products, options, onHand int
+	db.QueryRow(`SELECT (SELECT COUNT(*) FROM Products), (SELECT COUNT(*) FROM ProductOptions), (SELECT StockOnHand FROM ProductOptions WHERE Id = 'o')`).Scan(&products, &options, &onHand)
+	if products != 1 || options != 1 || onHand != 4 {
+		t.Errorf("Expected only the live rows kept, got %d products and %d options with %d on hand", products, options, onHand)
+	}
+	if _, err := db.Exec(`SELECT DeletedAt FROM Products`); err == nil {
+		t.Errorf("Expected the DeletedAt column dropped")
+	}
+}
+
+// Test that the existing rows belong to the default tenant and that reverting drops the rows of the other tenants
+func TestTenantMigration(t *testing.T) {
+
+	ctx := context.Background()
+	db, err := apmsql.Open("sqlite3", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	m, _ := migrations.NewMigrator(db, dialect.SQLite, schemaMigrations[:8], &debugcore.NoOpsLogger{})
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO Products (Id, Name, PriceMinor) VALUES ('a', 'before', 100)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO Categories (Id, Slug, Name) VALUES ('c', 'shoes', 'Shoes')`); err != nil {
+		t.Fatal(err)
+	}
+
+	m, _ = migrations.NewMigrator(db, dialect.SQLite, schemaMigrations[:9], &debugcore.NoOpsLogger{})
+	if applied, err := m.Up(ctx); err != nil || applied != 1 {
+		t.Fatalf("Expected the tenant migration, got %d: %v", applied, err)
+	}
+	var tenant string
+	if err := db.QueryRow(`SELECT TenantId FROM Products WHERE Id = 'a'`).Scan(&tenant); err != nil || tenant != "default" {
+		t.Errorf("Expected the product of the default tenant, got %q: %v", tenant, err)
+	}
+
+	// The slugs are unique in a tenant only
+	if _, err := db.Exec(`INSERT INTO Categories (Id, Slug, Name, TenantId) VALUES ('d', 'shoes', 'Shoes', 'acme')`); err != nil {
+		t.Errorf("Expected the slug of another tenant accepted: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO Categories (Id, Slug, Name, TenantId) VALUES ('e', 'shoes', 'Shoes', 'acme')`); err == nil {
+		t.Errorf("Expected the slug unique in the tenant")
+	}
+	if _, err := db.Exec(`INSERT INTO Products (Id, Name, PriceMinor, TenantId) VALUES ('b', 'acme', 100, 'acme')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO ProductPrices (ProductId, Currency, PriceMinor) VALUES ('b', 'USD', 100)`); err != nil {
+		t.Fatal(err)
+	}
+
+	if reverted, err := m.Down(ctx, 1); err != nil || reverted != 1 {
+		t.Fatalf("Expected the tenant migration reverted, got %d: %v", reverted, err)
+	}
+	var products, categories, prices int
+	db.QueryRow(`SELECT (SELECT COUNT(*) FROM Products), (SELECT COUNT(*) FROM Categories), (SELECT COUNT(*) FROM ProductPrices)`).Scan(&products, &categories, &prices)
+	if products != 1 || categories != 1 || prices != 0 {
+		t.Errorf("Expected only the rows of the default tenant kept, got %d products, %d categories and %d prices", products, categories, prices)
+	}
+	if _, err := db.Exec(`SELECT TenantId FROM Products`); err == nil {
+		t.Errorf("Expected the TenantId column dropped")
+	}
+	if _, err := db.Exec(`INSERT INTO Categories (Id, Slug, Name) VALUES ('f', 'shoes', 'Shoes')`); err == nil {
+		t.Errorf("Expected the slug unique again")
+	}
+}
+
+// Test that the pages of the products in the default order are read from the keyset index, without sorting the rows of the tenant
+func TestProductsKeysetIndex(t *testing.T) {
+
+	ctx := context.Background()
+	db, err := apmsql.Open("sqlite3", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	m, _ := migrations.NewMigrator(db, dialect.SQLite, schemaMigrations[:10], &debugcore.NoOpsLogger{})
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The statements FetchProductsPage runs for the first page and for the page after a cursor
+	for _, stmt := range []string{
+		`SELECT Id, Name, Description, PriceMinor, DeliveryPriceMinor, Currency, Version FROM Products WHERE DeletedAt IS NULL AND TenantId=? ORDER BY Name, Id LIMIT ? OFFSET ?`,
+		`SELECT Id, Name, Description, PriceMinor, DeliveryPriceMinor, Currency, Version FROM Products WHERE DeletedAt IS NULL AND TenantId=? AND ((Name > ?) OR (Name = ? AND Id > ?)) ORDER BY Name, Id LIMIT ? OFFSET ?`,
+	} {
+		rows, err := db.Query("EXPLAIN QUERY PLAN "+stmt, "default", "name", "name", "id", 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var plan []string
+		for rows.Next() {
+			var id, parent, unused int
+			var detail string
+			if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+				t.Fatal(err)
+			}
+			plan = append(plan, detail)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+		if joined := strings.Join(plan, "; "); strings.Contains(joined, "USE TEMP B-TREE") || !strings.Contains(joined, "product_keyset_index") {
+			t.Errorf("Expected the keyset index without sorting, got %s for %s", joined, stmt)
+		}
+	}
+}
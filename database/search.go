@@ -0,0 +1,137 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/techievee/xero/database/dialect"
+)
+
+// The full text index of the products is derived from the Products and ProductOptions tables
+// It is not part of the migrations, FTS5 is only compiled in the sqlite driver with the build tag sqlite_fts5
+// The index is built once from the existing rows and is kept in sync by the triggers from then on
+// A migration rebuilding Products or ProductOptions drops their triggers, the index is then built again at the next startup
+// The options in the trash are left out of the index, the products in the trash are left out by the search
+const (
+	searchIndexTable = `CREATE VIRTUAL TABLE ProductSearch USING fts5(
+	ProductId UNINDEXED,
+	Name,
+	Description,
+	Options,
+	tokenize = 'unicode61',
+	prefix = '2 3'
+	)`
+	searchIndexFill = `INSERT INTO ProductSearch (ProductId, Name, Description, Options)
+	SELECT Id, Name, Description, (SELECT COALESCE(group_concat(o.Name || ' ' || o.Description, ' '), '') FROM ProductOptions o WHERE o.ProductId = Products.Id AND o.DeletedAt IS NULL)
+	FROM Products`
+
+	stmtSearchIndexSchema = `SELECT name, sql FROM sqlite_master WHERE (type = 'table' AND name = 'ProductSearch') OR (type = 'trigger' AND name LIKE 'product_search_%')`
+)
+
+// searchIndexTriggers keep the index in sync with the tables, keyed by the trigger name
+var searchIndexTriggers = []struct{ name, stmt string }{
+	{"product_search_insert", `CREATE TRIGGER product_search_insert AFTER INSERT ON Products BEGIN
+	INSERT INTO ProductSearch (ProductId, Name, Description, Options) VALUES (new.Id, new.Name, new.Description, '');
+	END`},
+	{"product_search_update", `CREATE TRIGGER product_search_update AFTER UPDATE OF Name, Description ON Products BEGIN
+	UPDATE ProductSearch SET Name = new.Name, Description = new.Description WHERE ProductId = old.Id;
+	END`},
+	{"product_search_delete", `CREATE TRIGGER product_search_delete AFTER DELETE ON Products BEGIN
+	DELETE FROM ProductSearch WHERE ProductId = old.Id;
+	END`},
+	{"product_search_option_insert", `CREATE TRIGGER product_search_option_insert AFTER INSERT ON ProductOptions BEGIN
+	UPDATE ProductSearch SET Options = (SELECT COALESCE(group_concat(o.Name || ' ' || o.Description, ' '), '') FROM ProductOptions o WHERE o.ProductId = new.ProductId AND o.DeletedAt IS NULL)
+	WHERE ProductId = new.ProductId;
+	END`},
+	{"product_search_option_update", `CREATE TRIGGER product_search_option_update AFTER UPDATE OF Name, Description, DeletedAt ON ProductOptions BEGIN
+	UPDATE ProductSearch SET Options = (SELECT COALESCE(group_concat(o.Name || ' ' || o.Description, ' '), '') FROM ProductOptions o WHERE o.ProductId = new.ProductId AND o.DeletedAt IS NULL)
+	WHERE ProductId = new.ProductId;
+	END`},
+	{"product_search_option_delete", `CREATE TRIGGER product_search_option_delete AFTER DELETE ON ProductOptions BEGIN
+	UPDATE ProductSearch SET Options = (SELECT COALESCE(group_concat(o.Name || ' ' || o.Description, ' '), '') FROM ProductOptions o WHERE o.ProductId = old.ProductId AND o.DeletedAt IS NULL)
+	WHERE ProductId = old.ProductId;
+	END`},
+}
+
+// EnsureSearchIndex makes sure the full text index of the products exists
+// The index is only built when the table or one of its triggers is missing, or differs from the one of this version
+// With create false, the index is only looked for, it is never built
+// Returns false when the database cannot hold the index, the search is then unavailable but the service still serves
+func (d *DB) EnsureSearchIndex(ctx context.Context, create bool) (bool, error) {
+
+	if d.Dialect != nil && d.Dialect.Name() != dialect.SQLiteName {
+		return false, nil
+	}
+
+	current, err := d.searchIndexCurrent(ctx)
+	if err != nil {
+		return false, err
+	}
+	if current || !create {
+		return current, nil
+	}
+
+	err = d.InTx(ctx, func(tx *sql.Tx) error {
+		stmts := []string{}
+		for _, trigger := range searchIndexTriggers {
+			stmts = append(stmts, "DROP TRIGGER IF EXISTS "+trigger.name)
+		}
+		stmts = append(stmts, `DROP TABLE IF EXISTS ProductSearch`, searchIndexTable, searchIndexFill)
+		for _, trigger := range searchIndexTriggers {
+			stmts = append(stmts, trigger.stmt)
+		}
+
+		for _, stmt := range stmts {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+		d.Logger.Warn("The sqlite driver is built without FTS5, the product search is unavailable. Build with -tags sqlite_fts5 to enable it")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	d.Logger.Info("Built the product search index")
+	return true, nil
+}
+
+// Returns true when the index table and all its triggers exist as this version creates them
+// sqlite keeps the text of the CREATE statements in sqlite_master, they are compared as they are
+func (d *DB) searchIndexCurrent(ctx context.Context) (bool, error) {
+
+	rows, err := d.RW(ctx).QueryContext(ctx, stmtSearchIndexSchema)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	schema := map[string]string{}
+	for rows.Next() {
+		var name string
+		var stmt sql.NullString
+		if err := rows.Scan(&name, &stmt); err != nil {
+			return false, err
+		}
+		schema[name] = stmt.String
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	if schema["ProductSearch"] != searchIndexTable {
+		return false, nil
+	}
+	for _, trigger := range searchIndexTriggers {
+		if schema[trigger.name] != trigger.stmt {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
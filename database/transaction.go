@@ -0,0 +1,33 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// InTx runs fn in a transaction of the read write connection
+// The transaction is committed when fn returns nil, and rolled back when fn returns an error or panics
+// Statements of fn must go through tx, the read write connection may have a single connection open
+func (d *DB) InTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+
+	tx, err := d.RW(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			d.Logger.Error("Error while rolling back the transaction", "error", rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,309 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.elastic.co/apm"
+
+	"github.com/techievee/xero/productService/models"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// The states are read in the transaction of the change, the rows in the trash included
+const (
+	stmtInsertAuditLog = "INSERT INTO  AuditLog (Id, ProductId, Entity, EntityId, Action, Actor, RequestId, StateBefore, StateAfter, CreatedAt, TenantId) VALUES (?,?,?,?,?,?,?,?,?,?,?)"
+	stmtAuditLog       = "SELECT Id, ProductId, Entity, EntityId, Action, Actor, RequestId, StateBefore, StateAfter, CreatedAt FROM AuditLog WHERE ProductId=? AND TenantId=? ORDER BY CreatedAt DESC, Id LIMIT ? OFFSET ?"
+	stmtCountAuditLog  = "SELECT COUNT(*) FROM AuditLog WHERE ProductId=? AND TenantId=?"
+	stmtProductState   = "SELECT Id, Name, Description, PriceMinor, DeliveryPriceMinor, Currency, DeletedAt, Version FROM Products WHERE Id=? AND TenantId=?"
+	stmtOptionStates   = "SELECT Id, ProductId, Name, Description, DeletedAt, Version FROM ProductOptions WHERE ProductId=? AND TenantId=?"
+	stmtCategoryLinks  = "SELECT CategoryId FROM ProductCategories WHERE ProductId=? AND ProductId IN (" + stmtTenantProductIDs + ")"
+	stmtCategoryLinked = "SELECT ProductId FROM ProductCategories WHERE CategoryId=? AND CategoryId IN (" + stmtTenantCategoryIDs + ")"
+)
+
+// Size of the Actor and the RequestId columns, the longer values are cut
+const maxAuditText = 255
+
+// auditChange is one change of a product to record, the states are marshalled to JSON and nil is recorded as NULL
+// The ids are recorded as they are stored, the product id in lower case
+type auditChange struct {
+	productID string
+	entity    string
+	entityID  string
+	action    string
+	before    interface{}
+	after     interface{}
+}
+
+// Returns one page of the history of the product, the last change first, and the total number of entries of the product
+// The entries of the same change have the same time
+func (c *ProductsCmds) FetchProductHistory(ctx context.Context, pID string, limit int, offset int) ([]models.DBAuditLog, int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "product_history.show", "db")
+	span.SpanData.Context.SetTag("span", "FetchProductHistory")
+	defer span.End()
+
+	pID = strings.ToLower(pID)
+
+	db := c.DB.RO(ctx)
+	tenant := xeroHelper.TenantFrom(ctx)
+
+	var total int64
+	if err := db.QueryRowContext(ctx, c.sql(stmtCountAuditLog), pID, tenant).Scan(&total); err != nil {
+		c.Logger.Error("Error while counting the product history", "error", err)
+		return nil, 0, err
+	}
+
+	rows, err := db.QueryContext(ctx, c.sql(stmtAuditLog), pID, tenant, limit, offset)
+	if err != nil {
+		c.Logger.Error("Error while fetching the product history", "error", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	result := []models.DBAuditLog{}
+	for rows.Next() {
+		dbObj := models.DBAuditLog{}
+		if err := rows.Scan(&dbObj.DBID, &dbObj.DBProductID, &dbObj.DBEntity, &dbObj.DBEntityID, &dbObj.DBAction, &dbObj.DBActor, &dbObj.DBRequestID, &dbObj.DBBefore, &dbObj.DBAfter, &dbObj.DBCreatedAt); err != nil {
+			c.Logger.Error("Error while scanning rows", "error", err)
+			return nil, 0, err
+		}
+		result = append(result, dbObj)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger.Error("Error while scanning rows", "error", err)
+		return nil, 0, err
+	}
+
+	c.Logger.Debug("Fetched the product history", "total_rows", len(result), "total", total)
+	return result, total, nil
+}
+
+// audit records the changes in the transaction that made them, the changes and their entries are committed or rolled back together
+// The entries belong to the tenant of ctx, same as the changed rows
+func (c *ProductsCmds) audit(ctx context.Context, tx *sql.Tx, at time.Time, changes ...auditChange) error {
+
+	tenant := xeroHelper.TenantFrom(ctx)
+	for _, change := range changes {
+		row, err := auditRow(ctx, at, change)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, c.sql(stmtInsertAuditLog), row.DBID, row.DBProductID, row.DBEntity, row.DBEntityID, row.DBAction, row.DBActor, row.DBRequestID, row.DBBefore, row.DBAfter, row.DBCreatedAt, tenant); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the state of the product, sql.ErrNoRows when it does not exist or it belongs to another tenant
+func (c *ProductsCmds) productState(ctx context.Context, tx *sql.Tx, pID string) (models.DBProducts, error) {
+
+	p := models.DBProducts{}
+	err := tx.QueryRowContext(ctx, c.sql(stmtProductState), pID, xeroHelper.TenantFrom(ctx)).
+		Scan(&p.DBID, &p.DBName, &p.DBDescription, &p.DBPrice, &p.DBDeliveryPrice, &p.DBCurrency, &p.DBDeletedAt, &p.DBVersion)
+	return p, err
+}
+
+// Returns the states of the options of the product matching the clause, the clause starts with AND
+func (c *ProductsCmds) optionStates(ctx context.Context, tx *sql.Tx, pID string, clause string, params ...interface{}) ([]models.DBProductOptions, error) {
+
+	rows, err := tx.QueryContext(ctx, c.sql(stmtOptionStates+clause), append([]interface{}{pID, xeroHelper.TenantFrom(ctx)}, params...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []models.DBProductOptions
+	for rows.Next() {
+		o := models.DBProductOptions{}
+		if err := rows.Scan(&o.DBID, &o.DBProductID, &o.DBName, &o.DBDescription, &o.DBDeletedAt, &o.DBVersion); err != nil {
+			return nil, err
+		}
+		result = append(result, o)
+	}
+	return result, rows.Err()
+}
+
+// Returns the states of the prices of the product, only the price in the currency when it is specified
+func (c *ProductsCmds) priceStates(ctx context.Context, tx *sql.Tx, pID string, currency string) ([]models.DBProductPrices, error) {
+
+	params := []interface{}{pID, xeroHelper.TenantFrom(ctx)}
+	stmt := stmtProductPrices + " WHERE ProductId=? AND ProductId IN (" + stmtTenantProductIDs + ")"
+	if currency != "" {
+		stmt += " AND Currency=?"
+		params = append(params, currency)
+	}
+
+	rows, err := tx.QueryContext(ctx, c.sql(stmt), params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []models.DBProductPrices
+	for rows.Next() {
+		p := models.DBProductPrices{}
+		if err := rows.Scan(&p.DBProductID, &p.DBCurrency, &p.DBPrice, &p.DBDeliveryPrice); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+// Returns the first column of the rows of the statement, the ids of the links of the products to the categories
+// The statement takes the id and the tenant of ctx
+func (c *ProductsCmds) linkStates(ctx context.Context, tx *sql.Tx, stmt string, id string) ([]string, error) {
+
+	rows, err := tx.QueryContext(ctx, c.sql(stmt), strings.ToLower(id), xeroHelper.TenantFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var linked string
+		if err := rows.Scan(&linked); err != nil {
+			return nil, err
+		}
+		result = append(result, linked)
+	}
+	return result, rows.Err()
+}
+
+// auditRow returns the entry of the change made at the time by the actor of ctx
+func auditRow(ctx context.Context, at time.Time, change auditChange) (models.DBAuditLog, error) {
+
+	actor := xeroHelper.ActorFrom(ctx)
+	row := models.DBAuditLog{
+		DBID:        sql.NullString{String: uuid.New().String(), Valid: true},
+		DBProductID: sql.NullString{String: strings.ToLower(change.productID), Valid: true},
+		DBEntity:    sql.NullString{String: change.entity, Valid: true},
+		DBEntityID:  sql.NullString{String: change.entityID, Valid: true},
+		DBAction:    sql.NullString{String: change.action, Valid: true},
+		DBActor:     sql.NullString{String: truncate(actor.Name, maxAuditText), Valid: true},
+		DBRequestID: sql.NullString{String: truncate(actor.RequestID, maxAuditText), Valid: true},
+		DBCreatedAt: sql.NullInt64{Int64: at.UnixNano() / int64(time.Millisecond), Valid: true},
+	}
+
+	for _, state := range []struct {
+		value interface{}
+		dest  *sql.NullString
+	}{{change.before, &row.DBBefore}, {change.after, &row.DBAfter}} {
+		if state.value == nil {
+			continue
+		}
+		payload, err := json.Marshal(state.value)
+		if err != nil {
+			return row, err
+		}
+		*state.dest = sql.NullString{String: string(payload), Valid: true}
+	}
+
+	return row, nil
+}
+
+func productChange(action string, before *models.DBProducts, after *models.DBProducts) auditChange {
+	change := auditChange{entity: models.AuditProduct, action: action}
+	if before != nil {
+		change.productID, change.before = before.DBID.String, models.NewProduct(*before)
+	}
+	if after != nil {
+		change.productID, change.after = after.DBID.String, models.NewProduct(*after)
+	}
+	change.entityID = change.productID
+	return change
+}
+
+func optionChange(action string, before *models.DBProductOptions, after *models.DBProductOptions) auditChange {
+	change := auditChange{entity: models.AuditOption, action: action}
+	if before != nil {
+		change.productID, change.entityID, change.before = before.DBProductID.String, before.DBID.String, models.NewProductOption(*before)
+	}
+	if after != nil {
+		change.productID, change.entityID, change.after = after.DBProductID.String, after.DBID.String, models.NewProductOption(*after)
+	}
+	return change
+}
+
+func priceChange(action string, before *models.DBProductPrices, after *models.DBProductPrices) auditChange {
+	change := auditChange{entity: models.AuditPrice, action: action}
+	if before != nil {
+		change.productID, change.entityID, change.before = before.DBProductID.String, before.DBCurrency.String, models.NewProductPrice(*before)
+	}
+	if after != nil {
+		change.productID, change.entityID, change.after = after.DBProductID.String, after.DBCurrency.String, models.NewProductPrice(*after)
+	}
+	return change
+}
+
+func stockChange(action string, pID string, pOptionID string, before models.DBStock, after models.DBStock) auditChange {
+	return auditChange{
+		productID: pID,
+		entity:    models.AuditStock,
+		entityID:  strings.ToLower(pOptionID),
+		action:    action,
+		before:    models.NewStock(before),
+		after:     models.NewStock(after),
+	}
+}
+
+// A new link only has a state after the change, a removed link only has a state before the change
+func categoryChange(action string, pID string, categoryID string) auditChange {
+	change := auditChange{productID: pID, entity: models.AuditCategory, entityID: strings.ToLower(categoryID), action: action}
+	link := models.ProductCategoryLink{CategoryID: change.entityID}
+	if action == models.AuditCreate {
+		change.after = link
+	} else {
+		change.before = link
+	}
+	return change
+}
+
+// Returns the row of the new product, the rows start at version 1 same as the default of the column
+func productRow(id string, product models.Product) models.DBProducts {
+	return models.DBProducts{
+		DBID:            sql.NullString{String: id, Valid: true},
+		DBName:          sql.NullString{String: product.Name, Valid: true},
+		DBDescription:   sql.NullString{String: product.Description, Valid: true},
+		DBPrice:         sql.NullInt64{Int64: product.Price.Amount, Valid: true},
+		DBDeliveryPrice: sql.NullInt64{Int64: product.DeliveryPrice.Amount, Valid: true},
+		DBCurrency:      sql.NullString{String: product.PriceCurrency(), Valid: true},
+		DBVersion:       sql.NullInt64{Int64: 1, Valid: true},
+	}
+}
+
+// Returns the row of the new option of the product
+func optionRow(id string, pID string, option models.ProductOption) models.DBProductOptions {
+	return models.DBProductOptions{
+		DBID:          sql.NullString{String: id, Valid: true},
+		DBProductID:   sql.NullString{String: pID, Valid: true},
+		DBName:        sql.NullString{String: option.Name, Valid: true},
+		DBDescription: sql.NullString{String: option.Description, Valid: true},
+		DBVersion:     sql.NullInt64{Int64: 1, Valid: true},
+	}
+}
+
+// Returns the row of the price of the product
+func priceRow(pID string, price models.ProductPrice) models.DBProductPrices {
+	return models.DBProductPrices{
+		DBProductID:     sql.NullString{String: strings.ToLower(pID), Valid: true},
+		DBCurrency:      sql.NullString{String: price.Currency, Valid: true},
+		DBPrice:         sql.NullInt64{Int64: price.Price.Amount, Valid: true},
+		DBDeliveryPrice: sql.NullInt64{Int64: price.DeliveryPrice.Amount, Valid: true},
+	}
+}
+
+func truncate(s string, size int) string {
+	if len(s) > size {
+		return s[:size]
+	}
+	return s
+}
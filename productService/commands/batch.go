@@ -0,0 +1,408 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.elastic.co/apm"
+
+	"github.com/techievee/xero/database/dialect"
+	"github.com/techievee/xero/productService/models"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// The rows are only read in the tenant, an upsert creates the row of an id the tenant does not have
+// The ids are unique across the tenants, the insert of an id taken by another tenant inserts nothing and the upsert is refused with ErrIDTaken
+const (
+	stmtOptionProduct = "SELECT ProductId FROM ProductOptions WHERE Id=? AND TenantId=?"
+)
+
+var (
+	productColumns = []string{"Id", "Name", "Description", "PriceMinor", "DeliveryPriceMinor", "Currency", "TenantId"}
+	optionColumns  = []string{"Id", "ProductId", "Name", "Description", "TenantId"}
+	idKeys         = []string{"Id"}
+)
+
+var (
+	// ErrUnknownProduct is returned when the product of the operation does not exist or is in the trash
+	ErrUnknownProduct = errors.New("unknown product")
+	// ErrInTrash is returned when an upsert targets a product or an option in the trash, it must be restored to be changed
+	ErrInTrash = errors.New("product or option is in the trash")
+	// ErrIDTaken is returned when an upsert creates a row with an id that is taken, by an option of another product or by a row the tenant cannot see
+	ErrIDTaken = errors.New("id is taken")
+	// ErrBatchRolledBack is the outcome of the operations of an atomic batch that another operation rolled back
+	ErrBatchRolledBack = errors.New("batch was rolled back")
+
+	// errBatchRefused rolls back the transaction of an atomic batch when one of its operations is refused
+	errBatchRefused = errors.New("batch operation refused")
+)
+
+// BatchOutcome is the result of one operation of a batch
+// ID is the product or the option changed, OptionIDs the options created along with a product
+// Created is true when the operation inserted the row, an upsert of an existing row updates it
+// Err refuses the operation with ErrUnknownProduct, ErrUnknownProductOption, ErrInTrash, ErrIDTaken, ErrVersionMismatch, ErrQuotaExceeded or ErrBatchRolledBack,
+// any other error is the failure of the transaction of the operation
+type BatchOutcome struct {
+	ID        string
+	OptionIDs []string
+	Created   bool
+	Err       error
+}
+
+// batchTx runs the operations of one transaction of a batch, each statement is prepared once for all of them
+// The changes are recorded when the operations are done, with the same time
+type batchTx struct {
+	tx       *sql.Tx
+	prepared map[string]*sql.Stmt
+	changes  []auditChange
+	now      time.Time
+}
+
+// ApplyBatch applies the operations in their order, in transactions of chunkSize operations on the read write connection
+// An atomic batch runs in a single transaction, the first refused operation rolls back all the others and the operations after it are not tried
+// Otherwise a refused operation does not stop the others, and the failure of a transaction only fails the operations of its chunk
+// Returns one outcome per operation, an error only when the transaction of an atomic batch fails
+func (c *ProductsCmds) ApplyBatch(ctx context.Context, ops []models.BatchOperation, atomic bool, chunkSize int) ([]BatchOutcome, error) {
+
+	span, ctx := apm.StartSpan(ctx, "products.batch", "db")
+	span.SpanData.Context.SetTag("span", "ApplyBatch")
+	defer span.End()
+
+	if atomic || chunkSize <= 0 {
+		chunkSize = len(ops)
+	}
+
+	outcomes := make([]BatchOutcome, len(ops))
+	for start := 0; start < len(ops); start += chunkSize {
+		end := start + chunkSize
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+			b := &batchTx{tx: tx, prepared: map[string]*sql.Stmt{}, now: time.Now()}
+			defer b.close()
+
+			for i := start; i < end; i++ {
+				outcome, err := c.batchOperation(ctx, b, ops[i])
+				if err != nil {
+					return err
+				}
+				outcomes[i] = outcome
+				if outcome.Err != nil && atomic {
+					return errBatchRefused
+				}
+			}
+			return c.batchAudit(ctx, b)
+		})
+
+		switch {
+		case err == errBatchRefused:
+			rolledBack(outcomes, ops)
+			c.Logger.Debug("Rolled back the batch", "operations", len(ops))
+			return outcomes, nil
+		case err != nil && atomic:
+			c.Logger.Error("Error while applying the batch", "error", err)
+			return nil, err
+		case err != nil:
+			c.Logger.Error("Error while applying the chunk of the batch", "error", err, "from", start, "to", end)
+			for i := start; i < end; i++ {
+				if outcomes[i].Err == nil {
+					outcomes[i] = BatchOutcome{ID: ops[i].ID, Err: err}
+				}
+			}
+		}
+	}
+
+	c.Logger.Debug("Applied the batch", "operations", len(ops), "atomic", atomic)
+	return outcomes, nil
+}
+
+// batchOperation applies the operation in the transaction, the outcome holds the error when the operation is refused
+// The returned error is the failure of the transaction
+func (c *ProductsCmds) batchOperation(ctx context.Context, b *batchTx, op models.BatchOperation) (BatchOutcome, error) {
+
+	op.ID, op.ProductID = strings.ToLower(op.ID), strings.ToLower(op.ProductID)
+	if !op.IsOption() {
+		switch op.Op {
+		case models.BatchCreate:
+			return c.batchInsertProduct(ctx, b, uuid.New().String(), *op.Product)
+		case models.BatchUpsert:
+			return c.batchUpsertProduct(ctx, b, op.ID, *op.Product, op.Version)
+		default:
+			return c.batchDeleteProduct(ctx, b, op.ID)
+		}
+	}
+
+	product, err := c.productState(ctx, b.tx, op.ProductID)
+	if err == sql.ErrNoRows || (err == nil && product.DBDeletedAt.Valid) {
+		return BatchOutcome{ID: op.ID, Err: ErrUnknownProduct}, nil
+	} else if err != nil {
+		return BatchOutcome{}, err
+	}
+
+	switch op.Op {
+	case models.BatchCreate:
+		return c.batchInsertOption(ctx, b, uuid.New().String(), product.DBID.String, *op.Option)
+	case models.BatchUpsert:
+		return c.batchUpsertOption(ctx, b, op.ID, product.DBID.String, *op.Option, op.Version)
+	default:
+		return c.batchDeleteOption(ctx, b, op.ID, product.DBID.String)
+	}
+}
+
+// The quota counts the rows inserted by the operations before it in the transaction
+func (c *ProductsCmds) batchInsertProduct(ctx context.Context, b *batchTx, id string, product models.Product) (BatchOutcome, error) {
+
+	if err := c.checkQuota(ctx, b.tx, 1, int64(len(product.Options))); err == ErrQuotaExceeded {
+		return BatchOutcome{ID: id, Err: err}, nil
+	} else if err != nil {
+		return BatchOutcome{}, err
+	}
+
+	tenant := xeroHelper.TenantFrom(ctx)
+	row := productRow(id, product)
+	result, err := b.execSQL(ctx, c.insertIfAbsent("Products", productColumns), row.DBID, row.DBName, row.DBDescription, row.DBPrice, row.DBDeliveryPrice, row.DBCurrency, tenant)
+	if err != nil {
+		return BatchOutcome{}, err
+	}
+	if !changed(result) {
+		return BatchOutcome{ID: id, Err: ErrIDTaken}, nil
+	}
+	b.changes = append(b.changes, productChange(models.AuditCreate, nil, &row))
+
+	outcome := BatchOutcome{ID: row.DBID.String, Created: true}
+	for _, option := range product.Options {
+		optionState := optionRow(uuid.New().String(), row.DBID.String, option)
+		if _, err := b.exec(ctx, c, stmtInsertProductOption, optionState.DBID, optionState.DBProductID, optionState.DBName, optionState.DBDescription, tenant); err != nil {
+			return BatchOutcome{}, err
+		}
+		outcome.OptionIDs = append(outcome.OptionIDs, optionState.DBID.String)
+		b.changes = append(b.changes, optionChange(models.AuditCreate, nil, &optionState))
+	}
+	return outcome, nil
+}
+
+// With a version the upsert is refused when the product is not at that version, with version 0 when it exists
+func (c *ProductsCmds) batchUpsertProduct(ctx context.Context, b *batchTx, id string, product models.Product, version *int64) (BatchOutcome, error) {
+
+	before, err := c.productState(ctx, b.tx, id)
+	if err == sql.ErrNoRows {
+		if version != nil && *version != 0 {
+			return BatchOutcome{ID: id, Err: ErrVersionMismatch}, nil
+		}
+		return c.batchInsertProduct(ctx, b, id, product)
+	} else if err != nil {
+		return BatchOutcome{}, err
+	}
+	if before.DBDeletedAt.Valid {
+		return BatchOutcome{ID: id, Err: ErrInTrash}, nil
+	}
+	if version != nil && *version != before.DBVersion.Int64 {
+		return BatchOutcome{ID: id, Err: ErrVersionMismatch}, nil
+	}
+
+	result, err := b.exec(ctx, c, stmtUpdateProduct, product.Name, product.Description, product.Price.Amount, product.DeliveryPrice.Amount, product.PriceCurrency(), id, xeroHelper.TenantFrom(ctx), before.DBVersion.Int64)
+	if err != nil || !changed(result) {
+		return mismatch(id, err)
+	}
+	after := productRow(before.DBID.String, product)
+	after.DBVersion = sql.NullInt64{Int64: before.DBVersion.Int64 + 1, Valid: true}
+	b.changes = append(b.changes, productChange(models.AuditUpdate, &before, &after))
+	return BatchOutcome{ID: before.DBID.String}, nil
+}
+
+// Same as DeleteProduct, the options go to the trash with the product
+func (c *ProductsCmds) batchDeleteProduct(ctx context.Context, b *batchTx, id string) (BatchOutcome, error) {
+
+	before, err := c.productState(ctx, b.tx, id)
+	if err == sql.ErrNoRows || (err == nil && before.DBDeletedAt.Valid) {
+		return BatchOutcome{ID: id, Err: ErrUnknownProduct}, nil
+	} else if err != nil {
+		return BatchOutcome{}, err
+	}
+	options, err := c.optionStates(ctx, b.tx, id, " AND DeletedAt IS NULL")
+	if err != nil {
+		return BatchOutcome{}, err
+	}
+
+	deletedAt := sql.NullInt64{Int64: models.DeletedAtMillis(b.now), Valid: true}
+	result, err := b.exec(ctx, c, stmtDeleteProduct, deletedAt, id, xeroHelper.TenantFrom(ctx), before.DBVersion.Int64)
+	if err != nil || !changed(result) {
+		return mismatch(id, err)
+	}
+	if _, err := b.exec(ctx, c, stmtDeleteProductOptions, deletedAt, id, xeroHelper.TenantFrom(ctx)); err != nil {
+		return BatchOutcome{}, err
+	}
+
+	after := before
+	after.DBDeletedAt = deletedAt
+	after.DBVersion.Int64++
+	b.changes = append(b.changes, productChange(models.AuditDelete, &before, &after))
+	for i := range options {
+		optionAfter := options[i]
+		optionAfter.DBDeletedAt = deletedAt
+		optionAfter.DBVersion.Int64++
+		b.changes = append(b.changes, optionChange(models.AuditDelete, &options[i], &optionAfter))
+	}
+	return BatchOutcome{ID: before.DBID.String}, nil
+}
+
+func (c *ProductsCmds) batchInsertOption(ctx context.Context, b *batchTx, id string, pID string, option models.ProductOption) (BatchOutcome, error) {
+
+	if err := c.checkQuota(ctx, b.tx, 0, 1); err == ErrQuotaExceeded {
+		return BatchOutcome{ID: id, Err: err}, nil
+	} else if err != nil {
+		return BatchOutcome{}, err
+	}
+
+	row := optionRow(id, pID, option)
+	result, err := b.execSQL(ctx, c.insertIfAbsent("ProductOptions", optionColumns), row.DBID, row.DBProductID, row.DBName, row.DBDescription, xeroHelper.TenantFrom(ctx))
+	if err != nil {
+		return BatchOutcome{}, err
+	}
+	if !changed(result) {
+		return BatchOutcome{ID: id, Err: ErrIDTaken}, nil
+	}
+	b.changes = append(b.changes, optionChange(models.AuditCreate, nil, &row))
+	return BatchOutcome{ID: row.DBID.String, Created: true}, nil
+}
+
+// The id of the option is unique across the products, an upsert cannot take the id of the option of another product
+// The owner is only read in the tenant, the id of an option of another tenant is refused by the insert with ErrIDTaken
+// The version is checked the same as batchUpsertProduct
+func (c *ProductsCmds) batchUpsertOption(ctx context.Context, b *batchTx, id string, pID string, option models.ProductOption, version *int64) (BatchOutcome, error) {
+
+	var owner string
+	err := b.tx.QueryRowContext(ctx, c.sql(stmtOptionProduct), id, xeroHelper.TenantFrom(ctx)).Scan(&owner)
+	if err == sql.ErrNoRows {
+		if version != nil && *version != 0 {
+			return BatchOutcome{ID: id, Err: ErrVersionMismatch}, nil
+		}
+		return c.batchInsertOption(ctx, b, id, pID, option)
+	} else if err != nil {
+		return BatchOutcome{}, err
+	}
+	if !strings.EqualFold(owner, pID) {
+		return BatchOutcome{ID: id, Err: ErrIDTaken}, nil
+	}
+
+	before, err := c.optionStates(ctx, b.tx, pID, " AND Id=?", id)
+	if err != nil || len(before) == 0 {
+		return BatchOutcome{}, err
+	}
+	if before[0].DBDeletedAt.Valid {
+		return BatchOutcome{ID: id, Err: ErrInTrash}, nil
+	}
+	if version != nil && *version != before[0].DBVersion.Int64 {
+		return BatchOutcome{ID: id, Err: ErrVersionMismatch}, nil
+	}
+
+	result, err := b.exec(ctx, c, stmtUpdateProductOption, option.Name, option.Description, id, pID, xeroHelper.TenantFrom(ctx), before[0].DBVersion.Int64)
+	if err != nil || !changed(result) {
+		return mismatch(id, err)
+	}
+	after := optionRow(before[0].DBID.String, before[0].DBProductID.String, option)
+	after.DBVersion = sql.NullInt64{Int64: before[0].DBVersion.Int64 + 1, Valid: true}
+	b.changes = append(b.changes, optionChange(models.AuditUpdate, &before[0], &after))
+	return BatchOutcome{ID: before[0].DBID.String}, nil
+}
+
+func (c *ProductsCmds) batchDeleteOption(ctx context.Context, b *batchTx, id string, pID string) (BatchOutcome, error) {
+
+	before, err := c.optionStates(ctx, b.tx, pID, " AND Id=? AND DeletedAt IS NULL", id)
+	if err != nil {
+		return BatchOutcome{}, err
+	}
+	if len(before) == 0 {
+		return BatchOutcome{ID: id, Err: ErrUnknownProductOption}, nil
+	}
+
+	deletedAt := sql.NullInt64{Int64: models.DeletedAtMillis(b.now), Valid: true}
+	result, err := b.exec(ctx, c, stmtDeleteProductOption, deletedAt, id, pID, xeroHelper.TenantFrom(ctx), before[0].DBVersion.Int64)
+	if err != nil || !changed(result) {
+		return mismatch(id, err)
+	}
+	after := before[0]
+	after.DBDeletedAt = deletedAt
+	after.DBVersion.Int64++
+	b.changes = append(b.changes, optionChange(models.AuditDelete, &before[0], &after))
+	return BatchOutcome{ID: before[0].DBID.String}, nil
+}
+
+// batchAudit records the changes of the operations of the transaction
+func (c *ProductsCmds) batchAudit(ctx context.Context, b *batchTx) error {
+
+	for _, change := range b.changes {
+		row, err := auditRow(ctx, b.now, change)
+		if err != nil {
+			return err
+		}
+		if _, err := b.exec(ctx, c, stmtInsertAuditLog, row.DBID, row.DBProductID, row.DBEntity, row.DBEntityID, row.DBAction, row.DBActor, row.DBRequestID, row.DBBefore, row.DBAfter, row.DBCreatedAt, xeroHelper.TenantFrom(ctx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exec runs the statement, it is prepared in the transaction the first time it runs
+func (b *batchTx) exec(ctx context.Context, c *ProductsCmds, stmt string, args ...interface{}) (sql.Result, error) {
+	return b.execSQL(ctx, c.sql(stmt), args...)
+}
+
+// execSQL runs the statement already in the dialect of the database
+func (b *batchTx) execSQL(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+
+	prepared, ok := b.prepared[query]
+	if !ok {
+		var err error
+		if prepared, err = b.tx.PrepareContext(ctx, query); err != nil {
+			return nil, err
+		}
+		b.prepared[query] = prepared
+	}
+	return prepared.ExecContext(ctx, args...)
+}
+
+// insertIfAbsent returns the statement inserting a row of the table in the dialect of the database, it affects no row when the id is taken
+func (c *ProductsCmds) insertIfAbsent(table string, columns []string) string {
+	d := c.DB.Dialect
+	if d == nil {
+		d = dialect.SQLite
+	}
+	return d.InsertIfAbsent(table, columns, idKeys)
+}
+
+func (b *batchTx) close() {
+	for _, prepared := range b.prepared {
+		prepared.Close()
+	}
+}
+
+// changed tells if the change guarded by the version read updated the row
+func changed(result sql.Result) bool {
+	affectedRows, _ := result.RowsAffected()
+	return affectedRows != 0
+}
+
+// mismatch returns the failure of the change, the outcome is refused with ErrVersionMismatch when another connection changed the row since it was read
+func mismatch(id string, err error) (BatchOutcome, error) {
+	if err != nil {
+		return BatchOutcome{}, err
+	}
+	return BatchOutcome{ID: id, Err: ErrVersionMismatch}, nil
+}
+
+// rolledBack sets the outcome of the operations of an atomic batch that were not refused, none of them was applied
+func rolledBack(outcomes []BatchOutcome, ops []models.BatchOperation) {
+	for i := range outcomes {
+		if outcomes[i].Err == nil {
+			outcomes[i] = BatchOutcome{ID: ops[i].ID, Err: ErrBatchRolledBack}
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.elastic.co/apm"
+
+	"github.com/techievee/xero/productService/models"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// A product is only linked to the categories of its tenant, the link is inserted only when both belong to the tenant
+const (
+	stmtCategories                 = "SELECT Id, ParentId, Slug, Name, SortOrder FROM Categories WHERE TenantId=?"
+	stmtCategoriesOrder            = " ORDER BY SortOrder, Name, Id"
+	stmtInsertCategory             = "INSERT INTO  Categories (Id, ParentId, Slug, Name, SortOrder, TenantId) VALUES (?,?,?,?,?,?)"
+	stmtUpdateCategory             = "UPDATE Categories SET ParentId=?, Slug=?, Name=?, SortOrder=? WHERE Id=? AND TenantId=?"
+	stmtDeleteCategory             = "DELETE FROM Categories WHERE Id=? AND TenantId=?"
+	stmtCategoryChildren           = "SELECT Id FROM Categories WHERE ParentId=? AND TenantId=?"
+	stmtCategoryParent             = "SELECT ParentId FROM Categories WHERE Id=? AND TenantId=?"
+	stmtReparentCategories         = "UPDATE Categories SET ParentId=? WHERE ParentId=? AND TenantId=?"
+	stmtProductCategories          = "SELECT c.Id, c.ParentId, c.Slug, c.Name, c.SortOrder FROM Categories c JOIN ProductCategories pc ON pc.CategoryId = c.Id WHERE pc.ProductId=? AND c.TenantId=?"
+	stmtCountProductCategory       = "SELECT COUNT(*) FROM ProductCategories WHERE ProductId=? AND CategoryId=? AND ProductId IN (" + stmtTenantProductIDs + ")"
+	stmtInsertProductCategory      = "INSERT INTO  ProductCategories (ProductId, CategoryId) SELECT p.Id, c.Id FROM Products p, Categories c WHERE p.Id=? AND c.Id=? AND p.TenantId=? AND c.TenantId=?"
+	stmtDeleteProductCategory      = "DELETE FROM ProductCategories WHERE ProductId=? AND CategoryId=? AND CategoryId IN (" + stmtTenantCategoryIDs + ")"
+	stmtDeleteAllProductCategories = "DELETE FROM ProductCategories WHERE ProductId=? AND ProductId IN (" + stmtTenantProductIDs + ")"
+	stmtDeleteCategoryProducts     = "DELETE FROM ProductCategories WHERE CategoryId=? AND CategoryId IN (" + stmtTenantCategoryIDs + ")"
+)
+
+// ErrCategoryHasChildren is returned when a category with children is deleted without saying what happens to them
+var ErrCategoryHasChildren = errors.New("category has children")
+
+// Returns all the categories ordered by SortOrder and then by Name, the tree is built by the caller
+func (c *ProductsCmds) FetchCategories(ctx context.Context) ([]models.DBCategories, error) {
+
+	span, ctx := apm.StartSpan(ctx, "categories.show", "db")
+	span.SpanData.Context.SetTag("span", "FetchCategories")
+	defer span.End()
+
+	return c.queryCategories(ctx, stmtCategories+stmtCategoriesOrder, xeroHelper.TenantFrom(ctx))
+}
+
+// Returns the category with the id, or with the slug when the id is not specified
+func (c *ProductsCmds) FetchCategory(ctx context.Context, id string, slug string) ([]models.DBCategories, error) {
+
+	span, ctx := apm.StartSpan(ctx, "categories.show", "db")
+	span.SpanData.Context.SetTag("span", "FetchCategory")
+	defer span.End()
+
+	id = strings.ToLower(id)
+
+	tenant := xeroHelper.TenantFrom(ctx)
+	if id != "" {
+		return c.queryCategories(ctx, stmtCategories+" AND Id=?", tenant, id)
+	}
+	return c.queryCategories(ctx, stmtCategories+" AND Slug=?", tenant, slug)
+}
+
+// Returns the categories the product is linked to, in their order
+func (c *ProductsCmds) FetchProductCategories(ctx context.Context, pID string) ([]models.DBCategories, error) {
+
+	span, ctx := apm.StartSpan(ctx, "product_categories.show", "db")
+	span.SpanData.Context.SetTag("span", "FetchProductCategories")
+	defer span.End()
+
+	pID = strings.ToLower(pID)
+
+	return c.queryCategories(ctx, stmtProductCategories+" ORDER BY c.SortOrder, c.Name, c.Id", pID, xeroHelper.TenantFrom(ctx))
+}
+
+func (c *ProductsCmds) queryCategories(ctx context.Context, stmt string, params ...interface{}) ([]models.DBCategories, error) {
+
+	db := c.DB.RO(ctx)
+	rows, err := db.QueryContext(ctx, c.sql(stmt), params...)
+	if err != nil {
+		c.Logger.Error("Error while fetching categories", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []models.DBCategories{}
+	for rows.Next() {
+		dbObj := models.DBCategories{}
+		if err := rows.Scan(&dbObj.DBID, &dbObj.DBParentID, &dbObj.DBSlug, &dbObj.DBName, &dbObj.DBSortOrder); err != nil {
+			c.Logger.Error("Error while scanning rows", "error", err)
+			return nil, err
+		}
+		result = append(result, dbObj)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger.Error("Error while scanning rows", "error", err)
+		return nil, err
+	}
+
+	c.Logger.Debug("Fetched the categories", "total_rows", len(result))
+	return result, nil
+}
+
+// Returns the newly added category id
+func (c *ProductsCmds) AddNewCategory(ctx context.Context, category models.Category) (string, error) {
+
+	span, ctx := apm.StartSpan(ctx, "categories.add", "db")
+	span.SpanData.Context.SetTag("span", "AddNewCategory")
+	defer span.End()
+
+	db := c.DB.RW(ctx)
+	id := uuid.New().String()
+	if _, err := db.ExecContext(ctx, c.sql(stmtInsertCategory), id, parentID(category.ParentID), category.Slug, category.Name, category.SortOrder, xeroHelper.TenantFrom(ctx)); err != nil {
+		c.Logger.Error("Error while inserting new category", "error", err)
+		return "", err
+	}
+
+	c.Logger.Debug("Added new category", "uuid", id)
+	return id, nil
+}
+
+// Returns total number of rows affected by this update
+func (c *ProductsCmds) UpdateCategory(ctx context.Context, id string, category models.Category) (int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "categories.update", "db")
+	span.SpanData.Context.SetTag("span", "UpdateCategory")
+	defer span.End()
+
+	id = strings.ToLower(id)
+
+	db := c.DB.RW(ctx)
+	result, err := db.ExecContext(ctx, c.sql(stmtUpdateCategory), parentID(category.ParentID), category.Slug, category.Name, category.SortOrder, id, xeroHelper.TenantFrom(ctx))
+	if err != nil {
+		c.Logger.Error("Error while updating category", "error", err)
+		return 0, err
+	}
+	affectedRows, err := result.RowsAffected()
+	c.Logger.Debug("Updated the category", "affected_rows", affectedRows)
+	return affectedRows, err
+}
+
+// Deletes the category along with its links to the products, the products themselves are kept
+// orphans tells what happens to the children of the category, one of the models.Orphans values
+// Returns the number of categories deleted, ErrCategoryHasChildren when the children are refused
+func (c *ProductsCmds) DeleteCategory(ctx context.Context, id string, orphans string) (int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "categories.delete", "db")
+	span.SpanData.Context.SetTag("span", "DeleteCategory")
+	defer span.End()
+
+	id = strings.ToLower(id)
+
+	tenant := xeroHelper.TenantFrom(ctx)
+	var affectedRows int64
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+		var parent sql.NullString
+		if err := tx.QueryRowContext(ctx, c.sql(stmtCategoryParent), id, tenant).Scan(&parent); err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// The whole subtree, the category first and then its descendants level by level
+		ids := []string{id}
+		for i := 0; i < len(ids); i++ {
+			children, err := c.categoryChildren(ctx, tx, ids[i])
+			if err != nil {
+				return err
+			}
+			if len(children) > 0 && orphans != models.OrphansCascade {
+				if orphans != models.OrphansReparent {
+					return ErrCategoryHasChildren
+				}
+				if _, err := tx.ExecContext(ctx, c.sql(stmtReparentCategories), parent, id, tenant); err != nil {
+					return err
+				}
+				break
+			}
+			ids = append(ids, children...)
+		}
+
+		// The descendants go before their parents, the products unlinked record the change in their history
+		now := time.Now()
+		for i := len(ids) - 1; i >= 0; i-- {
+			productIDs, err := c.linkStates(ctx, tx, stmtCategoryLinked, ids[i])
+			if err != nil {
+				return err
+			}
+			for _, pID := range productIDs {
+				if err := c.audit(ctx, tx, now, categoryChange(models.AuditDelete, pID, ids[i])); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.ExecContext(ctx, c.sql(stmtDeleteCategoryProducts), ids[i], tenant); err != nil {
+				return err
+			}
+			result, err := tx.ExecContext(ctx, c.sql(stmtDeleteCategory), ids[i], tenant)
+			if err != nil {
+				return err
+			}
+			deleted, _ := result.RowsAffected()
+			affectedRows += deleted
+		}
+
+		return nil
+	})
+	if err != nil {
+		if err != ErrCategoryHasChildren {
+			c.Logger.Error("Error while deleting category", "error", err)
+		}
+		return 0, err
+	}
+
+	c.Logger.Debug("Deleted the category", "affected_rows", affectedRows, "orphans", orphans)
+	return affectedRows, nil
+}
+
+func (c *ProductsCmds) categoryChildren(ctx context.Context, tx *sql.Tx, id string) ([]string, error) {
+
+	rows, err := tx.QueryContext(ctx, c.sql(stmtCategoryChildren), id, xeroHelper.TenantFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var childID string
+		if err := rows.Scan(&childID); err != nil {
+			return nil, err
+		}
+		ids = append(ids, strings.ToLower(childID))
+	}
+	return ids, rows.Err()
+}
+
+// Links the product to the category, linking it again changes nothing
+// Returns ErrUnknownProduct when the product or the category is not of the tenant
+func (c *ProductsCmds) AddProductCategory(ctx context.Context, pID string, categoryID string) error {
+
+	span, ctx := apm.StartSpan(ctx, "product_categories.add", "db")
+	span.SpanData.Context.SetTag("span", "AddProductCategory")
+	defer span.End()
+
+	pID, categoryID = strings.ToLower(pID), strings.ToLower(categoryID)
+
+	// Every column is a key, there is nothing to update on conflict
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+		tenant := xeroHelper.TenantFrom(ctx)
+		var links int64
+		if err := tx.QueryRowContext(ctx, c.sql(stmtCountProductCategory), pID, categoryID, tenant).Scan(&links); err != nil || links > 0 {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, c.sql(stmtInsertProductCategory), pID, categoryID, tenant, tenant)
+		if err != nil {
+			return err
+		}
+		if linked, _ := result.RowsAffected(); linked == 0 {
+			return ErrUnknownProduct
+		}
+		return c.audit(ctx, tx, time.Now(), categoryChange(models.AuditCreate, pID, categoryID))
+	})
+	if err != nil {
+		c.Logger.Error("Error while linking the product to the category", "error", err)
+		return err
+	}
+
+	c.Logger.Debug("Linked the product to the category", "uuid", pID, "category", categoryID)
+	return nil
+}
+
+// Unlinks the product from the category
+func (c *ProductsCmds) DeleteProductCategory(ctx context.Context, pID string, categoryID string) (int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "product_categories.delete", "db")
+	span.SpanData.Context.SetTag("span", "DeleteProductCategory")
+	defer span.End()
+
+	pID, categoryID = strings.ToLower(pID), strings.ToLower(categoryID)
+
+	var affectedRows int64
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, c.sql(stmtDeleteProductCategory), pID, categoryID, xeroHelper.TenantFrom(ctx))
+		if err != nil {
+			return err
+		}
+		if affectedRows, _ = result.RowsAffected(); affectedRows == 0 {
+			return nil
+		}
+		return c.audit(ctx, tx, time.Now(), categoryChange(models.AuditDelete, pID, categoryID))
+	})
+	if err != nil {
+		c.Logger.Error("Error while unlinking the product from the category", "error", err)
+		return 0, err
+	}
+	c.Logger.Debug("Unlinked the product from the category", "affected_rows", affectedRows)
+	return affectedRows, nil
+}
+
+// Unlinks the product from all its categories
+func (c *ProductsCmds) DeleteAllProductCategories(ctx context.Context, pID string) (int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "product_categories.delete", "db")
+	span.SpanData.Context.SetTag("span", "DeleteAllProductCategories")
+	defer span.End()
+
+	pID = strings.ToLower(pID)
+
+	var affectedRows int64
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+		categoryIDs, err := c.linkStates(ctx, tx, stmtCategoryLinks, pID)
+		if err != nil || len(categoryIDs) == 0 {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, c.sql(stmtDeleteAllProductCategories), pID, xeroHelper.TenantFrom(ctx))
+		if err != nil {
+			return err
+		}
+		affectedRows, _ = result.RowsAffected()
+
+		changes := make([]auditChange, 0, len(categoryIDs))
+		for _, categoryID := range categoryIDs {
+			changes = append(changes, categoryChange(models.AuditDelete, pID, categoryID))
+		}
+		return c.audit(ctx, tx, time.Now(), changes...)
+	})
+	if err != nil {
+		c.Logger.Error("Error while unlinking the product from all the categories", "error", err)
+		return 0, err
+	}
+	c.Logger.Debug("Unlinked the product from all the categories", "affected_rows", affectedRows)
+	return affectedRows, nil
+}
+
+// The root categories have a NULL parent
+func parentID(id string) interface{} {
+	if id == "" {
+		return nil
+	}
+	return strings.ToLower(id)
+}
@@ -0,0 +1,1764 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+
+	"github.com/techievee/xero/productService/models"
+	"github.com/techievee/xero/xeroHelper"
+	"github.com/techievee/xero/xeroLog/debugcore"
+)
+
+// MemoryCmds is the in memory implementation of the Repository
+// It lets the catalogue be embedded without a database file, the content is lost when the process exits
+// Lookups by id are case insensitive and the rows are listed in insertion order, same as the database
+// The products and options in the trash stay in the maps with DBDeletedAt set until they are purged
+// Every tenant has its own catalogue, a method only ever sees the catalogue of the tenant of its context
+type MemoryCmds struct {
+	Logger debugcore.Logger
+
+	lock    sync.RWMutex
+	tenants map[string]*memoryCatalogue
+}
+
+// memoryCatalogue is the catalogue of one tenant, along with the row of the tenant and its quotas
+type memoryCatalogue struct {
+	tenant       models.DBTenants
+	products     map[string]models.DBProducts
+	productOrder []string
+	options      map[string]models.DBProductOptions
+	optionOrder  []string
+	// Prices by product id and then by currency
+	prices map[string]map[string]models.DBProductPrices
+	// Stock by option id, an option without stock has nothing on hand
+	stock        map[string]models.DBStock
+	reservations map[string]models.DBStockReservations
+	// Categories in insertion order, and the ids of the categories by product id
+	categories        map[string]models.DBCategories
+	categoryOrder     []string
+	productCategories map[string]map[string]bool
+	// History of the changes, in the order of the changes
+	auditLog []models.DBAuditLog
+}
+
+// NewMemoryCmds returns an empty in memory catalogue, with the default tenant same as the database
+func NewMemoryCmds(logger debugcore.Logger) *MemoryCmds {
+	return &MemoryCmds{
+		Logger: logger,
+		tenants: map[string]*memoryCatalogue{
+			xeroHelper.DefaultTenant: newMemoryCatalogue(models.DBTenants{
+				DBID:          sql.NullString{String: xeroHelper.DefaultTenant, Valid: true},
+				DBName:        sql.NullString{String: "Default", Valid: true},
+				DBMaxProducts: sql.NullInt64{Valid: true},
+				DBMaxOptions:  sql.NullInt64{Valid: true},
+				DBCreatedAt:   sql.NullInt64{Valid: true},
+			}),
+		},
+	}
+}
+
+func newMemoryCatalogue(tenant models.DBTenants) *memoryCatalogue {
+	return &memoryCatalogue{
+		tenant:   tenant,
+		products: map[string]models.DBProducts{},
+		options:  map[string]models.DBProductOptions{},
+		prices:   map[string]map[string]models.DBProductPrices{},
+
+		stock:        map[string]models.DBStock{},
+		reservations: map[string]models.DBStockReservations{},
+
+		categories:        map[string]models.DBCategories{},
+		productCategories: map[string]map[string]bool{},
+	}
+}
+
+// catalogue returns the catalogue of the tenant of ctx, the caller must hold the lock
+// An unknown tenant gets an empty catalogue that is never kept, its changes are refused with ErrUnknownTenant
+func (c *MemoryCmds) catalogue(ctx context.Context) *memoryCatalogue {
+	if t, ok := c.tenants[xeroHelper.TenantFrom(ctx)]; ok {
+		return t
+	}
+	return newMemoryCatalogue(models.DBTenants{})
+}
+
+// Ids are stored in lower case, so the lookups are case insensitive
+func memoryKey(id string) string {
+	return strings.ToLower(id)
+}
+
+func (c *MemoryCmds) FetchAllProducts(ctx context.Context, pName string, pID string) ([]models.DBProducts, error) {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	t := c.catalogue(ctx)
+
+	result := []models.DBProducts{}
+	for _, key := range t.productOrder {
+		p, ok := t.products[key]
+		if !ok || p.DBDeletedAt.Valid {
+			continue
+		}
+		if pID != "" {
+			if key != memoryKey(pID) {
+				continue
+			}
+		} else if pName != "" && !containsFold(p.DBName.String, pName) {
+			continue
+		}
+		result = append(result, p)
+	}
+
+	c.Logger.Debug("Fetched all the products", "total_rows", len(result))
+	return result, nil
+}
+
+func (c *MemoryCmds) FetchProductsAfter(ctx context.Context, afterID string, limit int) ([]models.DBProducts, error) {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	t := c.catalogue(ctx)
+
+	after := memoryKey(afterID)
+	keys := []string{}
+	for key, p := range t.products {
+		if !p.DBDeletedAt.Valid && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	result := make([]models.DBProducts, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, t.products[key])
+	}
+
+	c.Logger.Debug("Fetched the products after", "total_rows", len(result), "after", afterID)
+	return result, nil
+}
+
+func (c *MemoryCmds) FetchProductsPage(ctx context.Context, filter models.ProductFilter, page models.PageRequest) ([]models.DBProducts, int64, error) {
+
+	order := filter.Sort
+	if len(order) == 0 {
+		order = models.DefaultProductSort
+	}
+
+	c.lock.RLock()
+	t := c.catalogue(ctx)
+	matched := []models.DBProducts{}
+	for _, key := range t.productOrder {
+		if p, ok := t.products[key]; ok && !p.DBDeletedAt.Valid && matchProductFilter(p, filter) && t.matchCategories(key, filter.CategoryIDs) {
+			matched = append(matched, p)
+		}
+	}
+	c.lock.RUnlock()
+
+	total := int64(len(matched))
+	columns, after := sortColumns(order, page.Cursor)
+	sort.SliceStable(matched, func(i, j int) bool {
+		return compareSortValues(matched[i].SortValues(columns), matched[j].SortValues(columns), columns) < 0
+	})
+
+	// Skip up to the cursor, then apply the offset and the limit with one extra row
+	start := 0
+	for page.Cursor != nil && start < len(matched) && compareSortValues(matched[start].SortValues(columns), after, columns) <= 0 {
+		start++
+	}
+	start += page.Offset
+	if start > len(matched) {
+		start = len(matched)
+	}
+	matched = matched[start:]
+	if len(matched) > page.Limit+1 {
+		matched = matched[:page.Limit+1]
+	}
+
+	c.Logger.Debug("Fetched the products page", "total_rows", len(matched), "total", total)
+	return matched, total, nil
+}
+
+func (c *MemoryCmds) AddNewProduct(ctx context.Context, product models.Product) (string, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+	if err := t.checkQuota(1, 0); err != nil {
+		return "", err
+	}
+
+	id := uuid.New().String()
+	row := productRow(id, product)
+	t.products[id] = row
+	t.productOrder = append(t.productOrder, id)
+	c.record(ctx, time.Now(), productChange(models.AuditCreate, nil, &row))
+
+	c.Logger.Debug("Added new product", "uuid", id)
+	return id, nil
+}
+
+func (c *MemoryCmds) AddNewProductWithOptions(ctx context.Context, product models.Product) (string, []string, error) {
+
+	// Nothing can fail half way, holding the lock is enough for the product and its options to appear together
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+	if err := t.checkQuota(1, int64(len(product.Options))); err != nil {
+		return "", nil, err
+	}
+
+	id := uuid.New().String()
+	optionIDs, changes := t.insertProduct(id, product)
+	c.record(ctx, time.Now(), changes...)
+
+	c.Logger.Debug("Added new product with options", "uuid", id, "options", len(optionIDs))
+	return id, optionIDs, nil
+}
+
+// insertProduct adds the product with its options, the caller must hold the lock
+// Returns the ids of the options in the order of product.Options and the changes to record
+func (t *memoryCatalogue) insertProduct(id string, product models.Product) ([]string, []auditChange) {
+
+	row := productRow(id, product)
+	t.products[id] = row
+	t.productOrder = append(t.productOrder, id)
+	changes := []auditChange{productChange(models.AuditCreate, nil, &row)}
+
+	optionIDs := make([]string, 0, len(product.Options))
+	for _, option := range product.Options {
+		optionID := uuid.New().String()
+		optionState := optionRow(optionID, id, option)
+		t.options[optionID] = optionState
+		t.optionOrder = append(t.optionOrder, optionID)
+		optionIDs = append(optionIDs, optionID)
+		changes = append(changes, optionChange(models.AuditCreate, nil, &optionState))
+	}
+	return optionIDs, changes
+}
+
+func (c *MemoryCmds) UpdateProduct(ctx context.Context, product models.Product, productID string, version *int64) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	before, ok := t.products[memoryKey(productID)]
+	if !ok || before.DBDeletedAt.Valid {
+		return 0, nil
+	}
+	if version != nil && *version != before.DBVersion.Int64 {
+		return 0, ErrVersionMismatch
+	}
+	if product.Currency == "" {
+		product.Currency = models.NewProduct(before).Currency
+	}
+	after := productRow(before.DBID.String, product)
+	after.DBVersion.Int64 = before.DBVersion.Int64 + 1
+	t.products[memoryKey(productID)] = after
+	c.record(ctx, time.Now(), productChange(models.AuditUpdate, &before, &after))
+
+	c.Logger.Debug("Updated the products", "affected_rows", 1)
+	return 1, nil
+}
+
+func (c *MemoryCmds) DeleteProduct(ctx context.Context, productID string, version *int64) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	key := memoryKey(productID)
+	before, ok := t.products[key]
+	if !ok || before.DBDeletedAt.Valid {
+		return 0, nil
+	}
+	if version != nil && *version != before.DBVersion.Int64 {
+		return 0, ErrVersionMismatch
+	}
+
+	now := time.Now()
+	c.record(ctx, now, t.trashProduct(key, before, now)...)
+
+	c.Logger.Debug("Deleted the product", "affected_rows", 1)
+	return 1, nil
+}
+
+// trashProduct moves the product to the trash and returns the changes to record, the caller must hold the lock
+// Same as the database, the options go to the trash with the same time as the product
+func (t *memoryCatalogue) trashProduct(key string, before models.DBProducts, now time.Time) []auditChange {
+
+	deletedAt := sql.NullInt64{Int64: models.DeletedAtMillis(now), Valid: true}
+	after := before
+	after.DBDeletedAt = deletedAt
+	after.DBVersion.Int64++
+	t.products[key] = after
+	changes := []auditChange{productChange(models.AuditDelete, &before, &after)}
+	for _, optionKey := range t.optionOrder {
+		if o := t.options[optionKey]; memoryKey(o.DBProductID.String) == key && !o.DBDeletedAt.Valid {
+			optionAfter := o
+			optionAfter.DBDeletedAt = deletedAt
+			optionAfter.DBVersion.Int64++
+			t.options[optionKey] = optionAfter
+			changes = append(changes, optionChange(models.AuditDelete, &o, &optionAfter))
+		}
+	}
+	return changes
+}
+
+func (c *MemoryCmds) PatchProduct(ctx context.Context, productID string, product models.Product, fields []string, version *int64) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	key := memoryKey(productID)
+	before, ok := t.products[key]
+	if !ok || before.DBDeletedAt.Valid {
+		return 0, nil
+	}
+	if version != nil && *version != before.DBVersion.Int64 {
+		return 0, ErrVersionMismatch
+	}
+
+	// Same as the database, a patch without any field writes nothing
+	after := before
+	columns, _, err := patchProductRow(&after, product, fields)
+	if err != nil {
+		return 0, err
+	}
+	if len(columns) == 0 {
+		return 1, nil
+	}
+	after.DBVersion.Int64++
+	t.products[key] = after
+	c.record(ctx, time.Now(), productChange(models.AuditUpdate, &before, &after))
+
+	c.Logger.Debug("Patched the product", "affected_rows", 1, "fields", fields)
+	return 1, nil
+}
+
+func (c *MemoryCmds) FetchAllProductOptions(ctx context.Context, pID string, pOptionID string) ([]models.DBProductOptions, error) {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	t := c.catalogue(ctx)
+
+	result := []models.DBProductOptions{}
+	for _, key := range t.optionOrder {
+		o, ok := t.options[key]
+		if !ok || o.DBDeletedAt.Valid || memoryKey(o.DBProductID.String) != memoryKey(pID) {
+			continue
+		}
+		if pOptionID != "" && key != memoryKey(pOptionID) {
+			continue
+		}
+		result = append(result, o)
+	}
+
+	c.Logger.Debug("Fetched all the product options", "total_rows", len(result))
+	return result, nil
+}
+
+func (c *MemoryCmds) FetchOptionsOfProducts(ctx context.Context, pIDs []string) ([]models.DBProductOptions, error) {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	t := c.catalogue(ctx)
+
+	products := map[string]bool{}
+	for _, id := range pIDs {
+		products[memoryKey(id)] = true
+	}
+
+	result := []models.DBProductOptions{}
+	for _, key := range t.optionOrder {
+		o, ok := t.options[key]
+		if ok && !o.DBDeletedAt.Valid && products[memoryKey(o.DBProductID.String)] {
+			result = append(result, o)
+		}
+	}
+
+	c.Logger.Debug("Fetched the options of the products", "total_rows", len(result), "products", len(pIDs))
+	return result, nil
+}
+
+func (c *MemoryCmds) FetchProductOptionsPage(ctx context.Context, pID string, page models.PageRequest) ([]models.DBProductOptions, int64, error) {
+
+	matched, _ := c.FetchAllProductOptions(ctx, pID, "")
+	total := int64(len(matched))
+
+	columns, after := sortColumns(models.ProductOptionSort, page.Cursor)
+	values := func(o models.DBProductOptions) []interface{} {
+		return []interface{}{o.DBName.String, o.DBID.String}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return compareSortValues(values(matched[i]), values(matched[j]), columns) < 0
+	})
+
+	// Skip up to the cursor, then apply the offset and the limit with one extra row
+	start := 0
+	for page.Cursor != nil && start < len(matched) && compareSortValues(values(matched[start]), after, columns) <= 0 {
+		start++
+	}
+	start += page.Offset
+	if start > len(matched) {
+		start = len(matched)
+	}
+	matched = matched[start:]
+	if len(matched) > page.Limit+1 {
+		matched = matched[:page.Limit+1]
+	}
+
+	c.Logger.Debug("Fetched the product options page", "total_rows", len(matched), "total", total)
+	return matched, total, nil
+}
+
+func (c *MemoryCmds) AddNewProductOption(ctx context.Context, pID string, product models.ProductOption) (string, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+	if p, ok := t.products[memoryKey(pID)]; !ok || p.DBDeletedAt.Valid {
+		return "", ErrUnknownProduct
+	}
+	if err := t.checkQuota(0, 1); err != nil {
+		return "", err
+	}
+
+	id := uuid.New().String()
+	row := optionRow(id, pID, product)
+	t.options[id] = row
+	t.optionOrder = append(t.optionOrder, id)
+	c.record(ctx, time.Now(), optionChange(models.AuditCreate, nil, &row))
+
+	c.Logger.Debug("Added new product option", "uuid", id)
+	return id, nil
+}
+
+func (c *MemoryCmds) UpdateProductOption(ctx context.Context, pID string, pOptionID string, product models.ProductOption, version *int64) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	before, ok := t.options[memoryKey(pOptionID)]
+	if !ok || before.DBDeletedAt.Valid || memoryKey(before.DBProductID.String) != memoryKey(pID) {
+		return 0, nil
+	}
+	if version != nil && *version != before.DBVersion.Int64 {
+		return 0, ErrVersionMismatch
+	}
+	after := optionRow(before.DBID.String, before.DBProductID.String, product)
+	after.DBVersion.Int64 = before.DBVersion.Int64 + 1
+	t.options[memoryKey(pOptionID)] = after
+	c.record(ctx, time.Now(), optionChange(models.AuditUpdate, &before, &after))
+
+	c.Logger.Debug("Updated the product options", "affected_rows", 1)
+	return 1, nil
+}
+
+func (c *MemoryCmds) DeleteProductOption(ctx context.Context, pID string, pOptionID string, version *int64) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	key := memoryKey(pOptionID)
+	before, ok := t.options[key]
+	if !ok || before.DBDeletedAt.Valid || memoryKey(before.DBProductID.String) != memoryKey(pID) {
+		return 0, nil
+	}
+	if version != nil && *version != before.DBVersion.Int64 {
+		return 0, ErrVersionMismatch
+	}
+	now := time.Now()
+	after := before
+	after.DBDeletedAt = sql.NullInt64{Int64: models.DeletedAtMillis(now), Valid: true}
+	after.DBVersion.Int64++
+	t.options[key] = after
+	c.record(ctx, now, optionChange(models.AuditDelete, &before, &after))
+
+	c.Logger.Debug("Deleted the product option", "affected_rows", 1)
+	return 1, nil
+}
+
+func (c *MemoryCmds) PatchProductOption(ctx context.Context, pID string, pOptionID string, option models.ProductOption, fields []string, version *int64) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	key := memoryKey(pOptionID)
+	before, ok := t.options[key]
+	if !ok || before.DBDeletedAt.Valid || memoryKey(before.DBProductID.String) != memoryKey(pID) {
+		return 0, nil
+	}
+	if version != nil && *version != before.DBVersion.Int64 {
+		return 0, ErrVersionMismatch
+	}
+
+	after := before
+	columns, _, err := patchOptionRow(&after, option, fields)
+	if err != nil {
+		return 0, err
+	}
+	if len(columns) == 0 {
+		return 1, nil
+	}
+	after.DBVersion.Int64++
+	t.options[key] = after
+	c.record(ctx, time.Now(), optionChange(models.AuditUpdate, &before, &after))
+
+	c.Logger.Debug("Patched the product option", "affected_rows", 1, "fields", fields)
+	return 1, nil
+}
+
+func (c *MemoryCmds) DeleteAllProductOptions(ctx context.Context, pID string) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	var changes []auditChange
+	for _, key := range t.productOptionKeys(pID) {
+		o := t.options[key]
+		t.purgeOption(key)
+		changes = append(changes, optionChange(models.AuditPurge, &o, nil))
+	}
+	c.record(ctx, time.Now(), changes...)
+	affectedRows := int64(len(changes))
+
+	c.Logger.Debug("Deleted all the product options", "affected_rows", affectedRows)
+	return affectedRows, nil
+}
+
+func (c *MemoryCmds) ApplyBatch(ctx context.Context, ops []models.BatchOperation, atomic bool, _ int) ([]BatchOutcome, error) {
+
+	// Nothing can fail but the refused operations, the whole batch is applied under the lock and needs no chunks
+	// An atomic batch is undone from a copy of the products and the options taken before it, the changes are only recorded at the end
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	var undo func()
+	if atomic {
+		undo = t.batchUndo()
+	}
+
+	now := time.Now()
+	outcomes := make([]BatchOutcome, len(ops))
+	var changes []auditChange
+	for i, op := range ops {
+		var opChanges []auditChange
+		outcomes[i], opChanges = c.batchOperation(t, op, now)
+		if outcomes[i].Err != nil && atomic {
+			undo()
+			rolledBack(outcomes, ops)
+			c.Logger.Debug("Rolled back the batch", "operations", len(ops))
+			return outcomes, nil
+		}
+		changes = append(changes, opChanges...)
+	}
+	c.record(ctx, now, changes...)
+
+	c.Logger.Debug("Applied the batch", "operations", len(ops), "atomic", atomic)
+	return outcomes, nil
+}
+
+// batchOperation applies the operation to the catalogue of the tenant and returns its outcome with the changes to record, the caller must hold the lock
+// Same as the database the ids are unique across the tenants, an upsert creating the id of another tenant is refused with ErrIDTaken
+func (c *MemoryCmds) batchOperation(t *memoryCatalogue, op models.BatchOperation, now time.Time) (BatchOutcome, []auditChange) {
+
+	if !op.IsOption() {
+		key := memoryKey(op.ID)
+		before, ok := t.products[key]
+		switch {
+		case op.Op == models.BatchCreate || (op.Op == models.BatchUpsert && !ok):
+			if op.Op == models.BatchCreate {
+				key = uuid.New().String()
+			} else if op.Version != nil && *op.Version != 0 {
+				return BatchOutcome{ID: op.ID, Err: ErrVersionMismatch}, nil
+			}
+			if err := t.checkQuota(1, int64(len(op.Product.Options))); err != nil {
+				return BatchOutcome{ID: op.ID, Err: err}, nil
+			}
+			if c.productTaken(key) {
+				return BatchOutcome{ID: op.ID, Err: ErrIDTaken}, nil
+			}
+			optionIDs, changes := t.insertProduct(key, *op.Product)
+			return BatchOutcome{ID: key, OptionIDs: optionIDs, Created: true}, changes
+		case op.Op == models.BatchUpsert && before.DBDeletedAt.Valid:
+			return BatchOutcome{ID: op.ID, Err: ErrInTrash}, nil
+		case op.Op == models.BatchUpsert && op.Version != nil && *op.Version != before.DBVersion.Int64:
+			return BatchOutcome{ID: op.ID, Err: ErrVersionMismatch}, nil
+		case op.Op == models.BatchUpsert:
+			after := productRow(before.DBID.String, *op.Product)
+			after.DBVersion.Int64 = before.DBVersion.Int64 + 1
+			t.products[key] = after
+			return BatchOutcome{ID: key}, []auditChange{productChange(models.AuditUpdate, &before, &after)}
+		case !ok || before.DBDeletedAt.Valid:
+			return BatchOutcome{ID: op.ID, Err: ErrUnknownProduct}, nil
+		default:
+			return BatchOutcome{ID: key}, t.trashProduct(key, before, now)
+		}
+	}
+
+	pKey := memoryKey(op.ProductID)
+	if p, ok := t.products[pKey]; !ok || p.DBDeletedAt.Valid {
+		return BatchOutcome{ID: op.ID, Err: ErrUnknownProduct}, nil
+	}
+
+	key := memoryKey(op.ID)
+	before, ok := t.options[key]
+	switch {
+	case op.Op == models.BatchCreate || (op.Op == models.BatchUpsert && !ok):
+		if op.Op == models.BatchCreate {
+			key = uuid.New().String()
+		} else if op.Version != nil && *op.Version != 0 {
+			return BatchOutcome{ID: op.ID, Err: ErrVersionMismatch}, nil
+		}
+		if err := t.checkQuota(0, 1); err != nil {
+			return BatchOutcome{ID: op.ID, Err: err}, nil
+		}
+		if c.optionTaken(key) {
+			return BatchOutcome{ID: op.ID, Err: ErrIDTaken}, nil
+		}
+		row := optionRow(key, pKey, *op.Option)
+		t.options[key] = row
+		t.optionOrder = append(t.optionOrder, key)
+		return BatchOutcome{ID: key, Created: true}, []auditChange{optionChange(models.AuditCreate, nil, &row)}
+	case op.Op == models.BatchUpsert && memoryKey(before.DBProductID.String) != pKey:
+		return BatchOutcome{ID: op.ID, Err: ErrIDTaken}, nil
+	case op.Op == models.BatchUpsert && before.DBDeletedAt.Valid:
+		return BatchOutcome{ID: op.ID, Err: ErrInTrash}, nil
+	case op.Op == models.BatchUpsert && op.Version != nil && *op.Version != before.DBVersion.Int64:
+		return BatchOutcome{ID: op.ID, Err: ErrVersionMismatch}, nil
+	case op.Op == models.BatchUpsert:
+		after := optionRow(before.DBID.String, before.DBProductID.String, *op.Option)
+		after.DBVersion.Int64 = before.DBVersion.Int64 + 1
+		t.options[key] = after
+		return BatchOutcome{ID: key}, []auditChange{optionChange(models.AuditUpdate, &before, &after)}
+	case !ok || before.DBDeletedAt.Valid || memoryKey(before.DBProductID.String) != pKey:
+		return BatchOutcome{ID: op.ID, Err: ErrUnknownProductOption}, nil
+	default:
+		after := before
+		after.DBDeletedAt = sql.NullInt64{Int64: models.DeletedAtMillis(now), Valid: true}
+		after.DBVersion.Int64++
+		t.options[key] = after
+		return BatchOutcome{ID: key}, []auditChange{optionChange(models.AuditDelete, &before, &after)}
+	}
+}
+
+// batchUndo copies the products and the options, the returned func puts them back as they were, the caller must hold the lock
+// A batch only adds to the orders, so their lengths are enough to undo them
+func (t *memoryCatalogue) batchUndo() func() {
+
+	products := make(map[string]models.DBProducts, len(t.products))
+	for key, p := range t.products {
+		products[key] = p
+	}
+	options := make(map[string]models.DBProductOptions, len(t.options))
+	for key, o := range t.options {
+		options[key] = o
+	}
+	productCount, optionCount := len(t.productOrder), len(t.optionOrder)
+
+	return func() {
+		t.products, t.options = products, options
+		t.productOrder, t.optionOrder = t.productOrder[:productCount], t.optionOrder[:optionCount]
+	}
+}
+
+func (c *MemoryCmds) FetchProductPrices(ctx context.Context, pID string, currency string) ([]models.DBProductPrices, error) {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	t := c.catalogue(ctx)
+
+	result := []models.DBProductPrices{}
+	for _, price := range t.sortedPrices(pID) {
+		if currency == "" || price.DBCurrency.String == currency {
+			result = append(result, price)
+		}
+	}
+
+	return result, nil
+}
+
+// sortedPrices returns the prices of the product ordered by currency, the caller must hold the lock
+func (t *memoryCatalogue) sortedPrices(pID string) []models.DBProductPrices {
+
+	result := make([]models.DBProductPrices, 0, len(t.prices[memoryKey(pID)]))
+	for _, price := range t.prices[memoryKey(pID)] {
+		result = append(result, price)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].DBCurrency.String < result[j].DBCurrency.String })
+
+	return result
+}
+
+func (c *MemoryCmds) FetchPricesInCurrency(ctx context.Context, currency string, pIDs []string) ([]models.DBProductPrices, error) {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	t := c.catalogue(ctx)
+
+	result := []models.DBProductPrices{}
+	for _, id := range pIDs {
+		if price, ok := t.prices[memoryKey(id)][currency]; ok {
+			result = append(result, price)
+		}
+	}
+
+	return result, nil
+}
+
+func (c *MemoryCmds) SetProductPrice(ctx context.Context, pID string, price models.ProductPrice) error {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	key := memoryKey(pID)
+	if _, ok := t.products[key]; !ok {
+		return ErrUnknownProduct
+	}
+	if t.prices[key] == nil {
+		t.prices[key] = map[string]models.DBProductPrices{}
+	}
+	after := priceRow(key, price)
+	if before, ok := t.prices[key][price.Currency]; ok {
+		c.record(ctx, time.Now(), priceChange(models.AuditUpdate, &before, &after))
+	} else {
+		c.record(ctx, time.Now(), priceChange(models.AuditCreate, nil, &after))
+	}
+	t.prices[key][price.Currency] = after
+	t.touchProduct(key)
+
+	c.Logger.Debug("Set the product price", "uuid", pID, "currency", price.Currency)
+	return nil
+}
+
+func (c *MemoryCmds) DeleteProductPrice(ctx context.Context, pID string, currency string) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	before, ok := t.prices[memoryKey(pID)][currency]
+	if !ok {
+		return 0, nil
+	}
+	delete(t.prices[memoryKey(pID)], currency)
+	t.touchProduct(memoryKey(pID))
+	c.record(ctx, time.Now(), priceChange(models.AuditDelete, &before, nil))
+
+	c.Logger.Debug("Deleted the product price", "affected_rows", 1)
+	return 1, nil
+}
+
+func (c *MemoryCmds) DeleteAllProductPrices(ctx context.Context, pID string) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	var changes []auditChange
+	for _, before := range t.sortedPrices(pID) {
+		changes = append(changes, priceChange(models.AuditDelete, &before, nil))
+	}
+	delete(t.prices, memoryKey(pID))
+	if len(changes) > 0 {
+		t.touchProduct(memoryKey(pID))
+	}
+	c.record(ctx, time.Now(), changes...)
+	affectedRows := int64(len(changes))
+
+	c.Logger.Debug("Deleted all the product prices", "affected_rows", affectedRows)
+	return affectedRows, nil
+}
+
+// touchProduct increments the version of the product, same as the database when a price of the product changes
+func (t *memoryCatalogue) touchProduct(key string) {
+	if p, ok := t.products[key]; ok {
+		p.DBVersion.Int64++
+		t.products[key] = p
+	}
+}
+
+func (c *MemoryCmds) FetchStock(ctx context.Context, pID string, pOptionID string) (models.DBStock, error) {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	t := c.catalogue(ctx)
+
+	return t.optionStock(pID, pOptionID)
+}
+
+func (c *MemoryCmds) SetStock(ctx context.Context, pID string, pOptionID string, onHand int64, version *int64) (models.DBStock, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	stock, err := t.optionStock(pID, pOptionID)
+	if err != nil {
+		return stock, err
+	}
+	if version != nil && *version != stock.DBVersion.Int64 {
+		return models.DBStock{}, ErrStockConflict
+	}
+	if onHand < stock.DBReserved.Int64 {
+		return models.DBStock{}, ErrInsufficientStock
+	}
+
+	return c.saveStock(ctx, models.AuditUpdate, pID, pOptionID, onHand, stock.DBReserved.Int64, stock), nil
+}
+
+func (c *MemoryCmds) ReserveStock(ctx context.Context, pID string, pOptionID string, quantity int64) (models.DBStockReservations, error) {
+
+	// Holding the lock serialises the reservations, no version check is needed
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	stock, err := t.optionStock(pID, pOptionID)
+	if err != nil {
+		return models.DBStockReservations{}, err
+	}
+	if stock.DBOnHand.Int64-stock.DBReserved.Int64 < quantity {
+		return models.DBStockReservations{}, ErrInsufficientStock
+	}
+
+	id := uuid.New().String()
+	reservation := models.DBStockReservations{
+		DBID:              sql.NullString{String: id, Valid: true},
+		DBProductOptionID: sql.NullString{String: memoryKey(pOptionID), Valid: true},
+		DBQuantity:        sql.NullInt64{Int64: quantity, Valid: true},
+		DBStatus:          sql.NullString{String: models.ReservationReserved, Valid: true},
+	}
+	t.reservations[id] = reservation
+	c.saveStock(ctx, models.AuditReserve, pID, pOptionID, stock.DBOnHand.Int64, stock.DBReserved.Int64+quantity, stock)
+
+	c.Logger.Debug("Reserved the stock", "uuid", id, "quantity", quantity)
+	return reservation, nil
+}
+
+func (c *MemoryCmds) CommitStockReservation(ctx context.Context, pID string, pOptionID string, reservationID string) (models.DBStockReservations, error) {
+	return c.closeReservation(ctx, pID, pOptionID, reservationID, models.ReservationCommitted, models.AuditCommit)
+}
+
+func (c *MemoryCmds) ReleaseStockReservation(ctx context.Context, pID string, pOptionID string, reservationID string) (models.DBStockReservations, error) {
+	return c.closeReservation(ctx, pID, pOptionID, reservationID, models.ReservationReleased, models.AuditRelease)
+}
+
+func (c *MemoryCmds) closeReservation(ctx context.Context, pID string, pOptionID string, reservationID string, status string, action string) (models.DBStockReservations, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	stock, err := t.optionStock(pID, pOptionID)
+	if err != nil {
+		return models.DBStockReservations{}, err
+	}
+	reservation, ok := t.reservations[memoryKey(reservationID)]
+	if !ok || reservation.DBProductOptionID.String != memoryKey(pOptionID) {
+		return models.DBStockReservations{}, ErrUnknownReservation
+	}
+	if reservation.DBStatus.String != models.ReservationReserved {
+		return models.DBStockReservations{}, ErrReservationClosed
+	}
+
+	reservation.DBStatus.String = status
+	t.reservations[memoryKey(reservationID)] = reservation
+
+	quantity := reservation.DBQuantity.Int64
+	if status == models.ReservationCommitted {
+		c.saveStock(ctx, action, pID, pOptionID, stock.DBOnHand.Int64-quantity, stock.DBReserved.Int64-quantity, stock)
+	} else {
+		c.saveStock(ctx, action, pID, pOptionID, stock.DBOnHand.Int64, stock.DBReserved.Int64-quantity, stock)
+	}
+
+	c.Logger.Debug("Closed the stock reservation", "uuid", reservationID, "status", status)
+	return reservation, nil
+}
+
+// optionStock returns the stock of the option, the caller must hold the lock
+func (t *memoryCatalogue) optionStock(pID string, pOptionID string) (models.DBStock, error) {
+
+	o, ok := t.options[memoryKey(pOptionID)]
+	if !ok || o.DBDeletedAt.Valid || memoryKey(o.DBProductID.String) != memoryKey(pID) {
+		return models.DBStock{}, ErrUnknownProductOption
+	}
+	if stock, ok := t.stock[memoryKey(pOptionID)]; ok {
+		return stock, nil
+	}
+	return models.DBStock{
+		DBOnHand:   sql.NullInt64{Valid: true},
+		DBReserved: sql.NullInt64{Valid: true},
+		DBVersion:  sql.NullInt64{Valid: true},
+	}, nil
+}
+
+// saveStock stores the new quantities with the next version and records the change with the action, the caller must hold the lock
+func (c *MemoryCmds) saveStock(ctx context.Context, action string, pID string, pOptionID string, onHand int64, reserved int64, previous models.DBStock) models.DBStock {
+
+	stock := models.DBStock{
+		DBOnHand:   sql.NullInt64{Int64: onHand, Valid: true},
+		DBReserved: sql.NullInt64{Int64: reserved, Valid: true},
+		DBVersion:  sql.NullInt64{Int64: previous.DBVersion.Int64 + 1, Valid: true},
+	}
+	c.catalogue(ctx).stock[memoryKey(pOptionID)] = stock
+	c.record(ctx, time.Now(), stockChange(action, pID, pOptionID, previous, stock))
+	return stock
+}
+
+// deleteStock removes the stock and the reservations of the option, the caller must hold the lock
+func (t *memoryCatalogue) deleteStock(optionKey string) {
+
+	delete(t.stock, optionKey)
+	for id, r := range t.reservations {
+		if r.DBProductOptionID.String == optionKey {
+			delete(t.reservations, id)
+		}
+	}
+}
+
+func (c *MemoryCmds) FetchCategories(ctx context.Context) ([]models.DBCategories, error) {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	t := c.catalogue(ctx)
+
+	result := []models.DBCategories{}
+	for _, key := range t.categoryOrder {
+		result = append(result, t.categories[key])
+	}
+	sortCategories(result)
+
+	return result, nil
+}
+
+func (c *MemoryCmds) FetchCategory(ctx context.Context, id string, slug string) ([]models.DBCategories, error) {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	t := c.catalogue(ctx)
+
+	result := []models.DBCategories{}
+	for _, key := range t.categoryOrder {
+		v := t.categories[key]
+		if (id != "" && key == memoryKey(id)) || (id == "" && v.DBSlug.String == slug) {
+			result = append(result, v)
+		}
+	}
+
+	return result, nil
+}
+
+func (c *MemoryCmds) AddNewCategory(ctx context.Context, category models.Category) (string, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	id := uuid.New().String()
+	t.categories[id] = memoryCategory(id, category)
+	t.categoryOrder = append(t.categoryOrder, id)
+
+	c.Logger.Debug("Added new category", "uuid", id)
+	return id, nil
+}
+
+func (c *MemoryCmds) UpdateCategory(ctx context.Context, id string, category models.Category) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	key := memoryKey(id)
+	if _, ok := t.categories[key]; !ok {
+		return 0, nil
+	}
+	t.categories[key] = memoryCategory(key, category)
+
+	c.Logger.Debug("Updated the category", "affected_rows", 1)
+	return 1, nil
+}
+
+func (c *MemoryCmds) DeleteCategory(ctx context.Context, id string, orphans string) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	key := memoryKey(id)
+	category, ok := t.categories[key]
+	if !ok {
+		return 0, nil
+	}
+
+	// Same as the database, the subtree is only deleted when asked for
+	all := make([]models.DBCategories, 0, len(t.categories))
+	for _, v := range t.categories {
+		all = append(all, v)
+	}
+	ids := models.CategoryDescendants(all, key)
+	if len(ids) > 1 {
+		switch orphans {
+		case models.OrphansCascade:
+		case models.OrphansReparent:
+			for _, childID := range ids[1:] {
+				if child := t.categories[childID]; memoryKey(child.DBParentID.String) == key {
+					child.DBParentID = category.DBParentID
+					t.categories[childID] = child
+				}
+			}
+			ids = ids[:1]
+		default:
+			return 0, ErrCategoryHasChildren
+		}
+	}
+
+	// Same as the database, the products unlinked record the change in their history
+	now := time.Now()
+	for _, categoryID := range ids {
+		delete(t.categories, categoryID)
+		t.categoryOrder = removeKey(t.categoryOrder, categoryID)
+		for productKey, links := range t.productCategories {
+			if links[categoryID] {
+				delete(links, categoryID)
+				c.record(ctx, now, categoryChange(models.AuditDelete, productKey, categoryID))
+			}
+		}
+	}
+
+	c.Logger.Debug("Deleted the category", "affected_rows", len(ids), "orphans", orphans)
+	return int64(len(ids)), nil
+}
+
+func (c *MemoryCmds) FetchProductCategories(ctx context.Context, pID string) ([]models.DBCategories, error) {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	t := c.catalogue(ctx)
+
+	result := []models.DBCategories{}
+	for _, key := range t.categoryOrder {
+		if t.productCategories[memoryKey(pID)][key] {
+			result = append(result, t.categories[key])
+		}
+	}
+	sortCategories(result)
+
+	return result, nil
+}
+
+func (c *MemoryCmds) AddProductCategory(ctx context.Context, pID string, categoryID string) error {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	key := memoryKey(pID)
+	_, productOK := t.products[key]
+	_, categoryOK := t.categories[memoryKey(categoryID)]
+	if !productOK || !categoryOK {
+		return ErrUnknownProduct
+	}
+	if t.productCategories[key] == nil {
+		t.productCategories[key] = map[string]bool{}
+	}
+	if !t.productCategories[key][memoryKey(categoryID)] {
+		t.productCategories[key][memoryKey(categoryID)] = true
+		c.record(ctx, time.Now(), categoryChange(models.AuditCreate, pID, categoryID))
+	}
+
+	c.Logger.Debug("Linked the product to the category", "uuid", pID, "category", categoryID)
+	return nil
+}
+
+func (c *MemoryCmds) DeleteProductCategory(ctx context.Context, pID string, categoryID string) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	if !t.productCategories[memoryKey(pID)][memoryKey(categoryID)] {
+		return 0, nil
+	}
+	delete(t.productCategories[memoryKey(pID)], memoryKey(categoryID))
+	c.record(ctx, time.Now(), categoryChange(models.AuditDelete, pID, categoryID))
+
+	c.Logger.Debug("Unlinked the product from the category", "affected_rows", 1)
+	return 1, nil
+}
+
+func (c *MemoryCmds) DeleteAllProductCategories(ctx context.Context, pID string) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	var changes []auditChange
+	for categoryID := range t.productCategories[memoryKey(pID)] {
+		changes = append(changes, categoryChange(models.AuditDelete, pID, categoryID))
+	}
+	delete(t.productCategories, memoryKey(pID))
+	c.record(ctx, time.Now(), changes...)
+	affectedRows := int64(len(changes))
+
+	c.Logger.Debug("Unlinked the product from all the categories", "affected_rows", affectedRows)
+	return affectedRows, nil
+}
+
+func memoryCategory(id string, category models.Category) models.DBCategories {
+	return models.DBCategories{
+		DBID:        sql.NullString{String: id, Valid: true},
+		DBParentID:  sql.NullString{String: memoryKey(category.ParentID), Valid: category.ParentID != ""},
+		DBSlug:      sql.NullString{String: category.Slug, Valid: true},
+		DBName:      sql.NullString{String: category.Name, Valid: true},
+		DBSortOrder: sql.NullInt64{Int64: category.SortOrder, Valid: true},
+	}
+}
+
+// Same order as the database, by SortOrder, then by Name and then by Id
+func sortCategories(categories []models.DBCategories) {
+	sort.SliceStable(categories, func(i, j int) bool {
+		a, b := categories[i], categories[j]
+		if a.DBSortOrder.Int64 != b.DBSortOrder.Int64 {
+			return a.DBSortOrder.Int64 < b.DBSortOrder.Int64
+		}
+		if a.DBName.String != b.DBName.String {
+			return a.DBName.String < b.DBName.String
+		}
+		return a.DBID.String < b.DBID.String
+	})
+}
+
+// Weight of the matches in the name, the description and the options, the same as the database
+var memorySearchWeights = []float64{10, 5, 1}
+
+func (c *MemoryCmds) SearchProducts(ctx context.Context, query models.SearchQuery, limit int, offset int) ([]models.DBProductSearch, int64, error) {
+
+	c.lock.RLock()
+	t := c.catalogue(ctx)
+
+	optionText := map[string]string{}
+	for _, key := range t.optionOrder {
+		o := t.options[key]
+		if o.DBDeletedAt.Valid {
+			continue
+		}
+		productKey := memoryKey(o.DBProductID.String)
+		optionText[productKey] = strings.TrimSpace(optionText[productKey] + " " + o.DBName.String + " " + o.DBDescription.String)
+	}
+
+	matched := []models.DBProductSearch{}
+	for _, key := range t.productOrder {
+		p := t.products[key]
+		if p.DBDeletedAt.Valid {
+			continue
+		}
+		if score, snippet, ok := searchColumns(query, []string{p.DBName.String, p.DBDescription.String, optionText[key]}); ok {
+			matched = append(matched, models.DBProductSearch{
+				DBProducts: p,
+				DBSnippet:  sql.NullString{String: snippet, Valid: true},
+				DBScore:    sql.NullFloat64{Float64: score, Valid: true},
+			})
+		}
+	}
+	c.lock.RUnlock()
+
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].DBScore.Float64 > matched[j].DBScore.Float64 })
+
+	total := int64(len(matched))
+	if offset > len(matched) {
+		offset = len(matched)
+	}
+	matched = matched[offset:]
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+
+	c.Logger.Debug("Searched the products", "total_rows", len(matched), "total", total)
+	return matched, total, nil
+}
+
+// searchColumns matches every term of the query against the columns, a term may match in any of them
+// Returns the score of the match and the column with the most matches, highlighted
+func searchColumns(query models.SearchQuery, columns []string) (float64, string, bool) {
+
+	score := 0.0
+	hits := make([]int, len(columns))
+	for _, term := range query {
+		found := false
+		for i, column := range columns {
+			if n := len(searchHits(term, searchSpans(column))); n > 0 {
+				score += memorySearchWeights[i] * float64(n)
+				hits[i]++
+				found = true
+			}
+		}
+		if !found {
+			return 0, "", false
+		}
+	}
+
+	best := 0
+	for i := range hits {
+		if hits[i] > hits[best] {
+			best = i
+		}
+	}
+
+	// The matched tokens are wrapped with the marks, from the end so the offsets stay valid
+	text := columns[best]
+	spans := searchSpans(text)
+	marked := map[int]bool{}
+	for _, term := range query {
+		for _, start := range searchHits(term, spans) {
+			for k := start; k < start+len(term.Tokens); k++ {
+				marked[k] = true
+			}
+		}
+	}
+	for k := len(spans) - 1; k >= 0; k-- {
+		if marked[k] {
+			text = text[:spans[k].start] + models.SearchMarkOpen + text[spans[k].start:spans[k].end] + models.SearchMarkClose + text[spans[k].end:]
+		}
+	}
+
+	return score, text, true
+}
+
+// searchSpan is the position of a token in the text
+type searchSpan struct {
+	start, end int
+	token      string
+}
+
+// searchSpans splits the text in tokens the same way as models.SearchTokens, keeping their position
+func searchSpans(text string) []searchSpan {
+
+	var spans []searchSpan
+	start := -1
+	for i, r := range text + " " {
+		isToken := unicode.IsLetter(r) || unicode.IsNumber(r)
+		if isToken && start < 0 {
+			start = i
+		} else if !isToken && start >= 0 {
+			spans = append(spans, searchSpan{start: start, end: i, token: strings.ToLower(text[start:i])})
+			start = -1
+		}
+	}
+	return spans
+}
+
+// searchHits returns the index of the first token of every match of the term
+func searchHits(term models.SearchTerm, spans []searchSpan) []int {
+
+	var hits []int
+	for i := 0; i+len(term.Tokens) <= len(spans); i++ {
+		match := true
+		for k, token := range term.Tokens {
+			last := k == len(term.Tokens)-1
+			if spans[i+k].token != token && !(last && term.Prefix && strings.HasPrefix(spans[i+k].token, token)) {
+				match = false
+				break
+			}
+		}
+		if match {
+			hits = append(hits, i)
+		}
+	}
+	return hits
+}
+
+func (c *MemoryCmds) FetchDeletedProducts(ctx context.Context, limit int, offset int) ([]models.DBProducts, int64, error) {
+
+	c.lock.RLock()
+	t := c.catalogue(ctx)
+	matched := []models.DBProducts{}
+	for _, key := range t.productOrder {
+		if p := t.products[key]; p.DBDeletedAt.Valid {
+			matched = append(matched, p)
+		}
+	}
+	c.lock.RUnlock()
+
+	// Same order as the database, the last deleted first and then by Id
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].DBDeletedAt.Int64 != matched[j].DBDeletedAt.Int64 {
+			return matched[i].DBDeletedAt.Int64 > matched[j].DBDeletedAt.Int64
+		}
+		return matched[i].DBID.String < matched[j].DBID.String
+	})
+
+	total := int64(len(matched))
+	if offset > len(matched) {
+		offset = len(matched)
+	}
+	matched = matched[offset:]
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+
+	c.Logger.Debug("Fetched the deleted products", "total_rows", len(matched), "total", total)
+	return matched, total, nil
+}
+
+func (c *MemoryCmds) FetchDeletedProductOptions(ctx context.Context, pID string) ([]models.DBProductOptions, error) {
+
+	c.lock.RLock()
+	t := c.catalogue(ctx)
+	result := []models.DBProductOptions{}
+	for _, key := range t.optionOrder {
+		if o := t.options[key]; o.DBDeletedAt.Valid && memoryKey(o.DBProductID.String) == memoryKey(pID) {
+			result = append(result, o)
+		}
+	}
+	c.lock.RUnlock()
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].DBDeletedAt.Int64 != result[j].DBDeletedAt.Int64 {
+			return result[i].DBDeletedAt.Int64 > result[j].DBDeletedAt.Int64
+		}
+		return result[i].DBID.String < result[j].DBID.String
+	})
+
+	c.Logger.Debug("Fetched the deleted product options", "total_rows", len(result))
+	return result, nil
+}
+
+func (c *MemoryCmds) RestoreProduct(ctx context.Context, pID string) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	key := memoryKey(pID)
+	before, ok := t.products[key]
+	if !ok || !before.DBDeletedAt.Valid {
+		return 0, nil
+	}
+
+	// Only the options deleted along with the product come back
+	var optionKeys []string
+	for _, optionKey := range t.productOptionKeys(key) {
+		if t.options[optionKey].DBDeletedAt == before.DBDeletedAt {
+			optionKeys = append(optionKeys, optionKey)
+		}
+	}
+	if err := t.checkQuota(1, int64(len(optionKeys))); err != nil {
+		return 0, err
+	}
+
+	after := before
+	after.DBDeletedAt = sql.NullInt64{}
+	after.DBVersion.Int64++
+	t.products[key] = after
+	changes := []auditChange{productChange(models.AuditRestore, &before, &after)}
+	for _, optionKey := range optionKeys {
+		o := t.options[optionKey]
+		optionAfter := o
+		optionAfter.DBDeletedAt = sql.NullInt64{}
+		optionAfter.DBVersion.Int64++
+		t.options[optionKey] = optionAfter
+		changes = append(changes, optionChange(models.AuditRestore, &o, &optionAfter))
+	}
+	c.record(ctx, time.Now(), changes...)
+
+	c.Logger.Debug("Restored the product", "affected_rows", 1)
+	return 1, nil
+}
+
+func (c *MemoryCmds) RestoreProductOption(ctx context.Context, pID string, pOptionID string) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	key := memoryKey(pOptionID)
+	before, ok := t.options[key]
+	if !ok || !before.DBDeletedAt.Valid || memoryKey(before.DBProductID.String) != memoryKey(pID) {
+		return 0, nil
+	}
+	if err := t.checkQuota(0, 1); err != nil {
+		return 0, err
+	}
+	after := before
+	after.DBDeletedAt = sql.NullInt64{}
+	after.DBVersion.Int64++
+	t.options[key] = after
+	c.record(ctx, time.Now(), optionChange(models.AuditRestore, &before, &after))
+
+	c.Logger.Debug("Restored the product option", "affected_rows", 1)
+	return 1, nil
+}
+
+func (c *MemoryCmds) PurgeDeleted(ctx context.Context, before time.Time) (int64, int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := c.catalogue(ctx)
+
+	now := time.Now()
+	cutoff := models.DeletedAtMillis(before)
+	var products, options int64
+	for key, p := range t.products {
+		if !p.DBDeletedAt.Valid || p.DBDeletedAt.Int64 > cutoff {
+			continue
+		}
+		changes := []auditChange{productChange(models.AuditPurge, &p, nil)}
+		for _, optionKey := range t.productOptionKeys(key) {
+			o := t.options[optionKey]
+			t.purgeOption(optionKey)
+			changes = append(changes, optionChange(models.AuditPurge, &o, nil))
+		}
+		for _, price := range t.sortedPrices(key) {
+			changes = append(changes, priceChange(models.AuditPurge, &price, nil))
+		}
+		for categoryID := range t.productCategories[key] {
+			changes = append(changes, categoryChange(models.AuditPurge, key, categoryID))
+		}
+		delete(t.products, key)
+		t.productOrder = removeKey(t.productOrder, key)
+		delete(t.prices, key)
+		delete(t.productCategories, key)
+		c.record(ctx, now, changes...)
+		products++
+	}
+	for key, o := range t.options {
+		if o.DBDeletedAt.Valid && o.DBDeletedAt.Int64 <= cutoff {
+			t.purgeOption(key)
+			c.record(ctx, now, optionChange(models.AuditPurge, &o, nil))
+			options++
+		}
+	}
+
+	c.Logger.Debug("Purged the trash", "products", products, "options", options)
+	return products, options, nil
+}
+
+// productOptionKeys returns the keys of all the options of the product in insertion order, the ones in the trash included
+// The caller must hold the lock
+func (t *memoryCatalogue) productOptionKeys(pID string) []string {
+
+	var keys []string
+	for _, key := range t.optionOrder {
+		if o, ok := t.options[key]; ok && memoryKey(o.DBProductID.String) == memoryKey(pID) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// record appends the changes to the audit log of the tenant of ctx, the caller must hold the lock
+// The catalogue is changed under the same lock, so the changes and their entries are seen together
+func (c *MemoryCmds) record(ctx context.Context, at time.Time, changes ...auditChange) {
+
+	t := c.catalogue(ctx)
+	for _, change := range changes {
+		row, err := auditRow(ctx, at, change)
+		if err != nil {
+			c.Logger.Error("Error while recording the change", "error", err)
+			continue
+		}
+		t.auditLog = append(t.auditLog, row)
+	}
+}
+
+func (c *MemoryCmds) FetchProductHistory(ctx context.Context, pID string, limit int, offset int) ([]models.DBAuditLog, int64, error) {
+
+	c.lock.RLock()
+	t := c.catalogue(ctx)
+	matched := []models.DBAuditLog{}
+	for _, row := range t.auditLog {
+		if row.DBProductID.String == memoryKey(pID) {
+			matched = append(matched, row)
+		}
+	}
+	c.lock.RUnlock()
+
+	// Same order as the database, the last change first and then by Id
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].DBCreatedAt.Int64 != matched[j].DBCreatedAt.Int64 {
+			return matched[i].DBCreatedAt.Int64 > matched[j].DBCreatedAt.Int64
+		}
+		return matched[i].DBID.String < matched[j].DBID.String
+	})
+
+	total := int64(len(matched))
+	if offset > len(matched) {
+		offset = len(matched)
+	}
+	matched = matched[offset:]
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+
+	c.Logger.Debug("Fetched the product history", "total_rows", len(matched), "total", total)
+	return matched, total, nil
+}
+
+// purgeOption removes the option for good with its stock, the caller must hold the lock
+func (t *memoryCatalogue) purgeOption(optionKey string) {
+	delete(t.options, optionKey)
+	t.optionOrder = removeKey(t.optionOrder, optionKey)
+	t.deleteStock(optionKey)
+}
+
+func (c *MemoryCmds) CountCatalogue(ctx context.Context) (int64, int64, error) {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	products, options := c.catalogue(ctx).count()
+	return products, options, nil
+}
+
+// count returns the number of live products and options of the catalogue, the caller must hold the lock
+func (t *memoryCatalogue) count() (int64, int64) {
+
+	var products, options int64
+	for _, p := range t.products {
+		if !p.DBDeletedAt.Valid {
+			products++
+		}
+	}
+	for _, o := range t.options {
+		if !o.DBDeletedAt.Valid {
+			options++
+		}
+	}
+	return products, options
+}
+
+// matchCategories tells if the product is linked to one of the categories, the caller must hold the lock
+func (t *memoryCatalogue) matchCategories(productKey string, categoryIDs []string) bool {
+
+	if categoryIDs == nil {
+		return true
+	}
+	for _, id := range categoryIDs {
+		if t.productCategories[productKey][memoryKey(id)] {
+			return true
+		}
+	}
+	return false
+}
+
+// Same as the filter of the database, the matches are case insensitive
+func matchProductFilter(p models.DBProducts, filter models.ProductFilter) bool {
+
+	if filter.Name != "" && !containsFold(p.DBName.String, filter.Name) {
+		return false
+	}
+	if filter.ExactName != "" && !strings.EqualFold(p.DBName.String, filter.ExactName) {
+		return false
+	}
+	if filter.Description != "" && !containsFold(p.DBDescription.String, filter.Description) {
+		return false
+	}
+	if filter.MinPrice != nil && p.DBPrice.Int64 < *filter.MinPrice {
+		return false
+	}
+	if filter.MaxPrice != nil && p.DBPrice.Int64 > *filter.MaxPrice {
+		return false
+	}
+	if filter.MaxDeliveryPrice != nil && p.DBDeliveryPrice.Int64 > *filter.MaxDeliveryPrice {
+		return false
+	}
+
+	return true
+}
+
+// Compares the values of the sort columns, the result is flipped for the descending columns
+func compareSortValues(a []interface{}, b []interface{}, columns []models.SortField) int {
+
+	for i := range columns {
+		if i >= len(a) || i >= len(b) {
+			break
+		}
+
+		var cmp int
+		switch av := a[i].(type) {
+		case string:
+			cmp = strings.Compare(av, toString(b[i]))
+		default:
+			af, bf := toFloat(a[i]), toFloat(b[i])
+			if af < bf {
+				cmp = -1
+			} else if af > bf {
+				cmp = 1
+			}
+		}
+
+		if columns[i].Desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+
+	return 0
+}
+
+func toString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int64:
+		return float64(n)
+	case int:
+		return float64(n)
+	}
+	return 0
+}
+
+func containsFold(s string, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func removeKey(keys []string, key string) []string {
+	for i := range keys {
+		if keys[i] == key {
+			return append(keys[:i], keys[i+1:]...)
+		}
+	}
+	return keys
+}
+
+// checkQuota refuses with ErrQuotaExceeded the change adding the products and the options to the catalogue, the caller must hold the lock
+func (t *memoryCatalogue) checkQuota(products int64, options int64) error {
+
+	if !t.tenant.DBID.Valid {
+		return ErrUnknownTenant
+	}
+	liveProducts, liveOptions := t.count()
+	if exceeds(t.tenant.DBMaxProducts.Int64, liveProducts, products) || exceeds(t.tenant.DBMaxOptions.Int64, liveOptions, options) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// productTaken tells if a product of any tenant has the id, the caller must hold the lock
+func (c *MemoryCmds) productTaken(key string) bool {
+	for _, t := range c.tenants {
+		if _, ok := t.products[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// optionTaken tells if an option of any tenant has the id, the caller must hold the lock
+func (c *MemoryCmds) optionTaken(key string) bool {
+	for _, t := range c.tenants {
+		if _, ok := t.options[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *MemoryCmds) FetchTenants(_ context.Context) ([]models.DBTenants, error) {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	result := make([]models.DBTenants, 0, len(c.tenants))
+	for _, t := range c.tenants {
+		result = append(result, t.tenant)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].DBID.String < result[j].DBID.String })
+
+	c.Logger.Debug("Fetched the tenants", "total_rows", len(result))
+	return result, nil
+}
+
+func (c *MemoryCmds) FetchTenant(_ context.Context, id string) ([]models.DBTenants, error) {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	result := []models.DBTenants{}
+	if t, ok := c.tenants[memoryKey(id)]; ok {
+		result = append(result, t.tenant)
+	}
+	return result, nil
+}
+
+func (c *MemoryCmds) AddNewTenant(_ context.Context, tenant models.Tenant) error {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	id := memoryKey(tenant.ID)
+	if _, ok := c.tenants[id]; ok {
+		return ErrTenantExists
+	}
+	c.tenants[id] = newMemoryCatalogue(models.DBTenants{
+		DBID:          sql.NullString{String: id, Valid: true},
+		DBName:        sql.NullString{String: tenant.Name, Valid: true},
+		DBMaxProducts: sql.NullInt64{Int64: tenant.MaxProducts, Valid: true},
+		DBMaxOptions:  sql.NullInt64{Int64: tenant.MaxOptions, Valid: true},
+		DBCreatedAt:   sql.NullInt64{Int64: time.Now().UnixNano() / int64(time.Millisecond), Valid: true},
+	})
+
+	c.Logger.Debug("Added new tenant", "tenant", id)
+	return nil
+}
+
+func (c *MemoryCmds) UpdateTenant(_ context.Context, id string, tenant models.Tenant) (int64, error) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t, ok := c.tenants[memoryKey(id)]
+	if !ok {
+		return 0, nil
+	}
+	t.tenant.DBName = sql.NullString{String: tenant.Name, Valid: true}
+	t.tenant.DBMaxProducts = sql.NullInt64{Int64: tenant.MaxProducts, Valid: true}
+	t.tenant.DBMaxOptions = sql.NullInt64{Int64: tenant.MaxOptions, Valid: true}
+
+	c.Logger.Debug("Updated the tenant", "affected_rows", 1)
+	return 1, nil
+}
+
+// DeleteTenant drops the catalogue of the tenant along with its history, same as the database
+func (c *MemoryCmds) DeleteTenant(_ context.Context, id string) (int64, error) {
+
+	id = memoryKey(id)
+	if id == xeroHelper.DefaultTenant {
+		return 0, ErrDefaultTenant
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.tenants[id]; !ok {
+		return 0, nil
+	}
+	delete(c.tenants, id)
+
+	c.Logger.Debug("Deleted the tenant", "tenant", id, "affected_rows", 1)
+	return 1, nil
+}
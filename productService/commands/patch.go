@@ -0,0 +1,174 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.elastic.co/apm"
+
+	"github.com/techievee/xero/productService/models"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// The patches only set the columns of the fields they change, the other columns keep the changes made in between
+const (
+	stmtPatchProduct       = "UPDATE Products SET %s, Version=Version+1 WHERE Id=? AND TenantId=? AND DeletedAt IS NULL AND Version=?"
+	stmtPatchProductOption = "UPDATE ProductOptions SET %s, Version=Version+1 WHERE Id=? and ProductId=? AND TenantId=? AND DeletedAt IS NULL AND Version=?"
+)
+
+// Sets the fields of the product, the fields are named as in models.Product, e.g Price or Currency
+// When version is not nil the product must not have changed since that version, ErrVersionMismatch otherwise
+// Without any field nothing is written, neither the version nor the history change
+func (c *ProductsCmds) PatchProduct(ctx context.Context, productID string, product models.Product, fields []string, version *int64) (int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "products.patch", "db")
+	span.SpanData.Context.SetTag("span", "PatchProduct")
+	defer span.End()
+
+	productID = strings.ToLower(productID)
+
+	var affectedRows int64
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+		before, err := c.productState(ctx, tx, productID)
+		if err == sql.ErrNoRows || (err == nil && before.DBDeletedAt.Valid) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if version != nil && *version != before.DBVersion.Int64 {
+			return ErrVersionMismatch
+		}
+
+		after := before
+		columns, params, err := patchProductRow(&after, product, fields)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			affectedRows = 1
+			return nil
+		}
+
+		stmt := fmt.Sprintf(stmtPatchProduct, strings.Join(columns, ", "))
+		result, err := tx.ExecContext(ctx, c.sql(stmt), append(params, productID, xeroHelper.TenantFrom(ctx), before.DBVersion.Int64)...)
+		if err != nil {
+			return err
+		}
+		if affectedRows, _ = result.RowsAffected(); affectedRows == 0 {
+			return ErrVersionMismatch
+		}
+
+		after.DBVersion.Int64++
+		return c.audit(ctx, tx, time.Now(), productChange(models.AuditUpdate, &before, &after))
+	})
+	if err != nil {
+		c.Logger.Error("Error while patching the product", "error", err)
+		return 0, err
+	}
+	c.Logger.Debug("Patched the product", "affected_rows", affectedRows, "fields", fields)
+	return affectedRows, nil
+}
+
+// Sets the fields of the option of the product, the fields are named as in models.ProductOption
+// When version is not nil the option must not have changed since that version, ErrVersionMismatch otherwise
+func (c *ProductsCmds) PatchProductOption(ctx context.Context, pID string, pOptionID string, option models.ProductOption, fields []string, version *int64) (int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "product_options.patch", "db")
+	span.SpanData.Context.SetTag("span", "PatchProductOption")
+	defer span.End()
+
+	pID, pOptionID = strings.ToLower(pID), strings.ToLower(pOptionID)
+
+	var affectedRows int64
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+		before, err := c.optionStates(ctx, tx, pID, " AND Id=? AND DeletedAt IS NULL", pOptionID)
+		if err != nil || len(before) == 0 {
+			return err
+		}
+		if version != nil && *version != before[0].DBVersion.Int64 {
+			return ErrVersionMismatch
+		}
+
+		after := before[0]
+		columns, params, err := patchOptionRow(&after, option, fields)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			affectedRows = 1
+			return nil
+		}
+
+		stmt := fmt.Sprintf(stmtPatchProductOption, strings.Join(columns, ", "))
+		result, err := tx.ExecContext(ctx, c.sql(stmt), append(params, pOptionID, pID, xeroHelper.TenantFrom(ctx), before[0].DBVersion.Int64)...)
+		if err != nil {
+			return err
+		}
+		if affectedRows, _ = result.RowsAffected(); affectedRows == 0 {
+			return ErrVersionMismatch
+		}
+
+		after.DBVersion.Int64++
+		return c.audit(ctx, tx, time.Now(), optionChange(models.AuditUpdate, &before[0], &after))
+	})
+	if err != nil {
+		c.Logger.Error("Error while patching the product option", "error", err)
+		return 0, err
+	}
+	c.Logger.Debug("Patched the product option", "affected_rows", affectedRows, "fields", fields)
+	return affectedRows, nil
+}
+
+// patchProductRow sets the fields of the product in the row, returns the assignments of their columns and the values to assign
+func patchProductRow(row *models.DBProducts, product models.Product, fields []string) ([]string, []interface{}, error) {
+
+	var columns []string
+	var params []interface{}
+	for _, field := range fields {
+		switch field {
+		case "Name":
+			row.DBName = sql.NullString{String: product.Name, Valid: true}
+			columns, params = append(columns, "Name=?"), append(params, product.Name)
+		case "Description":
+			row.DBDescription = sql.NullString{String: product.Description, Valid: true}
+			columns, params = append(columns, "Description=?"), append(params, product.Description)
+		case "Price":
+			row.DBPrice = sql.NullInt64{Int64: product.Price.Amount, Valid: true}
+			columns, params = append(columns, "PriceMinor=?"), append(params, product.Price.Amount)
+		case "DeliveryPrice":
+			row.DBDeliveryPrice = sql.NullInt64{Int64: product.DeliveryPrice.Amount, Valid: true}
+			columns, params = append(columns, "DeliveryPriceMinor=?"), append(params, product.DeliveryPrice.Amount)
+		case "Currency":
+			row.DBCurrency = sql.NullString{String: product.PriceCurrency(), Valid: true}
+			columns, params = append(columns, "Currency=?"), append(params, product.PriceCurrency())
+		default:
+			return nil, nil, fmt.Errorf("unknown product field %s", field)
+		}
+	}
+	return columns, params, nil
+}
+
+// patchOptionRow sets the fields of the option in the row, returns the assignments of their columns and the values to assign
+func patchOptionRow(row *models.DBProductOptions, option models.ProductOption, fields []string) ([]string, []interface{}, error) {
+
+	var columns []string
+	var params []interface{}
+	for _, field := range fields {
+		switch field {
+		case "Name":
+			row.DBName = sql.NullString{String: option.Name, Valid: true}
+			columns, params = append(columns, "Name=?"), append(params, option.Name)
+		case "Description":
+			row.DBDescription = sql.NullString{String: option.Description, Valid: true}
+			columns, params = append(columns, "Description=?"), append(params, option.Description)
+		default:
+			return nil, nil, fmt.Errorf("unknown product option field %s", field)
+		}
+	}
+	return columns, params, nil
+}
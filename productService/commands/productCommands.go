@@ -1,11 +1,52 @@
 package commands
 
 import (
+	"context"
+
+	"go.elastic.co/apm"
+
 	"github.com/techievee/xero/database"
+	"github.com/techievee/xero/xeroHelper"
 	"github.com/techievee/xero/xeroLog/debugcore"
 )
 
+// ProductsCmds is the SQL database implementation of the Repository
 type ProductsCmds struct {
 	DB     *database.DB
 	Logger debugcore.Logger
 }
+
+// Every statement is scoped to the tenant of ctx, a row of another tenant is never read nor changed even when its id is known
+// The prices, the reservations and the links of the categories have no tenant, they are scoped by the tenant of their parent row
+// The ids are stored as lower case UUIDs, every method lowers the ids it is given so the plain Id=? comparisons use the primary keys
+const (
+	stmtCountCatalogue    = "SELECT (SELECT COUNT(*) FROM Products WHERE DeletedAt IS NULL AND TenantId=?), (SELECT COUNT(*) FROM ProductOptions WHERE DeletedAt IS NULL AND TenantId=?)"
+	stmtTenantProductIDs  = "SELECT Id FROM Products WHERE TenantId=?"
+	stmtTenantOptionIDs   = "SELECT Id FROM ProductOptions WHERE TenantId=?"
+	stmtTenantCategoryIDs = "SELECT Id FROM Categories WHERE TenantId=?"
+)
+
+// CountCatalogue returns the number of products and options of the tenant of ctx, the ones in the trash left out
+func (c *ProductsCmds) CountCatalogue(ctx context.Context) (int64, int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "catalogue.count", "db")
+	span.SpanData.Context.SetTag("span", "CountCatalogue")
+	defer span.End()
+
+	tenant := xeroHelper.TenantFrom(ctx)
+	var products, options int64
+	if err := c.DB.RO(ctx).QueryRowContext(ctx, c.sql(stmtCountCatalogue), tenant, tenant).Scan(&products, &options); err != nil {
+		c.Logger.Error("Error while counting the catalogue", "error", err)
+		return 0, 0, err
+	}
+
+	return products, options, nil
+}
+
+// Translates the statement in to the SQL of the database
+func (c *ProductsCmds) sql(stmt string) string {
+	if c.DB.Dialect == nil {
+		return stmt
+	}
+	return c.DB.Dialect.Rewrite(stmt)
+}
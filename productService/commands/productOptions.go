@@ -3,19 +3,27 @@ package commands
 import (
 	"context"
 	"database/sql"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"go.elastic.co/apm"
 
 	"github.com/techievee/xero/productService/models"
+	"github.com/techievee/xero/xeroHelper"
 )
 
+// The options in the trash have DeletedAt set, they are left out of all the statements but the ones of the trash
 const (
-	stmtProductOptions         = "SELECT Id, Name, Description FROM ProductOptions WHERE ProductId=? COLLATE NOCASE "
-	stmtInsertProductOption    = "INSERT INTO  ProductOptions (Id, ProductId, Name, Description) VALUES (?,?,?,?)"
-	stmtUpdateProductOption    = "UPDATE ProductOptions SET Name=?, Description=? WHERE Id=? COLLATE NOCASE and ProductId=? COLLATE NOCASE"
-	stmtDeleteProductOption    = "DELETE FROM ProductOptions WHERE Id=? COLLATE NOCASE and ProductId=? COLLATE NOCASE"
-	stmtDeleteAllProductOption = "DELETE FROM ProductOptions WHERE ProductId=? COLLATE NOCASE"
+	stmtProductOptions         = "SELECT Id, Name, Description, Version FROM ProductOptions WHERE ProductId=? AND TenantId=? AND DeletedAt IS NULL "
+	stmtInsertProductOption    = "INSERT INTO  ProductOptions (Id, ProductId, Name, Description, TenantId) VALUES (?,?,?,?,?)"
+	stmtUpdateProductOption    = "UPDATE ProductOptions SET Name=?, Description=?, Version=Version+1 WHERE Id=? and ProductId=? AND TenantId=? AND DeletedAt IS NULL AND Version=?"
+	stmtDeleteProductOption    = "UPDATE ProductOptions SET DeletedAt=?, Version=Version+1 WHERE Id=? and ProductId=? AND TenantId=? AND DeletedAt IS NULL AND Version=?"
+	stmtPurgeProductOption     = "DELETE FROM ProductOptions WHERE Id=? and ProductId=? AND TenantId=?"
+	stmtDeleteAllProductOption = "DELETE FROM ProductOptions WHERE ProductId=? AND TenantId=?"
+	stmtCountProductOptions    = "SELECT COUNT(*) FROM ProductOptions WHERE ProductId=? AND TenantId=? AND DeletedAt IS NULL "
+	stmtProductOptionsLimit    = " LIMIT ? OFFSET ?"
+	stmtOptionsOfProducts      = "SELECT Id, ProductId, Name, Description, Version FROM ProductOptions WHERE TenantId=? AND DeletedAt IS NULL AND ProductId IN "
 )
 
 // Returns all the product option for the specified product id
@@ -25,27 +33,33 @@ func (c *ProductsCmds) FetchAllProductOptions(ctx context.Context, pID string, p
 	span.SpanData.Context.SetTag("span", "ShowProductOptions")
 	defer span.End()
 
+	pID, pOptionID = strings.ToLower(pID), strings.ToLower(pOptionID)
+
 	db := c.DB.RO(ctx)
 
-	params := []interface{}{pID}
+	params := []interface{}{pID, xeroHelper.TenantFrom(ctx)}
 	stmt := stmtProductOptions
 	if pOptionID != "" {
-		stmt += " AND Id=? COLLATE NOCASE "
+		stmt += " AND Id=? "
 		params = append(params, pOptionID)
 	}
 
 	var rows *sql.Rows
 	var err error
-	rows, err = db.QueryContext(ctx, stmt, params...)
+	rows, err = db.QueryContext(ctx, c.sql(stmt), params...)
 	if err != nil {
 		c.Logger.Error("Error while fetching product options", "error", err)
 		return nil, err
 	}
+	defer rows.Close()
 
 	result := []models.DBProductOptions{}
 	for rows.Next() {
 		dbObj := models.DBProductOptions{}
-		rows.Scan(&dbObj.DBID, &dbObj.DBName, &dbObj.DBDescription)
+		if err := rows.Scan(&dbObj.DBID, &dbObj.DBName, &dbObj.DBDescription, &dbObj.DBVersion); err != nil {
+			c.Logger.Error("Error while scanning rows", "error", err)
+			return nil, err
+		}
 		result = append(result, dbObj)
 	}
 	if err = rows.Err(); err != nil {
@@ -57,79 +71,263 @@ func (c *ProductsCmds) FetchAllProductOptions(ctx context.Context, pID string, p
 	return result, nil
 }
 
+// Returns the options of the specified products in one statement, the products without options are left out
+func (c *ProductsCmds) FetchOptionsOfProducts(ctx context.Context, pIDs []string) ([]models.DBProductOptions, error) {
+
+	span, ctx := apm.StartSpan(ctx, "product_options.show", "db")
+	span.SpanData.Context.SetTag("span", "FetchOptionsOfProducts")
+	defer span.End()
+
+	if len(pIDs) == 0 {
+		return []models.DBProductOptions{}, nil
+	}
+
+	params := []interface{}{xeroHelper.TenantFrom(ctx)}
+	placeholders := make([]string, 0, len(pIDs))
+	for _, id := range pIDs {
+		placeholders = append(placeholders, "?")
+		params = append(params, strings.ToLower(id))
+	}
+	stmt := stmtOptionsOfProducts + "(" + strings.Join(placeholders, ",") + ")"
+
+	rows, err := c.DB.RO(ctx).QueryContext(ctx, c.sql(stmt), params...)
+	if err != nil {
+		c.Logger.Error("Error while fetching product options", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []models.DBProductOptions{}
+	for rows.Next() {
+		dbObj := models.DBProductOptions{}
+		if err := rows.Scan(&dbObj.DBID, &dbObj.DBProductID, &dbObj.DBName, &dbObj.DBDescription, &dbObj.DBVersion); err != nil {
+			c.Logger.Error("Error while scanning rows", "error", err)
+			return nil, err
+		}
+		result = append(result, dbObj)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger.Error("Error while scanning rows", "error", err)
+		return nil, err
+	}
+
+	c.Logger.Debug("Fetched the options of the products", "total_rows", len(result), "products", len(pIDs))
+	return result, nil
+}
+
+// Returns one page of options for the specified product ordered by (Name, Id) and the total number of options
+// One extra row is fetched over the limit, so the caller can tell if there is a next page
+func (c *ProductsCmds) FetchProductOptionsPage(ctx context.Context, pID string, page models.PageRequest) ([]models.DBProductOptions, int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "product_options.page", "db")
+	span.SpanData.Context.SetTag("span", "FetchProductOptionsPage")
+	defer span.End()
+
+	pID = strings.ToLower(pID)
+
+	db := c.DB.RO(ctx)
+	tenant := xeroHelper.TenantFrom(ctx)
+
+	var total int64
+	if err := db.QueryRowContext(ctx, c.sql(stmtCountProductOptions), pID, tenant).Scan(&total); err != nil {
+		c.Logger.Error("Error while counting product options", "error", err)
+		return nil, 0, err
+	}
+
+	params := []interface{}{pID, tenant}
+	stmt := stmtProductOptions
+	if page.Cursor != nil {
+		keyset, keysetParams := keysetClause(models.ProductOptionSort, page.Cursor)
+		stmt += " AND " + keyset
+		params = append(params, keysetParams...)
+	}
+	stmt += orderByClause(models.ProductOptionSort) + stmtProductOptionsLimit
+	params = append(params, page.Limit+1, page.Offset)
+
+	rows, err := db.QueryContext(ctx, c.sql(stmt), params...)
+	if err != nil {
+		c.Logger.Error("Error while fetching product options page", "error", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	result := []models.DBProductOptions{}
+	for rows.Next() {
+		dbObj := models.DBProductOptions{}
+		if err := rows.Scan(&dbObj.DBID, &dbObj.DBName, &dbObj.DBDescription, &dbObj.DBVersion); err != nil {
+			c.Logger.Error("Error while scanning rows", "error", err)
+			return nil, 0, err
+		}
+		result = append(result, dbObj)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger.Error("Error while scanning rows", "error", err)
+		return nil, 0, err
+	}
+
+	c.Logger.Debug("Fetched the product options page", "total_rows", len(result), "total", total)
+	return result, total, nil
+}
+
 // Returns the newly added product option id
+// Returns ErrUnknownProduct when the product is not of the tenant or in the trash, ErrQuotaExceeded when the tenant has all its options
 func (c *ProductsCmds) AddNewProductOption(ctx context.Context, pID string, product models.ProductOption) (string, error) {
 
 	span, ctx := apm.StartSpan(ctx, "product_options.add", "db")
 	span.SpanData.Context.SetTag("span", "AddNewProductOption")
 	defer span.End()
 
-	db := c.DB.RW(ctx)
-	id := uuid.New()
-	statement, _ := db.Prepare(stmtInsertProductOption)
-	result, err := statement.ExecContext(ctx, id, pID, product.Name, product.Description)
-	if err != nil {
+	pID = strings.ToLower(pID)
+
+	id := uuid.New().String()
+	row := optionRow(id, pID, product)
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+		owner, err := c.productState(ctx, tx, pID)
+		if err == sql.ErrNoRows || (err == nil && owner.DBDeletedAt.Valid) {
+			return ErrUnknownProduct
+		} else if err != nil {
+			return err
+		}
+		if err := c.checkQuota(ctx, tx, 0, 1); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, c.sql(stmtInsertProductOption), row.DBID, row.DBProductID, row.DBName, row.DBDescription, xeroHelper.TenantFrom(ctx)); err != nil {
+			return err
+		}
+		return c.audit(ctx, tx, time.Now(), optionChange(models.AuditCreate, nil, &row))
+	})
+	if err == ErrUnknownProduct || err == ErrQuotaExceeded {
+		return "", err
+	} else if err != nil {
 		c.Logger.Error("Error while inserting new rows to product option", "error", err)
 		return "", err
 	}
-	insertedID, _ := result.LastInsertId()
-	c.Logger.Debug("Added new product option", "inserted_id", insertedID, "uuid", id)
-	return id.String(), err
+	c.Logger.Debug("Added new product option", "uuid", id)
+	return id, nil
 }
 
 // Returns total number of rows affected by this update
-func (c *ProductsCmds) UpdateProductOption(ctx context.Context, pID string, pOptionID string, product models.ProductOption) (int64, error) {
+// When version is not nil the option must not have changed since that version, ErrVersionMismatch otherwise
+func (c *ProductsCmds) UpdateProductOption(ctx context.Context, pID string, pOptionID string, product models.ProductOption, version *int64) (int64, error) {
 
 	span, ctx := apm.StartSpan(ctx, "product_options.update", "db")
 	span.SpanData.Context.SetTag("span", "UpdateProductOptions")
 	defer span.End()
 
-	db := c.DB.RW(ctx)
-	statement, _ := db.Prepare(stmtUpdateProductOption)
-	result, err := statement.ExecContext(ctx, product.Name, product.Description, pOptionID, pID)
+	pID, pOptionID = strings.ToLower(pID), strings.ToLower(pOptionID)
+
+	var affectedRows int64
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+		before, err := c.optionStates(ctx, tx, pID, " AND Id=? AND DeletedAt IS NULL", pOptionID)
+		if err != nil || len(before) == 0 {
+			return err
+		}
+		if version != nil && *version != before[0].DBVersion.Int64 {
+			return ErrVersionMismatch
+		}
+
+		result, err := tx.ExecContext(ctx, c.sql(stmtUpdateProductOption), product.Name, product.Description, pOptionID, pID, xeroHelper.TenantFrom(ctx), before[0].DBVersion.Int64)
+		if err != nil {
+			return err
+		}
+		if affectedRows, _ = result.RowsAffected(); affectedRows == 0 {
+			return ErrVersionMismatch
+		}
+
+		after := optionRow(before[0].DBID.String, before[0].DBProductID.String, product)
+		after.DBVersion = sql.NullInt64{Int64: before[0].DBVersion.Int64 + 1, Valid: true}
+		return c.audit(ctx, tx, time.Now(), optionChange(models.AuditUpdate, &before[0], &after))
+	})
 	if err != nil {
 		c.Logger.Error("Error while updating product options", "error", err)
 		return 0, err
 	}
-	affectedRows, err := result.RowsAffected()
 	c.Logger.Debug("Updated the product options", "affected_rows", affectedRows)
-	return affectedRows, err
+	return affectedRows, nil
 }
 
-// Delete the product specified in the product option
-func (c *ProductsCmds) DeleteProductOption(ctx context.Context, pID string, pOptionID string) (int64, error) {
+// Moves the option of the product to the trash, its stock and its reservations are kept until it is purged
+// When version is not nil the option must not have changed since that version, ErrVersionMismatch otherwise
+func (c *ProductsCmds) DeleteProductOption(ctx context.Context, pID string, pOptionID string, version *int64) (int64, error) {
 
 	span, ctx := apm.StartSpan(ctx, "product_options.delete", "db")
 	span.SpanData.Context.SetTag("span", "DeleteProductoption")
 	defer span.End()
 
-	db := c.DB.RW(ctx)
-	statement, _ := db.Prepare(stmtDeleteProductOption)
-	result, err := statement.ExecContext(ctx, pOptionID, pID)
+	pID, pOptionID = strings.ToLower(pID), strings.ToLower(pOptionID)
+
+	now := time.Now()
+	deletedAt := sql.NullInt64{Int64: models.DeletedAtMillis(now), Valid: true}
+	var affectedRows int64
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+		before, err := c.optionStates(ctx, tx, pID, " AND Id=? AND DeletedAt IS NULL", pOptionID)
+		if err != nil || len(before) == 0 {
+			return err
+		}
+		if version != nil && *version != before[0].DBVersion.Int64 {
+			return ErrVersionMismatch
+		}
+
+		result, err := tx.ExecContext(ctx, c.sql(stmtDeleteProductOption), deletedAt, pOptionID, pID, xeroHelper.TenantFrom(ctx), before[0].DBVersion.Int64)
+		if err != nil {
+			return err
+		}
+		if affectedRows, _ = result.RowsAffected(); affectedRows == 0 {
+			return ErrVersionMismatch
+		}
+
+		after := before[0]
+		after.DBDeletedAt = deletedAt
+		after.DBVersion.Int64++
+		return c.audit(ctx, tx, now, optionChange(models.AuditDelete, &before[0], &after))
+	})
 	if err != nil {
 		c.Logger.Error("Error while deleting product option", "error", err)
 		return 0, err
 	}
-	affectedRows, _ := result.RowsAffected()
 	c.Logger.Debug("Deleted the product option", "affected_rows", affectedRows)
-	return affectedRows, err
+	return affectedRows, nil
 }
 
-// Delete the all options for the specified product
+// Delete the all options for the specified product for good, along with their reservations
 func (c *ProductsCmds) DeleteAllProductOptions(ctx context.Context, pID string) (int64, error) {
 
 	span, ctx := apm.StartSpan(ctx, "product_options.delete", "db")
 	span.SpanData.Context.SetTag("span", "DeleteAllProductoptions")
 	defer span.End()
 
-	db := c.DB.RW(ctx)
-	statement, _ := db.Prepare(stmtDeleteAllProductOption)
-	result, err := statement.ExecContext(ctx, pID)
+	pID = strings.ToLower(pID)
+
+	var affectedRows int64
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+		before, err := c.optionStates(ctx, tx, pID, "")
+		if err != nil {
+			return err
+		}
+
+		tenant := xeroHelper.TenantFrom(ctx)
+		if _, err := tx.ExecContext(ctx, c.sql(stmtDeleteProductReservations), pID, tenant); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, c.sql(stmtDeleteAllProductOption), pID, tenant)
+		if err != nil {
+			return err
+		}
+		affectedRows, _ = result.RowsAffected()
+
+		changes := make([]auditChange, 0, len(before))
+		for i := range before {
+			changes = append(changes, optionChange(models.AuditPurge, &before[i], nil))
+		}
+		return c.audit(ctx, tx, time.Now(), changes...)
+	})
 	if err != nil {
 		c.Logger.Error("Error while deleting all product option", "error", err)
 		return 0, err
 	}
-	affectedRows, _ := result.RowsAffected()
 	c.Logger.Debug("Deleted all the product options", "affected_rows", affectedRows)
-	return affectedRows, err
+	return affectedRows, nil
 }
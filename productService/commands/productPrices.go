@@ -0,0 +1,220 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"go.elastic.co/apm"
+
+	"github.com/techievee/xero/database/dialect"
+	"github.com/techievee/xero/productService/models"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// The prices belong to the tenant of their product, a price is only set once its product was changed in the tenant
+const (
+	stmtProductPrices          = "SELECT ProductId, Currency, PriceMinor, DeliveryPriceMinor FROM ProductPrices"
+	stmtTenantPrices           = " AND ProductId IN (" + stmtTenantProductIDs + ")"
+	stmtDeleteProductPrice     = "DELETE FROM ProductPrices WHERE ProductId=? AND Currency=?" + stmtTenantPrices
+	stmtDeleteAllProductPrices = "DELETE FROM ProductPrices WHERE ProductId=?" + stmtTenantPrices
+)
+
+var (
+	productPriceColumns = []string{"ProductId", "Currency", "PriceMinor", "DeliveryPriceMinor"}
+	productPriceKeys    = []string{"ProductId", "Currency"}
+)
+
+// Returns the prices of the specified product ordered by currency, only the price in the currency when it is specified
+func (c *ProductsCmds) FetchProductPrices(ctx context.Context, pID string, currency string) ([]models.DBProductPrices, error) {
+
+	span, ctx := apm.StartSpan(ctx, "product_prices.show", "db")
+	span.SpanData.Context.SetTag("span", "FetchProductPrices")
+	defer span.End()
+
+	pID = strings.ToLower(pID)
+
+	params := []interface{}{pID, xeroHelper.TenantFrom(ctx)}
+	stmt := stmtProductPrices + " WHERE ProductId=?" + stmtTenantPrices
+	if currency != "" {
+		stmt += " AND Currency=?"
+		params = append(params, currency)
+	}
+	stmt += " ORDER BY Currency"
+
+	return c.queryProductPrices(ctx, stmt, params)
+}
+
+// Returns the prices in the currency of the specified products, the products without a price in the currency are left out
+func (c *ProductsCmds) FetchPricesInCurrency(ctx context.Context, currency string, pIDs []string) ([]models.DBProductPrices, error) {
+
+	span, ctx := apm.StartSpan(ctx, "product_prices.show", "db")
+	span.SpanData.Context.SetTag("span", "FetchPricesInCurrency")
+	defer span.End()
+
+	if len(pIDs) == 0 {
+		return []models.DBProductPrices{}, nil
+	}
+
+	// The product ids are stored in lower case, the plain IN comparison can use the primary key
+	params := []interface{}{currency}
+	placeholders := make([]string, 0, len(pIDs))
+	for _, id := range pIDs {
+		placeholders = append(placeholders, "?")
+		params = append(params, strings.ToLower(id))
+	}
+	params = append(params, xeroHelper.TenantFrom(ctx))
+	stmt := stmtProductPrices + " WHERE Currency=? AND ProductId IN (" + strings.Join(placeholders, ",") + ")" + stmtTenantPrices
+
+	return c.queryProductPrices(ctx, stmt, params)
+}
+
+func (c *ProductsCmds) queryProductPrices(ctx context.Context, stmt string, params []interface{}) ([]models.DBProductPrices, error) {
+
+	db := c.DB.RO(ctx)
+	rows, err := db.QueryContext(ctx, c.sql(stmt), params...)
+	if err != nil {
+		c.Logger.Error("Error while fetching product prices", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []models.DBProductPrices{}
+	for rows.Next() {
+		dbObj := models.DBProductPrices{}
+		if err := rows.Scan(&dbObj.DBProductID, &dbObj.DBCurrency, &dbObj.DBPrice, &dbObj.DBDeliveryPrice); err != nil {
+			c.Logger.Error("Error while scanning rows", "error", err)
+			return nil, err
+		}
+		result = append(result, dbObj)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger.Error("Error while scanning rows", "error", err)
+		return nil, err
+	}
+
+	c.Logger.Debug("Fetched the product prices", "total_rows", len(result))
+	return result, nil
+}
+
+// Sets the price of the product in the currency of the price, the existing price in the currency is replaced
+// Returns ErrUnknownProduct when the product is not of the tenant
+func (c *ProductsCmds) SetProductPrice(ctx context.Context, pID string, price models.ProductPrice) error {
+
+	span, ctx := apm.StartSpan(ctx, "product_prices.set", "db")
+	span.SpanData.Context.SetTag("span", "SetProductPrice")
+	defer span.End()
+
+	pID = strings.ToLower(pID)
+
+	d := c.DB.Dialect
+	if d == nil {
+		d = dialect.SQLite
+	}
+
+	// Upsert returns the statement already in the dialect
+	stmt := d.Upsert("ProductPrices", productPriceColumns, productPriceKeys)
+	after := priceRow(pID, price)
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+		// The prices are part of the representation of the product, its version changes with them
+		// The product is changed first, the price of a product of another tenant is never written
+		touched, err := tx.ExecContext(ctx, c.sql(stmtTouchProduct), pID, xeroHelper.TenantFrom(ctx))
+		if err != nil {
+			return err
+		}
+		if affectedRows, _ := touched.RowsAffected(); affectedRows == 0 {
+			return ErrUnknownProduct
+		}
+
+		before, err := c.priceStates(ctx, tx, pID, price.Currency)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, stmt, after.DBProductID, after.DBCurrency, after.DBPrice, after.DBDeliveryPrice); err != nil {
+			return err
+		}
+
+		if len(before) == 0 {
+			return c.audit(ctx, tx, time.Now(), priceChange(models.AuditCreate, nil, &after))
+		}
+		return c.audit(ctx, tx, time.Now(), priceChange(models.AuditUpdate, &before[0], &after))
+	})
+	if err == ErrUnknownProduct {
+		return err
+	} else if err != nil {
+		c.Logger.Error("Error while setting the product price", "error", err)
+		return err
+	}
+
+	c.Logger.Debug("Set the product price", "uuid", pID, "currency", price.Currency)
+	return nil
+}
+
+// Deletes the price of the product in the currency
+func (c *ProductsCmds) DeleteProductPrice(ctx context.Context, pID string, currency string) (int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "product_prices.delete", "db")
+	span.SpanData.Context.SetTag("span", "DeleteProductPrice")
+	defer span.End()
+
+	pID = strings.ToLower(pID)
+
+	affectedRows, err := c.deletePrices(ctx, pID, currency, stmtDeleteProductPrice, pID, currency, xeroHelper.TenantFrom(ctx))
+	if err != nil {
+		c.Logger.Error("Error while deleting product price", "error", err)
+		return 0, err
+	}
+	c.Logger.Debug("Deleted the product price", "affected_rows", affectedRows)
+	return affectedRows, nil
+}
+
+// Deletes all the prices of the specified product
+func (c *ProductsCmds) DeleteAllProductPrices(ctx context.Context, pID string) (int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "product_prices.delete", "db")
+	span.SpanData.Context.SetTag("span", "DeleteAllProductPrices")
+	defer span.End()
+
+	pID = strings.ToLower(pID)
+
+	affectedRows, err := c.deletePrices(ctx, pID, "", stmtDeleteAllProductPrices, pID, xeroHelper.TenantFrom(ctx))
+	if err != nil {
+		c.Logger.Error("Error while deleting all product prices", "error", err)
+		return 0, err
+	}
+	c.Logger.Debug("Deleted all the product prices", "affected_rows", affectedRows)
+	return affectedRows, nil
+}
+
+// Deletes the prices of the product in the currency, all of them when the currency is not specified, the deletions are recorded in the audit log
+func (c *ProductsCmds) deletePrices(ctx context.Context, pID string, currency string, stmt string, params ...interface{}) (int64, error) {
+
+	var affectedRows int64
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+		before, err := c.priceStates(ctx, tx, pID, currency)
+		if err != nil || len(before) == 0 {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, c.sql(stmt), params...)
+		if err != nil {
+			return err
+		}
+		affectedRows, _ = result.RowsAffected()
+		if _, err := tx.ExecContext(ctx, c.sql(stmtTouchProduct), pID, xeroHelper.TenantFrom(ctx)); err != nil {
+			return err
+		}
+
+		changes := make([]auditChange, 0, len(before))
+		for i := range before {
+			changes = append(changes, priceChange(models.AuditDelete, &before[i], nil))
+		}
+		return c.audit(ctx, tx, time.Now(), changes...)
+	})
+
+	return affectedRows, err
+}
@@ -0,0 +1,122 @@
+package commands
+
+import (
+	"context"
+	"strings"
+
+	"github.com/techievee/xero/productService/models"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// Returns the conditions and their params for the product filter, the products of the tenant of ctx only
+// Column names are constants, user input is only ever passed as params
+func productFilterClause(ctx context.Context, filter models.ProductFilter) ([]string, []interface{}) {
+
+	// The products in the trash are never listed
+	where := []string{stmtLiveProducts, stmtTenantRows}
+	params := []interface{}{xeroHelper.TenantFrom(ctx)}
+
+	if filter.Name != "" {
+		where = append(where, " Name like ? COLLATE NOCASE ")
+		params = append(params, "%"+strings.ToLower(filter.Name)+"%")
+	}
+	if filter.ExactName != "" {
+		where = append(where, " Name = ? COLLATE NOCASE ")
+		params = append(params, filter.ExactName)
+	}
+	if filter.Description != "" {
+		where = append(where, " Description like ? COLLATE NOCASE ")
+		params = append(params, "%"+strings.ToLower(filter.Description)+"%")
+	}
+	if filter.MinPrice != nil {
+		where = append(where, " PriceMinor >= ? ")
+		params = append(params, *filter.MinPrice)
+	}
+	if filter.MaxPrice != nil {
+		where = append(where, " PriceMinor <= ? ")
+		params = append(params, *filter.MaxPrice)
+	}
+	if filter.MaxDeliveryPrice != nil {
+		where = append(where, " DeliveryPriceMinor <= ? ")
+		params = append(params, *filter.MaxDeliveryPrice)
+	}
+	if filter.CategoryIDs != nil {
+		placeholders := make([]string, 0, len(filter.CategoryIDs))
+		for _, id := range filter.CategoryIDs {
+			placeholders = append(placeholders, "?")
+			params = append(params, strings.ToLower(id))
+		}
+		if len(placeholders) == 0 {
+			placeholders = append(placeholders, "NULL")
+		}
+		where = append(where, " Id IN (SELECT ProductId FROM ProductCategories WHERE CategoryId IN ("+strings.Join(placeholders, ",")+")) ")
+	}
+
+	return where, params
+}
+
+// Returns the keyset condition that resumes the scan right after the cursor
+// For the order (c1, c2, Id) the condition is c1 > v1 OR (c1 = v1 AND c2 > v2) OR (c1 = v1 AND c2 = v2 AND Id > id)
+// The comparison is flipped for the descending columns
+func keysetClause(sort []models.SortField, cursor *models.Cursor) (string, []interface{}) {
+
+	columns, values := sortColumns(sort, cursor)
+
+	var terms []string
+	var params []interface{}
+	for i := range columns {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, columns[j].Column+" = ?")
+			params = append(params, values[j])
+		}
+		op := " > ?"
+		if columns[i].Desc {
+			op = " < ?"
+		}
+		parts = append(parts, columns[i].Column+op)
+		params = append(params, values[i])
+		terms = append(terms, "("+strings.Join(parts, " AND ")+")")
+	}
+
+	return " (" + strings.Join(terms, " OR ") + ") ", params
+}
+
+// Returns the ORDER BY clause for the sort order, Id is always the last column to make the order stable
+func orderByClause(sort []models.SortField) string {
+
+	columns, _ := sortColumns(sort, nil)
+
+	var order []string
+	for _, f := range columns {
+		if f.Desc {
+			order = append(order, f.Column+" DESC")
+		} else {
+			order = append(order, f.Column)
+		}
+	}
+
+	return " ORDER BY " + strings.Join(order, ", ")
+}
+
+// Appends the Id tie breaker to the sort columns, unless the order already contains it
+func sortColumns(sort []models.SortField, cursor *models.Cursor) ([]models.SortField, []interface{}) {
+
+	var values []interface{}
+	if cursor != nil {
+		values = append(values, cursor.Values...)
+	}
+
+	for _, f := range sort {
+		if f.Column == "Id" {
+			return sort, values
+		}
+	}
+
+	columns := append(append([]models.SortField{}, sort...), models.SortField{Field: "id", Column: "Id"})
+	if cursor != nil {
+		values = append(values, cursor.ID)
+	}
+
+	return columns, values
+}
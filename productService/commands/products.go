@@ -3,55 +3,69 @@ package commands
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"go.elastic.co/apm"
 
 	"github.com/techievee/xero/productService/models"
+	"github.com/techievee/xero/xeroHelper"
 )
 
+// The products in the trash have DeletedAt set, they are left out of all the statements but the ones of the trash
 const (
-	stmtProducts      = "SELECT Id, Name, Description, Price, DeliveryPrice FROM Products"
-	stmtInsertProduct = "INSERT INTO  Products (Id, Name, Description, Price, DeliveryPrice) VALUES (?,?,?,?,?)"
-	stmtUpdateProduct = "UPDATE Products SET Name=?, Description=?, Price=?, DeliveryPrice=? WHERE Id=? COLLATE NOCASE"
-	stmtDeleteProduct = "DELETE FROM Products WHERE Id=? COLLATE NOCASE"
+	stmtProducts             = "SELECT Id, Name, Description, PriceMinor, DeliveryPriceMinor, Currency, Version FROM Products"
+	stmtLiveProducts         = " DeletedAt IS NULL "
+	stmtTenantRows           = " TenantId=? "
+	stmtInsertProduct        = "INSERT INTO  Products (Id, Name, Description, PriceMinor, DeliveryPriceMinor, Currency, TenantId) VALUES (?,?,?,?,?,?,?)"
+	stmtUpdateProduct        = "UPDATE Products SET Name=?, Description=?, PriceMinor=?, DeliveryPriceMinor=?, Currency=?, Version=Version+1 WHERE Id=? AND TenantId=? AND DeletedAt IS NULL AND Version=?"
+	stmtDeleteProduct        = "UPDATE Products SET DeletedAt=?, Version=Version+1 WHERE Id=? AND TenantId=? AND DeletedAt IS NULL AND Version=?"
+	stmtDeleteProductOptions = "UPDATE ProductOptions SET DeletedAt=?, Version=Version+1 WHERE ProductId=? AND TenantId=? AND DeletedAt IS NULL"
+	stmtTouchProduct         = "UPDATE Products SET Version=Version+1 WHERE Id=? AND TenantId=?"
+	stmtCountProducts        = "SELECT COUNT(*) FROM Products"
+	stmtProductsLimit        = " LIMIT ? OFFSET ?"
+	stmtProductsAfter        = " AND Id>? ORDER BY Id LIMIT ?"
 )
 
+// ErrVersionMismatch is returned when the product or the option was changed since the version the change is based on
+var ErrVersionMismatch = errors.New("version does not match")
+
 func (c *ProductsCmds) FetchAllProducts(ctx context.Context, pName string, pID string) ([]models.DBProducts, error) {
 
 	span, ctx := apm.StartSpan(ctx, "products.show", "db")
 	span.SpanData.Context.SetTag("span", "FetchAllProducts")
 	defer span.End()
 
+	pID = strings.ToLower(pID)
+
 	db := c.DB.RO(ctx)
 
-	params := ""
-	stmt := stmtProducts
+	params := []interface{}{xeroHelper.TenantFrom(ctx)}
+	stmt := stmtProducts + " WHERE" + stmtLiveProducts + "AND" + stmtTenantRows
 	if pID != "" {
-		stmt += " WHERE Id=? COLLATE NOCASE"
-		params = strings.ToLower(pID)
+		stmt += " AND Id=?"
+		params = append(params, pID)
 	} else if pName != "" {
-		stmt += " WHERE Name like ? COLLATE NOCASE "
-		params = "%" + strings.ToLower(pName) + "%"
+		stmt += " AND Name like ? COLLATE NOCASE "
+		params = append(params, "%"+strings.ToLower(pName)+"%")
 	}
 
-	var rows *sql.Rows
-	var err error
-	if params != "" {
-		rows, err = db.QueryContext(ctx, stmt, params)
-	} else {
-		rows, err = db.QueryContext(ctx, stmt)
-	}
+	rows, err := db.QueryContext(ctx, c.sql(stmt), params...)
 	if err != nil {
 		c.Logger.Error("Error while fetching products", "error", err)
 		return nil, err
 	}
+	defer rows.Close()
 
 	result := []models.DBProducts{}
 	for rows.Next() {
 		dbObj := models.DBProducts{}
-		rows.Scan(&dbObj.DBID, &dbObj.DBName, &dbObj.DBDescription, &dbObj.DBPrice, &dbObj.DBDeliveryPrice)
+		if err := rows.Scan(&dbObj.DBID, &dbObj.DBName, &dbObj.DBDescription, &dbObj.DBPrice, &dbObj.DBDeliveryPrice, &dbObj.DBCurrency, &dbObj.DBVersion); err != nil {
+			c.Logger.Error("Error while scanning rows", "error", err)
+			return nil, err
+		}
 		result = append(result, dbObj)
 	}
 	if err = rows.Err(); err != nil {
@@ -63,64 +77,285 @@ func (c *ProductsCmds) FetchAllProducts(ctx context.Context, pName string, pID s
 	return result, nil
 }
 
+// Returns one page of products in the sort order of the filter and the total number of products matching the filter
+// One extra row is fetched over the limit, so the caller can tell if there is a next page
+func (c *ProductsCmds) FetchProductsPage(ctx context.Context, filter models.ProductFilter, page models.PageRequest) ([]models.DBProducts, int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "products.page", "db")
+	span.SpanData.Context.SetTag("span", "FetchProductsPage")
+	defer span.End()
+
+	db := c.DB.RO(ctx)
+
+	sort := filter.Sort
+	if len(sort) == 0 {
+		sort = models.DefaultProductSort
+	}
+	where, params := productFilterClause(ctx, filter)
+
+	// Total ignores the cursor, it is the size of the whole result set
+	var total int64
+	countStmt := stmtCountProducts
+	if len(where) > 0 {
+		countStmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	if err := db.QueryRowContext(ctx, c.sql(countStmt), params...).Scan(&total); err != nil {
+		c.Logger.Error("Error while counting products", "error", err)
+		return nil, 0, err
+	}
+
+	if page.Cursor != nil {
+		keyset, keysetParams := keysetClause(sort, page.Cursor)
+		where = append(where, keyset)
+		params = append(params, keysetParams...)
+	}
+
+	stmt := stmtProducts
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	stmt += orderByClause(sort) + stmtProductsLimit
+	params = append(params, page.Limit+1, page.Offset)
+
+	rows, err := db.QueryContext(ctx, c.sql(stmt), params...)
+	if err != nil {
+		c.Logger.Error("Error while fetching products page", "error", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	result := []models.DBProducts{}
+	for rows.Next() {
+		dbObj := models.DBProducts{}
+		if err := rows.Scan(&dbObj.DBID, &dbObj.DBName, &dbObj.DBDescription, &dbObj.DBPrice, &dbObj.DBDeliveryPrice, &dbObj.DBCurrency, &dbObj.DBVersion); err != nil {
+			c.Logger.Error("Error while scanning rows", "error", err)
+			return nil, 0, err
+		}
+		result = append(result, dbObj)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger.Error("Error while scanning rows", "error", err)
+		return nil, 0, err
+	}
+
+	c.Logger.Debug("Fetched the products page", "total_rows", len(result), "total", total)
+	return result, total, nil
+}
+
+// Returns at most limit products ordered by Id, the ones after the product afterID
+// The whole catalogue is read by pages this way, each page starts after the last product of the previous one
+func (c *ProductsCmds) FetchProductsAfter(ctx context.Context, afterID string, limit int) ([]models.DBProducts, error) {
+
+	span, ctx := apm.StartSpan(ctx, "products.after", "db")
+	span.SpanData.Context.SetTag("span", "FetchProductsAfter")
+	defer span.End()
+
+	afterID = strings.ToLower(afterID)
+
+	stmt := stmtProducts + " WHERE" + stmtLiveProducts + "AND" + stmtTenantRows + stmtProductsAfter
+	rows, err := c.DB.RO(ctx).QueryContext(ctx, c.sql(stmt), xeroHelper.TenantFrom(ctx), afterID, limit)
+	if err != nil {
+		c.Logger.Error("Error while fetching products", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []models.DBProducts{}
+	for rows.Next() {
+		dbObj := models.DBProducts{}
+		if err := rows.Scan(&dbObj.DBID, &dbObj.DBName, &dbObj.DBDescription, &dbObj.DBPrice, &dbObj.DBDeliveryPrice, &dbObj.DBCurrency, &dbObj.DBVersion); err != nil {
+			c.Logger.Error("Error while scanning rows", "error", err)
+			return nil, err
+		}
+		result = append(result, dbObj)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger.Error("Error while scanning rows", "error", err)
+		return nil, err
+	}
+
+	c.Logger.Debug("Fetched the products after", "total_rows", len(result), "after", afterID)
+	return result, nil
+}
+
+// Returns the newly added product id, ErrQuotaExceeded when the tenant has all its products
 func (c *ProductsCmds) AddNewProduct(ctx context.Context, product models.Product) (string, error) {
 
 	span, ctx := apm.StartSpan(ctx, "products.add", "db")
 	span.SpanData.Context.SetTag("span", "AddNewProduct")
 	defer span.End()
 
-	db := c.DB.RW(ctx)
-	id := uuid.New()
-	statement, _ := db.Prepare(stmtInsertProduct)
-	result, err := statement.ExecContext(ctx, id, product.Name, product.Description, product.Price, product.DeliveryPrice)
-	if err != nil {
+	id := uuid.New().String()
+	row := productRow(id, product)
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+		if err := c.checkQuota(ctx, tx, 1, 0); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, c.sql(stmtInsertProduct), row.DBID, row.DBName, row.DBDescription, row.DBPrice, row.DBDeliveryPrice, row.DBCurrency, xeroHelper.TenantFrom(ctx)); err != nil {
+			return err
+		}
+		return c.audit(ctx, tx, time.Now(), productChange(models.AuditCreate, nil, &row))
+	})
+	if err == ErrQuotaExceeded {
+		return "", err
+	} else if err != nil {
 		c.Logger.Error("Error while inserting new rows", "error", err)
 		return "", err
 	}
-	insertedID, _ := result.LastInsertId()
-	c.Logger.Debug("Added new product", "inserted_id", insertedID, "uuid", id)
-	return id.String(), err
+	c.Logger.Debug("Added new product", "uuid", id)
+	return id, nil
 }
 
-func (c *ProductsCmds) UpdateProduct(ctx context.Context, product models.Product, productID string) (int64, error) {
+// Returns the newly added product id and the ids of its options, ErrQuotaExceeded when the tenant has no room left for them
+func (c *ProductsCmds) AddNewProductWithOptions(ctx context.Context, product models.Product) (string, []string, error) {
+
+	span, ctx := apm.StartSpan(ctx, "products.add", "db")
+	span.SpanData.Context.SetTag("span", "AddNewProductWithOptions")
+	defer span.End()
+
+	id := uuid.New().String()
+	optionIDs := make([]string, 0, len(product.Options))
+
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+		if err := c.checkQuota(ctx, tx, 1, int64(len(product.Options))); err != nil {
+			return err
+		}
+		row := productRow(id, product)
+		if _, err := tx.ExecContext(ctx, c.sql(stmtInsertProduct), row.DBID, row.DBName, row.DBDescription, row.DBPrice, row.DBDeliveryPrice, row.DBCurrency, xeroHelper.TenantFrom(ctx)); err != nil {
+			return err
+		}
+		changes := []auditChange{productChange(models.AuditCreate, nil, &row)}
+
+		for _, option := range product.Options {
+			optionID := uuid.New().String()
+			if _, err := tx.ExecContext(ctx, c.sql(stmtInsertProductOption), optionID, id, option.Name, option.Description, xeroHelper.TenantFrom(ctx)); err != nil {
+				return err
+			}
+			optionIDs = append(optionIDs, optionID)
+			optionState := optionRow(optionID, id, option)
+			changes = append(changes, optionChange(models.AuditCreate, nil, &optionState))
+		}
+
+		return c.audit(ctx, tx, time.Now(), changes...)
+	})
+	if err == ErrQuotaExceeded {
+		return "", nil, err
+	} else if err != nil {
+		c.Logger.Error("Error while inserting new product with options", "error", err)
+		return "", nil, err
+	}
+
+	c.Logger.Debug("Added new product with options", "uuid", id, "options", len(optionIDs))
+	return id, optionIDs, nil
+}
+
+// Updates the product, when version is not nil the product must not have changed since that version
+// A product without currency keeps the currency it has
+// Returns ErrVersionMismatch when it changed, and 0 rows when the product does not exist
+func (c *ProductsCmds) UpdateProduct(ctx context.Context, product models.Product, productID string, version *int64) (int64, error) {
 
 	span, ctx := apm.StartSpan(ctx, "products.update", "db")
 	span.SpanData.Context.SetTag("span", "UpdateProduct")
 	defer span.End()
 
-	db := c.DB.RW(ctx)
-	statement, _ := db.Prepare(stmtUpdateProduct)
-	result, err := statement.ExecContext(ctx, product.Name, product.Description, product.Price, product.DeliveryPrice, productID)
+	productID = strings.ToLower(productID)
+
+	var affectedRows int64
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+		before, err := c.productState(ctx, tx, productID)
+		if err == sql.ErrNoRows || (err == nil && before.DBDeletedAt.Valid) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if version != nil && *version != before.DBVersion.Int64 {
+			return ErrVersionMismatch
+		}
+		if product.Currency == "" {
+			product.Currency = models.NewProduct(before).Currency
+		}
+
+		// The version read guards the update when another connection changed the product in between
+		result, err := tx.ExecContext(ctx, c.sql(stmtUpdateProduct), product.Name, product.Description, product.Price.Amount, product.DeliveryPrice.Amount, product.PriceCurrency(), productID, xeroHelper.TenantFrom(ctx), before.DBVersion.Int64)
+		if err != nil {
+			return err
+		}
+		if affectedRows, _ = result.RowsAffected(); affectedRows == 0 {
+			return ErrVersionMismatch
+		}
+
+		after := productRow(before.DBID.String, product)
+		after.DBVersion = sql.NullInt64{Int64: before.DBVersion.Int64 + 1, Valid: true}
+		return c.audit(ctx, tx, time.Now(), productChange(models.AuditUpdate, &before, &after))
+	})
 	if err != nil {
 		c.Logger.Error("Error while updating products", "error", err)
 		return 0, err
 	}
-	affectedRows, err := result.RowsAffected()
 	c.Logger.Debug("Updated the products", "affected_rows", affectedRows)
-	return affectedRows, err
+	return affectedRows, nil
 }
 
-func (c *ProductsCmds) DeleteProduct(ctx context.Context, productID string) (int64, error) {
+// DeleteProduct moves the product to the trash along with its options, the options are marked with the same time as the product
+// The prices, the stock and the categories are kept, so RestoreProduct brings the product back as it was
+// When version is not nil the product must not have changed since that version, ErrVersionMismatch otherwise
+func (c *ProductsCmds) DeleteProduct(ctx context.Context, productID string, version *int64) (int64, error) {
 
 	span, ctx := apm.StartSpan(ctx, "products.delete", "db")
 	span.SpanData.Context.SetTag("span", "DeleteProduct")
 	defer span.End()
 
-	db := c.DB.RW(ctx)
+	productID = strings.ToLower(productID)
 
-	// Delete all the options related to this product
-	if _, err := c.DeleteAllProductOptions(ctx, productID); err != nil {
-		c.Logger.Error("Error while deleting product options", "error", err)
-		return 0, err
-	}
+	now := time.Now()
+	deletedAt := sql.NullInt64{Int64: models.DeletedAtMillis(now), Valid: true}
+	var affectedRows int64
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+		before, err := c.productState(ctx, tx, productID)
+		if err == sql.ErrNoRows || (err == nil && before.DBDeletedAt.Valid) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if version != nil && *version != before.DBVersion.Int64 {
+			return ErrVersionMismatch
+		}
+		options, err := c.optionStates(ctx, tx, productID, " AND DeletedAt IS NULL")
+		if err != nil {
+			return err
+		}
 
-	statement, _ := db.Prepare(stmtDeleteProduct)
-	result, err := statement.ExecContext(ctx, productID)
+		result, err := tx.ExecContext(ctx, c.sql(stmtDeleteProduct), deletedAt, productID, xeroHelper.TenantFrom(ctx), before.DBVersion.Int64)
+		if err != nil {
+			return err
+		}
+		if affectedRows, _ = result.RowsAffected(); affectedRows == 0 {
+			return ErrVersionMismatch
+		}
+		if _, err = tx.ExecContext(ctx, c.sql(stmtDeleteProductOptions), deletedAt, productID, xeroHelper.TenantFrom(ctx)); err != nil {
+			return err
+		}
+
+		after := before
+		after.DBDeletedAt = deletedAt
+		after.DBVersion.Int64++
+		changes := []auditChange{productChange(models.AuditDelete, &before, &after)}
+		for i := range options {
+			optionAfter := options[i]
+			optionAfter.DBDeletedAt = deletedAt
+			optionAfter.DBVersion.Int64++
+			changes = append(changes, optionChange(models.AuditDelete, &options[i], &optionAfter))
+		}
+		return c.audit(ctx, tx, now, changes...)
+	})
 	if err != nil {
 		c.Logger.Error("Error while deleting products", "error", err)
 		return 0, err
 	}
-	affectedRows, _ := result.RowsAffected()
+
 	c.Logger.Debug("Deleted the product", "affected_rows", affectedRows)
-	return affectedRows, err
+	return affectedRows, nil
 }
@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"context"
+	"time"
+
+	"github.com/techievee/xero/productService/models"
+)
+
+// ProductRepository is the storage of the products, independent of the database behind it
+// The changes given a version return ErrVersionMismatch when the product changed since that version
+// UpdateProduct keeps the currency of the product when the product given has none
+type ProductRepository interface {
+	FetchAllProducts(ctx context.Context, pName string, pID string) ([]models.DBProducts, error)
+	FetchProductsPage(ctx context.Context, filter models.ProductFilter, page models.PageRequest) ([]models.DBProducts, int64, error)
+	FetchProductsAfter(ctx context.Context, afterID string, limit int) ([]models.DBProducts, error)
+	AddNewProduct(ctx context.Context, product models.Product) (string, error)
+	UpdateProduct(ctx context.Context, product models.Product, productID string, version *int64) (int64, error)
+	DeleteProduct(ctx context.Context, productID string, version *int64) (int64, error)
+	PatchProduct(ctx context.Context, productID string, product models.Product, fields []string, version *int64) (int64, error)
+}
+
+// ProductOptionRepository is the storage of the product options, independent of the database behind it
+// The changes given a version return ErrVersionMismatch when the option changed since that version
+type ProductOptionRepository interface {
+	FetchAllProductOptions(ctx context.Context, pID string, pOptionID string) ([]models.DBProductOptions, error)
+	FetchProductOptionsPage(ctx context.Context, pID string, page models.PageRequest) ([]models.DBProductOptions, int64, error)
+	FetchOptionsOfProducts(ctx context.Context, pIDs []string) ([]models.DBProductOptions, error)
+	AddNewProductOption(ctx context.Context, pID string, product models.ProductOption) (string, error)
+	UpdateProductOption(ctx context.Context, pID string, pOptionID string, product models.ProductOption, version *int64) (int64, error)
+	DeleteProductOption(ctx context.Context, pID string, pOptionID string, version *int64) (int64, error)
+	DeleteAllProductOptions(ctx context.Context, pID string) (int64, error)
+	PatchProductOption(ctx context.Context, pID string, pOptionID string, option models.ProductOption, fields []string, version *int64) (int64, error)
+}
+
+// ProductPriceRepository is the storage of the prices of the products in the other currencies than their base currency
+type ProductPriceRepository interface {
+	FetchProductPrices(ctx context.Context, pID string, currency string) ([]models.DBProductPrices, error)
+	FetchPricesInCurrency(ctx context.Context, currency string, pIDs []string) ([]models.DBProductPrices, error)
+	SetProductPrice(ctx context.Context, pID string, price models.ProductPrice) error
+	DeleteProductPrice(ctx context.Context, pID string, currency string) (int64, error)
+	DeleteAllProductPrices(ctx context.Context, pID string) (int64, error)
+}
+
+// ProductStockRepository is the stock of the product options and its reservations
+// The changes of the stock return ErrUnknownProductOption, ErrUnknownReservation, ErrReservationClosed, ErrInsufficientStock or ErrStockConflict when they are refused
+type ProductStockRepository interface {
+	FetchStock(ctx context.Context, pID string, pOptionID string) (models.DBStock, error)
+	SetStock(ctx context.Context, pID string, pOptionID string, onHand int64, version *int64) (models.DBStock, error)
+	ReserveStock(ctx context.Context, pID string, pOptionID string, quantity int64) (models.DBStockReservations, error)
+	CommitStockReservation(ctx context.Context, pID string, pOptionID string, reservationID string) (models.DBStockReservations, error)
+	ReleaseStockReservation(ctx context.Context, pID string, pOptionID string, reservationID string) (models.DBStockReservations, error)
+}
+
+// CategoryRepository is the taxonomy of the products and the links of the products to its categories
+type CategoryRepository interface {
+	FetchCategories(ctx context.Context) ([]models.DBCategories, error)
+	FetchCategory(ctx context.Context, id string, slug string) ([]models.DBCategories, error)
+	AddNewCategory(ctx context.Context, category models.Category) (string, error)
+	UpdateCategory(ctx context.Context, id string, category models.Category) (int64, error)
+	DeleteCategory(ctx context.Context, id string, orphans string) (int64, error)
+	FetchProductCategories(ctx context.Context, pID string) ([]models.DBCategories, error)
+	AddProductCategory(ctx context.Context, pID string, categoryID string) error
+	DeleteProductCategory(ctx context.Context, pID string, categoryID string) (int64, error)
+	DeleteAllProductCategories(ctx context.Context, pID string) (int64, error)
+}
+
+// ProductSearchRepository is the full text search of the products, ErrSearchUnavailable when the storage cannot search
+type ProductSearchRepository interface {
+	SearchProducts(ctx context.Context, query models.SearchQuery, limit int, offset int) ([]models.DBProductSearch, int64, error)
+}
+
+// ProductTrashRepository is the trash of the deleted products and options, they can be restored until they are purged
+// The products and the options in the trash are hidden from all the other methods of the Repository
+type ProductTrashRepository interface {
+	FetchDeletedProducts(ctx context.Context, limit int, offset int) ([]models.DBProducts, int64, error)
+	FetchDeletedProductOptions(ctx context.Context, pID string) ([]models.DBProductOptions, error)
+	RestoreProduct(ctx context.Context, pID string) (int64, error)
+	RestoreProductOption(ctx context.Context, pID string, pOptionID string) (int64, error)
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, int64, error)
+}
+
+// AuditRepository is the history of the changes of the products
+// Every change made through the Repository is recorded along with the change, by the actor carried by the context of the change
+type AuditRepository interface {
+	FetchProductHistory(ctx context.Context, pID string, limit int, offset int) ([]models.DBAuditLog, int64, error)
+}
+
+// TenantRepository is the onboarding and the removal of the tenants, the quotas of their catalogue
+// Every other method of the Repository reads and changes the catalogue of the tenant carried by the context, see xeroHelper.WithTenant
+// The changes adding products or options return ErrQuotaExceeded when they take the tenant over its quota
+type TenantRepository interface {
+	FetchTenants(ctx context.Context) ([]models.DBTenants, error)
+	FetchTenant(ctx context.Context, id string) ([]models.DBTenants, error)
+	AddNewTenant(ctx context.Context, tenant models.Tenant) error
+	UpdateTenant(ctx context.Context, id string, tenant models.Tenant) (int64, error)
+	DeleteTenant(ctx context.Context, id string) (int64, error)
+}
+
+// ProductBatchRepository applies the operations of a batch of products and options, see ProductsCmds.ApplyBatch
+// The refused operations return ErrUnknownProduct, ErrUnknownProductOption, ErrInTrash, ErrIDTaken, ErrVersionMismatch, ErrQuotaExceeded or ErrBatchRolledBack in their outcome
+type ProductBatchRepository interface {
+	ApplyBatch(ctx context.Context, ops []models.BatchOperation, atomic bool, chunkSize int) ([]BatchOutcome, error)
+}
+
+// Repository is the storage of the whole catalogue, the controllers only depend on this interface
+type Repository interface {
+	ProductRepository
+	ProductOptionRepository
+	ProductPriceRepository
+	ProductStockRepository
+	CategoryRepository
+	ProductSearchRepository
+	ProductTrashRepository
+	AuditRepository
+	ProductBatchRepository
+	TenantRepository
+
+	// AddNewProductWithOptions creates the product along with its options, either all of them are created or none
+	// Returns the id of the product and the ids of the options in the order of product.Options
+	AddNewProductWithOptions(ctx context.Context, product models.Product) (string, []string, error)
+
+	// CountCatalogue returns the number of products and the number of options of the catalogue of the tenant
+	CountCatalogue(ctx context.Context) (int64, int64, error)
+}
+
+// Both the implementations must satisfy the Repository
+var (
+	_ Repository = &ProductsCmds{}
+	_ Repository = &MemoryCmds{}
+)
@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"context"
+	"errors"
+
+	"go.elastic.co/apm"
+
+	"github.com/techievee/xero/productService/models"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// The products in the trash and the products of the other tenants are left out of the results
+// The columns of ProductSearch are ProductId, Name, Description and Options, bm25 weighs the matches of each of them
+// bm25 is lower for the better matches, the score returned is its opposite
+const (
+	stmtSearchProducts = `SELECT p.Id, p.Name, p.Description, p.PriceMinor, p.DeliveryPriceMinor, p.Currency,
+	snippet(ProductSearch, -1, ?, ?, '...', 12), -bm25(ProductSearch, 0.0, 10.0, 5.0, 1.0) AS Score
+	FROM ProductSearch JOIN Products p ON p.Id = ProductSearch.ProductId
+	WHERE ProductSearch MATCH ? AND p.DeletedAt IS NULL AND p.TenantId=? ORDER BY Score DESC, p.Id LIMIT ? OFFSET ?`
+	stmtCountSearchProducts = `SELECT COUNT(*) FROM ProductSearch JOIN Products p ON p.Id = ProductSearch.ProductId
+	WHERE ProductSearch MATCH ? AND p.DeletedAt IS NULL AND p.TenantId=?`
+)
+
+// ErrSearchUnavailable is returned when the database has no full text index of the products
+var ErrSearchUnavailable = errors.New("product search is unavailable")
+
+// Returns one page of the products matching the query, the best matches first, and the total number of matches
+func (c *ProductsCmds) SearchProducts(ctx context.Context, query models.SearchQuery, limit int, offset int) ([]models.DBProductSearch, int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "products.search", "db")
+	span.SpanData.Context.SetTag("span", "SearchProducts")
+	defer span.End()
+
+	if !c.DB.SearchIndex {
+		return nil, 0, ErrSearchUnavailable
+	}
+
+	db := c.DB.RO(ctx)
+	match := query.Match()
+	tenant := xeroHelper.TenantFrom(ctx)
+
+	var total int64
+	if err := db.QueryRowContext(ctx, c.sql(stmtCountSearchProducts), match, tenant).Scan(&total); err != nil {
+		c.Logger.Error("Error while counting the products matching the search", "error", err)
+		return nil, 0, err
+	}
+
+	rows, err := db.QueryContext(ctx, c.sql(stmtSearchProducts), models.SearchMarkOpen, models.SearchMarkClose, match, tenant, limit, offset)
+	if err != nil {
+		c.Logger.Error("Error while searching products", "error", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	result := []models.DBProductSearch{}
+	for rows.Next() {
+		dbObj := models.DBProductSearch{}
+		if err := rows.Scan(&dbObj.DBID, &dbObj.DBName, &dbObj.DBDescription, &dbObj.DBPrice, &dbObj.DBDeliveryPrice, &dbObj.DBCurrency, &dbObj.DBSnippet, &dbObj.DBScore); err != nil {
+			c.Logger.Error("Error while scanning rows", "error", err)
+			return nil, 0, err
+		}
+		result = append(result, dbObj)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger.Error("Error while scanning rows", "error", err)
+		return nil, 0, err
+	}
+
+	c.Logger.Debug("Searched the products", "total_rows", len(result), "total", total)
+	return result, total, nil
+}
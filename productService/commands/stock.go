@@ -0,0 +1,244 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.elastic.co/apm"
+
+	"github.com/techievee/xero/productService/models"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// The reservations belong to the tenant of their option, they are only inserted once the option was read in the tenant
+const (
+	stmtStock                     = "SELECT StockOnHand, StockReserved, StockVersion FROM ProductOptions WHERE Id=? and ProductId=? AND TenantId=? AND DeletedAt IS NULL"
+	stmtUpdateStock               = "UPDATE ProductOptions SET StockOnHand=?, StockReserved=?, StockVersion=StockVersion+1 WHERE Id=? and TenantId=? and StockVersion=?"
+	stmtStockReservation          = "SELECT Id, ProductOptionId, Quantity, Status FROM StockReservations WHERE Id=? and ProductOptionId=? AND ProductOptionId IN (" + stmtTenantOptionIDs + ")"
+	stmtInsertStockReservation    = "INSERT INTO  StockReservations (Id, ProductOptionId, Quantity, Status) VALUES (?,?,?,?)"
+	stmtUpdateStockReservation    = "UPDATE StockReservations SET Status=? WHERE Id=? and Status=? AND ProductOptionId IN (" + stmtTenantOptionIDs + ")"
+	stmtDeleteOptionReservations  = "DELETE FROM StockReservations WHERE ProductOptionId IN (SELECT Id FROM ProductOptions WHERE Id=? and ProductId=? AND TenantId=?)"
+	stmtDeleteProductReservations = "DELETE FROM StockReservations WHERE ProductOptionId IN (SELECT Id FROM ProductOptions WHERE ProductId=? AND TenantId=?)"
+)
+
+// Number of times a stock change is tried when the stock keeps being changed by the other requests
+const stockRetries = 3
+
+var (
+	// ErrUnknownProductOption is returned when the option does not exist for the product
+	ErrUnknownProductOption = errors.New("unknown product option")
+	// ErrUnknownReservation is returned when the reservation does not exist for the option
+	ErrUnknownReservation = errors.New("unknown stock reservation")
+	// ErrReservationClosed is returned when the reservation is already committed or released
+	ErrReservationClosed = errors.New("stock reservation is already closed")
+	// ErrInsufficientStock is returned when the quantity available is lower than the quantity requested
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrStockConflict is returned when the stock was changed since the version the change is based on
+	ErrStockConflict = errors.New("stock was changed concurrently")
+
+	// errStockRace is returned when the version of the stock changed between the read and the update, the change is tried again
+	errStockRace = errors.New("stock version changed")
+)
+
+// Returns the stock of the option of the product, ErrUnknownProductOption when the option does not exist
+func (c *ProductsCmds) FetchStock(ctx context.Context, pID string, pOptionID string) (models.DBStock, error) {
+
+	span, ctx := apm.StartSpan(ctx, "stock.show", "db")
+	span.SpanData.Context.SetTag("span", "FetchStock")
+	defer span.End()
+
+	pID, pOptionID = strings.ToLower(pID), strings.ToLower(pOptionID)
+
+	db := c.DB.RO(ctx)
+	stock := models.DBStock{}
+	err := db.QueryRowContext(ctx, c.sql(stmtStock), pOptionID, pID, xeroHelper.TenantFrom(ctx)).Scan(&stock.DBOnHand, &stock.DBReserved, &stock.DBVersion)
+	if err == sql.ErrNoRows {
+		return stock, ErrUnknownProductOption
+	} else if err != nil {
+		c.Logger.Error("Error while fetching the stock", "error", err)
+		return stock, err
+	}
+
+	return stock, nil
+}
+
+// Sets the quantity on hand of the option, when version is not nil the stock must not have changed since that version
+// The quantity on hand cannot go below the quantity reserved
+func (c *ProductsCmds) SetStock(ctx context.Context, pID string, pOptionID string, onHand int64, version *int64) (models.DBStock, error) {
+
+	span, ctx := apm.StartSpan(ctx, "stock.set", "db")
+	span.SpanData.Context.SetTag("span", "SetStock")
+	defer span.End()
+
+	pID, pOptionID = strings.ToLower(pID), strings.ToLower(pOptionID)
+
+	return c.changeStock(ctx, pID, pOptionID, models.AuditUpdate, func(_ *sql.Tx, stock models.DBStock) (int64, int64, error) {
+		if version != nil && *version != stock.DBVersion.Int64 {
+			return 0, 0, ErrStockConflict
+		}
+		if onHand < stock.DBReserved.Int64 {
+			return 0, 0, ErrInsufficientStock
+		}
+		return onHand, stock.DBReserved.Int64, nil
+	})
+}
+
+// Reserves the quantity of the option, the reservation is created along with the change of the stock
+func (c *ProductsCmds) ReserveStock(ctx context.Context, pID string, pOptionID string, quantity int64) (models.DBStockReservations, error) {
+
+	span, ctx := apm.StartSpan(ctx, "stock.reserve", "db")
+	span.SpanData.Context.SetTag("span", "ReserveStock")
+	defer span.End()
+
+	pID, pOptionID = strings.ToLower(pID), strings.ToLower(pOptionID)
+
+	reservation := models.DBStockReservations{
+		DBID:              sql.NullString{String: uuid.New().String(), Valid: true},
+		DBProductOptionID: sql.NullString{String: pOptionID, Valid: true},
+		DBQuantity:        sql.NullInt64{Int64: quantity, Valid: true},
+		DBStatus:          sql.NullString{String: models.ReservationReserved, Valid: true},
+	}
+
+	_, err := c.changeStock(ctx, pID, pOptionID, models.AuditReserve, func(tx *sql.Tx, stock models.DBStock) (int64, int64, error) {
+		if stock.DBOnHand.Int64-stock.DBReserved.Int64 < quantity {
+			return 0, 0, ErrInsufficientStock
+		}
+		if _, err := tx.ExecContext(ctx, c.sql(stmtInsertStockReservation), reservation.DBID, reservation.DBProductOptionID, reservation.DBQuantity, reservation.DBStatus); err != nil {
+			return 0, 0, err
+		}
+		return stock.DBOnHand.Int64, stock.DBReserved.Int64 + quantity, nil
+	})
+	if err != nil {
+		return models.DBStockReservations{}, err
+	}
+
+	c.Logger.Debug("Reserved the stock", "uuid", reservation.DBID.String, "quantity", quantity)
+	return reservation, nil
+}
+
+// Commits the reservation, the reserved quantity leaves the stock on hand
+func (c *ProductsCmds) CommitStockReservation(ctx context.Context, pID string, pOptionID string, reservationID string) (models.DBStockReservations, error) {
+
+	span, ctx := apm.StartSpan(ctx, "stock.commit", "db")
+	span.SpanData.Context.SetTag("span", "CommitStockReservation")
+	defer span.End()
+
+	pID, pOptionID, reservationID = strings.ToLower(pID), strings.ToLower(pOptionID), strings.ToLower(reservationID)
+
+	return c.closeReservation(ctx, pID, pOptionID, reservationID, models.ReservationCommitted, models.AuditCommit)
+}
+
+// Releases the reservation, the reserved quantity is available again
+func (c *ProductsCmds) ReleaseStockReservation(ctx context.Context, pID string, pOptionID string, reservationID string) (models.DBStockReservations, error) {
+
+	span, ctx := apm.StartSpan(ctx, "stock.release", "db")
+	span.SpanData.Context.SetTag("span", "ReleaseStockReservation")
+	defer span.End()
+
+	pID, pOptionID, reservationID = strings.ToLower(pID), strings.ToLower(pOptionID), strings.ToLower(reservationID)
+
+	return c.closeReservation(ctx, pID, pOptionID, reservationID, models.ReservationReleased, models.AuditRelease)
+}
+
+func (c *ProductsCmds) closeReservation(ctx context.Context, pID string, pOptionID string, reservationID string, status string, action string) (models.DBStockReservations, error) {
+
+	reservation := models.DBStockReservations{}
+	_, err := c.changeStock(ctx, pID, pOptionID, action, func(tx *sql.Tx, stock models.DBStock) (int64, int64, error) {
+
+		err := tx.QueryRowContext(ctx, c.sql(stmtStockReservation), reservationID, pOptionID, xeroHelper.TenantFrom(ctx)).
+			Scan(&reservation.DBID, &reservation.DBProductOptionID, &reservation.DBQuantity, &reservation.DBStatus)
+		if err == sql.ErrNoRows {
+			return 0, 0, ErrUnknownReservation
+		} else if err != nil {
+			return 0, 0, err
+		}
+		if reservation.DBStatus.String != models.ReservationReserved {
+			return 0, 0, ErrReservationClosed
+		}
+
+		if _, err := tx.ExecContext(ctx, c.sql(stmtUpdateStockReservation), status, reservationID, models.ReservationReserved, xeroHelper.TenantFrom(ctx)); err != nil {
+			return 0, 0, err
+		}
+		reservation.DBStatus.String = status
+
+		quantity := reservation.DBQuantity.Int64
+		if status == models.ReservationCommitted {
+			return stock.DBOnHand.Int64 - quantity, stock.DBReserved.Int64 - quantity, nil
+		}
+		return stock.DBOnHand.Int64, stock.DBReserved.Int64 - quantity, nil
+	})
+	if err != nil {
+		return models.DBStockReservations{}, err
+	}
+
+	c.Logger.Debug("Closed the stock reservation", "uuid", reservationID, "status", status)
+	return reservation, nil
+}
+
+// changeStock applies change to the stock of the option with optimistic concurrency
+// change gets the current stock and returns the new quantities on hand and reserved, its statements must go through tx
+// The stock is only updated when its version did not change since it was read, otherwise the whole change is rolled back and tried again
+// The version guards the stock when several connections write it, a single read write connection would already serialise the transactions
+// The change is recorded in the audit log with the action
+func (c *ProductsCmds) changeStock(ctx context.Context, pID string, pOptionID string, action string, change func(tx *sql.Tx, stock models.DBStock) (int64, int64, error)) (models.DBStock, error) {
+
+	for attempt := 1; ; attempt++ {
+
+		result := models.DBStock{}
+		err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+			stock := models.DBStock{}
+			err := tx.QueryRowContext(ctx, c.sql(stmtStock), pOptionID, pID, xeroHelper.TenantFrom(ctx)).Scan(&stock.DBOnHand, &stock.DBReserved, &stock.DBVersion)
+			if err == sql.ErrNoRows {
+				return ErrUnknownProductOption
+			} else if err != nil {
+				return err
+			}
+
+			onHand, reserved, err := change(tx, stock)
+			if err != nil {
+				return err
+			}
+
+			updated, err := tx.ExecContext(ctx, c.sql(stmtUpdateStock), onHand, reserved, pOptionID, xeroHelper.TenantFrom(ctx), stock.DBVersion.Int64)
+			if err != nil {
+				return err
+			}
+			if affectedRows, _ := updated.RowsAffected(); affectedRows == 0 {
+				return errStockRace
+			}
+
+			result = models.DBStock{
+				DBOnHand:   sql.NullInt64{Int64: onHand, Valid: true},
+				DBReserved: sql.NullInt64{Int64: reserved, Valid: true},
+				DBVersion:  sql.NullInt64{Int64: stock.DBVersion.Int64 + 1, Valid: true},
+			}
+			return c.audit(ctx, tx, time.Now(), stockChange(action, pID, pOptionID, stock, result))
+		})
+
+		if err != errStockRace {
+			if err != nil && !isStockError(err) {
+				c.Logger.Error("Error while changing the stock", "error", err)
+			}
+			return result, err
+		}
+		if attempt == stockRetries {
+			c.Logger.Warn("The stock kept changing, giving up", "uuid", pOptionID, "attempts", attempt)
+			return result, ErrStockConflict
+		}
+		c.Logger.Debug("The stock changed while it was updated, trying again", "uuid", pOptionID, "attempt", attempt)
+	}
+}
+
+// isStockError tells the errors of the stock rules apart from the database errors
+func isStockError(err error) bool {
+	switch err {
+	case ErrUnknownProductOption, ErrUnknownReservation, ErrReservationClosed, ErrInsufficientStock, ErrStockConflict:
+		return true
+	}
+	return false
+}
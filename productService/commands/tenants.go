@@ -0,0 +1,207 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"go.elastic.co/apm"
+
+	"github.com/techievee/xero/productService/models"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// The tenants are not scoped, they are only managed by the platform
+const (
+	stmtTenants      = "SELECT Id, Name, MaxProducts, MaxOptions, CreatedAt FROM Tenants"
+	stmtInsertTenant = "INSERT INTO  Tenants (Id, Name, MaxProducts, MaxOptions, CreatedAt) VALUES (?,?,?,?,?)"
+	stmtUpdateTenant = "UPDATE Tenants SET Name=?, MaxProducts=?, MaxOptions=? WHERE Id=?"
+	stmtCountTenant  = "SELECT COUNT(*) FROM Tenants WHERE Id=?"
+	stmtTenantQuota  = "SELECT MaxProducts, MaxOptions FROM Tenants WHERE Id=?"
+)
+
+// stmtPurgeTenant removes every row of the tenant, the children first, every placeholder is the id of the tenant
+// The parents of the categories are cleared first, MySQL checks the foreign key of each row as it is deleted
+var stmtPurgeTenant = []string{
+	"DELETE FROM StockReservations WHERE ProductOptionId IN (" + stmtTenantOptionIDs + ")",
+	"DELETE FROM ProductPrices WHERE ProductId IN (" + stmtTenantProductIDs + ")",
+	"DELETE FROM ProductCategories WHERE ProductId IN (" + stmtTenantProductIDs + ") OR CategoryId IN (" + stmtTenantCategoryIDs + ")",
+	"DELETE FROM ProductOptions WHERE TenantId=?",
+	"DELETE FROM Products WHERE TenantId=?",
+	"UPDATE Categories SET ParentId=NULL WHERE TenantId=?",
+	"DELETE FROM Categories WHERE TenantId=?",
+	"DELETE FROM AuditLog WHERE TenantId=?",
+	"DELETE FROM Tenants WHERE Id=?",
+}
+
+var (
+	// ErrQuotaExceeded is returned when the change would take the tenant over the quota of its products or its options
+	ErrQuotaExceeded = errors.New("tenant quota exceeded")
+	// ErrUnknownTenant is returned when the tenant of the change does not exist
+	ErrUnknownTenant = errors.New("unknown tenant")
+	// ErrTenantExists is returned when a tenant is added with the id of an existing tenant
+	ErrTenantExists = errors.New("tenant already exists")
+	// ErrDefaultTenant is returned when the default tenant is removed, it owns the catalogue when the tenancy is disabled
+	ErrDefaultTenant = errors.New("the default tenant cannot be removed")
+)
+
+// Returns all the tenants ordered by id
+func (c *ProductsCmds) FetchTenants(ctx context.Context) ([]models.DBTenants, error) {
+
+	span, ctx := apm.StartSpan(ctx, "tenants.show", "db")
+	span.SpanData.Context.SetTag("span", "FetchTenants")
+	defer span.End()
+
+	return c.queryTenants(ctx, stmtTenants+" ORDER BY Id")
+}
+
+// Returns the tenant with the id, none when it does not exist
+func (c *ProductsCmds) FetchTenant(ctx context.Context, id string) ([]models.DBTenants, error) {
+
+	span, ctx := apm.StartSpan(ctx, "tenants.show", "db")
+	span.SpanData.Context.SetTag("span", "FetchTenant")
+	defer span.End()
+
+	return c.queryTenants(ctx, stmtTenants+" WHERE Id=?", strings.ToLower(id))
+}
+
+func (c *ProductsCmds) queryTenants(ctx context.Context, stmt string, params ...interface{}) ([]models.DBTenants, error) {
+
+	rows, err := c.DB.RO(ctx).QueryContext(ctx, c.sql(stmt), params...)
+	if err != nil {
+		c.Logger.Error("Error while fetching tenants", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []models.DBTenants{}
+	for rows.Next() {
+		dbObj := models.DBTenants{}
+		if err := rows.Scan(&dbObj.DBID, &dbObj.DBName, &dbObj.DBMaxProducts, &dbObj.DBMaxOptions, &dbObj.DBCreatedAt); err != nil {
+			c.Logger.Error("Error while scanning rows", "error", err)
+			return nil, err
+		}
+		result = append(result, dbObj)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger.Error("Error while scanning rows", "error", err)
+		return nil, err
+	}
+
+	c.Logger.Debug("Fetched the tenants", "total_rows", len(result))
+	return result, nil
+}
+
+// Onboards the tenant with an empty catalogue, ErrTenantExists when the id is taken
+func (c *ProductsCmds) AddNewTenant(ctx context.Context, tenant models.Tenant) error {
+
+	span, ctx := apm.StartSpan(ctx, "tenants.add", "db")
+	span.SpanData.Context.SetTag("span", "AddNewTenant")
+	defer span.End()
+
+	id := strings.ToLower(tenant.ID)
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+		var existing int64
+		if err := tx.QueryRowContext(ctx, c.sql(stmtCountTenant), id).Scan(&existing); err != nil {
+			return err
+		}
+		if existing > 0 {
+			return ErrTenantExists
+		}
+		_, err := tx.ExecContext(ctx, c.sql(stmtInsertTenant), id, tenant.Name, tenant.MaxProducts, tenant.MaxOptions, time.Now().UnixNano()/int64(time.Millisecond))
+		return err
+	})
+	if err == ErrTenantExists {
+		return err
+	} else if err != nil {
+		c.Logger.Error("Error while inserting new tenant", "error", err)
+		return err
+	}
+
+	c.Logger.Debug("Added new tenant", "tenant", id)
+	return nil
+}
+
+// Returns total number of rows affected by this update, the quotas only apply to the next changes of the catalogue
+func (c *ProductsCmds) UpdateTenant(ctx context.Context, id string, tenant models.Tenant) (int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "tenants.update", "db")
+	span.SpanData.Context.SetTag("span", "UpdateTenant")
+	defer span.End()
+
+	result, err := c.DB.RW(ctx).ExecContext(ctx, c.sql(stmtUpdateTenant), tenant.Name, tenant.MaxProducts, tenant.MaxOptions, strings.ToLower(id))
+	if err != nil {
+		c.Logger.Error("Error while updating tenant", "error", err)
+		return 0, err
+	}
+	affectedRows, err := result.RowsAffected()
+	c.Logger.Debug("Updated the tenant", "affected_rows", affectedRows)
+	return affectedRows, err
+}
+
+// Removes the tenant along with its whole catalogue and its history, in one transaction
+// Returns the number of tenants removed, ErrDefaultTenant for the default tenant
+func (c *ProductsCmds) DeleteTenant(ctx context.Context, id string) (int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "tenants.delete", "db")
+	span.SpanData.Context.SetTag("span", "DeleteTenant")
+	defer span.End()
+
+	id = strings.ToLower(id)
+	if id == xeroHelper.DefaultTenant {
+		return 0, ErrDefaultTenant
+	}
+
+	var affectedRows int64
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+		for _, stmt := range stmtPurgeTenant {
+			params := make([]interface{}, strings.Count(stmt, "?"))
+			for i := range params {
+				params[i] = id
+			}
+			result, err := tx.ExecContext(ctx, c.sql(stmt), params...)
+			if err != nil {
+				return err
+			}
+			affectedRows, _ = result.RowsAffected()
+		}
+		return nil
+	})
+	if err != nil {
+		c.Logger.Error("Error while deleting tenant", "error", err)
+		return 0, err
+	}
+
+	c.Logger.Debug("Deleted the tenant", "tenant", id, "affected_rows", affectedRows)
+	return affectedRows, nil
+}
+
+// checkQuota refuses with ErrQuotaExceeded the change adding the products and the options to the tenant of ctx
+// The live rows are counted in the transaction of the change, along with the ones it already inserted
+func (c *ProductsCmds) checkQuota(ctx context.Context, tx *sql.Tx, products int64, options int64) error {
+
+	tenant := xeroHelper.TenantFrom(ctx)
+	var maxProducts, maxOptions int64
+	err := tx.QueryRowContext(ctx, c.sql(stmtTenantQuota), tenant).Scan(&maxProducts, &maxOptions)
+	if err == sql.ErrNoRows {
+		return ErrUnknownTenant
+	} else if err != nil || (maxProducts == 0 && maxOptions == 0) {
+		return err
+	}
+
+	var liveProducts, liveOptions int64
+	if err := tx.QueryRowContext(ctx, c.sql(stmtCountCatalogue), tenant, tenant).Scan(&liveProducts, &liveOptions); err != nil {
+		return err
+	}
+	if exceeds(maxProducts, liveProducts, products) || exceeds(maxOptions, liveOptions, options) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// exceeds tells if adding the rows takes the count over the quota, 0 is unlimited
+func exceeds(quota int64, count int64, added int64) bool {
+	return quota > 0 && added > 0 && count+added > quota
+}
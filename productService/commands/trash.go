@@ -0,0 +1,339 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"go.elastic.co/apm"
+
+	"github.com/techievee/xero/productService/models"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// The trash is the one of the tenant of ctx, the purge only removes the rows of the tenant
+const (
+	stmtDeletedProducts       = "SELECT Id, Name, Description, PriceMinor, DeliveryPriceMinor, Currency, DeletedAt FROM Products WHERE DeletedAt IS NOT NULL AND TenantId=? ORDER BY DeletedAt DESC, Id LIMIT ? OFFSET ?"
+	stmtCountDeletedProducts  = "SELECT COUNT(*) FROM Products WHERE DeletedAt IS NOT NULL AND TenantId=?"
+	stmtDeletedProductOptions = "SELECT Id, ProductId, Name, Description, DeletedAt FROM ProductOptions WHERE ProductId=? AND TenantId=? AND DeletedAt IS NOT NULL ORDER BY DeletedAt DESC, Id"
+	stmtRestoreProduct        = "UPDATE Products SET DeletedAt=NULL, Version=Version+1 WHERE Id=? AND TenantId=? AND DeletedAt IS NOT NULL"
+	stmtRestoreProductOptions = "UPDATE ProductOptions SET DeletedAt=NULL, Version=Version+1 WHERE ProductId=? AND TenantId=? AND DeletedAt=?"
+	stmtRestoreProductOption  = "UPDATE ProductOptions SET DeletedAt=NULL, Version=Version+1 WHERE Id=? and ProductId=? AND TenantId=? AND DeletedAt IS NOT NULL"
+	stmtExpiredProducts       = "SELECT Id FROM Products WHERE DeletedAt IS NOT NULL AND DeletedAt <= ? AND TenantId=?"
+	stmtExpiredProductOptions = "SELECT Id, ProductId FROM ProductOptions WHERE DeletedAt IS NOT NULL AND DeletedAt <= ? AND TenantId=?"
+	stmtPurgeProduct          = "DELETE FROM Products WHERE Id=? AND TenantId=?"
+)
+
+// Returns one page of the products in the trash, the last deleted first, and the total number of products in the trash
+func (c *ProductsCmds) FetchDeletedProducts(ctx context.Context, limit int, offset int) ([]models.DBProducts, int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "trash.show", "db")
+	span.SpanData.Context.SetTag("span", "FetchDeletedProducts")
+	defer span.End()
+
+	db := c.DB.RO(ctx)
+	tenant := xeroHelper.TenantFrom(ctx)
+
+	var total int64
+	if err := db.QueryRowContext(ctx, c.sql(stmtCountDeletedProducts), tenant).Scan(&total); err != nil {
+		c.Logger.Error("Error while counting the deleted products", "error", err)
+		return nil, 0, err
+	}
+
+	rows, err := db.QueryContext(ctx, c.sql(stmtDeletedProducts), tenant, limit, offset)
+	if err != nil {
+		c.Logger.Error("Error while fetching the deleted products", "error", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	result := []models.DBProducts{}
+	for rows.Next() {
+		dbObj := models.DBProducts{}
+		if err := rows.Scan(&dbObj.DBID, &dbObj.DBName, &dbObj.DBDescription, &dbObj.DBPrice, &dbObj.DBDeliveryPrice, &dbObj.DBCurrency, &dbObj.DBDeletedAt); err != nil {
+			c.Logger.Error("Error while scanning rows", "error", err)
+			return nil, 0, err
+		}
+		result = append(result, dbObj)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger.Error("Error while scanning rows", "error", err)
+		return nil, 0, err
+	}
+
+	c.Logger.Debug("Fetched the deleted products", "total_rows", len(result), "total", total)
+	return result, total, nil
+}
+
+// Returns the options of the product in the trash, the last deleted first
+func (c *ProductsCmds) FetchDeletedProductOptions(ctx context.Context, pID string) ([]models.DBProductOptions, error) {
+
+	span, ctx := apm.StartSpan(ctx, "trash.show", "db")
+	span.SpanData.Context.SetTag("span", "FetchDeletedProductOptions")
+	defer span.End()
+
+	pID = strings.ToLower(pID)
+
+	rows, err := c.DB.RO(ctx).QueryContext(ctx, c.sql(stmtDeletedProductOptions), pID, xeroHelper.TenantFrom(ctx))
+	if err != nil {
+		c.Logger.Error("Error while fetching the deleted product options", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []models.DBProductOptions{}
+	for rows.Next() {
+		dbObj := models.DBProductOptions{}
+		if err := rows.Scan(&dbObj.DBID, &dbObj.DBProductID, &dbObj.DBName, &dbObj.DBDescription, &dbObj.DBDeletedAt); err != nil {
+			c.Logger.Error("Error while scanning rows", "error", err)
+			return nil, err
+		}
+		result = append(result, dbObj)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger.Error("Error while scanning rows", "error", err)
+		return nil, err
+	}
+
+	c.Logger.Debug("Fetched the deleted product options", "total_rows", len(result))
+	return result, nil
+}
+
+// RestoreProduct brings the product back from the trash with the options deleted along with it
+// The options deleted before the product stay in the trash, ErrQuotaExceeded when the tenant has no room left for them
+func (c *ProductsCmds) RestoreProduct(ctx context.Context, pID string) (int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "trash.restore", "db")
+	span.SpanData.Context.SetTag("span", "RestoreProduct")
+	defer span.End()
+
+	pID = strings.ToLower(pID)
+
+	tenant := xeroHelper.TenantFrom(ctx)
+	var affectedRows int64
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+		before, err := c.productState(ctx, tx, pID)
+		if err == sql.ErrNoRows || (err == nil && !before.DBDeletedAt.Valid) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		options, err := c.optionStates(ctx, tx, pID, " AND DeletedAt=?", before.DBDeletedAt.Int64)
+		if err != nil {
+			return err
+		}
+		if err := c.checkQuota(ctx, tx, 1, int64(len(options))); err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, c.sql(stmtRestoreProduct), pID, tenant)
+		if err != nil {
+			return err
+		}
+		if affectedRows, _ = result.RowsAffected(); affectedRows == 0 {
+			return nil
+		}
+		if _, err = tx.ExecContext(ctx, c.sql(stmtRestoreProductOptions), pID, tenant, before.DBDeletedAt.Int64); err != nil {
+			return err
+		}
+
+		after := before
+		after.DBDeletedAt = sql.NullInt64{}
+		after.DBVersion.Int64++
+		changes := []auditChange{productChange(models.AuditRestore, &before, &after)}
+		for i := range options {
+			optionAfter := options[i]
+			optionAfter.DBDeletedAt = sql.NullInt64{}
+			optionAfter.DBVersion.Int64++
+			changes = append(changes, optionChange(models.AuditRestore, &options[i], &optionAfter))
+		}
+		return c.audit(ctx, tx, time.Now(), changes...)
+	})
+	if err == ErrQuotaExceeded {
+		return 0, err
+	} else if err != nil {
+		c.Logger.Error("Error while restoring the product", "error", err)
+		return 0, err
+	}
+
+	c.Logger.Debug("Restored the product", "affected_rows", affectedRows)
+	return affectedRows, nil
+}
+
+// RestoreProductOption brings the option of the product back from the trash, ErrQuotaExceeded when the tenant has all its options
+func (c *ProductsCmds) RestoreProductOption(ctx context.Context, pID string, pOptionID string) (int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "trash.restore", "db")
+	span.SpanData.Context.SetTag("span", "RestoreProductOption")
+	defer span.End()
+
+	pID, pOptionID = strings.ToLower(pID), strings.ToLower(pOptionID)
+
+	var affectedRows int64
+	err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+
+		before, err := c.optionStates(ctx, tx, pID, " AND Id=? AND DeletedAt IS NOT NULL", pOptionID)
+		if err != nil || len(before) == 0 {
+			return err
+		}
+		if err := c.checkQuota(ctx, tx, 0, 1); err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, c.sql(stmtRestoreProductOption), pOptionID, pID, xeroHelper.TenantFrom(ctx))
+		if err != nil {
+			return err
+		}
+		if affectedRows, _ = result.RowsAffected(); affectedRows == 0 {
+			return nil
+		}
+
+		after := before[0]
+		after.DBDeletedAt = sql.NullInt64{}
+		after.DBVersion.Int64++
+		return c.audit(ctx, tx, time.Now(), optionChange(models.AuditRestore, &before[0], &after))
+	})
+	if err == ErrQuotaExceeded {
+		return 0, err
+	} else if err != nil {
+		c.Logger.Error("Error while restoring the product option", "error", err)
+		return 0, err
+	}
+	c.Logger.Debug("Restored the product option", "affected_rows", affectedRows)
+	return affectedRows, nil
+}
+
+// PurgeDeleted removes for good the products and the options of the tenant deleted before the time, along with all their rows
+// Every product and every option is purged in its own transaction, a failed purge is picked up again by the next one
+// Returns the number of products and the number of options purged
+func (c *ProductsCmds) PurgeDeleted(ctx context.Context, before time.Time) (int64, int64, error) {
+
+	span, ctx := apm.StartSpan(ctx, "trash.purge", "db")
+	span.SpanData.Context.SetTag("span", "PurgeDeleted")
+	defer span.End()
+
+	cutoff := models.DeletedAtMillis(before)
+	tenant := xeroHelper.TenantFrom(ctx)
+
+	productIDs, err := c.expiredRows(ctx, stmtExpiredProducts, cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+	var products int64
+	for _, row := range productIDs {
+		pID := strings.ToLower(row[0])
+		err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+			changes, err := c.purgeChanges(ctx, tx, pID)
+			if err != nil {
+				return err
+			}
+			for _, stmt := range []string{stmtDeleteProductReservations, stmtDeleteAllProductOption, stmtDeleteAllProductPrices, stmtDeleteAllProductCategories, stmtPurgeProduct} {
+				if _, err := tx.ExecContext(ctx, c.sql(stmt), pID, tenant); err != nil {
+					return err
+				}
+			}
+			return c.audit(ctx, tx, time.Now(), changes...)
+		})
+		if err != nil {
+			c.Logger.Error("Error while purging the product", "uuid", pID, "error", err)
+			return products, 0, err
+		}
+		products++
+	}
+
+	// The options of the products purged above are already gone
+	optionIDs, err := c.expiredRows(ctx, stmtExpiredProductOptions, cutoff)
+	if err != nil {
+		return products, 0, err
+	}
+	var options int64
+	for _, row := range optionIDs {
+		err := c.DB.InTx(ctx, func(tx *sql.Tx) error {
+			before, err := c.optionStates(ctx, tx, row[1], " AND Id=?", row[0])
+			if err != nil || len(before) == 0 {
+				return err
+			}
+			for _, stmt := range []string{stmtDeleteOptionReservations, stmtPurgeProductOption} {
+				if _, err := tx.ExecContext(ctx, c.sql(stmt), row[0], row[1], tenant); err != nil {
+					return err
+				}
+			}
+			return c.audit(ctx, tx, time.Now(), optionChange(models.AuditPurge, &before[0], nil))
+		})
+		if err != nil {
+			c.Logger.Error("Error while purging the product option", "uuid", row[0], "error", err)
+			return products, options, err
+		}
+		options++
+	}
+
+	c.Logger.Debug("Purged the trash", "products", products, "options", options)
+	return products, options, nil
+}
+
+// Returns the changes of the purge of the product, the product goes for good along with its options, its prices and its links to the categories
+func (c *ProductsCmds) purgeChanges(ctx context.Context, tx *sql.Tx, pID string) ([]auditChange, error) {
+
+	product, err := c.productState(ctx, tx, pID)
+	if err != nil {
+		return nil, err
+	}
+	options, err := c.optionStates(ctx, tx, pID, "")
+	if err != nil {
+		return nil, err
+	}
+	prices, err := c.priceStates(ctx, tx, pID, "")
+	if err != nil {
+		return nil, err
+	}
+	categoryIDs, err := c.linkStates(ctx, tx, stmtCategoryLinks, pID)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []auditChange{productChange(models.AuditPurge, &product, nil)}
+	for i := range options {
+		changes = append(changes, optionChange(models.AuditPurge, &options[i], nil))
+	}
+	for i := range prices {
+		changes = append(changes, priceChange(models.AuditPurge, &prices[i], nil))
+	}
+	for _, categoryID := range categoryIDs {
+		changes = append(changes, categoryChange(models.AuditPurge, pID, categoryID))
+	}
+	return changes, nil
+}
+
+// Returns the ids of the rows of the tenant deleted before the cutoff, every row holds the columns of the statement
+func (c *ProductsCmds) expiredRows(ctx context.Context, stmt string, cutoff int64) ([][]string, error) {
+
+	rows, err := c.DB.RW(ctx).QueryContext(ctx, c.sql(stmt), cutoff, xeroHelper.TenantFrom(ctx))
+	if err != nil {
+		c.Logger.Error("Error while fetching the expired rows of the trash", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, _ := rows.Columns()
+	var result [][]string
+	for rows.Next() {
+		row := make([]string, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			c.Logger.Error("Error while scanning rows", "error", err)
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger.Error("Error while scanning rows", "error", err)
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,147 @@
+package ctls
+
+import (
+	"context"
+
+	"github.com/techievee/xero/productService/models"
+	xError "github.com/techievee/xero/xeroErrors"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// fieldsRestricted tells if the policy restricts the fields the caller of the request may change
+// The anonymous requests and the principals without policy may change every field
+func fieldsRestricted(ctx context.Context) bool {
+	principal, ok := xeroHelper.PrincipalFrom(ctx)
+	return ok && principal.Fields != nil
+}
+
+// authorizeChanges refuses the change when the caller may not change one of the fields of the resource
+// The creations are allowed by the route, but for the prices of the products checked by authorizeProductCreation
+func (p *ProductsCtl) authorizeChanges(ctx context.Context, resourceType string, fields []string) error {
+
+	principal, ok := xeroHelper.PrincipalFrom(ctx)
+	if !ok {
+		return nil
+	}
+
+	var denied []string
+	for _, field := range fields {
+		if !principal.MayChange(resourceType, field) {
+			denied = append(denied, field)
+		}
+	}
+	if len(denied) == 0 {
+		return nil
+	}
+
+	p.Logger.Info("Change denied", "subject", principal.Subject, "roles", principal.Roles, "resource", resourceType, "fields", denied)
+	return xError.XeroForbiddenError()
+}
+
+// authorizeProductCreation refuses the new product when the caller may not set its prices
+// A product is created with the prices of its body, the ones other than 0 need the grant of their field
+func (p *ProductsCtl) authorizeProductCreation(ctx context.Context, product models.Product) error {
+
+	var fields []string
+	if product.Price.Amount != 0 {
+		fields = append(fields, "Price")
+	}
+	if product.DeliveryPrice.Amount != 0 {
+		fields = append(fields, "DeliveryPrice")
+	}
+	return p.authorizeChanges(ctx, "product", fields)
+}
+
+// authorizeProductUpdate checks the fields the update changes in the current product
+// Returns the version the fields were checked against, the update only applies to it so a change made in between is not overwritten
+func (p *ProductsCtl) authorizeProductUpdate(ctx context.Context, productId string, product models.Product, version *int64) (*int64, error) {
+
+	if !fieldsRestricted(ctx) {
+		return version, nil
+	}
+
+	result, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId)
+	if err != nil {
+		return nil, xError.NewUnexpectedGenericError(err)
+	}
+	if len(result) == 0 {
+		return nil, xError.XeroUnknownIDError("product")
+	}
+	current := result[0].DBVersion.Int64
+	if version != nil && *version != current {
+		return nil, versionMismatch("product")
+	}
+
+	if err := p.authorizeChanges(ctx, "product", product.ChangedFields(models.NewProduct(result[0]))); err != nil {
+		return nil, err
+	}
+	return &current, nil
+}
+
+// authorizeOptionUpdate checks the fields the update changes in the current option, the same as authorizeProductUpdate
+func (p *ProductsCtl) authorizeOptionUpdate(ctx context.Context, productId string, productOptionId string, option models.ProductOption, version *int64) (*int64, error) {
+
+	if !fieldsRestricted(ctx) {
+		return version, nil
+	}
+
+	result, err := p.ServiceCommands.FetchAllProductOptions(ctx, productId, productOptionId)
+	if err != nil {
+		return nil, xError.NewUnexpectedGenericError(err)
+	}
+	if len(result) == 0 {
+		return nil, xError.XeroUnknownIDError("product_option")
+	}
+	current := result[0].DBVersion.Int64
+	if version != nil && *version != current {
+		return nil, versionMismatch("product_option")
+	}
+
+	if err := p.authorizeChanges(ctx, "product_option", option.ChangedFields(models.NewProductOption(result[0]))); err != nil {
+		return nil, err
+	}
+	return &current, nil
+}
+
+// authorizeBatchOperation checks the fields an upsert changes in the product or the option when it exists
+// The products created by the operation are checked by authorizeProductCreation, the other creations and the deletes are allowed by the route
+// The version checked is set on the operation, the same as authorizeProductUpdate the upsert only applies to it
+func (p *ProductsCtl) authorizeBatchOperation(ctx context.Context, op *models.BatchOperation) error {
+
+	if op.Op == models.BatchCreate && !op.IsOption() {
+		return p.authorizeProductCreation(ctx, *op.Product)
+	}
+	if op.Op != models.BatchUpsert || !fieldsRestricted(ctx) {
+		return nil
+	}
+
+	var current int64
+	if op.IsOption() {
+		result, err := p.ServiceCommands.FetchAllProductOptions(ctx, op.ProductID, op.ID)
+		if err != nil {
+			return xError.NewUnexpectedGenericError(err)
+		}
+		if len(result) != 0 {
+			if err := p.authorizeChanges(ctx, "product_option", op.Option.ChangedFields(models.NewProductOption(result[0]))); err != nil {
+				return err
+			}
+			current = result[0].DBVersion.Int64
+		}
+		op.Version = &current
+		return nil
+	}
+
+	result, err := p.ServiceCommands.FetchAllProducts(ctx, "", op.ID)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+	op.Version = &current
+	if len(result) == 0 {
+		return p.authorizeProductCreation(ctx, *op.Product)
+	}
+	if err := p.authorizeChanges(ctx, "product", op.Product.ChangedFields(models.NewProduct(result[0]))); err != nil {
+		return err
+	}
+	current = result[0].DBVersion.Int64
+	return nil
+}
@@ -0,0 +1,212 @@
+package ctls
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"go.elastic.co/apm"
+
+	productServiceCmds "github.com/techievee/xero/productService/commands"
+	"github.com/techievee/xero/productService/models"
+	xError "github.com/techievee/xero/xeroErrors"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// Limits of the batches when the config does not set them
+const (
+	defaultBatchChunkSize     = 500
+	defaultBatchMaxOperations = 10000
+)
+
+// BatchProducts applies the create, upsert and delete operations of the batch to the products and the options
+// Every operation is validated before any of them is applied, an atomic batch with an invalid operation applies nothing
+// Returns one result per operation, with the status of the batch or of its first failed operation when it is atomic
+func (p *ProductsCtl) BatchProducts(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "products.batch", "api")
+	defer span.End()
+
+	batch := models.ProductBatch{}
+	if err := c.Bind(&batch); err != nil {
+		return xError.XeroInvalidRequestError(err)
+	}
+
+	if batch.Mode == "" {
+		batch.Mode = models.BatchAtomic
+	}
+	if batch.Mode != models.BatchAtomic && batch.Mode != models.BatchBestEffort {
+		return xError.XeroValidationError(xError.NewFieldError("/Mode", xError.FieldInvalid, "Mode must be atomic or best_effort"))
+	}
+	if len(batch.Operations) == 0 {
+		return xError.XeroValidationError(xError.NewFieldError("/Operations", xError.FieldRequired, "Operations is required"))
+	}
+	if len(batch.Operations) > p.batchMaxOperations() {
+		return xError.XeroValidationError(xError.NewFieldError("/Operations", xError.FieldTooLong,
+			fmt.Sprintf("A batch has at most %d operations", p.batchMaxOperations())))
+	}
+
+	atomic := batch.Mode == models.BatchAtomic
+	requestID := c.Response().Header().Get(echo.HeaderXRequestID)
+	response := models.BatchResults{Mode: batch.Mode, Results: make([]models.BatchResult, len(batch.Operations))}
+
+	// Only the valid operations are applied, indexes maps them back to their result
+	var valid []models.BatchOperation
+	var indexes []int
+	for i := range batch.Operations {
+		response.Results[i] = models.BatchResult{Index: i, Op: batch.Operations[i].Op}
+		if err := p.validateBatchOperation(i, &batch.Operations[i]); err != nil {
+			response.Results[i].SetError(err, requestID)
+			continue
+		}
+		if err := p.authorizeBatchOperation(ctx, &batch.Operations[i]); err != nil {
+			response.Results[i].SetError(err, requestID)
+			continue
+		}
+		valid = append(valid, batch.Operations[i])
+		indexes = append(indexes, i)
+	}
+
+	if atomic && len(valid) != len(batch.Operations) {
+		for _, i := range indexes {
+			response.Results[i].SetError(batchError(productServiceCmds.ErrBatchRolledBack), requestID)
+		}
+	} else if len(valid) != 0 {
+		outcomes, err := p.ServiceCommands.ApplyBatch(ctx, valid, atomic, p.batchChunkSize())
+		if err != nil {
+			return xError.NewUnexpectedGenericError(err)
+		}
+		for n, outcome := range outcomes {
+			result := &response.Results[indexes[n]]
+			if outcome.Err != nil {
+				result.SetError(batchError(outcome.Err), requestID)
+				continue
+			}
+			result.ID, result.OptionIDs, result.Status = outcome.ID, outcome.OptionIDs, http.StatusOK
+			if outcome.Created {
+				result.Status = http.StatusCreated
+			}
+		}
+	}
+
+	status := http.StatusOK
+	for _, result := range response.Results {
+		if result.Error == nil {
+			response.Succeeded++
+			continue
+		}
+		if atomic && status == http.StatusOK && result.Status != http.StatusFailedDependency {
+			status = result.Status
+		}
+		response.Failed++
+	}
+
+	return c.JSON(status, response)
+
+}
+
+// validateBatchOperation checks the operation the same way as its own endpoint, the pointers of the fields are the ones in the body of the batch
+// The products created or upserted without a currency get the default currency
+func (p *ProductsCtl) validateBatchOperation(i int, op *models.BatchOperation) error {
+
+	pointer := fmt.Sprintf("/Operations/%d", i)
+	if op.Op != models.BatchCreate && op.Op != models.BatchUpsert && op.Op != models.BatchDelete {
+		return xError.XeroValidationError(xError.NewFieldError(pointer+"/Op", xError.FieldInvalid, "Op must be create, upsert or delete"))
+	}
+
+	resourceType := "product"
+	if op.IsOption() {
+		if !xeroHelper.ValidateUUID(op.ProductID) {
+			return xError.XeroInvalidIDError("product")
+		}
+		resourceType = "product_option"
+	}
+
+	// The ids are generated on creation
+	if op.Op == models.BatchCreate && op.ID != "" {
+		return xError.XeroValidationError(xError.NewFieldError(pointer+"/Id", xError.FieldNotAllowed, "Id is generated on creation"))
+	}
+	if op.Op != models.BatchCreate && !xeroHelper.ValidateUUID(op.ID) {
+		return xError.XeroInvalidIDError(resourceType)
+	}
+
+	// A delete has no body, the other operations have the body of their resource only
+	body, other := "/Product", "/Option"
+	if op.IsOption() {
+		body, other = other, body
+	}
+	switch {
+	case op.Op == models.BatchDelete && op.Product != nil:
+		return xError.XeroValidationError(xError.NewFieldError(pointer+"/Product", xError.FieldNotAllowed, "A delete has no body"))
+	case op.Op == models.BatchDelete && op.Option != nil:
+		return xError.XeroValidationError(xError.NewFieldError(pointer+"/Option", xError.FieldNotAllowed, "A delete has no body"))
+	case op.Op != models.BatchDelete && ((op.IsOption() && op.Product != nil) || (!op.IsOption() && op.Option != nil)):
+		return xError.XeroValidationError(xError.NewFieldError(pointer+other, xError.FieldNotAllowed, other[1:]+" cannot be set along with "+body[1:]))
+	case op.Op != models.BatchDelete && ((op.IsOption() && op.Option == nil) || (!op.IsOption() && op.Product == nil)):
+		return xError.XeroValidationError(xError.NewFieldError(pointer+body, xError.FieldRequired, body[1:]+" is required"))
+	case op.Op == models.BatchDelete:
+		return nil
+	}
+
+	var err error
+	if op.IsOption() {
+		err = op.Option.Validate()
+	} else {
+		// Same as the update, the options are embedded only on creation
+		if op.Op == models.BatchUpsert && op.Product.Options != nil {
+			return xError.XeroValidationError(xError.NewFieldError(pointer+"/Product/Options", xError.FieldNotAllowed, "Options can only be set on creation"))
+		}
+		if op.Product.Currency == "" {
+			op.Product.Currency = p.defaultCurrency()
+		}
+		err = op.Product.Validate()
+	}
+	if err == nil {
+		return nil
+	}
+
+	errs := xError.NewErrorCollection()
+	for _, field := range xError.FieldErrors(err) {
+		field.Pointer = pointer + body + field.Pointer
+		errs.AddError(field)
+	}
+	return xError.XeroValidationError(errs)
+}
+
+// batchError returns the error of the refused operation, the same as its own endpoint would return
+func batchError(err error) xError.Error {
+
+	switch err {
+	case productServiceCmds.ErrUnknownProduct:
+		return xError.XeroUnknownIDError("product")
+	case productServiceCmds.ErrUnknownProductOption:
+		return xError.XeroUnknownIDError("product_option")
+	case productServiceCmds.ErrInTrash:
+		return xError.XeroConflictError("in_trash", "Restore the product or the option before changing it")
+	case productServiceCmds.ErrIDTaken:
+		return xError.XeroConflictError("id_taken", "The id is taken")
+	case productServiceCmds.ErrQuotaExceeded:
+		return quotaExceededError()
+	case productServiceCmds.ErrVersionMismatch:
+		return xError.New(http.StatusConflict, "concurrent_change", xError.Retry, "It was changed by another request, retry the operation")
+	case productServiceCmds.ErrBatchRolledBack:
+		return xError.New(http.StatusFailedDependency, "not_applied", xError.Failed, "The operation was rolled back along with the batch")
+	}
+	return xError.NewUnexpectedGenericError(err)
+}
+
+func (p *ProductsCtl) batchChunkSize() int {
+	if p.BatchChunkSize <= 0 {
+		return defaultBatchChunkSize
+	}
+	return p.BatchChunkSize
+}
+
+func (p *ProductsCtl) batchMaxOperations() int {
+	if p.BatchMaxOperations <= 0 {
+		return defaultBatchMaxOperations
+	}
+	return p.BatchMaxOperations
+}
@@ -0,0 +1,444 @@
+package ctls
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"go.elastic.co/apm"
+
+	"github.com/techievee/xero/productService/models"
+	xError "github.com/techievee/xero/xeroErrors"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+const (
+	// Longest line of an NDJSON import, a product with many nested options
+	maxImportLineSize = 1024 * 1024
+	// Number of products read at once by the export, along with their options
+	exportPageSize = 500
+)
+
+// catalogueLine is one line of an import, err is set when the line cannot be read
+type catalogueLine struct {
+	number int
+	row    models.CatalogueRow
+	err    error
+}
+
+// ExportProducts streams the catalogue in CSV or NDJSON, the products are read by pages ordered by Id along with the options of the page
+// The lines of a page are written and flushed before the next page is read, the catalogue is never held whole in memory
+// The options are flattened with one line per option by default in CSV, and nested in the line of their product in NDJSON
+func (p *ProductsCtl) ExportProducts(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "products.export", "api")
+	defer span.End()
+
+	format := strings.ToLower(strings.TrimSpace(c.QueryParam("format")))
+	layout := models.OptionsFlat
+	switch format {
+	case "", models.CatalogueCSV:
+		format = models.CatalogueCSV
+	case models.CatalogueNDJSON:
+		layout = models.OptionsNested
+	default:
+		return xError.XeroBadRequestError("invalid_format", "format must be csv or ndjson")
+	}
+
+	switch v := strings.ToLower(strings.TrimSpace(c.QueryParam("options"))); v {
+	case "":
+	case models.OptionsFlat, models.OptionsNested:
+		layout = v
+	default:
+		return xError.XeroBadRequestError("invalid_options", "options must be flat or nested")
+	}
+
+	// The first page is read before the status is sent, a failing database is still answered with an error
+	products, err := p.ServiceCommands.FetchProductsAfter(ctx, "", exportPageSize)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
+	response := c.Response()
+	if format == models.CatalogueCSV {
+		response.Header().Set(echo.HeaderContentType, models.MIMETextCSV+"; charset=UTF-8")
+	} else {
+		response.Header().Set(echo.HeaderContentType, models.MIMEApplicationNDJSON)
+	}
+	response.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="products.%s"`, format))
+	response.WriteHeader(http.StatusOK)
+
+	// The status is sent, from now on a failure can only cut the export short
+	header := models.CatalogueHeader(layout)
+	csvWriter := csv.NewWriter(response)
+	encoder := json.NewEncoder(response)
+	if format == models.CatalogueCSV {
+		if err := csvWriter.Write(header); err != nil {
+			p.Logger.Error("Error while exporting the header", "error", err)
+			return nil
+		}
+	}
+
+	for len(products) > 0 {
+		ids := make([]string, 0, len(products))
+		for _, row := range products {
+			ids = append(ids, row.DBID.String)
+		}
+		dbOptions, err := p.ServiceCommands.FetchOptionsOfProducts(ctx, ids)
+		if err != nil {
+			p.Logger.Error("Error while exporting the options of the products", "error", err, "after", ids[0])
+			return nil
+		}
+		options := make(map[string][]models.ProductOption, len(products))
+		for _, id := range ids {
+			options[id] = []models.ProductOption{}
+		}
+		for _, v := range dbOptions {
+			options[v.DBProductID.String] = append(options[v.DBProductID.String], models.NewProductOption(v))
+		}
+
+		for _, row := range products {
+			for _, line := range models.CatalogueRows(models.NewProduct(row), options[row.DBID.String], layout) {
+				if format == models.CatalogueNDJSON {
+					err = encoder.Encode(line)
+				} else {
+					var record []string
+					if record, err = line.CSVRecord(header); err == nil {
+						err = csvWriter.Write(record)
+					}
+				}
+				if err != nil {
+					p.Logger.Error("Error while exporting the product", "error", err, "uuid", row.DBID.String)
+					return nil
+				}
+			}
+		}
+
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			p.Logger.Error("Error while exporting the products", "error", err, "after", ids[0])
+			return nil
+		}
+		response.Flush()
+
+		if len(products) < exportPageSize {
+			break
+		}
+		if products, err = p.ServiceCommands.FetchProductsAfter(ctx, ids[len(ids)-1], exportPageSize); err != nil {
+			p.Logger.Error("Error while exporting the products", "error", err, "after", ids[len(ids)-1])
+			return nil
+		}
+	}
+
+	return nil
+
+}
+
+// ImportProducts creates and updates the products and their options from a CSV or an NDJSON body
+// The lines with an Id upsert their product and their options, which need their id, the others create a new product with its options
+// Every line is validated before anything is written, the import is refused with the errors of all the invalid lines
+// With dryRun=true the lines are only validated
+func (p *ProductsCtl) ImportProducts(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "products.import", "api")
+	defer span.End()
+
+	dryRun := false
+	if v := c.QueryParam("dryRun"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			return xError.XeroBadRequestError("invalid_dry_run", "dryRun must be true or false")
+		}
+	}
+
+	var lines []catalogueLine
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	switch mediaType {
+	case models.MIMETextCSV:
+		lines, err = readCSVCatalogue(c.Request().Body, p.batchMaxOperations())
+	case models.MIMEApplicationNDJSON:
+		lines, err = readNDJSONCatalogue(c.Request().Body, p.batchMaxOperations())
+	default:
+		return xError.XeroUnsupportedMediaTypeError("unsupported_import", "The import is either text/csv or application/x-ndjson")
+	}
+	if err != nil {
+		return err
+	}
+
+	requestID := c.Response().Header().Get(echo.HeaderXRequestID)
+	results := models.ImportResults{DryRun: dryRun, Lines: len(lines), Errors: []models.ImportError{}}
+
+	// The operations of the lines, opLines maps them back to the number of their line
+	var ops []models.BatchOperation
+	var opLines []int
+	upserted := map[string]upsertedProduct{}
+	for i := range lines {
+		line := &lines[i]
+		if line.err == nil {
+			line.err = p.validateCatalogueRow(&line.row)
+		}
+		if line.err != nil {
+			results.AddError(line.number, line.err, requestID)
+			continue
+		}
+		var lineOps []models.BatchOperation
+		lineOps, line.err = importOperations(line.number, line.row, upserted)
+		for i := range lineOps {
+			if line.err = p.authorizeBatchOperation(ctx, &lineOps[i]); line.err != nil {
+				break
+			}
+		}
+		if line.err != nil {
+			results.AddError(line.number, line.err, requestID)
+			continue
+		}
+		for _, op := range lineOps {
+			ops = append(ops, op)
+			opLines = append(opLines, line.number)
+		}
+	}
+
+	if results.Failed != 0 {
+		return c.JSON(http.StatusBadRequest, results)
+	}
+	results.Operations = len(ops)
+	if dryRun || len(ops) == 0 {
+		return c.JSON(http.StatusOK, results)
+	}
+
+	outcomes, err := p.ServiceCommands.ApplyBatch(ctx, ops, false, p.batchChunkSize())
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+	for i, outcome := range outcomes {
+		switch {
+		case outcome.Err != nil:
+			results.AddError(opLines[i], batchError(outcome.Err), requestID)
+		case outcome.Created:
+			results.Created += 1 + len(outcome.OptionIDs)
+		default:
+			results.Updated++
+		}
+	}
+
+	return c.JSON(http.StatusOK, results)
+
+}
+
+// validateCatalogueRow checks the line of the import, the pointers of the fields are the ones of the NDJSON and the columns of the CSV
+// The products without a currency get the default currency
+func (p *ProductsCtl) validateCatalogueRow(row *models.CatalogueRow) error {
+
+	if row.ID != "" && !xeroHelper.ValidateUUID(row.ID) {
+		return xError.XeroInvalidIDError("product")
+	}
+	if row.HasOption() && row.Options != nil {
+		return xError.XeroValidationError(xError.NewFieldError("/Options", xError.FieldNotAllowed, "Options cannot be set along with the flattened option"))
+	}
+
+	// The options of a new product get generated ids
+	pointers, ids := []string{"/OptionId"}, []string{row.OptionID}
+	for i, option := range row.Options {
+		pointers, ids = append(pointers, fmt.Sprintf("/Options/%d/Id", i)), append(ids, option.ID)
+	}
+	for i, id := range ids {
+		pointer := pointers[i]
+		if id == "" {
+			// Without its id a re-import would add the option again
+			if row.ID != "" && (i > 0 || row.HasOption()) {
+				return xError.XeroValidationError(xError.NewFieldError(pointer, xError.FieldRequired, "The options of a product with an Id need their id"))
+			}
+			continue
+		}
+		if row.ID == "" {
+			return xError.XeroValidationError(xError.NewFieldError(pointer, xError.FieldNotAllowed, "The options of a new product get generated ids"))
+		}
+		if !xeroHelper.ValidateUUID(id) {
+			return xError.XeroInvalidIDError("product_option")
+		}
+	}
+
+	if row.Currency == "" {
+		row.Currency = p.defaultCurrency()
+	}
+
+	errs := xError.NewErrorCollection()
+	if err := row.Product.Validate(); err != nil {
+		for _, field := range xError.FieldErrors(err) {
+			errs.AddError(field)
+		}
+	}
+	if row.HasOption() {
+		option := row.FlatOption()
+		if err := option.Validate(); err != nil {
+			for _, field := range xError.FieldErrors(err) {
+				field.Pointer = "/Option" + strings.TrimPrefix(field.Pointer, "/")
+				errs.AddError(field)
+			}
+		}
+	}
+	if xError.IsNil(errs) {
+		return nil
+	}
+	return xError.XeroValidationError(errs)
+}
+
+// upsertedProduct is the product upserted by the first line of its id
+type upsertedProduct struct {
+	line    int
+	product models.Product
+}
+
+// importOperations returns the operations of a valid line, a product is upserted once even when it has one line per option
+// The later lines of the product are refused when their product fields differ from the first line
+func importOperations(number int, row models.CatalogueRow, upserted map[string]upsertedProduct) ([]models.BatchOperation, error) {
+
+	product := row.Product
+	options := product.Options
+	if row.HasOption() {
+		options = append(options, row.FlatOption())
+	}
+	product.Options = nil
+
+	if product.ID == "" {
+		product.Options = options
+		return []models.BatchOperation{{Op: models.BatchCreate, Product: &product}}, nil
+	}
+
+	var ops []models.BatchOperation
+	key := strings.ToLower(product.ID)
+	if first, ok := upserted[key]; ok {
+		if fields := product.ChangedFields(first.product); len(fields) != 0 {
+			errs := xError.NewErrorCollection()
+			for _, field := range fields {
+				errs.AddError(xError.NewFieldError("/"+field, xError.FieldInvalid, fmt.Sprintf("The product has another %s on the line %d", field, first.line)))
+			}
+			return nil, xError.XeroValidationError(errs)
+		}
+	} else {
+		upserted[key] = upsertedProduct{line: number, product: product}
+		ops = append(ops, models.BatchOperation{Op: models.BatchUpsert, ID: product.ID, Product: &product})
+	}
+	for i := range options {
+		ops = append(ops, models.BatchOperation{Op: models.BatchUpsert, ID: options[i].ID, ProductID: product.ID, Option: &options[i]})
+	}
+	return ops, nil
+}
+
+// readCSVCatalogue reads the lines of a CSV with a header, the header is the line 1 and the line numbers are the rows of the spreadsheet
+// The csv reader skips the blank lines and cannot tell the line of a record, so every record is read on its own
+func readCSVCatalogue(body io.Reader, maxLines int) ([]catalogueLine, error) {
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineSize)
+
+	var header []string
+	var lines []catalogueLine
+	for number := 1; scanner.Scan(); number++ {
+
+		// A quoted field may hold line breaks, the record goes on until its quotes are closed
+		first, text := number, scanner.Text()
+		for strings.Count(text, `"`)%2 == 1 && scanner.Scan() {
+			number++
+			text += "\n" + scanner.Text()
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		record, err := csv.NewReader(strings.NewReader(text)).Read()
+		if header == nil {
+			if err != nil {
+				return nil, xError.XeroInvalidRequestError(err)
+			}
+			if header, err = csvCatalogueHeader(record); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if len(lines) == maxLines {
+			return nil, tooManyLines(maxLines)
+		}
+
+		line := catalogueLine{number: first}
+		if err != nil {
+			line.err = xError.XeroInvalidRequestError(err)
+		} else if len(record) != len(header) {
+			line.err = xError.XeroInvalidRequestError(fmt.Sprintf("The line has %d fields, the header has %d", len(record), len(header)))
+		} else if strings.TrimSpace(strings.Join(record, "")) == "" {
+			// The spreadsheets export the empty rows as separators only
+			continue
+		} else {
+			line.row, line.err = models.ParseCSVRecord(header, record)
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, xError.XeroInvalidRequestError(err)
+	}
+	if header == nil {
+		return nil, xError.XeroInvalidRequestError("The CSV has no header")
+	}
+	return lines, nil
+}
+
+// csvCatalogueHeader returns the columns of the header of an imported CSV
+func csvCatalogueHeader(record []string) ([]string, error) {
+
+	// The spreadsheets may start the file with a byte order mark
+	record[0] = strings.TrimPrefix(record[0], "\uFEFF")
+	for i := range record {
+		record[i] = strings.TrimSpace(record[i])
+	}
+	if err := models.ValidateCatalogueHeader(record); err != nil {
+		return nil, xError.XeroBadRequestError("invalid_header", err)
+	}
+	return record, nil
+}
+
+// readNDJSONCatalogue reads the lines of an NDJSON body, one JSON object per line, the blank lines are skipped
+func readNDJSONCatalogue(body io.Reader, maxLines int) ([]catalogueLine, error) {
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineSize)
+
+	var lines []catalogueLine
+	for number := 1; scanner.Scan(); number++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		if len(lines) == maxLines {
+			return nil, tooManyLines(maxLines)
+		}
+
+		line := catalogueLine{number: number}
+		decoder := json.NewDecoder(bytes.NewReader(text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&line.row); err != nil {
+			line.err = xError.XeroInvalidRequestError(err)
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, xError.XeroInvalidRequestError(err)
+	}
+	return lines, nil
+}
+
+func tooManyLines(maxLines int) error {
+	return xError.XeroBadRequestError("too_many_lines", fmt.Sprintf("An import has at most %d lines", maxLines))
+}
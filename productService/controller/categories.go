@@ -0,0 +1,322 @@
+package ctls
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"go.elastic.co/apm"
+
+	productServiceCmds "github.com/techievee/xero/productService/commands"
+	"github.com/techievee/xero/productService/models"
+	xError "github.com/techievee/xero/xeroErrors"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+func (p *ProductsCtl) ShowCategories(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "categories.show", "api")
+	defer span.End()
+
+	result, err := p.ServiceCommands.FetchCategories(ctx)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
+	items := models.CategoryTree(result)
+	return c.JSON(http.StatusOK, models.Categories{Items: &items})
+
+}
+
+func (p *ProductsCtl) ShowCategory(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "categories.show", "api")
+	defer span.End()
+
+	categoryId := c.Param("id")
+	if !xeroHelper.ValidateUUID(categoryId) {
+		return xError.XeroInvalidIDError("category")
+	}
+
+	result, err := p.ServiceCommands.FetchCategories(ctx)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
+	category, ok := models.CategorySubtree(result, categoryId)
+	if !ok {
+		return xError.XeroUnknownIDError("category")
+	}
+
+	return c.JSON(http.StatusOK, category)
+
+}
+
+func (p *ProductsCtl) AddNewCategory(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "categories.add", "api")
+	defer span.End()
+
+	category := models.Category{}
+	if err := c.Bind(&category); err != nil {
+		return xError.XeroInvalidRequestError(err)
+	}
+	if err := p.validateCategory(c, "", &category); err != nil {
+		return err
+	}
+
+	id, err := p.ServiceCommands.AddNewCategory(ctx, category)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
+	// Return 201, created
+	return c.JSON(http.StatusCreated, id)
+
+}
+
+func (p *ProductsCtl) UpdateCategory(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "categories.update", "api")
+	defer span.End()
+
+	categoryId := c.Param("id")
+	if !xeroHelper.ValidateUUID(categoryId) {
+		return xError.XeroInvalidIDError("category")
+	}
+
+	category := models.Category{}
+	if err := c.Bind(&category); err != nil {
+		return xError.XeroInvalidRequestError(err)
+	}
+	if err := p.validateCategory(c, categoryId, &category); err != nil {
+		return err
+	}
+
+	affectedRows, err := p.ServiceCommands.UpdateCategory(ctx, categoryId, category)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+	if affectedRows == 0 {
+		return xError.XeroUnknownIDError("category")
+	}
+
+	return c.JSON(http.StatusOK, categoryId)
+
+}
+
+// DeleteCategory deletes the category, the param orphans tells what happens to its children
+// A category with children is only deleted with orphans=reparent or orphans=cascade
+func (p *ProductsCtl) DeleteCategory(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "categories.delete", "api")
+	defer span.End()
+
+	categoryId := c.Param("id")
+	if !xeroHelper.ValidateUUID(categoryId) {
+		return xError.XeroInvalidIDError("category")
+	}
+
+	orphans := strings.ToLower(strings.TrimSpace(c.QueryParam("orphans")))
+	switch orphans {
+	case "":
+		orphans = models.OrphansRefuse
+	case models.OrphansRefuse, models.OrphansReparent, models.OrphansCascade:
+	default:
+		return xError.XeroBadRequestError("invalid_orphans", "orphans must be one of refuse, reparent or cascade")
+	}
+
+	affectedRows, err := p.ServiceCommands.DeleteCategory(ctx, categoryId, orphans)
+	if err == productServiceCmds.ErrCategoryHasChildren {
+		return xError.XeroConflictError("category_has_children", "The category has children, delete it with orphans=reparent or orphans=cascade")
+	} else if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+	if affectedRows == 0 {
+		return xError.XeroUnknownIDError("category")
+	}
+
+	return c.JSON(http.StatusOK, categoryId)
+
+}
+
+func (p *ProductsCtl) ShowProductCategories(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "product_categories.show", "api")
+	defer span.End()
+
+	productId := c.Param("id")
+	if !xeroHelper.ValidateUUID(productId) {
+		return xError.XeroInvalidIDError("product")
+	}
+	if product, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId); err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	} else if len(product) == 0 {
+		return xError.XeroUnknownIDError("product")
+	}
+
+	result, err := p.ServiceCommands.FetchProductCategories(ctx, productId)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
+	items := []models.Category{}
+	for _, v := range result {
+		items = append(items, models.NewCategory(v))
+	}
+
+	return c.JSON(http.StatusOK, models.Categories{Items: &items})
+
+}
+
+func (p *ProductsCtl) AddProductCategory(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "product_categories.add", "api")
+	defer span.End()
+
+	productId, categoryId, err := productCategoryParams(c)
+	if err != nil {
+		return err
+	}
+
+	if product, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId); err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	} else if len(product) == 0 {
+		return xError.XeroUnknownIDError("product")
+	}
+	if category, err := p.ServiceCommands.FetchCategory(ctx, categoryId, ""); err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	} else if len(category) == 0 {
+		return xError.XeroUnknownIDError("category")
+	}
+
+	if err := p.ServiceCommands.AddProductCategory(ctx, productId, categoryId); err != nil {
+		return catalogueError(err)
+	}
+
+	return c.JSON(http.StatusOK, categoryId)
+
+}
+
+func (p *ProductsCtl) DeleteProductCategory(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "product_categories.delete", "api")
+	defer span.End()
+
+	productId, categoryId, err := productCategoryParams(c)
+	if err != nil {
+		return err
+	}
+
+	affectedRows, err := p.ServiceCommands.DeleteProductCategory(ctx, productId, categoryId)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+	if affectedRows == 0 {
+		return xError.XeroBadRequestError("category_not_linked", "The product is not in this category")
+	}
+
+	return c.JSON(http.StatusOK, categoryId)
+
+}
+
+// validateCategory checks the body of the category, id is empty for a new category
+// The parent must exist and must not be the category itself or one of its descendants, the slug must be unique
+func (p *ProductsCtl) validateCategory(c echo.Context, id string, category *models.Category) error {
+
+	if err := category.Validate(); err != nil {
+		return xError.XeroValidationError(err)
+	}
+
+	all, err := p.ServiceCommands.FetchCategories(c.Request().Context())
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
+	if category.ParentID != "" {
+		if _, ok := models.CategorySubtree(all, category.ParentID); !ok {
+			return xError.XeroValidationError(xError.NewFieldError("/ParentId", xError.FieldInvalid, "No category with this id"))
+		}
+		if id != "" {
+			for _, descendant := range models.CategoryDescendants(all, id) {
+				if strings.EqualFold(descendant, category.ParentID) {
+					return xError.XeroValidationError(xError.NewFieldError("/ParentId", xError.FieldInvalid, "A category cannot be moved under itself or one of its descendants"))
+				}
+			}
+		}
+	}
+
+	for _, v := range all {
+		if v.DBSlug.String == category.Slug && !strings.EqualFold(v.DBID.String, id) {
+			return xError.XeroConflictError("slug_taken", "Another category has the slug "+category.Slug)
+		}
+	}
+
+	return nil
+}
+
+// Returns the product id and the category id of the url
+func productCategoryParams(c echo.Context) (string, string, error) {
+
+	productId := c.Param("id")
+	if !xeroHelper.ValidateUUID(productId) {
+		return "", "", xError.XeroInvalidIDError("product")
+	}
+	categoryId := c.Param("categoryId")
+	if !xeroHelper.ValidateUUID(categoryId) {
+		return "", "", xError.XeroInvalidIDError("category")
+	}
+
+	return productId, categoryId, nil
+}
+
+// categoryFilter restricts the listing to the products of the category of the param category, a slug
+// With includeDescendants=true the products of the whole subtree of the category are listed
+func (p *ProductsCtl) categoryFilter(c echo.Context, filter *models.ProductFilter) error {
+
+	slug := strings.TrimSpace(c.QueryParam("category"))
+	if slug == "" {
+		return nil
+	}
+
+	includeDescendants := false
+	if v := c.QueryParam("includeDescendants"); v != "" {
+		var err error
+		if includeDescendants, err = strconv.ParseBool(v); err != nil {
+			return xError.XeroBadRequestError("invalid_filter", "includeDescendants must be true or false")
+		}
+	}
+
+	all, err := p.ServiceCommands.FetchCategories(c.Request().Context())
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+	for _, v := range all {
+		if v.DBSlug.String == strings.ToLower(slug) {
+			filter.CategoryIDs = []string{v.DBID.String}
+			if includeDescendants {
+				filter.CategoryIDs = models.CategoryDescendants(all, v.DBID.String)
+			}
+			return nil
+		}
+	}
+
+	return xError.XeroBadRequestError("unknown_category", "No category with the slug "+slug)
+}
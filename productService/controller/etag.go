@@ -0,0 +1,128 @@
+package ctls
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+
+	productServiceCmds "github.com/techievee/xero/productService/commands"
+	xError "github.com/techievee/xero/xeroErrors"
+)
+
+// The ETag of a product or of an option is its version, the version changes with every change of the representation
+// The tags are strong, the product shown in another currency than its base one also carries the currency in its tag
+const (
+	headerETag        = "ETag"
+	headerIfMatch     = "If-Match"
+	headerIfNoneMatch = "If-None-Match"
+)
+
+// entityTag returns the strong tag of the version, with the variant of the representation when there is one
+func entityTag(version int64, variant string) string {
+	tag := strconv.FormatInt(version, 10)
+	if variant != "" {
+		tag += "-" + variant
+	}
+	return `"` + tag + `"`
+}
+
+// notModified tells if the If-None-Match of the request matches the tag, the comparison is weak as for all the GET
+func notModified(c echo.Context, tag string) bool {
+
+	for _, t := range headerTags(c, headerIfNoneMatch) {
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion returns the version the change of the request is based on, nil without If-Match or with If-Match *
+// The tags are compared strongly to the current version whatever their variant, the change is refused when none of them matches
+// current is only read when there are tags to compare, it does not find the resource when it does not exist
+func ifMatchVersion(c echo.Context, resourceType string, current func() (int64, bool, error)) (*int64, error) {
+
+	tags := headerTags(c, headerIfMatch)
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	for _, t := range tags {
+		if t == "*" {
+			return nil, nil
+		}
+	}
+
+	version, found, err := current()
+	if err != nil {
+		return nil, xError.NewUnexpectedGenericError(err)
+	}
+	if !found {
+		// The change finds nothing either and answers with the unknown id
+		return nil, nil
+	}
+	for _, t := range tags {
+		if tagVersion(t) == strconv.FormatInt(version, 10) {
+			return &version, nil
+		}
+	}
+
+	return nil, versionMismatch(resourceType)
+}
+
+// Returns the current version of the product, not found when it does not exist or is in the trash
+func (p *ProductsCtl) productVersion(ctx context.Context, productId string) (int64, bool, error) {
+
+	result, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId)
+	if err != nil || len(result) == 0 {
+		return 0, false, err
+	}
+	return result[0].DBVersion.Int64, true, nil
+}
+
+// Returns the current version of the option of the product, not found when it does not exist or is in the trash
+func (p *ProductsCtl) optionVersion(ctx context.Context, productId string, productOptionId string) (int64, bool, error) {
+
+	result, err := p.ServiceCommands.FetchAllProductOptions(ctx, productId, productOptionId)
+	if err != nil || len(result) == 0 {
+		return 0, false, err
+	}
+	return result[0].DBVersion.Int64, true, nil
+}
+
+// Converts the refusal of a change based on an outdated version to its response, the other errors are unexpected
+func versionError(err error, resourceType string) error {
+	if err == productServiceCmds.ErrVersionMismatch {
+		return versionMismatch(resourceType)
+	}
+	return xError.NewUnexpectedGenericError(err)
+}
+
+func versionMismatch(resourceType string) error {
+	return xError.XeroPreconditionFailedError("precondition_failed",
+		"The "+strings.Replace(resourceType, "_", " ", -1)+" was changed since the version of If-Match")
+}
+
+// Returns the tags listed in the header of the request, the weak ones keep their W/ prefix
+func headerTags(c echo.Context, header string) []string {
+
+	var tags []string
+	for _, value := range c.Request().Header[header] {
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tags = append(tags, t)
+			}
+		}
+	}
+	return tags
+}
+
+// Returns the version of the strong tag, empty for a weak or a malformed tag so that it never matches
+func tagVersion(tag string) string {
+
+	if len(tag) < 2 || !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) {
+		return ""
+	}
+	return strings.SplitN(strings.Trim(tag, `"`), "-", 2)[0]
+}
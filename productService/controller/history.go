@@ -0,0 +1,66 @@
+package ctls
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"go.elastic.co/apm"
+
+	"github.com/techievee/xero/productService/models"
+	xError "github.com/techievee/xero/xeroErrors"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// Query parameters accepted by the history, it is ordered by the time of the changes so it is paged with the offset only
+var productHistoryParams = map[string]bool{
+	"limit":  true,
+	"offset": true,
+}
+
+// ShowProductHistory lists the changes of the product, the last change first
+// The history is kept when the product is in the trash and after it is purged
+func (p *ProductsCtl) ShowProductHistory(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "product_history.show", "api")
+	defer span.End()
+
+	productId := c.Param("id")
+	if !xeroHelper.ValidateUUID(productId) {
+		return xError.XeroInvalidIDError("product")
+	}
+
+	for param := range c.QueryParams() {
+		if !productHistoryParams[param] {
+			return xError.XeroBadRequestError("unknown_field", models.UnknownFieldError{Field: param})
+		}
+	}
+
+	page, err := parsePageRequest(c)
+	if err != nil {
+		return xError.XeroBadRequestError("invalid_page", err)
+	}
+
+	result, total, err := p.ServiceCommands.FetchProductHistory(ctx, productId, page.Limit, page.Offset)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
+	// The products changed before the history was recorded have no entry yet
+	if total == 0 {
+		if product, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId); err != nil {
+			return xError.NewUnexpectedGenericError(err)
+		} else if len(product) == 0 {
+			return xError.XeroUnknownIDError("product")
+		}
+	}
+
+	items := make([]models.AuditEntry, 0, len(result))
+	for _, v := range result {
+		items = append(items, models.NewAuditEntry(v))
+	}
+
+	return c.JSON(http.StatusOK, models.AuditLog{Items: &items, Total: total})
+
+}
@@ -0,0 +1,96 @@
+package ctls
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+
+	"github.com/techievee/xero/productService/models"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// Parse the limit, offset and cursor query parameters of the listing endpoints
+func parsePageRequest(c echo.Context) (models.PageRequest, error) {
+
+	page := models.PageRequest{Limit: defaultPageLimit}
+
+	if v := strings.TrimSpace(c.QueryParam("limit")); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxPageLimit {
+			return page, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageLimit))
+		}
+		page.Limit = limit
+	}
+
+	if v := strings.TrimSpace(c.QueryParam("offset")); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return page, errors.New("offset must be a positive number")
+		}
+		page.Offset = offset
+	}
+
+	if v := strings.TrimSpace(c.QueryParam("cursor")); v != "" {
+		if page.Offset != 0 {
+			return page, errors.New("cursor and offset cannot be used together")
+		}
+		cursor, err := models.DecodeCursor(v)
+		if err != nil {
+			return page, err
+		}
+		page.Cursor = cursor
+	}
+
+	return page, nil
+}
+
+// Check that the cursor was issued for the same sort order of the listing
+func validateCursor(cursor *models.Cursor, sort []models.SortField) error {
+
+	if cursor == nil {
+		return nil
+	}
+
+	if cursor.Sort != models.SortKey(sort) || len(cursor.Values) != len(sort) {
+		return errors.New("cursor does not match the sort order")
+	}
+
+	return nil
+}
+
+// Returns the cursor positioned at the specified row values
+func nextPageCursor(sort []models.SortField, values []interface{}, id string) string {
+	return (&models.Cursor{Sort: models.SortKey(sort), Values: values, ID: id}).Encode()
+}
+
+// Build the navigation links of the page from the current request url
+func pageLinks(c echo.Context, nextCursor string) *models.PageLinks {
+
+	link := func(set map[string]string) string {
+		u := *c.Request().URL
+		q := u.Query()
+		q.Del("offset")
+		q.Del("cursor")
+		for k, v := range set {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+		return u.RequestURI()
+	}
+
+	links := &models.PageLinks{
+		Self:  c.Request().URL.RequestURI(),
+		First: link(nil),
+	}
+	if nextCursor != "" {
+		links.Next = link(map[string]string{"cursor": nextCursor})
+	}
+
+	return links
+}
@@ -0,0 +1,271 @@
+package ctls
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"go.elastic.co/apm"
+
+	productServiceCmds "github.com/techievee/xero/productService/commands"
+	"github.com/techievee/xero/productService/models"
+	xError "github.com/techievee/xero/xeroErrors"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// The media types of the PATCH bodies, a body sent as plain JSON is a merge patch
+const (
+	mimeMergePatch = "application/merge-patch+json"
+	mimeJSONPatch  = "application/json-patch+json"
+)
+
+// Number of times a patch is applied to a fresh read when the resource keeps being changed by the other requests
+const patchRetries = 3
+
+// PatchProduct applies an RFC 7396 merge patch or an RFC 6902 JSON patch to the product
+// The patch applies to the product as ShowProduct returns it in its base currency, the patched product is validated as a whole
+// Only the columns of the fields changed by the patch are updated
+func (p *ProductsCtl) PatchProduct(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "product.patch", "api")
+	defer span.End()
+
+	productId := c.Param("id")
+	if !xeroHelper.ValidateUUID(productId) {
+		return xError.XeroInvalidIDError("product")
+	}
+
+	patch, err := readPatch(c)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+
+		result, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId)
+		if err != nil {
+			return xError.NewUnexpectedGenericError(err)
+		}
+		if len(result) == 0 {
+			return xError.XeroUnknownIDError("product")
+		}
+		current := models.NewProduct(result[0])
+		version := result[0].DBVersion.Int64
+
+		// With If-Match, the patch only applies to the version the client has
+		if _, err := ifMatchVersion(c, "product", func() (int64, bool, error) { return version, true, nil }); err != nil {
+			return err
+		}
+
+		product := models.Product{}
+		if err := patch.apply(current, &product); err != nil {
+			return err
+		}
+		if product.ID != current.ID {
+			return xError.XeroValidationError(xError.NewFieldError("/Id", xError.FieldNotAllowed, "Id cannot be changed"))
+		}
+		if product.Options != nil {
+			return xError.XeroValidationError(xError.NewFieldError("/Options", xError.FieldNotAllowed, "Options can only be set on creation"))
+		}
+		if product.DeletedAt != nil {
+			return xError.XeroValidationError(xError.NewFieldError("/DeletedAt", xError.FieldNotAllowed, "DeletedAt is set by the deletion"))
+		}
+
+		// A patch removing the currency keeps the currency of the product, same as a PUT without currency
+		if product.Currency == "" {
+			product.Currency = current.Currency
+		}
+		if err := product.Validate(); err != nil {
+			return xError.XeroValidationError(err)
+		}
+
+		fields := product.ChangedFields(current)
+		if err := p.authorizeChanges(ctx, "product", fields); err != nil {
+			return err
+		}
+
+		// The version read guards the update, a change made in between is patched again
+		affectedRows, err := p.ServiceCommands.PatchProduct(ctx, productId, product, fields, &version)
+		if err == productServiceCmds.ErrVersionMismatch && !patch.conditional && attempt < patchRetries {
+			continue
+		}
+		if err != nil {
+			return patch.error(err, "product")
+		}
+		if affectedRows == 0 {
+			return xError.XeroUnknownIDError("product")
+		}
+
+		return c.JSON(http.StatusOK, productId)
+	}
+
+}
+
+// PatchProductOption applies an RFC 7396 merge patch or an RFC 6902 JSON patch to the option of the product
+// The patch applies to the option as ShowProductOption returns it, only the columns of the fields changed are updated
+func (p *ProductsCtl) PatchProductOption(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "product_option.patch", "api")
+	defer span.End()
+
+	productId := c.Param("id")
+	if !xeroHelper.ValidateUUID(productId) {
+		return xError.XeroInvalidIDError("product")
+	}
+	// Check for existence of Product
+	if product, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId); err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	} else if len(product) == 0 {
+		return xError.XeroUnknownIDError("product")
+	}
+
+	productOptionId := c.Param("optionId")
+	if !xeroHelper.ValidateUUID(productOptionId) {
+		return xError.XeroInvalidIDError("product_option")
+	}
+
+	patch, err := readPatch(c)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+
+		result, err := p.ServiceCommands.FetchAllProductOptions(ctx, productId, productOptionId)
+		if err != nil {
+			return xError.NewUnexpectedGenericError(err)
+		}
+		if len(result) == 0 {
+			return xError.XeroUnknownIDError("product_option")
+		}
+		current := models.NewProductOption(result[0])
+		version := result[0].DBVersion.Int64
+
+		// With If-Match, the patch only applies to the version the client has
+		if _, err := ifMatchVersion(c, "product_option", func() (int64, bool, error) { return version, true, nil }); err != nil {
+			return err
+		}
+
+		productOption := models.ProductOption{}
+		if err := patch.apply(current, &productOption); err != nil {
+			return err
+		}
+		if productOption.ID != current.ID {
+			return xError.XeroValidationError(xError.NewFieldError("/Id", xError.FieldNotAllowed, "Id cannot be changed"))
+		}
+		if productOption.DeletedAt != nil {
+			return xError.XeroValidationError(xError.NewFieldError("/DeletedAt", xError.FieldNotAllowed, "DeletedAt is set by the deletion"))
+		}
+		if err := productOption.Validate(); err != nil {
+			return xError.XeroValidationError(err)
+		}
+
+		fields := productOption.ChangedFields(current)
+		if err := p.authorizeChanges(ctx, "product_option", fields); err != nil {
+			return err
+		}
+
+		affectedRows, err := p.ServiceCommands.PatchProductOption(ctx, productId, productOptionId, productOption, fields, &version)
+		if err == productServiceCmds.ErrVersionMismatch && !patch.conditional && attempt < patchRetries {
+			continue
+		}
+		if err != nil {
+			return patch.error(err, "product_option")
+		}
+		if affectedRows == 0 {
+			return xError.XeroUnknownIDError("product_option")
+		}
+
+		return c.JSON(http.StatusOK, productOptionId)
+	}
+
+}
+
+// patchBody is the patch sent in the body of a PATCH request
+type patchBody struct {
+	mediaType  string
+	body       []byte
+	operations jsonpatch.Patch
+	// The request has an If-Match, the patch is not applied again to a version the client has not seen
+	conditional bool
+}
+
+// readPatch reads the patch of the request, the type of the patch is the content type of the request
+func readPatch(c echo.Context) (patchBody, error) {
+
+	mediaType, _, err := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if err != nil {
+		mediaType = ""
+	}
+
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return patchBody{}, xError.XeroInvalidRequestError(err)
+	}
+	patch := patchBody{mediaType: mediaType, body: body, conditional: len(headerTags(c, headerIfMatch)) > 0}
+
+	switch mediaType {
+	case mimeMergePatch, echo.MIMEApplicationJSON:
+		// A merge patch of a resource is an object, any other value would replace the whole resource
+		fields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return patchBody{}, xError.XeroInvalidRequestError(err)
+		}
+	case mimeJSONPatch:
+		if patch.operations, err = jsonpatch.DecodePatch(body); err != nil {
+			return patchBody{}, xError.XeroInvalidRequestError(err)
+		}
+	default:
+		return patchBody{}, xError.XeroUnsupportedMediaTypeError("unsupported_patch",
+			"The patch must be sent as "+mimeMergePatch+" or "+mimeJSONPatch)
+	}
+
+	return patch, nil
+}
+
+// apply applies the patch to the JSON of the resource and decodes the result in patched
+func (b patchBody) apply(resource interface{}, patched interface{}) error {
+
+	document, err := json.Marshal(resource)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
+	var result []byte
+	if b.mediaType == mimeJSONPatch {
+		result, err = b.operations.Apply(document)
+	} else {
+		result, err = jsonpatch.MergePatch(document, b.body)
+	}
+	if errors.Cause(err) == jsonpatch.ErrTestFailed {
+		return xError.XeroConflictError("patch_test_failed", err.Error())
+	}
+	if err != nil {
+		return xError.XeroInvalidRequestError(err.Error())
+	}
+
+	if err := json.Unmarshal(result, patched); err != nil {
+		return xError.XeroInvalidRequestError(err)
+	}
+	return nil
+}
+
+// Converts the refusal of the patched resource to its response
+// Without If-Match, the resource changed at every try, the client can send the patch again
+func (b patchBody) error(err error, resourceType string) error {
+
+	if err == productServiceCmds.ErrVersionMismatch && !b.conditional {
+		e := xError.XeroConflictError("patch_conflict", "The "+resourceType+" kept changing while the patch was applied")
+		e.Status = xError.Retry
+		return e
+	}
+	return versionError(err, resourceType)
+}
@@ -2,10 +2,25 @@ package ctls
 
 import (
 	productServiceCmds "github.com/techievee/xero/productService/commands"
+	"github.com/techievee/xero/productService/models"
 	"github.com/techievee/xero/xeroLog/debugcore"
 )
 
+// ProductsCtl serves the product endpoints, it only depends on the storage interface
+// so the catalogue can be backed by the database or kept in memory
 type ProductsCtl struct {
-	ServiceCommands *productServiceCmds.ProductsCmds
+	ServiceCommands productServiceCmds.Repository
 	Logger          debugcore.Logger
+	// Currency of the listings and of the new products when none is specified, models.DefaultCurrency when empty
+	DefaultCurrency string
+	// Operations of a best effort batch applied per transaction, and operations allowed in a batch, the defaults when 0
+	BatchChunkSize     int
+	BatchMaxOperations int
+}
+
+func (p *ProductsCtl) defaultCurrency() string {
+	if p.DefaultCurrency == "" {
+		return models.DefaultCurrency
+	}
+	return p.DefaultCurrency
 }
@@ -24,18 +24,27 @@ func (p *ProductsCtl) ShowProductOptions(c echo.Context) error {
 	productId := c.Param("id")
 	if strings.Trim(productId, " ") == "" || xeroHelper.ValidateUUID(productId) == false {
 		// Return 400, Bad request
-		return c.JSON(http.StatusBadRequest, "Invalid Request : Valid Product Id required")
+		return xError.XeroInvalidIDError("product")
 	}
 
 	// Check for existence of Product
 	if product, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId); err != nil {
 		return xError.NewUnexpectedGenericError(err)
 	} else if len(product) == 0 {
-		return c.JSON(http.StatusBadRequest, "Invalid Request : Valid Product Id required")
+		return xError.XeroUnknownIDError("product")
+	}
+
+	// Look for the paging params
+	page, err := parsePageRequest(c)
+	if err == nil {
+		err = validateCursor(page.Cursor, models.ProductOptionSort)
+	}
+	if err != nil {
+		return xError.XeroBadRequestError("invalid_page", err)
 	}
 
 	items := []models.ProductOption{}
-	result, err := p.ServiceCommands.FetchAllProductOptions(ctx, productId, "")
+	result, total, err := p.ServiceCommands.FetchProductOptionsPage(ctx, productId, page)
 	if err != nil {
 		if err != sql.ErrNoRows {
 			// Return 500, Server Error
@@ -43,6 +52,14 @@ func (p *ProductsCtl) ShowProductOptions(c echo.Context) error {
 		}
 	}
 
+	// The extra row fetched over the limit only tells that there is a next page
+	nextCursor := ""
+	if len(result) > page.Limit {
+		result = result[:page.Limit]
+		last := result[len(result)-1]
+		nextCursor = nextPageCursor(models.ProductOptionSort, []interface{}{last.DBName.String}, last.DBID.String)
+	}
+
 	if len(result) > 0 {
 		for _, v := range result {
 			item := models.ProductOption{}
@@ -66,7 +83,10 @@ func (p *ProductsCtl) ShowProductOptions(c echo.Context) error {
 	}
 
 	resultProductOptions := models.ProductOptions{
-		Items: &items,
+		Items:      &items,
+		Total:      total,
+		NextCursor: nextCursor,
+		Links:      pageLinks(c, nextCursor),
 	}
 
 	// Return 200
@@ -85,20 +105,20 @@ func (p *ProductsCtl) ShowProductOption(c echo.Context) error {
 	productId := c.Param("id")
 	if strings.Trim(productId, " ") == "" || xeroHelper.ValidateUUID(productId) == false {
 		// Return 400, Bad request
-		return c.JSON(http.StatusBadRequest, "Invalid Request : Valid Product Id required")
+		return xError.XeroInvalidIDError("product")
 	}
 
 	// Check for existence of Product
 	if product, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId); err != nil {
 		return xError.NewUnexpectedGenericError(err)
 	} else if len(product) == 0 {
-		return c.JSON(http.StatusBadRequest, "Invalid Request : Valid Product Id required")
+		return xError.XeroUnknownIDError("product")
 	}
 
 	productOptionId := c.Param("optionId")
 	if xeroHelper.ValidateUUID(productOptionId) == false {
 		// Return 400, Bad request
-		return c.JSON(http.StatusBadRequest, "Invalid Request Format")
+		return xError.XeroInvalidIDError("product_option")
 	}
 
 	items := []models.ProductOption{}
@@ -112,7 +132,7 @@ func (p *ProductsCtl) ShowProductOption(c echo.Context) error {
 
 	if len(result) == 0 {
 		// Return 400, Bad request
-		return c.JSON(http.StatusBadRequest, "Invalid option Id")
+		return xError.XeroUnknownIDError("product_option")
 	}
 	productOption := models.ProductOption{}
 	for _, v := range result {
@@ -131,6 +151,12 @@ func (p *ProductsCtl) ShowProductOption(c echo.Context) error {
 
 	}
 
+	tag := entityTag(result[0].DBVersion.Int64, "")
+	c.Response().Header().Set(headerETag, tag)
+	if notModified(c, tag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
 	// Return 200
 	return c.JSON(http.StatusOK, productOption)
 
@@ -146,31 +172,31 @@ func (p *ProductsCtl) AddNewProductOption(c echo.Context) error {
 	productId := c.Param("id")
 	if strings.Trim(productId, " ") == "" || xeroHelper.ValidateUUID(productId) == false {
 		// Return 400, Bad request
-		return c.JSON(http.StatusBadRequest, "Invalid Request : Valid Product Id required")
+		return xError.XeroInvalidIDError("product")
 	}
 
 	// Check for existence of Product
 	if product, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId); err != nil {
 		return xError.NewUnexpectedGenericError(err)
 	} else if len(product) == 0 {
-		return c.JSON(http.StatusBadRequest, "Invalid Request : Valid Product Id required")
+		return xError.XeroUnknownIDError("product")
 	}
 
 	// Parse the productOption from the post body
 	productOption := models.ProductOption{}
 	if err := c.Bind(&productOption); err != nil {
-		return c.JSON(http.StatusBadRequest, "Invalid Request Format")
+		return xError.XeroInvalidRequestError(err)
 	}
 
 	// Validate the format of the productOption json
 	if err := productOption.Validate(); err != nil {
-		return c.JSON(http.StatusBadRequest, "Invalid Request Format :"+err.Error())
+		return xError.XeroValidationError(err)
 	}
 
 	// Validate the name
 	id, err := p.ServiceCommands.AddNewProductOption(ctx, productId, productOption)
 	if err != nil {
-		return xError.NewUnexpectedGenericError(err)
+		return catalogueError(err)
 	}
 
 	// Return 200 with Newly created ID
@@ -187,40 +213,53 @@ func (p *ProductsCtl) UpdateProductOption(c echo.Context) error {
 
 	productId := c.Param("id")
 	if !xeroHelper.ValidateUUID(productId) {
-		return c.JSON(http.StatusBadRequest, "Invalid product id")
+		return xError.XeroInvalidIDError("product")
 	}
 	// Check for existence of Product
 	if product, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId); err != nil {
 		return xError.NewUnexpectedGenericError(err)
 	} else if len(product) == 0 {
-		return c.JSON(http.StatusBadRequest, "Invalid Request : Valid Product Id required")
+		return xError.XeroUnknownIDError("product")
 	}
 
 	productOptionId := c.Param("optionId")
 	if xeroHelper.ValidateUUID(productOptionId) == false {
 		// Return 400, Bad request
-		return c.JSON(http.StatusBadRequest, "Invalid Request Format")
+		return xError.XeroInvalidIDError("product_option")
 	}
 
 	// Parse the productOption of the post parameter
 	productOption := models.ProductOption{}
 	if err := c.Bind(&productOption); err != nil {
-		return c.JSON(http.StatusBadRequest, "Invalid Request Format")
+		return xError.XeroInvalidRequestError(err)
 	}
 
 	// Validate the format of the productOption json
 	if err := productOption.Validate(); err != nil {
-		return c.JSON(http.StatusBadRequest, "Invalid Request Format"+err.Error())
+		return xError.XeroValidationError(err)
+	}
+
+	// With If-Match, the option must not have changed since the version the client has
+	version, err := ifMatchVersion(c, "product_option", func() (int64, bool, error) {
+		return p.optionVersion(ctx, productId, productOptionId)
+	})
+	if err != nil {
+		return err
+	}
+
+	// The fields the caller may not change must keep their value
+	if version, err = p.authorizeOptionUpdate(ctx, productId, productOptionId, productOption, version); err != nil {
+		return err
 	}
 
 	// Validate the name
-	affectedRows, err := p.ServiceCommands.UpdateProductOption(ctx, productId, productOptionId, productOption)
+	affectedRows, err := p.ServiceCommands.UpdateProductOption(ctx, productId, productOptionId, productOption, version)
 	if err != nil {
-		// Returns 500, Server error
-		return xError.NewUnexpectedGenericError(err)
+		// Returns 412 when the option changed in between, 500 otherwise
+		return versionError(err, "product_option")
 	}
 	if affectedRows == 0 {
-		return c.JSON(http.StatusBadRequest, "Invalid product Option id")
+		return xError.XeroUnknownIDError("product_option")
 	}
 
 	return c.JSON(http.StatusOK, productOptionId)
@@ -236,29 +275,37 @@ func (p *ProductsCtl) DeleteProductOption(c echo.Context) error {
 
 	productId := c.Param("id")
 	if !xeroHelper.ValidateUUID(productId) {
-		return c.JSON(http.StatusBadRequest, "Invalid productOption id")
+		return xError.XeroInvalidIDError("product")
 	}
 	// Check for existence of Product
 	if product, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId); err != nil {
 		return xError.NewUnexpectedGenericError(err)
 	} else if len(product) == 0 {
-		return c.JSON(http.StatusBadRequest, "Invalid Request : Valid Product Id required")
+		return xError.XeroUnknownIDError("product")
 	}
 
 	productOptionId := c.Param("optionId")
 	if xeroHelper.ValidateUUID(productOptionId) == false {
 		// Return 400, Bad request
-		return c.JSON(http.StatusBadRequest, "Invalid Request Format")
+		return xError.XeroInvalidIDError("product_option")
+	}
+
+	// With If-Match, the option must not have changed since the version the client has
+	version, err := ifMatchVersion(c, "product_option", func() (int64, bool, error) {
+		return p.optionVersion(ctx, productId, productOptionId)
+	})
+	if err != nil {
+		return err
 	}
 
 	// Validate the name
-	affectedRows, err := p.ServiceCommands.DeleteProductOption(ctx, productId, productOptionId)
+	affectedRows, err := p.ServiceCommands.DeleteProductOption(ctx, productId, productOptionId, version)
 	if err != nil {
-		// Returns 500, Server error
-		return xError.NewUnexpectedGenericError(err)
+		// Returns 412 when the option changed in between, 500 otherwise
+		return versionError(err, "product_option")
 	}
 	if affectedRows == 0 {
-		return c.JSON(http.StatusBadRequest, "Invalid product option id")
+		return xError.XeroUnknownIDError("product_option")
 	}
 
 	return c.JSON(http.StatusOK, productOptionId)
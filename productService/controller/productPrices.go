@@ -0,0 +1,181 @@
+package ctls
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+	"go.elastic.co/apm"
+
+	"github.com/techievee/xero/productService/models"
+	xError "github.com/techievee/xero/xeroErrors"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+func (p *ProductsCtl) ShowProductPrices(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "product_prices.show", "api")
+	defer span.End()
+
+	productId := c.Param("id")
+	if !xeroHelper.ValidateUUID(productId) {
+		return xError.XeroInvalidIDError("product")
+	}
+
+	// Check for existence of Product, its price in the base currency comes first
+	product, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	} else if len(product) == 0 {
+		return xError.XeroUnknownIDError("product")
+	}
+	base := baseProductPrice(product[0])
+
+	result, err := p.ServiceCommands.FetchProductPrices(ctx, productId, "")
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
+	items := []models.ProductPrice{base}
+	for _, v := range result {
+		if v.DBCurrency.String != base.Currency {
+			items = append(items, models.NewProductPrice(v))
+		}
+	}
+
+	// Return 200
+	return c.JSON(http.StatusOK, models.ProductPrices{BaseCurrency: base.Currency, Items: &items})
+
+}
+
+func (p *ProductsCtl) SetProductPrice(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "product_prices.set", "api")
+	defer span.End()
+
+	productId := c.Param("id")
+	if !xeroHelper.ValidateUUID(productId) {
+		return xError.XeroInvalidIDError("product")
+	}
+
+	currency, err := currencyParam(c.Param("currency"))
+	if err != nil {
+		return err
+	}
+
+	// Check for existence of Product
+	product, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	} else if len(product) == 0 {
+		return xError.XeroUnknownIDError("product")
+	}
+
+	// The base price is part of the product, it is only changed by updating the product
+	if currency == baseProductPrice(product[0]).Currency {
+		return xError.XeroBadRequestError("base_currency", "The price in the base currency "+currency+" is updated with the product")
+	}
+
+	price := models.ProductPrice{}
+	if err := c.Bind(&price); err != nil {
+		return xError.XeroInvalidRequestError(err)
+	}
+	if err := price.Validate(); err != nil {
+		return xError.XeroValidationError(err)
+	}
+	price.Currency = currency
+	price.Price.Currency, price.DeliveryPrice.Currency = currency, currency
+
+	if err := p.ServiceCommands.SetProductPrice(ctx, productId, price); err != nil {
+		return catalogueError(err)
+	}
+
+	return c.JSON(http.StatusOK, price)
+
+}
+
+func (p *ProductsCtl) DeleteProductPrice(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "product_prices.delete", "api")
+	defer span.End()
+
+	productId := c.Param("id")
+	if !xeroHelper.ValidateUUID(productId) {
+		return xError.XeroInvalidIDError("product")
+	}
+
+	currency, err := currencyParam(c.Param("currency"))
+	if err != nil {
+		return err
+	}
+
+	affectedRows, err := p.ServiceCommands.DeleteProductPrice(ctx, productId, currency)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+	if affectedRows == 0 {
+		return xError.XeroBadRequestError("unknown_currency", "No price in "+currency+" for this product")
+	}
+
+	return c.JSON(http.StatusOK, currency)
+
+}
+
+// Parses the ISO 4217 code of a currency, the lower case codes are accepted
+func currencyParam(s string) (string, error) {
+	currency := strings.ToUpper(strings.TrimSpace(s))
+	if !models.IsValidCurrency(currency) {
+		return "", xError.XeroBadRequestError("invalid_currency", "currency must be an ISO 4217 code such as NZD")
+	}
+	return currency, nil
+}
+
+// Returns the price of the product in its base currency
+func baseProductPrice(v models.DBProducts) models.ProductPrice {
+	currency := models.DefaultCurrency
+	if v.DBCurrency.Valid {
+		currency = v.DBCurrency.String
+	}
+	return models.ProductPrice{
+		Currency:      currency,
+		Price:         models.NewMoney(v.DBPrice.Int64, currency),
+		DeliveryPrice: models.NewMoney(v.DBDeliveryPrice.Int64, currency),
+	}
+}
+
+// Replaces the prices of the products by their price in the currency, the products without a price in the currency keep their base price
+func (p *ProductsCtl) priceInCurrency(c echo.Context, items []models.Product, currency string) error {
+
+	var ids []string
+	for _, item := range items {
+		if item.Currency != currency {
+			ids = append(ids, item.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	prices, err := p.ServiceCommands.FetchPricesInCurrency(c.Request().Context(), currency, ids)
+	if err != nil {
+		return err
+	}
+
+	byProduct := map[string]models.ProductPrice{}
+	for _, v := range prices {
+		byProduct[strings.ToLower(v.DBProductID.String)] = models.NewProductPrice(v)
+	}
+	for i := range items {
+		if price, ok := byProduct[strings.ToLower(items[i].ID)]; ok {
+			items[i].Price, items[i].DeliveryPrice, items[i].Currency = price.Price, price.DeliveryPrice, price.Currency
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package ctls
+
+import (
+	"strings"
+
+	"github.com/labstack/echo"
+
+	"github.com/techievee/xero/productService/models"
+	xError "github.com/techievee/xero/xeroErrors"
+)
+
+// Query parameters accepted by the product listing
+var productListingParams = map[string]bool{
+	"name":               true,
+	"exactName":          true,
+	"description":        true,
+	"minPrice":           true,
+	"maxPrice":           true,
+	"maxDeliveryPrice":   true,
+	"sort":               true,
+	"limit":              true,
+	"offset":             true,
+	"cursor":             true,
+	"currency":           true,
+	"category":           true,
+	"includeDescendants": true,
+}
+
+// Parse the filters and the sort order of the product listing
+// Unknown params and sort fields are rejected with a 400, so they are never silently ignored
+func parseProductFilter(c echo.Context) (models.ProductFilter, error) {
+
+	filter := models.ProductFilter{}
+
+	for param := range c.QueryParams() {
+		if !productListingParams[param] {
+			return filter, xError.XeroBadRequestError("unknown_field", models.UnknownFieldError{Field: param})
+		}
+	}
+
+	sort, err := models.ParseProductSort(c.QueryParam("sort"))
+	if err != nil {
+		return filter, xError.XeroBadRequestError("unknown_field", err)
+	}
+	filter.Sort = sort
+
+	filter.Name = strings.TrimSpace(c.QueryParam("name"))
+	filter.ExactName = strings.TrimSpace(c.QueryParam("exactName"))
+	filter.Description = strings.TrimSpace(c.QueryParam("description"))
+
+	prices := map[string]**int64{
+		"minPrice":         &filter.MinPrice,
+		"maxPrice":         &filter.MaxPrice,
+		"maxDeliveryPrice": &filter.MaxDeliveryPrice,
+	}
+	for param, target := range prices {
+		v := strings.TrimSpace(c.QueryParam(param))
+		if v == "" {
+			continue
+		}
+		price, err := models.ParseMoney(v, "")
+		if err != nil || price.Amount < 0 {
+			return filter, xError.XeroBadRequestError("invalid_filter", param+" must be a positive amount with at most 2 decimal places")
+		}
+		*target = &price.Amount
+	}
+
+	return filter, nil
+}
@@ -18,15 +18,46 @@ func (p *ProductsCtl) ShowProducts(c echo.Context) error {
 	span, _ := apm.StartSpan(ctx, "products.show", "api")
 	defer span.End()
 
-	// Look for the name param
-	productName := c.QueryParam("name")
+	// Look for the filter and sort params
+	filter, err := parseProductFilter(c)
+	if err == nil {
+		err = p.categoryFilter(c, &filter)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Look for the paging params
+	page, err := parsePageRequest(c)
+	if err == nil {
+		err = validateCursor(page.Cursor, filter.Sort)
+	}
+	if err != nil {
+		return xError.XeroBadRequestError("invalid_page", err)
+	}
+
+	// The prices are listed in the requested currency, or in the default currency
+	currency := p.defaultCurrency()
+	if c.QueryParam("currency") != "" {
+		if currency, err = currencyParam(c.QueryParam("currency")); err != nil {
+			return err
+		}
+	}
 
 	items := []models.Product{}
-	result, err := p.ServiceCommands.FetchAllProducts(ctx, productName, "")
+	result, total, err := p.ServiceCommands.FetchProductsPage(ctx, filter, page)
 	if err != nil {
 		return xError.NewUnexpectedGenericError(err)
 	}
 
+	// The extra row fetched over the limit only tells that there is a next page
+	nextCursor := ""
+	if len(result) > page.Limit {
+		result = result[:page.Limit]
+		last := result[len(result)-1]
+		nextCursor = nextPageCursor(filter.Sort, last.SortValues(filter.Sort), last.DBID.String)
+	}
+
 	if len(result) > 0 {
 		for _, v := range result {
 			item := models.Product{}
@@ -41,11 +72,15 @@ func (p *ProductsCtl) ShowProducts(c echo.Context) error {
 			if v.DBDescription.Valid {
 				item.Description = v.DBDescription.String
 			}
+			item.Currency = models.DefaultCurrency
+			if v.DBCurrency.Valid {
+				item.Currency = v.DBCurrency.String
+			}
 			if v.DBPrice.Valid {
-				item.Price = v.DBPrice.Float64
+				item.Price = models.NewMoney(v.DBPrice.Int64, item.Currency)
 			}
 			if v.DBDeliveryPrice.Valid {
-				item.DeliveryPrice = v.DBDeliveryPrice.Float64
+				item.DeliveryPrice = models.NewMoney(v.DBDeliveryPrice.Int64, item.Currency)
 			}
 			items = append(items, item)
 
@@ -55,8 +90,15 @@ func (p *ProductsCtl) ShowProducts(c echo.Context) error {
 		items = []models.Product{}
 	}
 
+	if err := p.priceInCurrency(c, items, currency); err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
 	resultProducts := models.Products{
-		Items: &items,
+		Items:      &items,
+		Total:      total,
+		NextCursor: nextCursor,
+		Links:      pageLinks(c, nextCursor),
 	}
 
 	// Return 200
@@ -75,7 +117,7 @@ func (p *ProductsCtl) ShowProduct(c echo.Context) error {
 	productId := c.Param("id")
 	if xeroHelper.ValidateUUID(productId) == false {
 		// Return 400, Bad request
-		return c.JSON(http.StatusBadRequest, "Invalid Request Format")
+		return xError.XeroInvalidIDError("product")
 	}
 
 	result, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId)
@@ -83,10 +125,11 @@ func (p *ProductsCtl) ShowProduct(c echo.Context) error {
 		return xError.NewUnexpectedGenericError(err)
 	}
 	if len(result) == 0 {
-		return c.JSON(http.StatusBadRequest, "Product ID not available")
+		return xError.XeroUnknownIDError("product")
 	}
 
 	product := models.Product{}
+	version := result[0].DBVersion.Int64
 	for _, v := range result {
 
 		// Safely convert the DbTypes to GoTypes
@@ -99,17 +142,49 @@ func (p *ProductsCtl) ShowProduct(c echo.Context) error {
 		if v.DBDescription.Valid {
 			product.Description = v.DBDescription.String
 		}
+		product.Currency = models.DefaultCurrency
+		if v.DBCurrency.Valid {
+			product.Currency = v.DBCurrency.String
+		}
 		if v.DBPrice.Valid {
-			product.Price = v.DBPrice.Float64
+			product.Price = models.NewMoney(v.DBPrice.Int64, product.Currency)
 		}
 		if v.DBDeliveryPrice.Valid {
-			product.DeliveryPrice = v.DBDeliveryPrice.Float64
+			product.DeliveryPrice = models.NewMoney(v.DBDeliveryPrice.Int64, product.Currency)
+		}
+
+	}
+
+	// Without a currency the product is shown in the default currency, or in its base currency when it has no price in it
+	currency := p.defaultCurrency()
+	requested := c.QueryParam("currency") != ""
+	if requested {
+		if currency, err = currencyParam(c.QueryParam("currency")); err != nil {
+			return err
 		}
+	}
+
+	items := []models.Product{product}
+	if err := p.priceInCurrency(c, items, currency); err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+	if requested && items[0].Currency != currency {
+		return xError.XeroBadRequestError("currency_not_available", "No price in "+currency+" for this product")
+	}
 
+	// The product shown in another currency than its base one is another representation, with its own tag
+	variant := ""
+	if items[0].Currency != product.Currency {
+		variant = items[0].Currency
+	}
+	tag := entityTag(version, variant)
+	c.Response().Header().Set(headerETag, tag)
+	if notModified(c, tag) {
+		return c.NoContent(http.StatusNotModified)
 	}
 
 	// Return 200
-	return c.JSON(http.StatusOK, product)
+	return c.JSON(http.StatusOK, items[0])
 
 }
 
@@ -123,18 +198,36 @@ func (p *ProductsCtl) AddNewProduct(c echo.Context) error {
 	// Parse the product from the post body
 	product := models.Product{}
 	if err := c.Bind(&product); err != nil {
-		return c.JSON(http.StatusBadRequest, "Invalid Request Format")
+		return xError.XeroInvalidRequestError(err)
+	}
+
+	if product.Currency == "" {
+		product.Currency = p.defaultCurrency()
 	}
 
 	// Validate the format of the product json
 	if err := product.Validate(); err != nil {
-		return c.JSON(http.StatusBadRequest, "Invalid Request Format"+err.Error())
+		return xError.XeroValidationError(err)
+	}
+	if err := p.authorizeProductCreation(ctx, product); err != nil {
+		return err
+	}
+
+	// With the embedded options, the product and its options are created in one transaction
+	if product.Options != nil {
+		id, optionIDs, err := p.ServiceCommands.AddNewProductWithOptions(ctx, product)
+		if err != nil {
+			return catalogueError(err)
+		}
+
+		// Return 201 with all the newly created IDs
+		return c.JSON(http.StatusCreated, models.CreatedProduct{ID: id, OptionIDs: optionIDs})
 	}
 
 	// Validate the name
 	id, err := p.ServiceCommands.AddNewProduct(ctx, product)
 	if err != nil {
-		return xError.NewUnexpectedGenericError(err)
+		return catalogueError(err)
 	}
 
 	// Return 200 with Newly created ID
@@ -151,28 +244,46 @@ func (p *ProductsCtl) UpdateProduct(c echo.Context) error {
 
 	productId := c.Param("id")
 	if !xeroHelper.ValidateUUID(productId) {
-		return c.JSON(http.StatusBadRequest, "Invalid product id")
+		return xError.XeroInvalidIDError("product")
 	}
 
 	// Parse the product of the post parameter
 	product := models.Product{}
 	if err := c.Bind(&product); err != nil {
-		return c.JSON(http.StatusBadRequest, "Invalid Request Format")
+		return xError.XeroInvalidRequestError(err)
 	}
 
-	// Validate the format of the product json
+	// The options are embedded only on creation, afterwards they have their own endpoints
+	if product.Options != nil {
+		return xError.XeroValidationError(xError.NewFieldError("/Options", xError.FieldNotAllowed, "Options can only be set on creation"))
+	}
+
+	// Validate the format of the product json, without currency the product keeps its currency
 	if err := product.Validate(); err != nil {
-		return c.JSON(http.StatusBadRequest, "Invalid Request Format"+err.Error())
+		return xError.XeroValidationError(err)
+	}
+
+	// With If-Match, the product must not have changed since the version the client has
+	version, err := ifMatchVersion(c, "product", func() (int64, bool, error) {
+		return p.productVersion(ctx, productId)
+	})
+	if err != nil {
+		return err
+	}
+
+	// The fields the caller may not change must keep their value
+	if version, err = p.authorizeProductUpdate(ctx, productId, product, version); err != nil {
+		return err
 	}
 
 	// Validate the name
-	affectedRows, err := p.ServiceCommands.UpdateProduct(ctx, product, productId)
+	affectedRows, err := p.ServiceCommands.UpdateProduct(ctx, product, productId, version)
 	if err != nil {
-		// Returns 500, Server error
-		return xError.NewUnexpectedGenericError(err)
+		// Returns 412 when the product changed in between, 500 otherwise
+		return versionError(err, "product")
 	}
 	if affectedRows == 0 {
-		return c.JSON(http.StatusBadRequest, "Invalid product id")
+		return xError.XeroUnknownIDError("product")
 	}
 
 	return c.JSON(http.StatusOK, productId)
@@ -188,17 +299,25 @@ func (p *ProductsCtl) DeleteProduct(c echo.Context) error {
 
 	productId := c.Param("id")
 	if !xeroHelper.ValidateUUID(productId) {
-		return c.JSON(http.StatusBadRequest, "Invalid product id")
+		return xError.XeroInvalidIDError("product")
+	}
+
+	// With If-Match, the product must not have changed since the version the client has
+	version, err := ifMatchVersion(c, "product", func() (int64, bool, error) {
+		return p.productVersion(ctx, productId)
+	})
+	if err != nil {
+		return err
 	}
 
 	// Validate the name
-	affectedRows, err := p.ServiceCommands.DeleteProduct(ctx, productId)
+	affectedRows, err := p.ServiceCommands.DeleteProduct(ctx, productId, version)
 	if err != nil {
-		// Returns 500, Server error
-		return xError.NewUnexpectedGenericError(err)
+		// Returns 412 when the product changed in between, 500 otherwise
+		return versionError(err, "product")
 	}
 	if affectedRows == 0 {
-		return c.JSON(http.StatusBadRequest, "Invalid product id")
+		return xError.XeroUnknownIDError("product")
 	}
 
 	return c.JSON(http.StatusOK, productId)
@@ -0,0 +1,67 @@
+package ctls
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"go.elastic.co/apm"
+
+	productServiceCmds "github.com/techievee/xero/productService/commands"
+	"github.com/techievee/xero/productService/models"
+	xError "github.com/techievee/xero/xeroErrors"
+)
+
+// Query parameters accepted by the search, the results are ranked so they are paged with the offset only
+var productSearchParams = map[string]bool{
+	"q":      true,
+	"limit":  true,
+	"offset": true,
+}
+
+// SearchProducts returns the products matching the free text of the param q, the best matches first
+func (p *ProductsCtl) SearchProducts(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "products.search", "api")
+	defer span.End()
+
+	for param := range c.QueryParams() {
+		if !productSearchParams[param] {
+			return xError.XeroBadRequestError("unknown_field", models.UnknownFieldError{Field: param})
+		}
+	}
+
+	query, err := models.ParseSearchQuery(c.QueryParam("q"))
+	if err != nil {
+		return xError.XeroBadRequestError("invalid_search", err)
+	}
+
+	page, err := parsePageRequest(c)
+	if err != nil {
+		return xError.XeroBadRequestError("invalid_page", err)
+	}
+
+	result, total, err := p.ServiceCommands.SearchProducts(ctx, query, page.Limit, page.Offset)
+	if err == productServiceCmds.ErrSearchUnavailable {
+		return xError.XeroNotImplementedError("search_unavailable", "The product search is not enabled on this database")
+	} else if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
+	items := []models.ProductSearchResult{}
+	for _, v := range result {
+		item := models.ProductSearchResult{Snippet: v.DBSnippet.String, Score: v.DBScore.Float64}
+		item.ID, item.Name, item.Description = v.DBID.String, v.DBName.String, v.DBDescription.String
+		item.Currency = models.DefaultCurrency
+		if v.DBCurrency.Valid {
+			item.Currency = v.DBCurrency.String
+		}
+		item.Price = models.NewMoney(v.DBPrice.Int64, item.Currency)
+		item.DeliveryPrice = models.NewMoney(v.DBDeliveryPrice.Int64, item.Currency)
+		items = append(items, item)
+	}
+
+	return c.JSON(http.StatusOK, models.ProductSearchResults{Items: &items, Total: total})
+
+}
@@ -0,0 +1,195 @@
+package ctls
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"go.elastic.co/apm"
+
+	productServiceCmds "github.com/techievee/xero/productService/commands"
+	"github.com/techievee/xero/productService/models"
+	xError "github.com/techievee/xero/xeroErrors"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+func (p *ProductsCtl) ShowStock(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "stock.show", "api")
+	defer span.End()
+
+	productId, productOptionId, err := optionParams(c)
+	if err != nil {
+		return err
+	}
+
+	stock, err := p.ServiceCommands.FetchStock(ctx, productId, productOptionId)
+	if err != nil {
+		return stockError(err)
+	}
+
+	return c.JSON(http.StatusOK, models.NewStock(stock))
+
+}
+
+func (p *ProductsCtl) SetStock(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "stock.set", "api")
+	defer span.End()
+
+	productId, productOptionId, err := optionParams(c)
+	if err != nil {
+		return err
+	}
+
+	update := models.StockUpdate{}
+	if err := c.Bind(&update); err != nil {
+		return xError.XeroInvalidRequestError(err)
+	}
+	if err := update.Validate(); err != nil {
+		return xError.XeroValidationError(err)
+	}
+
+	stock, err := p.ServiceCommands.SetStock(ctx, productId, productOptionId, *update.OnHand, update.Version)
+	if err != nil {
+		return stockError(err)
+	}
+
+	return c.JSON(http.StatusOK, models.NewStock(stock))
+
+}
+
+func (p *ProductsCtl) ReserveStock(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "stock.reserve", "api")
+	defer span.End()
+
+	productId, productOptionId, err := optionParams(c)
+	if err != nil {
+		return err
+	}
+
+	request := models.StockReservation{}
+	if err := c.Bind(&request); err != nil {
+		return xError.XeroInvalidRequestError(err)
+	}
+	if err := request.Validate(); err != nil {
+		return xError.XeroValidationError(err)
+	}
+
+	reservation, err := p.ServiceCommands.ReserveStock(ctx, productId, productOptionId, request.Quantity)
+	if err != nil {
+		return stockError(err)
+	}
+
+	// Return 201, created
+	return c.JSON(http.StatusCreated, stockReservation(reservation))
+
+}
+
+func (p *ProductsCtl) CommitStockReservation(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "stock.commit", "api")
+	defer span.End()
+
+	productId, productOptionId, reservationId, err := reservationParams(c)
+	if err != nil {
+		return err
+	}
+
+	reservation, err := p.ServiceCommands.CommitStockReservation(ctx, productId, productOptionId, reservationId)
+	if err != nil {
+		return stockError(err)
+	}
+
+	return c.JSON(http.StatusOK, stockReservation(reservation))
+
+}
+
+func (p *ProductsCtl) ReleaseStockReservation(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "stock.release", "api")
+	defer span.End()
+
+	productId, productOptionId, reservationId, err := reservationParams(c)
+	if err != nil {
+		return err
+	}
+
+	reservation, err := p.ServiceCommands.ReleaseStockReservation(ctx, productId, productOptionId, reservationId)
+	if err != nil {
+		return stockError(err)
+	}
+
+	return c.JSON(http.StatusOK, stockReservation(reservation))
+
+}
+
+// Returns the product id and the option id of the url
+func optionParams(c echo.Context) (string, string, error) {
+
+	productId := c.Param("id")
+	if !xeroHelper.ValidateUUID(productId) {
+		return "", "", xError.XeroInvalidIDError("product")
+	}
+	productOptionId := c.Param("optionId")
+	if !xeroHelper.ValidateUUID(productOptionId) {
+		return "", "", xError.XeroInvalidIDError("product_option")
+	}
+
+	return productId, productOptionId, nil
+}
+
+// Returns the product id, the option id and the reservation id of the url
+func reservationParams(c echo.Context) (string, string, string, error) {
+
+	productId, productOptionId, err := optionParams(c)
+	if err != nil {
+		return "", "", "", err
+	}
+	reservationId := c.Param("reservationId")
+	if !xeroHelper.ValidateUUID(reservationId) {
+		return "", "", "", xError.XeroInvalidIDError("stock_reservation")
+	}
+
+	return productId, productOptionId, reservationId, nil
+}
+
+// Converts the refusals of the stock commands to their response, the other errors are unexpected
+func stockError(err error) error {
+
+	switch err {
+	case productServiceCmds.ErrUnknownProductOption:
+		return xError.XeroUnknownIDError("product_option")
+	case productServiceCmds.ErrUnknownReservation:
+		return xError.XeroUnknownIDError("stock_reservation")
+	case productServiceCmds.ErrReservationClosed:
+		return xError.XeroConflictError("reservation_closed", "The reservation is already committed or released")
+	case productServiceCmds.ErrInsufficientStock:
+		return xError.XeroConflictError("insufficient_stock", "Not enough stock is available")
+	case productServiceCmds.ErrStockConflict:
+		// Reading the stock again gives the version to retry with
+		e := xError.XeroConflictError("stock_conflict", "The stock was changed by another request")
+		e.Status = xError.Retry
+		return e
+	}
+
+	return xError.NewUnexpectedGenericError(err)
+}
+
+func stockReservation(v models.DBStockReservations) models.StockReservation {
+	return models.StockReservation{
+		ID:       v.DBID.String,
+		Quantity: v.DBQuantity.Int64,
+		Status:   v.DBStatus.String,
+	}
+}
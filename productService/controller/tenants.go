@@ -0,0 +1,183 @@
+package ctls
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+	"go.elastic.co/apm"
+
+	productServiceCmds "github.com/techievee/xero/productService/commands"
+	"github.com/techievee/xero/productService/models"
+	xError "github.com/techievee/xero/xeroErrors"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+func (p *ProductsCtl) ShowTenants(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "tenants.show", "api")
+	defer span.End()
+
+	result, err := p.ServiceCommands.FetchTenants(ctx)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
+	items := make([]models.Tenant, 0, len(result))
+	for _, v := range result {
+		items = append(items, models.NewTenant(v))
+	}
+	return c.JSON(http.StatusOK, models.Tenants{Items: &items})
+
+}
+
+// ShowTenant returns the tenant along with the usage of its quotas
+func (p *ProductsCtl) ShowTenant(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "tenants.show", "api")
+	defer span.End()
+
+	tenantId := strings.ToLower(c.Param("id"))
+	if !xeroHelper.ValidateTenantID(tenantId) {
+		return xError.XeroInvalidIDError("tenant")
+	}
+
+	result, err := p.ServiceCommands.FetchTenant(ctx, tenantId)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+	if len(result) == 0 {
+		return xError.XeroUnknownIDError("tenant")
+	}
+
+	products, options, err := p.ServiceCommands.CountCatalogue(xeroHelper.WithTenant(ctx, tenantId))
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
+	tenant := models.NewTenant(result[0])
+	tenant.Usage = &models.TenantUsage{Products: products, Options: options}
+	return c.JSON(http.StatusOK, tenant)
+
+}
+
+// AddNewTenant onboards the tenant with an empty catalogue, its id is chosen by the platform
+func (p *ProductsCtl) AddNewTenant(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "tenants.add", "api")
+	defer span.End()
+
+	tenant := models.Tenant{}
+	if err := c.Bind(&tenant); err != nil {
+		return xError.XeroInvalidRequestError(err)
+	}
+	tenant.ID = strings.ToLower(strings.TrimSpace(tenant.ID))
+	if tenant.ID == "" {
+		return xError.XeroValidationError(xError.NewFieldError("/Id", xError.FieldRequired, "Id is required"))
+	}
+	if err := tenant.Validate(); err != nil {
+		return xError.XeroValidationError(err)
+	}
+
+	err := p.ServiceCommands.AddNewTenant(ctx, tenant)
+	if err == productServiceCmds.ErrTenantExists {
+		return xError.XeroConflictError("tenant_exists", "Another tenant has the id "+tenant.ID)
+	} else if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
+	// Return 201, created
+	return c.JSON(http.StatusCreated, tenant.ID)
+
+}
+
+// UpdateTenant changes the name and the quotas of the tenant, a lower quota does not remove the rows over it
+func (p *ProductsCtl) UpdateTenant(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "tenants.update", "api")
+	defer span.End()
+
+	tenantId := strings.ToLower(c.Param("id"))
+	if !xeroHelper.ValidateTenantID(tenantId) {
+		return xError.XeroInvalidIDError("tenant")
+	}
+
+	tenant := models.Tenant{}
+	if err := c.Bind(&tenant); err != nil {
+		return xError.XeroInvalidRequestError(err)
+	}
+	if tenant.ID != "" && !strings.EqualFold(tenant.ID, tenantId) {
+		return xError.XeroValidationError(xError.NewFieldError("/Id", xError.FieldNotAllowed, "Id cannot be changed"))
+	}
+	if err := tenant.Validate(); err != nil {
+		return xError.XeroValidationError(err)
+	}
+
+	affectedRows, err := p.ServiceCommands.UpdateTenant(ctx, tenantId, tenant)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+	if affectedRows == 0 {
+		return xError.XeroUnknownIDError("tenant")
+	}
+
+	return c.JSON(http.StatusOK, tenantId)
+
+}
+
+// DeleteTenant removes the tenant along with its whole catalogue, the trash and the history included
+func (p *ProductsCtl) DeleteTenant(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "tenants.delete", "api")
+	defer span.End()
+
+	tenantId := strings.ToLower(c.Param("id"))
+	if !xeroHelper.ValidateTenantID(tenantId) {
+		return xError.XeroInvalidIDError("tenant")
+	}
+
+	affectedRows, err := p.ServiceCommands.DeleteTenant(ctx, tenantId)
+	if err == productServiceCmds.ErrDefaultTenant {
+		return xError.XeroConflictError("default_tenant", "The default tenant cannot be removed")
+	} else if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+	if affectedRows == 0 {
+		return xError.XeroUnknownIDError("tenant")
+	}
+
+	return c.JSON(http.StatusOK, tenantId)
+
+}
+
+// catalogueError returns the error of a change adding to the catalogue of the tenant
+func catalogueError(err error) error {
+	switch err {
+	case productServiceCmds.ErrQuotaExceeded:
+		return quotaExceededError()
+	case productServiceCmds.ErrUnknownProduct:
+		return xError.XeroUnknownIDError("product")
+	}
+	return xError.NewUnexpectedGenericError(err)
+}
+
+func quotaExceededError() xError.Error {
+	return xError.XeroConflictError("quota_exceeded", "The change would take the tenant over the quota of its products or its options")
+}
+
+// TenantExists tells the tenancy middleware if the tenant of the request exists
+func (p *ProductsCtl) TenantExists(ctx context.Context, id string) (bool, error) {
+	result, err := p.ServiceCommands.FetchTenant(ctx, id)
+	return len(result) != 0, err
+}
@@ -0,0 +1,138 @@
+package ctls
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"go.elastic.co/apm"
+
+	"github.com/techievee/xero/productService/models"
+	xError "github.com/techievee/xero/xeroErrors"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// Query parameters accepted by the trash, it is ordered by the time of the deletion so it is paged with the offset only
+var productTrashParams = map[string]bool{
+	"limit":  true,
+	"offset": true,
+}
+
+// ShowTrash lists the deleted products that are not purged yet, the last deleted first
+func (p *ProductsCtl) ShowTrash(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "trash.show", "api")
+	defer span.End()
+
+	for param := range c.QueryParams() {
+		if !productTrashParams[param] {
+			return xError.XeroBadRequestError("unknown_field", models.UnknownFieldError{Field: param})
+		}
+	}
+
+	page, err := parsePageRequest(c)
+	if err != nil {
+		return xError.XeroBadRequestError("invalid_page", err)
+	}
+
+	result, total, err := p.ServiceCommands.FetchDeletedProducts(ctx, page.Limit, page.Offset)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
+	items := []models.Product{}
+	for _, v := range result {
+		items = append(items, models.NewProduct(v))
+	}
+
+	return c.JSON(http.StatusOK, models.Products{Items: &items, Total: total})
+
+}
+
+// RestoreProduct brings the product back from the trash, with the options deleted along with it
+func (p *ProductsCtl) RestoreProduct(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "product.restore", "api")
+	defer span.End()
+
+	productId := c.Param("id")
+	if !xeroHelper.ValidateUUID(productId) {
+		return xError.XeroInvalidIDError("product")
+	}
+
+	affectedRows, err := p.ServiceCommands.RestoreProduct(ctx, productId)
+	if err != nil {
+		return catalogueError(err)
+	}
+	if affectedRows == 0 {
+		return xError.XeroUnknownIDError("product")
+	}
+
+	return c.JSON(http.StatusOK, productId)
+
+}
+
+// ShowProductOptionsTrash lists the deleted options of the product, the last deleted first
+func (p *ProductsCtl) ShowProductOptionsTrash(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "product_options_trash.show", "api")
+	defer span.End()
+
+	productId := c.Param("id")
+	if !xeroHelper.ValidateUUID(productId) {
+		return xError.XeroInvalidIDError("product")
+	}
+	if product, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId); err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	} else if len(product) == 0 {
+		return xError.XeroUnknownIDError("product")
+	}
+
+	result, err := p.ServiceCommands.FetchDeletedProductOptions(ctx, productId)
+	if err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	}
+
+	items := []models.ProductOption{}
+	for _, v := range result {
+		items = append(items, models.NewProductOption(v))
+	}
+
+	return c.JSON(http.StatusOK, models.ProductOptions{Items: &items, Total: int64(len(items))})
+
+}
+
+// RestoreProductOption brings the option back from the trash, the product itself must not be in the trash
+func (p *ProductsCtl) RestoreProductOption(c echo.Context) error {
+
+	defer xError.CatchErr(nil)
+	ctx := c.Request().Context()
+	span, _ := apm.StartSpan(ctx, "product_option.restore", "api")
+	defer span.End()
+
+	productId, productOptionId, err := optionParams(c)
+	if err != nil {
+		return err
+	}
+	if product, err := p.ServiceCommands.FetchAllProducts(ctx, "", productId); err != nil {
+		return xError.NewUnexpectedGenericError(err)
+	} else if len(product) == 0 {
+		return xError.XeroUnknownIDError("product")
+	}
+
+	affectedRows, err := p.ServiceCommands.RestoreProductOption(ctx, productId, productOptionId)
+	if err != nil {
+		return catalogueError(err)
+	}
+	if affectedRows == 0 {
+		return xError.XeroUnknownIDError("product_option")
+	}
+
+	return c.JSON(http.StatusOK, productOptionId)
+
+}
@@ -0,0 +1,75 @@
+package productService
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	productServiceCmds "github.com/techievee/xero/productService/commands"
+	"github.com/techievee/xero/xeroHelper"
+	"github.com/techievee/xero/xeroLog/debugcore"
+)
+
+// Time given to the repository to count the catalogue at every scrape
+const catalogueScrapeTimeout = 2 * time.Second
+
+// catalogueCollector exposes the size of the catalogue, counted from the repository at every scrape
+// The gauges are the total of every tenant, the tenants are not labels so their number does not grow the series
+type catalogueCollector struct {
+	repository productServiceCmds.Repository
+	logger     debugcore.Logger
+
+	products *prometheus.Desc
+	options  *prometheus.Desc
+}
+
+func newCatalogueCollector(repository productServiceCmds.Repository, logger debugcore.Logger) *catalogueCollector {
+	return &catalogueCollector{
+		repository: repository,
+		logger:     logger,
+		products:   prometheus.NewDesc("xero_catalogue_products", "Number of products in the catalogue.", nil, nil),
+		options:    prometheus.NewDesc("xero_catalogue_product_options", "Number of product options in the catalogue.", nil, nil),
+	}
+}
+
+func (c *catalogueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.products
+	ch <- c.options
+}
+
+func (c *catalogueCollector) Collect(ch chan<- prometheus.Metric) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), catalogueScrapeTimeout)
+	defer cancel()
+
+	// The gauges are left out of the scrape rather than reported as a partial catalogue
+	tenants, err := c.repository.FetchTenants(ctx)
+	if err != nil {
+		c.logger.Error("Error while counting the catalogue for the metrics", "error", err)
+		return
+	}
+	var products, options int64
+	for _, tenant := range tenants {
+		tenantProducts, tenantOptions, err := c.repository.CountCatalogue(xeroHelper.WithTenant(ctx, tenant.DBID.String))
+		if err != nil {
+			c.logger.Error("Error while counting the catalogue for the metrics", "tenant", tenant.DBID.String, "error", err)
+			return
+		}
+		products += tenantProducts
+		options += tenantOptions
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.products, prometheus.GaugeValue, float64(products))
+	ch <- prometheus.MustNewConstMetric(c.options, prometheus.GaugeValue, float64(options))
+}
+
+// LoadMetrics registers the catalogue gauges, and the connection pool statistics when backed by the database
+func (ps *ProductService) LoadMetrics() {
+
+	ps.RestAPI.RegisterMetrics(newCatalogueCollector(ps.ServiceController.ServiceCommands, ps.Logger))
+
+	if ps.DB != nil {
+		ps.RestAPI.RegisterMetrics(ps.DB.StatsCollector())
+	}
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Entities recorded in the audit log of a product
+// EntityId is the id of the product, the id of the option for the options and their stock, the currency for the prices and the id of the category for the links to the categories
+const (
+	AuditProduct  = "product"
+	AuditOption   = "option"
+	AuditStock    = "stock"
+	AuditPrice    = "price"
+	AuditCategory = "category"
+)
+
+// Actions recorded in the audit log, delete moves the row to the trash and purge removes it for good
+// The changes of the stock are recorded with the operation on the reservation
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+	AuditReserve = "reserve"
+	AuditCommit  = "commit"
+	AuditRelease = "release"
+)
+
+// AuditLog is one page of the history of a product, the last change first
+type AuditLog struct {
+	Items *[]AuditEntry `json:"Items"`
+	Total int64         `json:"Total"`
+}
+
+// AuditEntry is one change of a product, Before is null for a creation and After is null for a deletion for good
+// The states are the representations of the entity returned by the API at the time of the change
+type AuditEntry struct {
+	ID        string          `json:"Id"`
+	ProductID string          `json:"ProductId"`
+	Entity    string          `json:"Entity"`
+	EntityID  string          `json:"EntityId"`
+	Action    string          `json:"Action"`
+	Actor     string          `json:"Actor"`
+	RequestID string          `json:"RequestId,omitempty"`
+	Before    json.RawMessage `json:"Before"`
+	After     json.RawMessage `json:"After"`
+	CreatedAt time.Time       `json:"CreatedAt"`
+}
+
+// ProductCategoryLink is the state of a link of a product to a category
+type ProductCategoryLink struct {
+	CategoryID string `json:"CategoryId"`
+}
+
+// NewAuditEntry returns the entry of the row
+func NewAuditEntry(v DBAuditLog) AuditEntry {
+	entry := AuditEntry{
+		ID:        v.DBID.String,
+		ProductID: v.DBProductID.String,
+		Entity:    v.DBEntity.String,
+		EntityID:  v.DBEntityID.String,
+		Action:    v.DBAction.String,
+		Actor:     v.DBActor.String,
+		RequestID: v.DBRequestID.String,
+		CreatedAt: time.Unix(0, v.DBCreatedAt.Int64*int64(time.Millisecond)).UTC(),
+	}
+	if v.DBBefore.Valid {
+		entry.Before = json.RawMessage(v.DBBefore.String)
+	}
+	if v.DBAfter.Valid {
+		entry.After = json.RawMessage(v.DBAfter.String)
+	}
+	return entry
+}
@@ -0,0 +1,77 @@
+package models
+
+import xError "github.com/techievee/xero/xeroErrors"
+
+// Operations of a batch
+const (
+	BatchCreate = "create"
+	BatchUpsert = "upsert"
+	BatchDelete = "delete"
+)
+
+// Modes of a batch, an atomic batch applies all its operations or none of them
+// A best effort batch applies every operation it can and reports the others
+const (
+	BatchAtomic     = "atomic"
+	BatchBestEffort = "best_effort"
+)
+
+// ProductBatch is the body of POST /api/products/batch, the operations are applied in their order
+type ProductBatch struct {
+	// BatchAtomic when it is not specified
+	Mode       string           `json:"Mode,omitempty"`
+	Operations []BatchOperation `json:"Operations"`
+}
+
+// BatchOperation is one change of a batch
+// It changes the option of the product ProductId when it is set, and the product otherwise
+// Id is the product or the option to upsert or to delete, create always makes new ids
+type BatchOperation struct {
+	Op        string         `json:"Op"`
+	ID        string         `json:"Id,omitempty"`
+	ProductID string         `json:"ProductId,omitempty"`
+	Product   *Product       `json:"Product,omitempty"`
+	Option    *ProductOption `json:"Option,omitempty"`
+	// Version of the product or the option the fields of the upsert were checked against, 0 when it did not exist
+	// The upsert is refused when the row changed since, it is set by the authorization and never read from the body
+	Version *int64 `json:"-"`
+}
+
+// IsOption tells if the operation changes an option
+func (o *BatchOperation) IsOption() bool {
+	return o.ProductID != ""
+}
+
+// BatchResults is the response of a batch, one result per operation in the order of the operations
+type BatchResults struct {
+	Mode      string        `json:"Mode"`
+	Succeeded int           `json:"Succeeded"`
+	Failed    int           `json:"Failed"`
+	Results   []BatchResult `json:"Results"`
+}
+
+// BatchResult is the outcome of one operation, Status is the status code the operation would have on its own endpoint
+// Id is the product or the option changed, OptionIds the options created along with a product
+type BatchResult struct {
+	Index     int             `json:"Index"`
+	Op        string          `json:"Op"`
+	Status    int             `json:"Status"`
+	ID        string          `json:"Id,omitempty"`
+	OptionIDs []string        `json:"OptionIds,omitempty"`
+	Error     *xError.Problem `json:"Error,omitempty"`
+}
+
+// SetError sets the status and the problem of the failed operation
+func (r *BatchResult) SetError(err error, requestID string) {
+	r.Error = itemProblem(err, requestID)
+	r.Status = r.Error.Status
+}
+
+// itemProblem returns the problem of one item of a bulk request, without traceback
+func itemProblem(err error, requestID string) *xError.Problem {
+	e := xError.NewUnexpectedGenericError(err)
+	e.RequestID = requestID
+	problem := e.Problem("")
+	problem.Traceback = nil
+	return &problem
+}
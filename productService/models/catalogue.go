@@ -0,0 +1,229 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	xError "github.com/techievee/xero/xeroErrors"
+)
+
+// Formats of the export and of the import of the catalogue
+const (
+	CatalogueCSV    = "csv"
+	CatalogueNDJSON = "ndjson"
+)
+
+// Media types of the formats of the catalogue
+const (
+	MIMETextCSV           = "text/csv"
+	MIMEApplicationNDJSON = "application/x-ndjson"
+)
+
+// Layouts of the options in the catalogue, nested in the line of their product or flattened with one line per option
+const (
+	OptionsNested = "nested"
+	OptionsFlat   = "flat"
+)
+
+// Columns of the products in the CSV, followed by the columns of the options of the layout
+var (
+	catalogueColumns       = []string{"Id", "Name", "Description", "Price", "DeliveryPrice", "Currency"}
+	catalogueNestedColumns = []string{"Options"}
+	catalogueFlatColumns   = []string{"OptionId", "OptionName", "OptionDescription"}
+)
+
+// CatalogueRow is one line of the catalogue, a product with its options nested in Options,
+// or with one of its options flattened in the Option fields
+// In NDJSON a line is the JSON of the row, in CSV a line has one column per field and the nested options are a JSON array
+type CatalogueRow struct {
+	Product
+	OptionID          string `json:"OptionId,omitempty"`
+	OptionName        string `json:"OptionName,omitempty"`
+	OptionDescription string `json:"OptionDescription,omitempty"`
+}
+
+// ImportResults is the response of an import, the lines are counted without the header and the blank lines
+// Operations is the number of changes applied, or that a dry run would apply
+// Created and Updated count the products and the options, Failed the lines with at least one error
+type ImportResults struct {
+	DryRun     bool          `json:"DryRun"`
+	Lines      int           `json:"Lines"`
+	Operations int           `json:"Operations"`
+	Created    int           `json:"Created"`
+	Updated    int           `json:"Updated"`
+	Failed     int           `json:"Failed"`
+	Errors     []ImportError `json:"Errors"`
+}
+
+// ImportError is the failure of a line of the import, the header of a CSV is the line 1
+type ImportError struct {
+	Line  int             `json:"Line"`
+	Error *xError.Problem `json:"Error"`
+}
+
+// AddError adds the failure of the line
+func (r *ImportResults) AddError(line int, err error, requestID string) {
+	if len(r.Errors) == 0 || r.Errors[len(r.Errors)-1].Line != line {
+		r.Failed++
+	}
+	r.Errors = append(r.Errors, ImportError{Line: line, Error: itemProblem(err, requestID)})
+}
+
+// CatalogueRows returns the lines of the product in the layout, a product without option has one line in both the layouts
+func CatalogueRows(product Product, options []ProductOption, layout string) []CatalogueRow {
+
+	if layout == OptionsNested {
+		product.Options = options
+		return []CatalogueRow{{Product: product}}
+	}
+
+	product.Options = nil
+	if len(options) == 0 {
+		return []CatalogueRow{{Product: product}}
+	}
+	rows := make([]CatalogueRow, 0, len(options))
+	for _, option := range options {
+		rows = append(rows, CatalogueRow{Product: product, OptionID: option.ID, OptionName: option.Name, OptionDescription: option.Description})
+	}
+	return rows
+}
+
+// CatalogueHeader returns the header of the CSV in the layout
+func CatalogueHeader(layout string) []string {
+	if layout == OptionsNested {
+		return append(append([]string{}, catalogueColumns...), catalogueNestedColumns...)
+	}
+	return append(append([]string{}, catalogueColumns...), catalogueFlatColumns...)
+}
+
+// CSVRecord returns the fields of the row in the order of the header
+func (r *CatalogueRow) CSVRecord(header []string) ([]string, error) {
+
+	record := make([]string, len(header))
+	for i, column := range header {
+		switch column {
+		case "Id":
+			record[i] = r.ID
+		case "Name":
+			record[i] = r.Name
+		case "Description":
+			record[i] = r.Description
+		case "Price":
+			record[i] = r.Price.String()
+		case "DeliveryPrice":
+			record[i] = r.DeliveryPrice.String()
+		case "Currency":
+			record[i] = r.Currency
+		case "Options":
+			options := r.Options
+			if options == nil {
+				options = []ProductOption{}
+			}
+			value, err := json.Marshal(options)
+			if err != nil {
+				return nil, err
+			}
+			record[i] = string(value)
+		case "OptionId":
+			record[i] = r.OptionID
+		case "OptionName":
+			record[i] = r.OptionName
+		case "OptionDescription":
+			record[i] = r.OptionDescription
+		}
+	}
+	return record, nil
+}
+
+// ValidateCatalogueHeader checks the header of an imported CSV, the columns can be in any order but Name and Price are required
+// The Options column cannot be mixed with the columns of the flat layout
+func ValidateCatalogueHeader(header []string) error {
+
+	known := map[string]bool{}
+	for _, column := range append(CatalogueHeader(OptionsNested), catalogueFlatColumns...) {
+		known[column] = true
+	}
+
+	seen := map[string]bool{}
+	for _, column := range header {
+		if !known[column] {
+			return fmt.Errorf("unknown column %q", column)
+		}
+		if seen[column] {
+			return fmt.Errorf("duplicate column %q", column)
+		}
+		seen[column] = true
+	}
+	for _, column := range []string{"Name", "Price"} {
+		if !seen[column] {
+			return fmt.Errorf("column %q is required", column)
+		}
+	}
+	for _, column := range catalogueFlatColumns {
+		if seen[column] && seen["Options"] {
+			return fmt.Errorf("column %q cannot be mixed with the Options column", column)
+		}
+	}
+	return nil
+}
+
+// ParseCSVRecord returns the row of the fields of a valid header
+// A price that is not an exact amount is kept invalid, so Product.Validate reports it on its field
+func ParseCSVRecord(header []string, record []string) (CatalogueRow, error) {
+
+	row := CatalogueRow{}
+	for i, column := range header {
+		value := strings.TrimSpace(record[i])
+		switch column {
+		case "Id":
+			row.ID = value
+		case "Name":
+			row.Name = record[i]
+		case "Description":
+			row.Description = record[i]
+		case "Price":
+			row.Price = parseCSVMoney(value)
+		case "DeliveryPrice":
+			row.DeliveryPrice = parseCSVMoney(value)
+		case "Currency":
+			row.Currency = strings.ToUpper(value)
+		case "Options":
+			if value == "" {
+				continue
+			}
+			if err := json.Unmarshal([]byte(value), &row.Options); err != nil {
+				return row, xError.XeroValidationError(xError.NewFieldError("/Options", xError.FieldInvalidType, "Options must be a JSON array of options"))
+			}
+		case "OptionId":
+			row.OptionID = value
+		case "OptionName":
+			row.OptionName = record[i]
+		case "OptionDescription":
+			row.OptionDescription = record[i]
+		}
+	}
+	return row, nil
+}
+
+// An empty amount is 0, same as an amount missing from the JSON
+func parseCSVMoney(value string) Money {
+	if value == "" {
+		return Money{}
+	}
+	money, err := ParseMoney(value, "")
+	if err != nil {
+		return Money{invalid: value}
+	}
+	return money
+}
+
+// HasOption tells if the row holds a flattened option
+func (r *CatalogueRow) HasOption() bool {
+	return r.OptionID != "" || r.OptionName != "" || r.OptionDescription != ""
+}
+
+// FlatOption returns the flattened option of the row
+func (r *CatalogueRow) FlatOption() ProductOption {
+	return ProductOption{ID: r.OptionID, Name: r.OptionName, Description: r.OptionDescription}
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+
+	xError "github.com/techievee/xero/xeroErrors"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// Length of the columns of the categories
+const (
+	CategoryNameMaxLen = 35
+	CategorySlugMaxLen = 64
+)
+
+// What happens to the children of a deleted category
+const (
+	// OrphansRefuse refuses to delete a category that has children, it is the default
+	OrphansRefuse = "refuse"
+	// OrphansReparent moves the children to the parent of the deleted category
+	OrphansReparent = "reparent"
+	// OrphansCascade deletes the whole subtree of the category
+	OrphansCascade = "cascade"
+)
+
+var (
+	slugPattern   = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	slugSeparator = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// Categories is the tree of the categories, the roots are listed in their order with their children nested
+type Categories struct {
+	Items *[]Category `json:"Items"`
+}
+
+// Category is one node of the taxonomy, the root categories have no parent
+// The children of a category are listed by SortOrder and then by Name
+type Category struct {
+	ID        string     `json:"Id"`
+	ParentID  string     `json:"ParentId,omitempty"`
+	Slug      string     `json:"Slug"`
+	Name      string     `json:"Name"`
+	SortOrder int64      `json:"SortOrder"`
+	Children  []Category `json:"Children,omitempty"`
+}
+
+// Slugify returns the slug of the name, the runs of other characters than letters and digits become a single dash
+func Slugify(name string) string {
+	return strings.Trim(slugSeparator.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// Validate returns nil when the category can be saved, the slug is derived from the name when it is not set
+// Otherwise, the returned xeroErrors.ErrorCollection holds one xeroErrors.FieldError per invalid field
+func (p *Category) Validate() error {
+
+	errs := xError.NewErrorCollection()
+
+	validateText(errs, "/Name", "Name", p.Name, CategoryNameMaxLen)
+
+	if p.Slug == "" {
+		p.Slug = Slugify(p.Name)
+	}
+	if len(p.Slug) > CategorySlugMaxLen {
+		errs.AddError(xError.NewFieldError("/Slug", xError.FieldTooLong, "Slug must be at most 64 characters"))
+	} else if p.Slug != "" && !slugPattern.MatchString(p.Slug) {
+		errs.AddError(xError.NewFieldError("/Slug", xError.FieldInvalid, "Slug must be lower case letters and digits separated by dashes"))
+	}
+
+	if p.ParentID != "" && !xeroHelper.ValidateUUID(p.ParentID) {
+		errs.AddError(xError.NewFieldError("/ParentId", xError.FieldInvalid, "ParentId must be the id of a category"))
+	}
+
+	return validationResult(errs)
+}
+
+// CategoryTree nests the categories under their parents, the categories are expected in their order
+func CategoryTree(categories []DBCategories) []Category {
+
+	items := nestCategories(categories, "")
+	if items == nil {
+		items = []Category{}
+	}
+	return items
+}
+
+// CategorySubtree returns the category with its descendants nested, false when the category is not in the list
+func CategorySubtree(categories []DBCategories, id string) (Category, bool) {
+
+	for _, v := range categories {
+		if strings.EqualFold(v.DBID.String, id) {
+			item := NewCategory(v)
+			item.Children = nestCategories(categories, strings.ToLower(item.ID))
+			return item, true
+		}
+	}
+	return Category{}, false
+}
+
+func nestCategories(categories []DBCategories, parentID string) []Category {
+
+	var items []Category
+	for _, v := range categories {
+		if strings.ToLower(v.DBParentID.String) == parentID {
+			item := NewCategory(v)
+			item.Children = nestCategories(categories, strings.ToLower(item.ID))
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// CategoryDescendants returns the ids of the whole subtree of the category, the category included
+func CategoryDescendants(categories []DBCategories, id string) []string {
+
+	ids := []string{strings.ToLower(id)}
+	for i := 0; i < len(ids); i++ {
+		for _, v := range categories {
+			if strings.ToLower(v.DBParentID.String) == ids[i] {
+				ids = append(ids, strings.ToLower(v.DBID.String))
+			}
+		}
+	}
+	return ids
+}
+
+// NewCategory converts the row of the category, without its children
+func NewCategory(v DBCategories) Category {
+	return Category{
+		ID:        v.DBID.String,
+		ParentID:  v.DBParentID.String,
+		Slug:      v.DBSlug.String,
+		Name:      v.DBName.String,
+		SortOrder: v.DBSortOrder.Int64,
+	}
+}
@@ -8,8 +8,11 @@ type DBProducts struct {
 	DBID            sql.NullString
 	DBName          sql.NullString
 	DBDescription   sql.NullString
-	DBPrice         sql.NullFloat64
-	DBDeliveryPrice sql.NullFloat64
+	DBPrice         sql.NullInt64
+	DBDeliveryPrice sql.NullInt64
+	DBCurrency      sql.NullString
+	DBDeletedAt     sql.NullInt64
+	DBVersion       sql.NullInt64
 }
 
 type DBProductOptions struct {
@@ -17,4 +20,61 @@ type DBProductOptions struct {
 	DBProductID   sql.NullString
 	DBName        sql.NullString
 	DBDescription sql.NullString
+	DBDeletedAt   sql.NullInt64
+	DBVersion     sql.NullInt64
+}
+
+type DBProductPrices struct {
+	DBProductID     sql.NullString
+	DBCurrency      sql.NullString
+	DBPrice         sql.NullInt64
+	DBDeliveryPrice sql.NullInt64
+}
+
+type DBStock struct {
+	DBOnHand   sql.NullInt64
+	DBReserved sql.NullInt64
+	DBVersion  sql.NullInt64
+}
+
+type DBStockReservations struct {
+	DBID              sql.NullString
+	DBProductOptionID sql.NullString
+	DBQuantity        sql.NullInt64
+	DBStatus          sql.NullString
+}
+
+type DBCategories struct {
+	DBID        sql.NullString
+	DBParentID  sql.NullString
+	DBSlug      sql.NullString
+	DBName      sql.NullString
+	DBSortOrder sql.NullInt64
+}
+
+type DBTenants struct {
+	DBID          sql.NullString
+	DBName        sql.NullString
+	DBMaxProducts sql.NullInt64
+	DBMaxOptions  sql.NullInt64
+	DBCreatedAt   sql.NullInt64
+}
+
+type DBProductSearch struct {
+	DBProducts
+	DBSnippet sql.NullString
+	DBScore   sql.NullFloat64
+}
+
+type DBAuditLog struct {
+	DBID        sql.NullString
+	DBProductID sql.NullString
+	DBEntity    sql.NullString
+	DBEntityID  sql.NullString
+	DBAction    sql.NullString
+	DBActor     sql.NullString
+	DBRequestID sql.NullString
+	DBBefore    sql.NullString
+	DBAfter     sql.NullString
+	DBCreatedAt sql.NullInt64
 }
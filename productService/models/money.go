@@ -0,0 +1,112 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency of the prices sent without a currency, and of the rows priced before the currency was stored
+const DefaultCurrency = "NZD"
+
+// moneyScale is the number of minor units in one unit of the currency, prices have at most 2 decimal places
+const moneyScale = 100
+
+// amountPattern accepts a plain decimal number with at most 2 decimal places, exponents are not supported
+var amountPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]{1,2})?$`)
+
+// currencyPattern is an ISO 4217 alphabetic code
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ErrAmountPrecision is returned for an amount that cannot be represented in minor units
+var ErrAmountPrecision = errors.New("amount must be a number with at most 2 decimal places")
+
+// Money is an exact amount of a currency, stored as an integer number of minor units (cents)
+// In JSON it is a plain number such as 1229.99, the currency is carried next to it
+type Money struct {
+	Amount   int64
+	Currency string
+	// The JSON value that could not be represented, reported by Validate on the field holding the amount
+	invalid string
+}
+
+// NewMoney returns the amount of minor units in the currency
+func NewMoney(minorUnits int64, currency string) Money {
+	return Money{Amount: minorUnits, Currency: currency}
+}
+
+// ParseMoney parses a decimal amount such as `12.5` in the currency, more than 2 decimal places are rejected
+func ParseMoney(s string, currency string) (Money, error) {
+
+	s = strings.TrimSpace(s)
+	if !amountPattern.MatchString(s) {
+		return Money{}, ErrAmountPrecision
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	units := strings.TrimPrefix(s, "-")
+	cents := "00"
+	if dot := strings.IndexByte(units, '.'); dot >= 0 {
+		cents = (units[dot+1:] + "0")[:2]
+		units = units[:dot]
+	}
+
+	u, err := strconv.ParseInt(units, 10, 64)
+	if err != nil || u > (1<<62)/moneyScale {
+		return Money{}, ErrAmountPrecision
+	}
+	c, _ := strconv.ParseInt(cents, 10, 64)
+
+	amount := u*moneyScale + c
+	if negative {
+		amount = -amount
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// IsValidCurrency reports whether code is an ISO 4217 alphabetic code
+func IsValidCurrency(code string) bool {
+	return currencyPattern.MatchString(code)
+}
+
+// String returns the decimal amount with its 2 decimal places, e.g 1229.90
+func (m Money) String() string {
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/moneyScale, amount%moneyScale)
+}
+
+// MarshalJSON encodes the amount as a JSON number, compatible with the former float prices
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON decodes a JSON number, the currency is not part of the number, it is left unchanged
+// An amount with more than 2 decimal places is not rounded, it is kept as invalid so the validation can report its field
+func (m *Money) UnmarshalJSON(b []byte) error {
+
+	if string(b) == "null" {
+		return nil
+	}
+
+	parsed, err := ParseMoney(string(b), m.Currency)
+	if err != nil {
+		*m = Money{Currency: m.Currency, invalid: string(b)}
+		return nil
+	}
+
+	*m = parsed
+	return nil
+}
+
+// IsValid reports whether the decoded amount was exact
+func (m Money) IsValid() bool {
+	return m.invalid == ""
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// PageRequest holds the paging parameters accepted by the listing endpoints
+// Offset and Cursor are mutually exclusive, Cursor is preferred for deep pages
+type PageRequest struct {
+	Limit  int
+	Offset int
+	Cursor *Cursor
+}
+
+// PageLinks are the navigation links returned along with a page of items
+type PageLinks struct {
+	Self  string `json:"Self"`
+	First string `json:"First"`
+	Next  string `json:"Next,omitempty"`
+}
+
+// Cursor is the keyset position of the last row returned in a page
+// Rows are ordered by the sort columns and then by Id, so their values are enough to resume the scan
+type Cursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+	ID     string        `json:"i"`
+}
+
+// Encode returns the opaque representation of the cursor sent to the clients
+func (c *Cursor) Encode() string {
+	payload, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// DecodeCursor parses the opaque cursor received from the clients
+func DecodeCursor(s string) (*Cursor, error) {
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+
+	cursor := &Cursor{}
+	if err := json.Unmarshal(payload, cursor); err != nil || cursor.ID == "" {
+		return nil, errors.New("malformed cursor")
+	}
+
+	return cursor, nil
+}
@@ -1,11 +1,41 @@
 package models
 
+import "time"
+
 type ProductOptions struct {
-	Items *[]ProductOption `json:"Items"`
+	Items      *[]ProductOption `json:"Items"`
+	Total      int64            `json:"Total"`
+	NextCursor string           `json:"NextCursor,omitempty"`
+	Links      *PageLinks       `json:"Links,omitempty"`
 }
 
 type ProductOption struct {
 	ID          string `json:"Id"`
 	Name        string `json:"Name"`
 	Description string `json:"Description"`
+	// Time of the deletion, only set for the options in the trash
+	DeletedAt *time.Time `json:"DeletedAt,omitempty"`
+}
+
+// NewProductOption returns the option of the row, with the time of the deletion when it is in the trash
+func NewProductOption(v DBProductOptions) ProductOption {
+	return ProductOption{
+		ID:          v.DBID.String,
+		Name:        v.DBName.String,
+		Description: v.DBDescription.String,
+		DeletedAt:   DeletedAtTime(v.DBDeletedAt),
+	}
+}
+
+// ChangedFields returns the names of the fields that the option changes in the current option, in the order of the columns
+func (p *ProductOption) ChangedFields(current ProductOption) []string {
+
+	var fields []string
+	if p.Name != current.Name {
+		fields = append(fields, "Name")
+	}
+	if p.Description != current.Description {
+		fields = append(fields, "Description")
+	}
+	return fields
 }
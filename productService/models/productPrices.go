@@ -0,0 +1,38 @@
+package models
+
+import (
+	xError "github.com/techievee/xero/xeroErrors"
+)
+
+// ProductPrices lists the prices of a product, the price in the base currency of the product comes first
+type ProductPrices struct {
+	BaseCurrency string          `json:"BaseCurrency"`
+	Items        *[]ProductPrice `json:"Items"`
+}
+
+// ProductPrice is the price of a product in one currency
+// The currency is taken from the url when the price is set, it is ignored in the body
+type ProductPrice struct {
+	Currency      string `json:"Currency"`
+	Price         Money  `json:"Price"`
+	DeliveryPrice Money  `json:"DeliveryPrice"`
+}
+
+// Validate returns nil when the price can be saved
+// Otherwise, the returned xeroErrors.ErrorCollection holds one xeroErrors.FieldError per invalid field
+func (p *ProductPrice) Validate() error {
+
+	errs := xError.NewErrorCollection()
+	validatePrices(errs, p.Price, p.DeliveryPrice)
+
+	return validationResult(errs)
+}
+
+// NewProductPrice returns the price of the row
+func NewProductPrice(v DBProductPrices) ProductPrice {
+	return ProductPrice{
+		Currency:      v.DBCurrency.String,
+		Price:         NewMoney(v.DBPrice.Int64, v.DBCurrency.String),
+		DeliveryPrice: NewMoney(v.DBDeliveryPrice.Int64, v.DBCurrency.String),
+	}
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// productSortColumns is the whitelist of the sortable fields and their column names
+// Only the column names from this list are ever written in to the SQL text
+var productSortColumns = map[string]string{
+	"id":            "Id",
+	"name":          "Name",
+	"description":   "Description",
+	"price":         "PriceMinor",
+	"deliveryprice": "DeliveryPriceMinor",
+}
+
+// DefaultProductSort is used when the listing does not specify the sort order
+var DefaultProductSort = []SortField{{Field: "name", Column: "Name"}}
+
+// ProductOptionSort is the fixed order of the product option listing
+var ProductOptionSort = []SortField{{Field: "name", Column: "Name"}}
+
+// ProductFilter holds the filters and the sort order accepted by the product listing
+type ProductFilter struct {
+	Name        string
+	ExactName   string
+	Description string
+	// The price filters are in minor units
+	MinPrice         *int64
+	MaxPrice         *int64
+	MaxDeliveryPrice *int64
+	// The products linked to one of the categories, no filter when nil
+	CategoryIDs []string
+	Sort        []SortField
+}
+
+// SortField is one whitelisted field of the sort order
+type SortField struct {
+	Field  string
+	Column string
+	Desc   bool
+}
+
+// UnknownFieldError is returned when the request refers to a field that is not supported
+type UnknownFieldError struct {
+	Field string
+}
+
+func (e UnknownFieldError) Error() string {
+	return fmt.Sprintf("unknown field %q", e.Field)
+}
+
+// ParseProductSort parses a sort expression such as `price,-name`
+// A leading `-` sorts the field in descending order
+func ParseProductSort(s string) ([]SortField, error) {
+
+	if strings.TrimSpace(s) == "" {
+		return DefaultProductSort, nil
+	}
+
+	var sort []SortField
+	seen := map[string]bool{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		desc := strings.HasPrefix(f, "-")
+		f = strings.TrimPrefix(strings.TrimPrefix(f, "-"), "+")
+
+		column, ok := productSortColumns[strings.ToLower(f)]
+		if !ok {
+			return nil, UnknownFieldError{Field: f}
+		}
+		if seen[column] {
+			continue
+		}
+		seen[column] = true
+		sort = append(sort, SortField{Field: strings.ToLower(f), Column: column, Desc: desc})
+	}
+
+	return sort, nil
+}
+
+// SortKey returns the canonical form of the sort order, cursors are only valid for the same sort key
+func SortKey(sort []SortField) string {
+	var keys []string
+	for _, f := range sort {
+		if f.Desc {
+			keys = append(keys, "-"+f.Field)
+		} else {
+			keys = append(keys, f.Field)
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+// SortValues returns the values of the sort columns for the product row, used to build the next cursor
+func (p DBProducts) SortValues(sort []SortField) []interface{} {
+	var values []interface{}
+	for _, f := range sort {
+		switch f.Column {
+		case "Id":
+			values = append(values, p.DBID.String)
+		case "Name":
+			values = append(values, p.DBName.String)
+		case "Description":
+			values = append(values, p.DBDescription.String)
+		case "PriceMinor":
+			values = append(values, p.DBPrice.Int64)
+		case "DeliveryPriceMinor":
+			values = append(values, p.DBDeliveryPrice.Int64)
+		}
+	}
+	return values
+}
@@ -1,13 +1,74 @@
 package models
 
+import "time"
+
 type Products struct {
-	Items *[]Product `json:"Items"`
+	Items      *[]Product `json:"Items"`
+	Total      int64      `json:"Total"`
+	NextCursor string     `json:"NextCursor,omitempty"`
+	Links      *PageLinks `json:"Links,omitempty"`
 }
 
 type Product struct {
-	ID            string  `json:"Id"`
-	Name          string  `json:"Name"`
-	Description   string  `json:"Description"`
-	Price         float64 `json:"Price"`
-	DeliveryPrice float64 `json:"DeliveryPrice"`
+	ID            string `json:"Id"`
+	Name          string `json:"Name"`
+	Description   string `json:"Description"`
+	Price         Money  `json:"Price"`
+	DeliveryPrice Money  `json:"DeliveryPrice"`
+	// ISO 4217 code of both prices, DefaultCurrency when it is not specified
+	Currency string `json:"Currency,omitempty"`
+	// Options created along with the product, they are not returned by the product endpoints
+	Options []ProductOption `json:"Options,omitempty"`
+	// Time of the deletion, only set for the products in the trash
+	DeletedAt *time.Time `json:"DeletedAt,omitempty"`
+}
+
+// CreatedProduct holds the ids generated for a product created with its options
+type CreatedProduct struct {
+	ID        string   `json:"Id"`
+	OptionIDs []string `json:"OptionIds"`
+}
+
+// PriceCurrency returns the currency of the prices, DefaultCurrency when the product does not specify it
+func (p *Product) PriceCurrency() string {
+	if p.Currency == "" {
+		return DefaultCurrency
+	}
+	return p.Currency
+}
+
+// NewProduct returns the product of the row, with the time of the deletion when it is in the trash
+func NewProduct(v DBProducts) Product {
+	product := Product{ID: v.DBID.String, Name: v.DBName.String, Description: v.DBDescription.String, Currency: DefaultCurrency}
+	if v.DBCurrency.Valid {
+		product.Currency = v.DBCurrency.String
+	}
+	product.Price = NewMoney(v.DBPrice.Int64, product.Currency)
+	product.DeliveryPrice = NewMoney(v.DBDeliveryPrice.Int64, product.Currency)
+	product.DeletedAt = DeletedAtTime(v.DBDeletedAt)
+	return product
+}
+
+// ChangedFields returns the names of the fields that the product changes in the current product, in the order of the columns
+// Only the stored fields are compared, the id, the options and the time of the deletion are not changed by an update
+// A product without currency keeps the current currency
+func (p *Product) ChangedFields(current Product) []string {
+
+	var fields []string
+	if p.Name != current.Name {
+		fields = append(fields, "Name")
+	}
+	if p.Description != current.Description {
+		fields = append(fields, "Description")
+	}
+	if p.Price.Amount != current.Price.Amount {
+		fields = append(fields, "Price")
+	}
+	if p.DeliveryPrice.Amount != current.DeliveryPrice.Amount {
+		fields = append(fields, "DeliveryPrice")
+	}
+	if p.Currency != "" && p.PriceCurrency() != current.PriceCurrency() {
+		fields = append(fields, "Currency")
+	}
+	return fields
 }
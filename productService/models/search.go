@@ -0,0 +1,106 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// The matched terms are wrapped with the marks in the snippets of the search results
+const (
+	SearchMarkOpen  = "<mark>"
+	SearchMarkClose = "</mark>"
+)
+
+// ErrEmptySearch is returned when the search query has no term to look for
+var ErrEmptySearch = errors.New("q must have at least one word to search for")
+
+// SearchTerm is one term of the search query, a phrase when it has several tokens
+// With Prefix, the last token matches the words starting with it
+type SearchTerm struct {
+	Tokens []string
+	Prefix bool
+}
+
+// SearchQuery holds the terms of the search, a product must match all of them
+type SearchQuery []SearchTerm
+
+// ParseSearchQuery parses the free text search, the syntax is a subset of the FTS5 query syntax
+// `phone case` matches both the words, `"phone case"` the phrase, and `pho*` or `"phone ca"*` the words starting with the prefix
+// Any other character separates the words, so the query is always valid
+func ParseSearchQuery(q string) (SearchQuery, error) {
+
+	var query SearchQuery
+	add := func(text string, prefix bool) {
+		if tokens := SearchTokens(text); len(tokens) > 0 {
+			query = append(query, SearchTerm{Tokens: tokens, Prefix: prefix})
+		}
+	}
+
+	for i := 0; i < len(q); {
+		switch {
+		case q[i] == '"':
+			end := strings.IndexByte(q[i+1:], '"')
+			if end < 0 {
+				end = len(q) - i - 1
+			}
+			phrase := q[i+1 : i+1+end]
+			i += end + 2
+			prefix := i < len(q) && q[i] == '*'
+			if prefix {
+				i++
+			}
+			add(phrase, prefix)
+		case q[i] == ' ' || q[i] == '\t':
+			i++
+		default:
+			end := strings.IndexAny(q[i:], " \t\"")
+			if end < 0 {
+				end = len(q) - i
+			}
+			word := q[i : i+end]
+			i += end
+			add(word, strings.HasSuffix(word, "*"))
+		}
+	}
+
+	if len(query) == 0 {
+		return nil, ErrEmptySearch
+	}
+	return query, nil
+}
+
+// Match returns the FTS5 expression of the query, every term is quoted as the tokens only hold letters and digits
+func (q SearchQuery) Match() string {
+
+	terms := make([]string, 0, len(q))
+	for _, term := range q {
+		match := `"` + strings.Join(term.Tokens, " ") + `"`
+		if term.Prefix {
+			match += "*"
+		}
+		terms = append(terms, match)
+	}
+	return strings.Join(terms, " ")
+}
+
+// SearchTokens splits the text in lower case words the same way as the unicode61 tokenizer of FTS5
+func SearchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// ProductSearchResults is one page of the products matching the search, the best matches first
+type ProductSearchResults struct {
+	Items *[]ProductSearchResult `json:"Items"`
+	Total int64                  `json:"Total"`
+}
+
+// ProductSearchResult is a product matching the search
+// Snippet is the text of the product where the terms matched, Score is higher for the better matches
+type ProductSearchResult struct {
+	Product
+	Snippet string  `json:"Snippet"`
+	Score   float64 `json:"Score"`
+}
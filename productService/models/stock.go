@@ -0,0 +1,68 @@
+package models
+
+import (
+	xError "github.com/techievee/xero/xeroErrors"
+)
+
+// Status of a stock reservation, a reservation is reserved until it is either committed or released
+const (
+	ReservationReserved  = "reserved"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+)
+
+// Stock is the quantity of a product option, the reserved quantity is held for the orders that are not committed yet
+// Version changes at every change of the stock, it is sent back to set the quantity on hand without overwriting a concurrent change
+type Stock struct {
+	OnHand    int64 `json:"OnHand"`
+	Reserved  int64 `json:"Reserved"`
+	Available int64 `json:"Available"`
+	Version   int64 `json:"Version"`
+}
+
+// StockUpdate sets the quantity on hand, the update is refused when Version is set and the stock has changed since
+type StockUpdate struct {
+	OnHand  *int64 `json:"OnHand"`
+	Version *int64 `json:"Version"`
+}
+
+// StockReservation holds a quantity of a product option until it is committed or released
+type StockReservation struct {
+	ID       string `json:"Id"`
+	Quantity int64  `json:"Quantity"`
+	Status   string `json:"Status"`
+}
+
+// NewStock returns the stock of the option with the quantity available computed
+func NewStock(v DBStock) Stock {
+	return Stock{
+		OnHand:    v.DBOnHand.Int64,
+		Reserved:  v.DBReserved.Int64,
+		Available: v.DBOnHand.Int64 - v.DBReserved.Int64,
+		Version:   v.DBVersion.Int64,
+	}
+}
+
+// Validate returns nil when the quantity on hand can be set
+func (s *StockUpdate) Validate() error {
+
+	errs := xError.NewErrorCollection()
+	if s.OnHand == nil {
+		errs.AddError(xError.NewFieldError("/OnHand", xError.FieldRequired, "OnHand is required"))
+	} else if *s.OnHand < 0 {
+		errs.AddError(xError.NewFieldError("/OnHand", xError.FieldNegative, "OnHand cannot be negative"))
+	}
+
+	return validationResult(errs)
+}
+
+// Validate returns nil when the quantity can be reserved
+func (r *StockReservation) Validate() error {
+
+	errs := xError.NewErrorCollection()
+	if r.Quantity <= 0 {
+		errs.AddError(xError.NewFieldError("/Quantity", xError.FieldNotPositive, "Quantity must be greater than zero"))
+	}
+
+	return validationResult(errs)
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	xError "github.com/techievee/xero/xeroErrors"
+	"github.com/techievee/xero/xeroHelper"
+)
+
+// Length of the name of the tenants
+const TenantNameMaxLen = 255
+
+// Tenants is the list of the tenants of the deployment, in the order of their id
+type Tenants struct {
+	Items *[]Tenant `json:"Items"`
+}
+
+// Tenant is one brand of the deployment, every product, option and category belongs to one tenant
+// MaxProducts and MaxOptions are the quotas of the live products and options of the tenant, 0 when unlimited
+// Usage is only returned for a single tenant
+type Tenant struct {
+	ID          string       `json:"Id"`
+	Name        string       `json:"Name"`
+	MaxProducts int64        `json:"MaxProducts"`
+	MaxOptions  int64        `json:"MaxOptions"`
+	CreatedAt   time.Time    `json:"CreatedAt"`
+	Usage       *TenantUsage `json:"Usage,omitempty"`
+}
+
+// TenantUsage is the number of live products and options of the tenant, the rows in the trash are not counted
+type TenantUsage struct {
+	Products int64 `json:"Products"`
+	Options  int64 `json:"Options"`
+}
+
+// Validate returns nil when the tenant can be saved, the id is only checked when it is set, it cannot change once created
+// Otherwise, the returned xeroErrors.ErrorCollection holds one xeroErrors.FieldError per invalid field
+func (p *Tenant) Validate() error {
+
+	errs := xError.NewErrorCollection()
+
+	if p.ID != "" && !xeroHelper.ValidateTenantID(p.ID) {
+		errs.AddError(xError.NewFieldError("/Id", xError.FieldInvalid, "Id must be lower case letters, digits and dashes, at most 63 characters"))
+	}
+	validateText(errs, "/Name", "Name", p.Name, TenantNameMaxLen)
+	if p.MaxProducts < 0 {
+		errs.AddError(xError.NewFieldError("/MaxProducts", xError.FieldNegative, "MaxProducts must not be negative"))
+	}
+	if p.MaxOptions < 0 {
+		errs.AddError(xError.NewFieldError("/MaxOptions", xError.FieldNegative, "MaxOptions must not be negative"))
+	}
+
+	return validationResult(errs)
+}
+
+// NewTenant converts the row of the tenant, without its usage
+func NewTenant(v DBTenants) Tenant {
+	return Tenant{
+		ID:          v.DBID.String,
+		Name:        v.DBName.String,
+		MaxProducts: v.DBMaxProducts.Int64,
+		MaxOptions:  v.DBMaxOptions.Int64,
+		CreatedAt:   time.Unix(0, v.DBCreatedAt.Int64*int64(time.Millisecond)).UTC(),
+	}
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// DeletedAtMillis returns the value of the DeletedAt column for the time of the deletion, milliseconds since the epoch
+func DeletedAtMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// DeletedAtTime returns the time of the deletion of the DeletedAt column, nil when the row is not deleted
+func DeletedAtTime(v sql.NullInt64) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t := time.Unix(0, v.Int64*int64(time.Millisecond)).UTC()
+	return &t
+}
@@ -1,56 +1,92 @@
 package models
 
 import (
-	"errors"
+	"fmt"
 	"strings"
+	"unicode/utf8"
+
+	xError "github.com/techievee/xero/xeroErrors"
+)
+
+// Length of the text columns, a longer value is rejected instead of being cut by the database
+const (
+	ProductNameMaxLen              = 17
+	ProductDescriptionMaxLen       = 35
+	ProductOptionNameMaxLen        = 9
+	ProductOptionDescriptionMaxLen = 23
 )
 
+// Validate returns nil when the product can be saved
+// Otherwise, the returned xeroErrors.ErrorCollection holds one xeroErrors.FieldError per invalid field
 func (p *Product) Validate() error {
 
-	// Validate the sort by field
+	errs := xError.NewErrorCollection()
 
-	var listErr []string
+	validateText(errs, "/Name", "Name", p.Name, ProductNameMaxLen)
+	validateText(errs, "/Description", "Description", p.Description, ProductDescriptionMaxLen)
 
-	if strings.Trim(p.Name, " ") == "" {
-		listErr = append(listErr, "| Name Required |")
-	}
+	validatePrices(errs, p.Price, p.DeliveryPrice)
 
-	if strings.Trim(p.Description, " ") == "" {
-		listErr = append(listErr, "| Description Required |")
+	if p.Currency != "" && !IsValidCurrency(p.Currency) {
+		errs.AddError(xError.NewFieldError("/Currency", xError.FieldInvalid, "Currency must be an ISO 4217 code such as NZD"))
 	}
 
-	if p.Price <= 0 {
-		listErr = append(listErr, "| Price value is required |")
+	for i := range p.Options {
+		p.Options[i].validate(errs, fmt.Sprintf("/Options/%d", i))
 	}
 
-	if p.DeliveryPrice < 0 {
-		listErr = append(listErr, "| Invalid Delivery price |")
-	}
+	return validationResult(errs)
+}
 
-	if len(listErr) != 0 {
-		return errors.New(strings.Join(listErr, ", "))
-	}
+// Validate returns nil when the option can be saved
+// Otherwise, the returned xeroErrors.ErrorCollection holds one xeroErrors.FieldError per invalid field
+func (p *ProductOption) Validate() error {
 
-	return nil
+	errs := xError.NewErrorCollection()
+	p.validate(errs, "")
+
+	return validationResult(errs)
 }
 
-func (p *ProductOption) Validate() error {
+// validate adds the failures of the option to errs, prefix is the pointer of the option in the body
+func (p *ProductOption) validate(errs *xError.ErrorCollection, prefix string) {
+	validateText(errs, prefix+"/Name", "Name", p.Name, ProductOptionNameMaxLen)
+	validateText(errs, prefix+"/Description", "Description", p.Description, ProductOptionDescriptionMaxLen)
+}
 
-	// Validate the sort by field
+// validatePrices checks the price is positive and the delivery price is not negative, both must be exact amounts
+func validatePrices(errs *xError.ErrorCollection, price Money, deliveryPrice Money) {
 
-	var listErr []string
+	if !price.IsValid() {
+		errs.AddError(xError.NewFieldError("/Price", xError.FieldInvalidType, "Price must be a number with at most 2 decimal places"))
+	} else if price.Amount <= 0 {
+		errs.AddError(xError.NewFieldError("/Price", xError.FieldNotPositive, "Price must be greater than 0"))
+	}
 
-	if strings.Trim(p.Name, " ") == "" {
-		listErr = append(listErr, "| Name Required |")
+	if !deliveryPrice.IsValid() {
+		errs.AddError(xError.NewFieldError("/DeliveryPrice", xError.FieldInvalidType, "DeliveryPrice must be a number with at most 2 decimal places"))
+	} else if deliveryPrice.Amount < 0 {
+		errs.AddError(xError.NewFieldError("/DeliveryPrice", xError.FieldNegative, "DeliveryPrice cannot be negative"))
 	}
+}
+
+// validateText checks a required text field fits in its column, the length is counted in characters
+func validateText(errs *xError.ErrorCollection, pointer string, name string, value string, maxLen int) {
 
-	if strings.Trim(p.Description, " ") == "" {
-		listErr = append(listErr, "| Description Required |")
+	if strings.Trim(value, " ") == "" {
+		errs.AddError(xError.NewFieldError(pointer, xError.FieldRequired, name+" is required"))
+		return
 	}
 
-	if len(listErr) != 0 {
-		return errors.New(strings.Join(listErr, ", "))
+	if utf8.RuneCountInString(value) > maxLen {
+		errs.AddError(xError.NewFieldError(pointer, xError.FieldTooLong, fmt.Sprintf("%s must be at most %d characters", name, maxLen)))
 	}
+}
 
-	return nil
+// validationResult avoids returning a typed nil when nothing failed
+func validationResult(errs *xError.ErrorCollection) error {
+	if xError.IsNil(errs) {
+		return nil
+	}
+	return errs
 }
@@ -1,6 +1,11 @@
 package productService
 
 import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
 	"github.com/spf13/viper"
 
 	"github.com/techievee/xero/apiServer"
@@ -10,6 +15,19 @@ import (
 	"github.com/techievee/xero/xeroLog/debugcore"
 )
 
+// Scopes of the products routes, and of the tenants routes of the platform
+const (
+	scopeProductsRead  = "products:read"
+	scopeProductsWrite = "products:write"
+	scopeTenantsAdmin  = "tenants:admin"
+)
+
+// The batch is served on POST /api/products:batch, echo reads the colon as a param so the path is rewritten to the route of the batch
+const (
+	batchPath      = "/api/products:batch"
+	batchRoutePath = "/api/products/batch"
+)
+
 type ProductService struct {
 	Config            *viper.Viper
 	ServiceController *productServiceCtl.ProductsCtl
@@ -17,13 +35,33 @@ type ProductService struct {
 	DB      *database.DB
 	RestAPI *apiServer.APIServer
 	Logger  debugcore.Logger
+
+	// Stops the purge of the trash and tells when it stopped
+	purgeStop chan struct{}
+	purgeDone chan struct{}
 }
 
+// NewProductService returns the product service backed by the database
 func NewProductService(config *viper.Viper, db *database.DB, restAPI *apiServer.APIServer, logger debugcore.Logger) *ProductService {
 
 	// Create a new controller for the Product
 	productsCmds := &productServiceCmds.ProductsCmds{DB: db, Logger: logger}
-	productsCtl := &productServiceCtl.ProductsCtl{ServiceCommands: productsCmds, Logger: logger}
+
+	ps := NewProductServiceWithRepository(config, productsCmds, restAPI, logger)
+	ps.DB = db
+	return ps
+}
+
+// NewProductServiceWithRepository returns the product service backed by any storage
+// Use it with productServiceCmds.NewMemoryCmds to embed the catalogue without a database file
+func NewProductServiceWithRepository(config *viper.Viper, repository productServiceCmds.Repository, restAPI *apiServer.APIServer, logger debugcore.Logger) *ProductService {
+
+	productsCtl := &productServiceCtl.ProductsCtl{ServiceCommands: repository, Logger: logger}
+	if config != nil {
+		productsCtl.DefaultCurrency = strings.ToUpper(config.GetString("app.pricing.default_currency"))
+		productsCtl.BatchChunkSize = config.GetInt("app.batch.chunk_size")
+		productsCtl.BatchMaxOperations = config.GetInt("app.batch.max_operations")
+	}
 
 	return &ProductService{
 		Config:            config,
@@ -37,27 +75,111 @@ func (ps *ProductService) SetupService() {
 
 	ps.Logger.Debug("Product Service Starting")
 	ps.LoadRoutes()
+	ps.LoadMetrics()
+
+	// The in memory catalogue has no dependency to check
+	if ps.DB != nil {
+		ps.LoadHealthChecks()
+	}
 
 }
 
 func (ps *ProductService) LoadRoutes() {
 
 	ps.Logger.Debug("Setting up routes")
-	productsRoute := ps.RestAPI.EchoFramework.Group("/api/products")
+	ps.RestAPI.EchoFramework.Pre(rewriteBatchPath)
+
+	// Reading the catalogue needs products:read, every change needs products:write
+	authorize := ps.RestAPI.Authorize(scopeProductsRead, scopeProductsWrite)
+	// Every read and change of the catalogue is of the tenant of the request
+	tenant := ps.RestAPI.ResolveTenant(scopeTenantsAdmin, ps.ServiceController.TenantExists)
+	productsRoute := ps.RestAPI.EchoFramework.Group("/api/products", authorize, tenant)
 
 	// Products Routes
 	productsRoute.GET("", ps.ServiceController.ShowProducts)
+	productsRoute.GET("/search", ps.ServiceController.SearchProducts)
+	productsRoute.GET("/trash", ps.ServiceController.ShowTrash)
+	productsRoute.GET("/export", ps.ServiceController.ExportProducts)
 	productsRoute.GET("/:id", ps.ServiceController.ShowProduct)
 	productsRoute.POST("", ps.ServiceController.AddNewProduct)
+	productsRoute.POST("/import", ps.ServiceController.ImportProducts)
+	productsRoute.POST("/batch", ps.ServiceController.BatchProducts)
 	productsRoute.PUT("/:id", ps.ServiceController.UpdateProduct)
+	productsRoute.PATCH("/:id", ps.ServiceController.PatchProduct)
 	productsRoute.DELETE("/:id", ps.ServiceController.DeleteProduct)
+	productsRoute.POST("/:id/restore", ps.ServiceController.RestoreProduct)
+	productsRoute.GET("/:id/history", ps.ServiceController.ShowProductHistory)
 
 	// ProductOption Routes
 	productsRoute.GET("/:id/options", ps.ServiceController.ShowProductOptions)
 	productsRoute.GET("/:id/options/:optionId", ps.ServiceController.ShowProductOption)
 	productsRoute.POST("/:id/options", ps.ServiceController.AddNewProductOption)
 	productsRoute.PUT("/:id/options/:optionId", ps.ServiceController.UpdateProductOption)
+	productsRoute.PATCH("/:id/options/:optionId", ps.ServiceController.PatchProductOption)
 	productsRoute.DELETE("/:id/options/:optionId", ps.ServiceController.DeleteProductOption)
+	productsRoute.GET("/:id/options/trash", ps.ServiceController.ShowProductOptionsTrash)
+	productsRoute.POST("/:id/options/:optionId/restore", ps.ServiceController.RestoreProductOption)
+
+	// ProductPrice Routes
+	productsRoute.GET("/:id/prices", ps.ServiceController.ShowProductPrices)
+	productsRoute.PUT("/:id/prices/:currency", ps.ServiceController.SetProductPrice)
+	productsRoute.DELETE("/:id/prices/:currency", ps.ServiceController.DeleteProductPrice)
+
+	// Stock Routes
+	productsRoute.GET("/:id/options/:optionId/stock", ps.ServiceController.ShowStock)
+	productsRoute.PUT("/:id/options/:optionId/stock", ps.ServiceController.SetStock)
+	productsRoute.POST("/:id/options/:optionId/stock/reserve", ps.ServiceController.ReserveStock)
+	productsRoute.POST("/:id/options/:optionId/stock/reservations/:reservationId/commit", ps.ServiceController.CommitStockReservation)
+	productsRoute.POST("/:id/options/:optionId/stock/reservations/:reservationId/release", ps.ServiceController.ReleaseStockReservation)
+
+	// ProductCategory Routes
+	productsRoute.GET("/:id/categories", ps.ServiceController.ShowProductCategories)
+	productsRoute.PUT("/:id/categories/:categoryId", ps.ServiceController.AddProductCategory)
+	productsRoute.DELETE("/:id/categories/:categoryId", ps.ServiceController.DeleteProductCategory)
+
+	// Category Routes
+	categoriesRoute := ps.RestAPI.EchoFramework.Group("/api/categories", authorize, tenant)
+	categoriesRoute.GET("", ps.ServiceController.ShowCategories)
+	categoriesRoute.GET("/:id", ps.ServiceController.ShowCategory)
+	categoriesRoute.POST("", ps.ServiceController.AddNewCategory)
+	categoriesRoute.PUT("/:id", ps.ServiceController.UpdateCategory)
+	categoriesRoute.DELETE("/:id", ps.ServiceController.DeleteCategory)
+
+	// Tenant Routes, onboarding and removal of the tenants are only for the principals of the platform
+	tenantsRoute := ps.RestAPI.EchoFramework.Group("/api/tenants", ps.RestAPI.Authorize(scopeTenantsAdmin, scopeTenantsAdmin), ps.RestAPI.PlatformOnly)
+	tenantsRoute.GET("", ps.ServiceController.ShowTenants)
+	tenantsRoute.GET("/:id", ps.ServiceController.ShowTenant)
+	tenantsRoute.POST("", ps.ServiceController.AddNewTenant)
+	tenantsRoute.PUT("/:id", ps.ServiceController.UpdateTenant)
+	tenantsRoute.DELETE("/:id", ps.ServiceController.DeleteTenant)
 
 	ps.Logger.Debug("Routes were successfully configured")
 }
+
+// LoadHealthChecks registers the database connections and the schema as dependencies of the readiness
+func (ps *ProductService) LoadHealthChecks() {
+
+	for _, label := range []string{"readwrite-db", "readonly-db"} {
+		label := label
+		ps.RestAPI.AddReadinessCheck(label, func(ctx context.Context) error {
+			return ps.DB.Ping(ctx, label)
+		})
+	}
+
+	ps.RestAPI.AddReadinessCheck("schema", func(ctx context.Context) error {
+		return ps.DB.CheckTables(ctx, "readonly-db")
+	})
+}
+
+// rewriteBatchPath routes POST /api/products:batch to the batch, before the router reads the path
+// Only the exact path is rewritten, no other action after /api/products is served
+func rewriteBatchPath(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request := c.Request()
+		if request.Method == http.MethodPost && request.URL.Path == batchPath {
+			request.URL.Path = batchRoutePath
+			request.URL.RawPath = ""
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,94 @@
+package productService
+
+import (
+	"context"
+	"time"
+
+	"github.com/techievee/xero/xeroHelper"
+)
+
+const defaultPurgeInterval = time.Hour
+
+// TrashRetention is the time the deleted products and options stay in the trash, from app.trash.retention_days
+// Zero keeps them until they are restored
+func (ps *ProductService) TrashRetention() time.Duration {
+	if ps.Config == nil {
+		return 0
+	}
+	return time.Duration(ps.Config.GetInt("app.trash.retention_days")) * 24 * time.Hour
+}
+
+// StartTrashPurge purges the trash at startup and then at every app.trash.purge_interval, until StopTrashPurge
+// Nothing is started when the trash has no retention
+func (ps *ProductService) StartTrashPurge() {
+
+	if ps.TrashRetention() <= 0 {
+		ps.Logger.Info("The trash is never purged, app.trash.retention_days is not set")
+		return
+	}
+
+	interval := defaultPurgeInterval
+	if ps.Config.IsSet("app.trash.purge_interval") {
+		interval = ps.Config.GetDuration("app.trash.purge_interval")
+	}
+	if interval <= 0 {
+		interval = defaultPurgeInterval
+	}
+
+	ps.purgeStop = make(chan struct{})
+	ps.purgeDone = make(chan struct{})
+	go func() {
+		defer close(ps.purgeDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			ps.PurgeTrash(context.Background())
+			select {
+			case <-ticker.C:
+			case <-ps.purgeStop:
+				return
+			}
+		}
+	}()
+}
+
+// StopTrashPurge stops the purge of the trash and waits for the purge in progress
+func (ps *ProductService) StopTrashPurge() {
+	if ps.purgeStop == nil {
+		return
+	}
+	close(ps.purgeStop)
+	<-ps.purgeDone
+	ps.purgeStop = nil
+}
+
+// PurgeTrash removes for good the products and options deleted for longer than the retention of the trash, of every tenant
+func (ps *ProductService) PurgeTrash(ctx context.Context) (int64, int64, error) {
+
+	retention := ps.TrashRetention()
+	if retention <= 0 {
+		return 0, 0, nil
+	}
+
+	tenants, err := ps.ServiceController.ServiceCommands.FetchTenants(ctx)
+	if err != nil {
+		ps.Logger.Error("Error while purging the trash", "error", err)
+		return 0, 0, err
+	}
+
+	var products, options int64
+	cutoff := time.Now().Add(-retention)
+	for _, tenant := range tenants {
+		tenantProducts, tenantOptions, err := ps.ServiceController.ServiceCommands.PurgeDeleted(xeroHelper.WithTenant(ctx, tenant.DBID.String), cutoff)
+		products += tenantProducts
+		options += tenantOptions
+		if err != nil {
+			ps.Logger.Error("Error while purging the trash", "tenant", tenant.DBID.String, "products", products, "options", options, "error", err)
+			return products, options, err
+		}
+	}
+	if products > 0 || options > 0 {
+		ps.Logger.Info("Purged the trash", "products", products, "options", options)
+	}
+	return products, options, nil
+}
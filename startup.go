@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/labstack/echo"
 	"github.com/spf13/viper"
+	"go.elastic.co/apm"
 
 	"github.com/techievee/xero/apiServer"
 	"github.com/techievee/xero/database"
@@ -46,6 +52,11 @@ func main() {
 	xeroLogger := xeroLog.NewLogger(env, xeroLog.WithServiceName("xero-api"))
 	xeroLogger.Debug("xeroLogger successfuly configured")
 
+	// Schema migration commands, runs the command and exits without serving
+	if action := flag.Lookup("migrate").Value.String(); action != "" {
+		os.Exit(runMigrations(config, action, xeroLogger))
+	}
+
 	// Database initialization
 	xeroLogger.Debug("Initializing the DB")
 	db := database.NewDB(config, "mysqlite", xeroLogger)
@@ -58,23 +69,128 @@ func main() {
 	// Starting the API framework for serving the Prodcut
 	xeroLogger.Debug("Initializing the Rest Framework")
 	restAPI := apiServer.NewRestAPI(env, config, xeroLogger)
+	if err := restAPI.LoadAuth(); err != nil {
+		xeroLogger.Error("Error loading the authentication", "error", err)
+		os.Exit(1)
+	}
+	if err := restAPI.LoadTenancy(); err != nil {
+		xeroLogger.Error("Error loading the tenancy", "error", err)
+		os.Exit(1)
+	}
 
 	xeroLogger.Debug("Starting Products API Service")
-	startProductsService(config, db, restAPI, xeroLogger)
+	ps := startProductsService(config, db, restAPI, xeroLogger)
 
-	go restAPI.StartServer()
+	// All the listeners report their failure, a failed listener stops the whole service
+	serverErr := make(chan error, 3)
+	go func() { serverErr <- restAPI.StartServer() }()
 
-	tlsEnabled := config.Get("app.service.tls.enabled").(bool)
+	tlsEnabled := config.GetBool("app.service.tls.enabled")
 	if tlsEnabled {
-		go restAPI.StartTLSServer()
+		go func() { serverErr <- restAPI.StartTLSServer() }()
+	}
+
+	if restAPI.AdminEnabled() {
+		go func() { serverErr <- restAPI.StartAdminServer() }()
+	}
+
+	exitCode := 0
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case sig := <-quit:
+		xeroLogger.Info("Shutting down the service", "signal", sig.String())
+	case err := <-serverErr:
+		// A listener returns without error only once the server is shutdown, which has not been requested yet
+		xeroLogger.Error("Listener failed, shutting down the service", "error", err)
+		exitCode = 1
+	}
+
+	os.Exit(shutdown(restAPI, ps, db, xeroLogger, exitCode))
+
+}
+
+// Drains the in-flight requests, stops the background jobs, closes the database pools and flushes the APM events
+// Returns the exit code of the process
+func shutdown(restAPI *apiServer.APIServer, ps *productService.ProductService, db *database.DB, logger debugcore.Logger, exitCode int) int {
+
+	// Readiness fails first, so no new request is routed to the service while it drains
+	restAPI.Drain()
+	time.Sleep(restAPI.DrainDelay())
+
+	ctx, cancel := context.WithTimeout(context.Background(), restAPI.ShutdownTimeout())
+	defer cancel()
+
+	if err := restAPI.Shutdown(ctx); err != nil {
+		logger.Error("Error while shutting down the server", "error", err)
+		exitCode = 1
+	}
+
+	ps.StopTrashPurge()
+
+	if err := db.Close(); err != nil {
+		exitCode = 1
 	}
 
-	quit := make(chan bool)
-	<-quit
+	// The APM events still buffered are sent within the remaining drain time
+	apm.DefaultTracer.Flush(ctx.Done())
+	apm.DefaultTracer.Close()
 
+	logger.Info("Service stopped", "exit_code", exitCode)
+	return exitCode
 }
 
-func startProductsService(config *viper.Viper, db *database.DB, restAPI *apiServer.APIServer, logger debugcore.Logger) {
+func startProductsService(config *viper.Viper, db *database.DB, restAPI *apiServer.APIServer, logger debugcore.Logger) *productService.ProductService {
 	ps := productService.NewProductService(config, db, restAPI, logger)
 	ps.SetupService()
+	ps.StartTrashPurge()
+	return ps
+}
+
+// Runs the schema migration command (up, down or status) and returns the exit code
+func runMigrations(config *viper.Viper, action string, logger debugcore.Logger) int {
+
+	ctx := context.Background()
+	db := database.OpenDB(config, "mysqlite", logger)
+	migrator, err := db.Migrator(ctx)
+	if err != nil {
+		logger.Error("Error initializing the migrations", "error", err)
+		return 1
+	}
+
+	switch action {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			logger.Error("Error applying the migrations", "applied", applied, "error", err)
+			return 1
+		}
+		logger.Info("Migrations applied", "applied", applied)
+	case "down":
+		reverted, err := migrator.Down(ctx, 1)
+		if err != nil {
+			logger.Error("Error reverting the migration", "error", err)
+			return 1
+		}
+		logger.Info("Migrations reverted", "reverted", reverted)
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			logger.Error("Error reading the migrations", "error", err)
+			return 1
+		}
+		for _, s := range status {
+			if s.Applied {
+				logger.Info("Migration applied", "version", s.Version, "name", s.Name, "applied_at", s.AppliedAt)
+			} else {
+				logger.Info("Migration pending", "version", s.Version, "name", s.Name)
+			}
+		}
+	default:
+		logger.Error("Unknown migrate command, expected up, down or status", "command", action)
+		return 1
+	}
+
+	return 0
 }
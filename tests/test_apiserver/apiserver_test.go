@@ -0,0 +1,129 @@
+package test_apiserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+
+	"github.com/techievee/xero/apiServer"
+	"github.com/techievee/xero/xeroLog/debugcore"
+)
+
+func newTestServer(t *testing.T, shutdownTimeout string) (*apiServer.APIServer, string) {
+
+	config := viper.New()
+	config.Set("app.service.host", "127.0.0.1")
+	config.Set("app.service.port", "0")
+	config.Set("app.service.shutdown_timeout", shutdownTimeout)
+
+	restAPI := apiServer.NewRestAPI("test", config, &debugcore.NoOpsLogger{})
+	restAPI.EchoFramework.HidePort = true
+
+	// The listener is opened up front, so the address is known before serving
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restAPI.EchoFramework.Listener = listener
+
+	return restAPI, "http://" + listener.Addr().String()
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+
+	restAPI, url := newTestServer(t, "5s")
+	started := make(chan struct{})
+	restAPI.EchoFramework.GET("/slow", func(c echo.Context) error {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		return c.String(http.StatusOK, "done")
+	})
+
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- restAPI.StartServer() }()
+
+	response := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			response <- 0
+			return
+		}
+		resp.Body.Close()
+		response <- resp.StatusCode
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), restAPI.ShutdownTimeout())
+	defer cancel()
+	if err := restAPI.Shutdown(ctx); err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+
+	if code := <-response; code != http.StatusOK {
+		t.Errorf("Expected the in-flight request to complete, got %d", code)
+	}
+	if err := <-serverErr; err != nil {
+		t.Errorf("Expected the server to stop without error, got %v", err)
+	}
+
+}
+
+func TestShutdownTimeout(t *testing.T) {
+
+	restAPI, url := newTestServer(t, "50ms")
+	if restAPI.ShutdownTimeout() != 50*time.Millisecond {
+		t.Errorf("Wrong shutdown timeout %v", restAPI.ShutdownTimeout())
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	restAPI.EchoFramework.GET("/stuck", func(c echo.Context) error {
+		close(started)
+		<-release
+		return c.NoContent(http.StatusOK)
+	})
+
+	go restAPI.StartServer()
+	go http.Get(url + "/stuck")
+	<-started
+
+	// The stuck request is not waited for beyond the drain timeout
+	ctx, cancel := context.WithTimeout(context.Background(), restAPI.ShutdownTimeout())
+	defer cancel()
+	if err := restAPI.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected the drain to time out, got %v", err)
+	}
+
+}
+
+func TestStartServerBindFailure(t *testing.T) {
+
+	// The port is already taken
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	config := viper.New()
+	config.Set("app.service.host", "127.0.0.1")
+	config.Set("app.service.port", taken.Addr().(*net.TCPAddr).Port)
+
+	restAPI := apiServer.NewRestAPI("test", config, &debugcore.NoOpsLogger{})
+	restAPI.EchoFramework.HidePort = true
+	if err := restAPI.StartServer(); err == nil {
+		t.Errorf("Expected the bind failure to be returned")
+	}
+
+	if restAPI.ShutdownTimeout() != 15*time.Second {
+		t.Errorf("Expected the default shutdown timeout, got %v", restAPI.ShutdownTimeout())
+	}
+
+}
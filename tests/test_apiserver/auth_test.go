@@ -0,0 +1,297 @@
+package test_apiserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+
+	"github.com/techievee/xero/apiServer"
+	"github.com/techievee/xero/xeroHelper"
+	"github.com/techievee/xero/xeroLog/debugcore"
+)
+
+const authConfig = `
+auth:
+  enabled: true
+  api_keys:
+    - name: "reader"
+      key: "read-key"
+      scopes: ["products:read"]
+    - name: "merchandising"
+      key: "write-key"
+      scopes: ["products:read", "products:write"]
+  jwt:
+    secret: "hs256-secret"
+    jwks_file: "%s"
+    issuer: "https://issuer.example"
+    audience: "xero"
+`
+
+// newAuthServer returns a server with the auth config read the same way as the config files, and the RSA key of its JWKS file
+func newAuthServer(t *testing.T) (*apiServer.APIServer, *rsa.PrivateKey) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	jwks := filepath.Join(dir, "jwks.json")
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	content := fmt.Sprintf(`{"keys": [{"kty": "EC", "kid": "ec"}, {"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": "%s", "e": "%s"}]}`,
+		encode(key.N.Bytes()), encode(big.NewInt(int64(key.E)).Bytes()))
+	if err := ioutil.WriteFile(jwks, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	file := viper.New()
+	file.SetConfigType("yaml")
+	if err := file.ReadConfig(bytes.NewBufferString(fmt.Sprintf(authConfig, filepath.ToSlash(jwks)))); err != nil {
+		t.Fatal(err)
+	}
+	config := viper.New()
+	config.Set("app", file.AllSettings())
+
+	restAPI := apiServer.NewRestAPI("test", config, &debugcore.NoOpsLogger{})
+	if err := restAPI.LoadAuth(); err != nil {
+		t.Fatal(err)
+	}
+
+	routes := restAPI.EchoFramework.Group("/api/products", restAPI.Authorize("products:read", "products:write"))
+	actor := func(c echo.Context) error {
+		return c.String(http.StatusOK, xeroHelper.ActorFrom(c.Request().Context()).Name)
+	}
+	routes.GET("", actor)
+	routes.POST("", actor)
+
+	return restAPI, key
+}
+
+func token(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	tok := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	signed, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestAuthorize(t *testing.T) {
+
+	restAPI, key := newAuthServer(t)
+	claims := func(changes jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{"sub": "alice", "iss": "https://issuer.example", "aud": []string{"other", "xero"},
+			"exp": time.Now().Add(time.Hour).Unix(), "scope": "products:read products:write"}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	for _, test := range []struct {
+		name   string
+		method string
+		header string
+		value  string
+		code   int
+		actor  string
+	}{
+		{"anonymous", http.MethodGet, "", "", http.StatusUnauthorized, ""},
+		{"read key", http.MethodGet, apiServer.HeaderAPIKey, "read-key", http.StatusOK, "reader"},
+		{"read key change", http.MethodPost, apiServer.HeaderAPIKey, "read-key", http.StatusForbidden, ""},
+		{"write key change", http.MethodPost, apiServer.HeaderAPIKey, "write-key", http.StatusOK, "merchandising"},
+		{"unknown key", http.MethodGet, apiServer.HeaderAPIKey, "other-key", http.StatusUnauthorized, ""},
+		{"hs256", http.MethodPost, echo.HeaderAuthorization, "Bearer " + token(t, jwt.SigningMethodHS256, []byte("hs256-secret"), "", claims(nil)), http.StatusOK, "alice"},
+		{"hs256 other secret", http.MethodGet, echo.HeaderAuthorization, "Bearer " + token(t, jwt.SigningMethodHS256, []byte("other"), "", claims(nil)), http.StatusUnauthorized, ""},
+		{"rs256", http.MethodGet, echo.HeaderAuthorization, "Bearer " + token(t, jwt.SigningMethodRS256, key, "rsa-1", claims(jwt.MapClaims{"scope": nil, "scp": []string{"products:read"}})), http.StatusOK, "alice"},
+		{"rs256 read scope change", http.MethodPost, echo.HeaderAuthorization, "Bearer " + token(t, jwt.SigningMethodRS256, key, "rsa-1", claims(jwt.MapClaims{"scope": "products:read"})), http.StatusForbidden, ""},
+		{"rs256 unknown kid", http.MethodGet, echo.HeaderAuthorization, "Bearer " + token(t, jwt.SigningMethodRS256, key, "rsa-2", claims(nil)), http.StatusUnauthorized, ""},
+		{"rs256 as hs256", http.MethodGet, echo.HeaderAuthorization, "Bearer " + token(t, jwt.SigningMethodHS256, []byte(key.PublicKey.N.String()), "rsa-1", claims(nil)), http.StatusUnauthorized, ""},
+		{"none", http.MethodGet, echo.HeaderAuthorization, "Bearer " + token(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", claims(nil)), http.StatusUnauthorized, ""},
+		{"expired", http.MethodGet, echo.HeaderAuthorization, "Bearer " + token(t, jwt.SigningMethodHS256, []byte("hs256-secret"), "", claims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})), http.StatusUnauthorized, ""},
+		{"no expiry", http.MethodGet, echo.HeaderAuthorization, "Bearer " + token(t, jwt.SigningMethodHS256, []byte("hs256-secret"), "", claims(jwt.MapClaims{"exp": nil})), http.StatusUnauthorized, ""},
+		{"other issuer", http.MethodGet, echo.HeaderAuthorization, "Bearer " + token(t, jwt.SigningMethodHS256, []byte("hs256-secret"), "", claims(jwt.MapClaims{"iss": "https://other.example"})), http.StatusUnauthorized, ""},
+		{"other audience", http.MethodGet, echo.HeaderAuthorization, "Bearer " + token(t, jwt.SigningMethodHS256, []byte("hs256-secret"), "", claims(jwt.MapClaims{"aud": "other"})), http.StatusUnauthorized, ""},
+		{"no subject", http.MethodGet, echo.HeaderAuthorization, "Bearer " + token(t, jwt.SigningMethodHS256, []byte("hs256-secret"), "", claims(jwt.MapClaims{"sub": nil})), http.StatusUnauthorized, ""},
+		{"basic", http.MethodGet, echo.HeaderAuthorization, "Basic YWxpY2U6c2VjcmV0", http.StatusUnauthorized, ""},
+	} {
+		request := httptest.NewRequest(test.method, "/api/products", nil)
+		if test.header != "" {
+			request.Header.Set(test.header, test.value)
+		}
+		responseRecorder := httptest.NewRecorder()
+		restAPI.EchoFramework.ServeHTTP(responseRecorder, request)
+
+		if responseRecorder.Code != test.code {
+			t.Errorf("Expected %d for %s, got %d %v", test.code, test.name, responseRecorder.Code, responseRecorder.Body.String())
+			continue
+		}
+		switch test.code {
+		case http.StatusOK:
+			if responseRecorder.Body.String() != test.actor {
+				t.Errorf("Expected the actor %s for %s, got %v", test.actor, test.name, responseRecorder.Body.String())
+			}
+		case http.StatusUnauthorized:
+			if !strings.Contains(responseRecorder.Body.String(), "/problems/unauthorized") || responseRecorder.Header().Get(echo.HeaderWWWAuthenticate) == "" {
+				t.Errorf("Expected the unauthorized problem for %s, got %v", test.name, responseRecorder.Body.String())
+			}
+		case http.StatusForbidden:
+			if !strings.Contains(responseRecorder.Body.String(), "/problems/access_denied") {
+				t.Errorf("Expected the access denied problem for %s, got %v", test.name, responseRecorder.Body.String())
+			}
+		}
+	}
+
+	// The probes stay anonymous
+	if responseRecorder := serve(restAPI, http.MethodGet, "/healthz"); responseRecorder.Code != http.StatusOK {
+		t.Errorf("Expected the health anonymous, got %d", responseRecorder.Code)
+	}
+
+}
+
+func TestLoadAuth(t *testing.T) {
+
+	for _, test := range []struct {
+		name     string
+		settings map[string]interface{}
+		valid    bool
+	}{
+		{"disabled", map[string]interface{}{"app.auth.enabled": false}, true},
+		{"nothing", map[string]interface{}{"app.auth.enabled": true}, false},
+		{"key without name", map[string]interface{}{"app.auth.enabled": true, "app.auth.api_keys": []map[string]interface{}{{"key": "k"}}}, false},
+		{"missing JWKS file", map[string]interface{}{"app.auth.enabled": true, "app.auth.jwt.jwks_file": "./missing.json"}, false},
+		{"key of an invalid tenant", map[string]interface{}{"app.auth.enabled": true, "app.auth.api_keys": []map[string]interface{}{{"name": "n", "key": "k", "tenant": "Not valid"}}}, false},
+		{"secret", map[string]interface{}{"app.auth.enabled": true, "app.auth.jwt.secret": "s"}, true},
+	} {
+		config := viper.New()
+		for k, v := range test.settings {
+			config.Set(k, v)
+		}
+		err := apiServer.NewRestAPI("test", config, &debugcore.NoOpsLogger{}).LoadAuth()
+		if (err == nil) != test.valid {
+			t.Errorf("Expected %s valid %v, got %v", test.name, test.valid, err)
+		}
+	}
+
+	// Without authenticator the routes are anonymous
+	restAPI := apiServer.NewRestAPI("test", viper.New(), &debugcore.NoOpsLogger{})
+	restAPI.EchoFramework.POST("/api/products", func(c echo.Context) error {
+		return c.String(http.StatusOK, xeroHelper.ActorFrom(c.Request().Context()).Name)
+	}, restAPI.Authorize("products:read", "products:write"))
+	if responseRecorder := serve(restAPI, http.MethodPost, "/api/products"); responseRecorder.Code != http.StatusOK || responseRecorder.Body.String() != xeroHelper.AnonymousActor {
+		t.Errorf("Expected the anonymous actor, got %d %v", responseRecorder.Code, responseRecorder.Body.String())
+	}
+
+}
+
+func TestPolicy(t *testing.T) {
+
+	// The policy shipped with the service, read the same way as the config files
+	policyFile, err := ioutil.ReadFile("../../config/rbac.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := viper.New()
+	file.SetConfigType("yaml")
+	if err := file.ReadConfig(bytes.NewBuffer(policyFile)); err != nil {
+		t.Fatal(err)
+	}
+	config := viper.New()
+	config.Set("rbac", file.AllSettings())
+	config.Set("app.auth.enabled", true)
+	config.Set("app.auth.api_keys", []map[string]interface{}{
+		{"name": "viewer", "key": "viewer-key", "scopes": []string{"products:read"}, "roles": []string{"viewer"}},
+		{"name": "editor", "key": "editor-key", "scopes": []string{"products:read", "products:write"}, "roles": []string{"editor"}},
+		{"name": "pricing", "key": "pricing-key", "scopes": []string{"products:read", "products:write"}, "roles": []string{"pricing-manager"}},
+		{"name": "both", "key": "both-key", "scopes": []string{"products:read", "products:write"}, "roles": []string{"Editor", "pricing-manager"}},
+		{"name": "admin", "key": "admin-key", "scopes": []string{"products:read", "products:write"}, "roles": []string{"admin"}},
+		{"name": "nobody", "key": "nobody-key", "scopes": []string{"products:read", "products:write"}},
+	})
+
+	restAPI := apiServer.NewRestAPI("test", config, &debugcore.NoOpsLogger{})
+	if err := restAPI.LoadAuth(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The routes tell the fields of the product the caller may change
+	fields := func(c echo.Context) error {
+		principal, _ := xeroHelper.PrincipalFrom(c.Request().Context())
+		var may []string
+		for _, field := range []string{"Name", "Price"} {
+			if principal.MayChange("product", field) {
+				may = append(may, field)
+			}
+		}
+		return c.String(http.StatusOK, strings.Join(may, ","))
+	}
+	routes := restAPI.EchoFramework.Group("/api/products", restAPI.Authorize("products:read", "products:write"))
+	routes.GET("/:id", fields)
+	routes.PUT("/:id", fields)
+	routes.POST("/batch", fields)
+	routes.PUT("/:id/prices/:currency", fields)
+
+	for _, test := range []struct {
+		key    string
+		method string
+		path   string
+		code   int
+		fields string
+	}{
+		{"viewer-key", http.MethodGet, "/api/products/1", http.StatusOK, ""},
+		{"viewer-key", http.MethodPut, "/api/products/1", http.StatusForbidden, ""},
+		{"editor-key", http.MethodPut, "/api/products/1", http.StatusOK, "Name"},
+		{"editor-key", http.MethodPost, "/api/products/batch", http.StatusOK, "Name"},
+		{"editor-key", http.MethodPut, "/api/products/1/prices/USD", http.StatusForbidden, ""},
+		{"pricing-key", http.MethodPut, "/api/products/1", http.StatusOK, "Price"},
+		{"pricing-key", http.MethodPut, "/api/products/1/prices/USD", http.StatusOK, "Price"},
+		{"both-key", http.MethodPut, "/api/products/1", http.StatusOK, "Name,Price"},
+		{"admin-key", http.MethodPut, "/api/products/1/prices/USD", http.StatusOK, "Name"},
+		{"nobody-key", http.MethodGet, "/api/products/1", http.StatusForbidden, ""},
+	} {
+		request := httptest.NewRequest(test.method, test.path, nil)
+		request.Header.Set(apiServer.HeaderAPIKey, test.key)
+		responseRecorder := httptest.NewRecorder()
+		restAPI.EchoFramework.ServeHTTP(responseRecorder, request)
+
+		if responseRecorder.Code != test.code {
+			t.Errorf("Expected %d for %s %s %s, got %d %v", test.code, test.key, test.method, test.path, responseRecorder.Code, responseRecorder.Body.String())
+		} else if test.code == http.StatusOK && responseRecorder.Body.String() != test.fields {
+			t.Errorf("Expected the fields %s for %s %s %s, got %v", test.fields, test.key, test.method, test.path, responseRecorder.Body.String())
+		} else if test.code == http.StatusForbidden && !strings.Contains(responseRecorder.Body.String(), "/problems/access_denied") {
+			t.Errorf("Expected the access denied problem for %s %s %s, got %v", test.key, test.method, test.path, responseRecorder.Body.String())
+		}
+	}
+
+	// A typo in a route refuses the policy
+	config.Set("rbac", map[string]interface{}{"roles": map[string]interface{}{"viewer": map[string]interface{}{"routes": []string{"GET/api/products"}}}})
+	if err := apiServer.NewRestAPI("test", config, &debugcore.NoOpsLogger{}).LoadAuth(); err == nil {
+		t.Errorf("Expected the invalid route refused")
+	}
+
+}
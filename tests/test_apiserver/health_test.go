@@ -0,0 +1,68 @@
+package test_apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/techievee/xero/apiServer"
+	"github.com/techievee/xero/xeroLog/debugcore"
+)
+
+func readyz(t *testing.T, restAPI *apiServer.APIServer, path string) (int, apiServer.HealthStatus) {
+
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	responseRecorder := httptest.NewRecorder()
+	restAPI.EchoFramework.ServeHTTP(responseRecorder, request)
+
+	status := apiServer.HealthStatus{}
+	if err := json.Unmarshal(responseRecorder.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Invalid health response %v", responseRecorder.Body.String())
+	}
+	return responseRecorder.Code, status
+}
+
+func TestReadyz(t *testing.T) {
+
+	restAPI := apiServer.NewRestAPI("test", viper.New(), &debugcore.NoOpsLogger{})
+	restAPI.AddReadinessCheck("db", func(context.Context) error { return nil })
+
+	code, status := readyz(t, restAPI, "/readyz")
+	if code != http.StatusOK || status.Status != "ok" || len(status.Checks) != 1 || status.Checks[0].Name != "db" {
+		t.Errorf("Expected the service to be ready, got %d %+v", code, status)
+	}
+
+	// One failing dependency fails the readiness, the others are still reported
+	restAPI.AddReadinessCheck("cache", func(context.Context) error { return errors.New("unreachable") })
+	code, status = readyz(t, restAPI, "/readyz")
+	if code != http.StatusServiceUnavailable || status.Status != "failing" || len(status.Checks) != 2 {
+		t.Errorf("Expected the service not to be ready, got %d %+v", code, status)
+	}
+	if status.Checks[0].Status != "ok" || status.Checks[1].Status != "failing" || status.Checks[1].Error != "unreachable" {
+		t.Errorf("Wrong dependency status %+v", status.Checks)
+	}
+
+}
+
+func TestReadyzDraining(t *testing.T) {
+
+	restAPI := apiServer.NewRestAPI("test", viper.New(), &debugcore.NoOpsLogger{})
+	restAPI.AddReadinessCheck("db", func(context.Context) error { return nil })
+	restAPI.Drain()
+
+	code, status := readyz(t, restAPI, "/readyz")
+	if code != http.StatusServiceUnavailable || status.Status != "draining" {
+		t.Errorf("Expected the readiness to fail while draining, got %d %+v", code, status)
+	}
+
+	// The process is still alive
+	if code, status = readyz(t, restAPI, "/healthz"); code != http.StatusOK || status.Status != "ok" {
+		t.Errorf("Expected the process to be alive, got %d %+v", code, status)
+	}
+
+}
@@ -0,0 +1,78 @@
+package test_apiserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+
+	"github.com/techievee/xero/apiServer"
+	"github.com/techievee/xero/xeroLog/debugcore"
+)
+
+func serve(restAPI *apiServer.APIServer, method string, path string) *httptest.ResponseRecorder {
+	responseRecorder := httptest.NewRecorder()
+	restAPI.EchoFramework.ServeHTTP(responseRecorder, httptest.NewRequest(method, path, nil))
+	return responseRecorder
+}
+
+func TestMetrics(t *testing.T) {
+
+	restAPI := apiServer.NewRestAPI("test", viper.New(), &debugcore.NoOpsLogger{})
+	restAPI.EchoFramework.GET("/api/products/:id", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusNotFound)
+	})
+
+	serve(restAPI, http.MethodGet, "/healthz")
+	serve(restAPI, http.MethodGet, "/api/products/1")
+	serve(restAPI, http.MethodGet, "/api/products/2")
+
+	responseRecorder := serve(restAPI, http.MethodGet, "/metrics")
+	if responseRecorder.Code != http.StatusOK {
+		t.Fatalf("Expected the metrics, got %d", responseRecorder.Code)
+	}
+
+	// The requests are labelled by route template, and by the status rendered for the returned errors
+	body := responseRecorder.Body.String()
+	for _, expected := range []string{
+		`xero_http_requests_total{method="GET",route="/healthz",status="200"} 1`,
+		`xero_http_requests_total{method="GET",route="/api/products/:id",status="404"} 2`,
+		`xero_http_request_duration_seconds_count{method="GET",route="/api/products/:id",status="404"} 2`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected %s in the metrics", expected)
+		}
+	}
+
+}
+
+func TestMetricsOnAdminPort(t *testing.T) {
+
+	config := viper.New()
+	config.Set("app.admin.enabled", true)
+	restAPI := apiServer.NewRestAPI("test", config, &debugcore.NoOpsLogger{})
+
+	// The metrics are not exposed with the API
+	if code := serve(restAPI, http.MethodGet, "/metrics").Code; code != http.StatusNotFound {
+		t.Errorf("Expected no metrics on the API port, got %d", code)
+	}
+
+	adminServer := httptest.NewServer(restAPI.AdminServer.Handler)
+	defer adminServer.Close()
+
+	resp, err := http.Get(adminServer.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "go_goroutines") {
+		t.Errorf("Expected the metrics on the admin port, got %d", resp.StatusCode)
+	}
+
+}
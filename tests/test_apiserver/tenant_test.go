@@ -0,0 +1,127 @@
+package test_apiserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+
+	"github.com/techievee/xero/apiServer"
+	"github.com/techievee/xero/xeroHelper"
+	"github.com/techievee/xero/xeroLog/debugcore"
+)
+
+// newTenantServer returns a server knowing the tenants default, acme and other, its routes answer the tenant of the request
+func newTenantServer(t *testing.T, enabled bool) *apiServer.APIServer {
+
+	config := viper.New()
+	config.Set("app.auth.enabled", true)
+	config.Set("app.auth.api_keys", []map[string]interface{}{
+		{"name": "acme", "key": "acme-key", "scopes": []string{"products:read", "tenants:admin"}, "tenant": "acme"},
+		{"name": "platform", "key": "platform-key", "scopes": []string{"products:read", "tenants:admin"}},
+		{"name": "reader", "key": "reader-key", "scopes": []string{"products:read"}},
+	})
+	config.Set("app.auth.jwt.secret", "hs256-secret")
+	config.Set("app.tenancy.enabled", enabled)
+	config.Set("app.tenancy.domain", "shop.example")
+
+	restAPI := apiServer.NewRestAPI("test", config, &debugcore.NoOpsLogger{})
+	if err := restAPI.LoadAuth(); err != nil {
+		t.Fatal(err)
+	}
+	if err := restAPI.LoadTenancy(); err != nil {
+		t.Fatal(err)
+	}
+
+	known := func(_ context.Context, id string) (bool, error) {
+		return id == xeroHelper.DefaultTenant || id == "acme" || id == "other", nil
+	}
+	tenant := func(c echo.Context) error {
+		return c.String(http.StatusOK, xeroHelper.TenantFrom(c.Request().Context()))
+	}
+	restAPI.EchoFramework.GET("/api/products", tenant, restAPI.Authorize("products:read", "products:write"), restAPI.ResolveTenant("tenants:admin", known))
+	restAPI.EchoFramework.GET("/api/tenants", tenant, restAPI.Authorize("tenants:admin", "tenants:admin"), restAPI.PlatformOnly)
+	return restAPI
+}
+
+func TestResolveTenant(t *testing.T) {
+
+	bearer := func(claims jwt.MapClaims) string {
+		claims["sub"] = "alice"
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		claims["scope"] = "products:read"
+		return "Bearer " + token(t, jwt.SigningMethodHS256, []byte("hs256-secret"), "", claims)
+	}
+
+	for _, test := range []struct {
+		name    string
+		enabled bool
+		path    string
+		headers map[string]string
+		host    string
+		code    int
+		tenant  string
+	}{
+		{"bound key", true, "/api/products", map[string]string{apiServer.HeaderAPIKey: "acme-key"}, "", http.StatusOK, "acme"},
+		{"bound key of its tenant", true, "/api/products", map[string]string{apiServer.HeaderAPIKey: "acme-key", apiServer.HeaderTenant: "acme"}, "", http.StatusOK, "acme"},
+		{"bound key of another tenant", true, "/api/products", map[string]string{apiServer.HeaderAPIKey: "acme-key", apiServer.HeaderTenant: "other"}, "", http.StatusForbidden, ""},
+		{"bound key of another subdomain", true, "/api/products", map[string]string{apiServer.HeaderAPIKey: "acme-key"}, "other.shop.example", http.StatusForbidden, ""},
+		{"platform key", true, "/api/products", map[string]string{apiServer.HeaderAPIKey: "platform-key", apiServer.HeaderTenant: "Other"}, "", http.StatusOK, "other"},
+		{"platform key of a subdomain", true, "/api/products", map[string]string{apiServer.HeaderAPIKey: "platform-key"}, "other.shop.example", http.StatusOK, "other"},
+		{"platform key of a nested subdomain", true, "/api/products", map[string]string{apiServer.HeaderAPIKey: "platform-key"}, "a.other.shop.example", http.StatusBadRequest, ""},
+		{"platform key without tenant", true, "/api/products", map[string]string{apiServer.HeaderAPIKey: "platform-key"}, "", http.StatusBadRequest, ""},
+		{"bound token", true, "/api/products", map[string]string{echo.HeaderAuthorization: bearer(jwt.MapClaims{"tenant": "acme"})}, "", http.StatusOK, "acme"},
+		{"token of an invalid tenant", true, "/api/products", map[string]string{echo.HeaderAuthorization: bearer(jwt.MapClaims{"tenant": "Not valid"})}, "", http.StatusUnauthorized, ""},
+		{"unbound key of a tenant", true, "/api/products", map[string]string{apiServer.HeaderAPIKey: "reader-key", apiServer.HeaderTenant: "other"}, "", http.StatusForbidden, ""},
+		{"unbound key of a subdomain", true, "/api/products", map[string]string{apiServer.HeaderAPIKey: "reader-key"}, "other.shop.example", http.StatusForbidden, ""},
+		{"unbound key without tenant", true, "/api/products", map[string]string{apiServer.HeaderAPIKey: "reader-key"}, "", http.StatusForbidden, ""},
+		{"unbound token of a tenant", true, "/api/products", map[string]string{echo.HeaderAuthorization: bearer(jwt.MapClaims{}), apiServer.HeaderTenant: "other"}, "", http.StatusForbidden, ""},
+		{"disabled unbound key", false, "/api/products", map[string]string{apiServer.HeaderAPIKey: "reader-key", apiServer.HeaderTenant: "other"}, "", http.StatusOK, xeroHelper.DefaultTenant},
+		{"disabled header", false, "/api/products", map[string]string{apiServer.HeaderAPIKey: "platform-key", apiServer.HeaderTenant: "other"}, "", http.StatusOK, xeroHelper.DefaultTenant},
+		{"disabled bound key", false, "/api/products", map[string]string{apiServer.HeaderAPIKey: "acme-key"}, "", http.StatusOK, "acme"},
+		{"platform route", true, "/api/tenants", map[string]string{apiServer.HeaderAPIKey: "platform-key"}, "", http.StatusOK, xeroHelper.DefaultTenant},
+		{"platform route bound key", true, "/api/tenants", map[string]string{apiServer.HeaderAPIKey: "acme-key"}, "", http.StatusForbidden, ""},
+	} {
+		restAPI := newTenantServer(t, test.enabled)
+		request := httptest.NewRequest(http.MethodGet, test.path, nil)
+		for k, v := range test.headers {
+			request.Header.Set(k, v)
+		}
+		if test.host != "" {
+			request.Host = test.host
+		}
+		responseRecorder := httptest.NewRecorder()
+		restAPI.EchoFramework.ServeHTTP(responseRecorder, request)
+
+		if responseRecorder.Code != test.code {
+			t.Errorf("Expected %d for %s, got %d %v", test.code, test.name, responseRecorder.Code, responseRecorder.Body.String())
+		} else if test.code == http.StatusOK && responseRecorder.Body.String() != test.tenant {
+			t.Errorf("Expected the tenant %s for %s, got %v", test.tenant, test.name, responseRecorder.Body.String())
+		} else if test.code == http.StatusForbidden && !strings.Contains(responseRecorder.Body.String(), "/problems/access_denied") {
+			t.Errorf("Expected the access denied problem for %s, got %v", test.name, responseRecorder.Body.String())
+		}
+	}
+
+}
+
+func TestTenancyNeedsAuth(t *testing.T) {
+
+	config := viper.New()
+	config.Set("app.tenancy.enabled", true)
+	config.Set("app.tenancy.domain", "shop.example")
+
+	restAPI := apiServer.NewRestAPI("test", config, &debugcore.NoOpsLogger{})
+	if err := restAPI.LoadAuth(); err != nil {
+		t.Fatal(err)
+	}
+	if err := restAPI.LoadTenancy(); err == nil {
+		t.Errorf("Expected the tenancy without the auth to be refused")
+	}
+
+}
@@ -2,10 +2,17 @@ package test_commands
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"log"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/spf13/viper"
 
 	"github.com/techievee/xero/database"
@@ -73,8 +80,8 @@ func TestCommands(t *testing.T) {
 	p1 := models.Product{
 		Name:          "test name",
 		Description:   "test description",
-		Price:         10.5,
-		DeliveryPrice: 1.5,
+		Price:         models.Money{Amount: 1050},
+		DeliveryPrice: models.Money{Amount: 150},
 	}
 	uuid, err := pCmd.AddNewProduct(ctx, p1)
 	if err != nil {
@@ -93,10 +100,10 @@ func TestCommands(t *testing.T) {
 	p2 := models.Product{
 		Name:          "test updated",
 		Description:   "test description updated",
-		Price:         104.5,
-		DeliveryPrice: 10.5,
+		Price:         models.Money{Amount: 10450},
+		DeliveryPrice: models.Money{Amount: 1050},
 	}
-	updateCount, err := pCmd.UpdateProduct(ctx, p2, uuid)
+	updateCount, err := pCmd.UpdateProduct(ctx, p2, strings.ToUpper(uuid), nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -118,6 +125,11 @@ func TestCommands(t *testing.T) {
 		t.Errorf("Wrong number of records %d", len(prod))
 	}
 
+	// The ids are found whatever their case
+	if prodID, _ := pCmd.FetchAllProducts(ctx, "", strings.ToUpper(uuid)); len(prodID) != 1 || prodID[0].DBID.String != uuid {
+		t.Errorf("Expected the product of the upper case id, got %v", prodID)
+	}
+
 	// Test with the Updated product name
 	prodU, err := pCmd.FetchAllProducts(ctx, "updated", "")
 	if err != nil {
@@ -212,7 +224,7 @@ func TestCommands(t *testing.T) {
 		Name:        "color",
 		Description: "Black-Updated",
 	}
-	pou_count, err := pCmd.UpdateProductOption(ctx, uuid, po_uuid, po3)
+	pou_count, err := pCmd.UpdateProductOption(ctx, uuid, po_uuid, po3, nil)
 	if err != nil {
 		t.Error(err)
 		return
@@ -225,7 +237,7 @@ func TestCommands(t *testing.T) {
 	}
 
 	// Delete one option
-	poCount, err := pCmd.DeleteProductOption(ctx, uuid, po_uuid)
+	poCount, err := pCmd.DeleteProductOption(ctx, uuid, po_uuid, nil)
 	if err != nil {
 		t.Error(err)
 		return
@@ -249,7 +261,7 @@ func TestCommands(t *testing.T) {
 	}
 
 	// Delete the product
-	poDel, err := pCmd.DeleteProduct(ctx, uuid)
+	poDel, err := pCmd.DeleteProduct(ctx, uuid, nil)
 	if err != nil {
 		t.Error(err)
 		return
@@ -273,3 +285,934 @@ func TestCommands(t *testing.T) {
 	}
 
 }
+
+// Test the keyset and offset pagination of the products
+
+func TestProductsPage(t *testing.T) {
+
+	ctx := context.Background()
+
+	names := []string{"page c", "page a", "page b", "page e", "page d"}
+	var ids []string
+	for _, name := range names {
+		id, err := pCmd.AddNewProduct(ctx, models.Product{Name: name, Description: "paging", Price: models.Money{Amount: 100}, DeliveryPrice: models.Money{Amount: 100}})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	// First page, one extra row to indicate next page
+	filter := models.ProductFilter{Name: "page"}
+	page1, total, err := pCmd.FetchProductsPage(ctx, filter, models.PageRequest{Limit: 2})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if total != 5 || len(page1) != 3 {
+		t.Errorf("Wrong page size %d or total %d", len(page1), total)
+		return
+	}
+	if page1[0].DBName.String != "page a" || page1[1].DBName.String != "page b" {
+		t.Errorf("Wrong order %v, %v", page1[0].DBName.String, page1[1].DBName.String)
+	}
+
+	// Resume after the last row of the first page
+	next := &models.Cursor{Sort: "name", Values: page1[1].SortValues(models.DefaultProductSort), ID: page1[1].DBID.String}
+	cursor, err := models.DecodeCursor(next.Encode())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	page2, _, err := pCmd.FetchProductsPage(ctx, filter, models.PageRequest{Limit: 2, Cursor: cursor})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(page2) != 3 || page2[0].DBName.String != "page c" {
+		t.Errorf("Wrong second page %d", len(page2))
+	}
+
+	// Offset based paging returns the last row only
+	page3, _, err := pCmd.FetchProductsPage(ctx, filter, models.PageRequest{Limit: 2, Offset: 4})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(page3) != 1 || page3[0].DBName.String != "page e" {
+		t.Errorf("Wrong last page %d", len(page3))
+	}
+
+	// The whole catalogue read by pages of Id, each product once
+	seen := map[string]bool{}
+	last := ""
+	for after := ""; ; {
+		products, err := pCmd.FetchProductsAfter(ctx, after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range products {
+			if seen[p.DBID.String] || p.DBID.String <= last {
+				t.Errorf("Expected the products once in the order of Id, got %v after %v", p.DBID.String, last)
+			}
+			seen[p.DBID.String], last = true, p.DBID.String
+		}
+		if len(products) < 2 {
+			break
+		}
+		after = last
+	}
+	for _, id := range ids {
+		if !seen[id] {
+			t.Errorf("Expected the product %v read", id)
+		}
+	}
+
+	// The options of a page in one statement
+	optionID, _ := pCmd.AddNewProductOption(ctx, ids[1], models.ProductOption{Name: "red", Description: "colour"})
+	if options, err := pCmd.FetchOptionsOfProducts(ctx, ids); err != nil || len(options) != 1 || options[0].DBID.String != optionID || options[0].DBProductID.String != ids[1] {
+		t.Errorf("Expected the option of the page, got %v %v", options, err)
+	}
+
+	for _, id := range ids {
+		pCmd.DeleteProduct(ctx, id, nil)
+	}
+
+}
+
+// Test the sorting and the filters of the products
+
+func TestProductsSortAndFilter(t *testing.T) {
+
+	ctx := context.Background()
+
+	products := []models.Product{
+		{Name: "sort a", Description: "cheap phone", Price: models.Money{Amount: 1000}, DeliveryPrice: models.Money{Amount: 500}},
+		{Name: "sort b", Description: "fancy phone", Price: models.Money{Amount: 3000}, DeliveryPrice: models.Money{Amount: 100}},
+		{Name: "sort c", Description: "fancy case", Price: models.Money{Amount: 2000}, DeliveryPrice: models.Money{Amount: 200}},
+		{Name: "sort d", Description: "plain case", Price: models.Money{Amount: 2000}, DeliveryPrice: models.Money{Amount: 0}},
+	}
+	var ids []string
+	for _, p := range products {
+		id, err := pCmd.AddNewProduct(ctx, p)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ids = append(ids, id)
+	}
+	defer func() {
+		for _, id := range ids {
+			pCmd.DeleteProduct(ctx, id, nil)
+		}
+	}()
+
+	sort, err := models.ParseProductSort("-price,name")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Sort by price descending, ties are sorted by name
+	result, total, err := pCmd.FetchProductsPage(ctx, models.ProductFilter{Name: "sort", Sort: sort}, models.PageRequest{Limit: 2})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if total != 4 || result[0].DBName.String != "sort b" || result[1].DBName.String != "sort c" {
+		t.Errorf("Wrong sort order %d", total)
+		return
+	}
+
+	// The keyset of the mixed sort order continues with the tie on the price
+	cursor := &models.Cursor{Sort: models.SortKey(sort), Values: result[1].SortValues(sort), ID: result[1].DBID.String}
+	result, _, err = pCmd.FetchProductsPage(ctx, models.ProductFilter{Name: "sort", Sort: sort}, models.PageRequest{Limit: 2, Cursor: cursor})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(result) != 2 || result[0].DBName.String != "sort d" || result[1].DBName.String != "sort a" {
+		t.Errorf("Wrong second page %d", len(result))
+	}
+
+	// Range filters on the prices and search on the description
+	minPrice, maxPrice, maxDelivery := int64(1500), int64(2500), int64(100)
+	filter := models.ProductFilter{Description: "CASE", MinPrice: &minPrice, MaxPrice: &maxPrice, MaxDeliveryPrice: &maxDelivery}
+	result, total, err = pCmd.FetchProductsPage(ctx, filter, models.PageRequest{Limit: 10})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if total != 1 || result[0].DBName.String != "sort d" {
+		t.Errorf("Wrong number of records %d", total)
+	}
+
+	// Exact name does not match partially
+	result, _, err = pCmd.FetchProductsPage(ctx, models.ProductFilter{ExactName: "SORT A"}, models.PageRequest{Limit: 10})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(result) != 1 {
+		t.Errorf("Wrong number of records %d", len(result))
+	}
+
+	// Unknown fields never reaches the SQL
+	if _, err := models.ParseProductSort("price;drop table Products"); err == nil {
+		t.Errorf("Expected error for unknown sort field")
+	}
+
+}
+
+func TestAddNewProductWithOptions(t *testing.T) {
+
+	ctx := context.Background()
+
+	product := models.Product{
+		Name:          "with options",
+		Description:   "created in one transaction",
+		Price:         models.Money{Amount: 1000},
+		DeliveryPrice: models.Money{Amount: 100},
+		Options:       []models.ProductOption{{Name: "color", Description: "Black"}, {Name: "size", Description: "Large"}},
+	}
+	id, optionIDs, err := pCmd.AddNewProductWithOptions(ctx, product)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer pCmd.DeleteProduct(ctx, id, nil)
+
+	if len(optionIDs) != 2 {
+		t.Errorf("Wrong number of option ids %d", len(optionIDs))
+	}
+	options, _ := pCmd.FetchAllProductOptions(ctx, id, "")
+	if len(options) != 2 || options[0].DBID.String != optionIDs[0] {
+		t.Errorf("Wrong number of options %d", len(options))
+	}
+
+}
+
+func TestInTxRollback(t *testing.T) {
+
+	ctx := context.Background()
+
+	// The product inserted before the failure is rolled back
+	err := pCmd.DB.InTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO Products (Id, Name, Description, PriceMinor, DeliveryPriceMinor) VALUES (?,?,?,?,?)",
+			"0f2b8a52-7cc4-4cd4-bb9c-6a1ec7cbeb38", "rolled back", "rolled back", 1, 1); err != nil {
+			return err
+		}
+		return errors.New("failed half way")
+	})
+	if err == nil || err.Error() != "failed half way" {
+		t.Errorf("Expected the error of the transaction, got %v", err)
+	}
+
+	if prod, _ := pCmd.FetchAllProducts(ctx, "rolled back", ""); len(prod) != 0 {
+		t.Errorf("Expected the product to be rolled back")
+	}
+
+}
+
+func TestStockReservations(t *testing.T) {
+
+	ctx := context.Background()
+
+	id, optionIDs, err := pCmd.AddNewProductWithOptions(ctx, models.Product{
+		Name:          "in stock",
+		Description:   "reserved concurrently",
+		Price:         models.Money{Amount: 1000},
+		DeliveryPrice: models.Money{Amount: 100},
+		Options:       []models.ProductOption{{Name: "color", Description: "Black"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pCmd.DeleteProduct(ctx, id, nil)
+	optionID := optionIDs[0]
+
+	stock, err := pCmd.SetStock(ctx, id, optionID, 10, nil)
+	if err != nil || stock.DBOnHand.Int64 != 10 || stock.DBVersion.Int64 != 1 {
+		t.Fatalf("Wrong stock %v: %v", stock, err)
+	}
+
+	// A stale version is refused
+	stale := int64(0)
+	if _, err := pCmd.SetStock(ctx, id, optionID, 5, &stale); err != productServiceCmds.ErrStockConflict {
+		t.Errorf("Expected the stock conflict, got %v", err)
+	}
+
+	// Twice the stock is requested concurrently, exactly the stock on hand is reserved
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var reserved, refused int
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := pCmd.ReserveStock(ctx, id, optionID, 1)
+			lock.Lock()
+			defer lock.Unlock()
+			if err == nil {
+				reserved++
+			} else if err == productServiceCmds.ErrInsufficientStock {
+				refused++
+			} else {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved != 10 || refused != 10 {
+		t.Errorf("Expected 10 reservations and 10 refusals, got %d and %d", reserved, refused)
+	}
+	if stock, _ := pCmd.FetchStock(ctx, id, optionID); stock.DBReserved.Int64 != 10 || stock.DBOnHand.Int64 != 10 {
+		t.Errorf("Wrong stock after the reservations %v", stock)
+	}
+
+	// The stock on hand cannot go below the reserved quantity
+	if _, err := pCmd.SetStock(ctx, id, optionID, 9, nil); err != productServiceCmds.ErrInsufficientStock {
+		t.Errorf("Expected the insufficient stock, got %v", err)
+	}
+
+	// Committing takes the quantity out of the stock, releasing makes it available again
+	first, _ := pCmd.ReserveStock(ctx, id, optionID, 1)
+	if first.DBID.Valid {
+		t.Errorf("Expected no stock left to reserve")
+	}
+	if _, err := pCmd.SetStock(ctx, id, optionID, 13, nil); err != nil {
+		t.Fatal(err)
+	}
+	first, _ = pCmd.ReserveStock(ctx, id, optionID, 2)
+	second, _ := pCmd.ReserveStock(ctx, id, optionID, 1)
+
+	if r, err := pCmd.CommitStockReservation(ctx, id, optionID, first.DBID.String); err != nil || r.DBStatus.String != models.ReservationCommitted {
+		t.Errorf("Wrong committed reservation %v: %v", r, err)
+	}
+	if _, err := pCmd.ReleaseStockReservation(ctx, id, optionID, first.DBID.String); err != productServiceCmds.ErrReservationClosed {
+		t.Errorf("Expected the reservation closed, got %v", err)
+	}
+	if r, err := pCmd.ReleaseStockReservation(ctx, id, optionID, second.DBID.String); err != nil || r.DBStatus.String != models.ReservationReleased {
+		t.Errorf("Wrong released reservation %v: %v", r, err)
+	}
+	if stock, _ := pCmd.FetchStock(ctx, id, optionID); stock.DBOnHand.Int64 != 11 || stock.DBReserved.Int64 != 10 {
+		t.Errorf("Wrong stock after commit and release %v", stock)
+	}
+
+	// The reservations stay with the option in the trash, and go when it is purged
+	if _, err := pCmd.DeleteProductOption(ctx, id, optionID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pCmd.FetchStock(ctx, id, optionID); err != productServiceCmds.ErrUnknownProductOption {
+		t.Errorf("Expected the unknown option, got %v", err)
+	}
+	var left int
+	pCmd.DB.RW(ctx).QueryRow("SELECT COUNT(*) FROM StockReservations WHERE ProductOptionId=?", optionID).Scan(&left)
+	if left != 12 {
+		t.Errorf("Expected the reservations kept in the trash, %d left", left)
+	}
+	if _, options, err := pCmd.PurgeDeleted(ctx, time.Now()); err != nil || options == 0 {
+		t.Fatalf("Expected the option purged, got %d: %v", options, err)
+	}
+	pCmd.DB.RW(ctx).QueryRow("SELECT COUNT(*) FROM StockReservations WHERE ProductOptionId=?", optionID).Scan(&left)
+	if left != 0 {
+		t.Errorf("Expected the reservations deleted, %d left", left)
+	}
+
+}
+
+func TestTrash(t *testing.T) {
+
+	ctx := context.Background()
+
+	id, optionIDs, err := pCmd.AddNewProductWithOptions(ctx, models.Product{
+		Name:    "trash",
+		Price:   models.Money{Amount: 100},
+		Options: []models.ProductOption{{Name: "kept"}, {Name: "earlier"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pCmd.SetProductPrice(ctx, id, models.ProductPrice{Currency: "USD", Price: models.Money{Amount: 60}})
+
+	// An option deleted before the product stays in the trash when the product is restored
+	if affected, _ := pCmd.DeleteProductOption(ctx, id, optionIDs[1], nil); affected != 1 {
+		t.Fatalf("Expected the option deleted")
+	}
+	time.Sleep(2 * time.Millisecond)
+	if affected, _ := pCmd.DeleteProduct(ctx, id, nil); affected != 1 {
+		t.Fatalf("Expected the product deleted")
+	}
+
+	// Hidden from the reads and the changes
+	if products, _ := pCmd.FetchAllProducts(ctx, "", id); len(products) != 0 {
+		t.Errorf("Expected the product hidden, got %v", products)
+	}
+	if page, _, _ := pCmd.FetchProductsPage(ctx, models.ProductFilter{ExactName: "trash"}, models.PageRequest{Limit: 10}); len(page) != 0 {
+		t.Errorf("Expected the product out of the listing, got %v", page)
+	}
+	if affected, _ := pCmd.UpdateProduct(ctx, models.Product{Name: "updated"}, id, nil); affected != 0 {
+		t.Errorf("Expected the deleted product not updated")
+	}
+	if affected, _ := pCmd.DeleteProduct(ctx, id, nil); affected != 0 {
+		t.Errorf("Expected the product deleted once")
+	}
+
+	deleted, total, err := pCmd.FetchDeletedProducts(ctx, 10, 0)
+	if err != nil || total == 0 || deleted[0].DBID.String != id || !deleted[0].DBDeletedAt.Valid {
+		t.Errorf("Wrong trash %v %d: %v", deleted, total, err)
+	}
+
+	if affected, err := pCmd.RestoreProduct(ctx, strings.ToUpper(id)); err != nil || affected != 1 {
+		t.Fatalf("Expected the product restored, got %d: %v", affected, err)
+	}
+	if options, _ := pCmd.FetchAllProductOptions(ctx, id, ""); len(options) != 1 || options[0].DBID.String != optionIDs[0] {
+		t.Errorf("Expected only the option deleted with the product restored, got %v", options)
+	}
+	if options, _ := pCmd.FetchDeletedProductOptions(ctx, id); len(options) != 1 || options[0].DBID.String != optionIDs[1] {
+		t.Errorf("Wrong options in the trash %v", options)
+	}
+	if affected, _ := pCmd.RestoreProductOption(ctx, id, optionIDs[1]); affected != 1 {
+		t.Errorf("Expected the option restored")
+	}
+	if affected, _ := pCmd.RestoreProduct(ctx, id); affected != 0 {
+		t.Errorf("Expected a live product not restored")
+	}
+
+	// Nothing is purged before its time, then the product goes for good with all its rows
+	pCmd.DeleteProduct(ctx, id, nil)
+	if products, _, _ := pCmd.PurgeDeleted(ctx, time.Now().Add(-time.Hour)); products != 0 {
+		t.Errorf("Expected nothing purged")
+	}
+	if products, _, err := pCmd.PurgeDeleted(ctx, time.Now()); err != nil || products == 0 {
+		t.Errorf("Expected the product purged, got %d: %v", products, err)
+	}
+	if affected, _ := pCmd.RestoreProduct(ctx, id); affected != 0 {
+		t.Errorf("Expected the purged product gone")
+	}
+	var rows int
+	pCmd.DB.RW(ctx).QueryRow("SELECT (SELECT COUNT(*) FROM ProductOptions WHERE ProductId=?) + (SELECT COUNT(*) FROM ProductPrices WHERE ProductId=?)", id, id).Scan(&rows)
+	if rows != 0 {
+		t.Errorf("Expected the options and the prices purged, %d left", rows)
+	}
+
+}
+
+func TestProductHistory(t *testing.T) {
+
+	ctx := xeroHelper.WithActor(context.Background(), xeroHelper.Actor{Name: "auditor", RequestID: "request-1"})
+
+	id, optionIDs, err := pCmd.AddNewProductWithOptions(ctx, models.Product{
+		Name:    "history",
+		Price:   models.Money{Amount: 100},
+		Options: []models.ProductOption{{Name: "red"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if affected, err := pCmd.UpdateProduct(ctx, models.Product{Name: "history", Price: models.Money{Amount: 250}}, id, nil); err != nil || affected != 1 {
+		t.Fatalf("Expected the product updated, got %d: %v", affected, err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	pCmd.SetProductPrice(ctx, id, models.ProductPrice{Currency: "USD", Price: models.Money{Amount: 90}})
+	time.Sleep(2 * time.Millisecond)
+	pCmd.SetStock(ctx, id, optionIDs[0], 5, nil)
+
+	// A refused change records nothing, its entry is rolled back with it
+	if _, err := pCmd.ReserveStock(ctx, id, optionIDs[0], 50); err != productServiceCmds.ErrInsufficientStock {
+		t.Fatalf("Expected the reservation refused, got %v", err)
+	}
+
+	history, total, err := pCmd.FetchProductHistory(ctx, strings.ToUpper(id), 3, 0)
+	if err != nil || total != 5 || len(history) != 3 {
+		t.Fatalf("Wrong history %v %d: %v", history, total, err)
+	}
+	stock, price, update := models.NewAuditEntry(history[0]), models.NewAuditEntry(history[1]), models.NewAuditEntry(history[2])
+	if stock.Entity != models.AuditStock || stock.EntityID != optionIDs[0] || stock.Action != models.AuditUpdate || !strings.Contains(string(stock.After), `"OnHand":5`) {
+		t.Errorf("Wrong stock entry %+v", stock)
+	}
+	if price.Entity != models.AuditPrice || price.EntityID != "USD" || price.Action != models.AuditCreate || price.Before != nil {
+		t.Errorf("Wrong price entry %+v", price)
+	}
+	before, after := models.Product{}, models.Product{}
+	json.Unmarshal(update.Before, &before)
+	json.Unmarshal(update.After, &after)
+	if update.Entity != models.AuditProduct || update.Action != models.AuditUpdate || before.Price.Amount != 100 || after.Price.Amount != 250 {
+		t.Errorf("Wrong update entry %+v", update)
+	}
+	if update.Actor != "auditor" || update.RequestID != "request-1" || update.CreatedAt.IsZero() {
+		t.Errorf("Wrong actor of the entry %+v", update)
+	}
+
+	// The changes made without a request are made by the system, the history outlives the product
+	time.Sleep(2 * time.Millisecond)
+	pCmd.DeleteProduct(ctx, id, nil)
+	time.Sleep(2 * time.Millisecond)
+	pCmd.PurgeDeleted(context.Background(), time.Now())
+	history, total, _ = pCmd.FetchProductHistory(ctx, id, 50, 0)
+	actions := map[string]int{}
+	for _, v := range history {
+		actions[v.DBEntity.String+" "+v.DBAction.String]++
+		if v.DBAction.String == models.AuditPurge && v.DBActor.String != xeroHelper.SystemActor {
+			t.Errorf("Expected the purge made by the system, got %s", v.DBActor.String)
+		}
+	}
+	if total != 10 || actions["product delete"] != 1 || actions["option delete"] != 1 || actions["product purge"] != 1 || actions["option purge"] != 1 || actions["price purge"] != 1 {
+		t.Errorf("Wrong history after the purge %d %v", total, actions)
+	}
+
+}
+
+func TestProductVersion(t *testing.T) {
+
+	ctx := context.Background()
+	id, _ := pCmd.AddNewProduct(ctx, models.Product{Name: "versioned", Price: models.Money{Amount: 100}})
+	defer pCmd.PurgeDeleted(ctx, time.Now())
+
+	version := func() int64 {
+		result, _ := pCmd.FetchAllProducts(ctx, "", id)
+		if len(result) != 1 {
+			t.Fatalf("Expected the product, got %v", result)
+		}
+		return result[0].DBVersion.Int64
+	}
+	if v := version(); v != 1 {
+		t.Fatalf("Expected the new product at version 1, got %d", v)
+	}
+
+	current := int64(1)
+	if affected, err := pCmd.UpdateProduct(ctx, models.Product{Name: "versioned", Price: models.Money{Amount: 200}}, id, &current); err != nil || affected != 1 {
+		t.Fatalf("Expected the update of the current version, got %d: %v", affected, err)
+	}
+	if affected, err := pCmd.UpdateProduct(ctx, models.Product{Name: "outdated"}, id, &current); err != productServiceCmds.ErrVersionMismatch || affected != 0 {
+		t.Errorf("Expected the update of the outdated version refused, got %d: %v", affected, err)
+	}
+
+	// The prices are part of the product
+	pCmd.SetProductPrice(ctx, id, models.ProductPrice{Currency: "USD", Price: models.Money{Amount: 90}})
+	pCmd.DeleteProductPrice(ctx, id, "USD")
+	if v := version(); v != 4 {
+		t.Errorf("Expected the version changed by the prices, got %d", v)
+	}
+
+	optionID, _ := pCmd.AddNewProductOption(ctx, id, models.ProductOption{Name: "red"})
+	if options, _ := pCmd.FetchAllProductOptions(ctx, id, optionID); len(options) != 1 || options[0].DBVersion.Int64 != 1 {
+		t.Fatalf("Expected the new option at version 1, got %v", options)
+	}
+	if affected, err := pCmd.UpdateProductOption(ctx, id, optionID, models.ProductOption{Name: "blue"}, &current); err != nil || affected != 1 {
+		t.Fatalf("Expected the update of the current option, got %d: %v", affected, err)
+	}
+	if affected, err := pCmd.DeleteProductOption(ctx, id, optionID, &current); err != productServiceCmds.ErrVersionMismatch || affected != 0 {
+		t.Errorf("Expected the delete of the outdated option refused, got %d: %v", affected, err)
+	}
+
+	stale := int64(3)
+	if affected, err := pCmd.DeleteProduct(ctx, id, &stale); err != productServiceCmds.ErrVersionMismatch || affected != 0 {
+		t.Errorf("Expected the delete of the outdated product refused, got %d: %v", affected, err)
+	}
+	current = 4
+	if affected, err := pCmd.DeleteProduct(ctx, id, &current); err != nil || affected != 1 {
+		t.Errorf("Expected the delete of the current product, got %d: %v", affected, err)
+	}
+
+	// The product in the trash is unknown whatever the version
+	if affected, err := pCmd.UpdateProduct(ctx, models.Product{Name: "deleted"}, id, &current); err != nil || affected != 0 {
+		t.Errorf("Expected the deleted product unknown, got %d: %v", affected, err)
+	}
+	pCmd.RestoreProduct(ctx, id)
+	if v := version(); v != 6 {
+		t.Errorf("Expected the version changed by the delete and the restore, got %d", v)
+	}
+	pCmd.DeleteProduct(ctx, id, nil)
+
+}
+
+func TestPatchProduct(t *testing.T) {
+
+	ctx := context.Background()
+	id, _ := pCmd.AddNewProduct(ctx, models.Product{Name: "patched", Description: "patch", Price: models.Money{Amount: 100}})
+	defer pCmd.PurgeDeleted(ctx, time.Now())
+
+	// The fields not patched keep their value, even when the product given holds another one
+	current := int64(1)
+	if affected, err := pCmd.PatchProduct(ctx, id, models.Product{Name: "ignored", Price: models.Money{Amount: 300}}, []string{"Price"}, &current); err != nil || affected != 1 {
+		t.Fatalf("Expected the product patched, got %d: %v", affected, err)
+	}
+	result, _ := pCmd.FetchAllProducts(ctx, "", id)
+	if len(result) != 1 || result[0].DBName.String != "patched" || result[0].DBPrice.Int64 != 300 || result[0].DBVersion.Int64 != 2 {
+		t.Errorf("Wrong patched product %v", result)
+	}
+	if _, err := pCmd.PatchProduct(ctx, id, models.Product{Name: "outdated"}, []string{"Name"}, &current); err != productServiceCmds.ErrVersionMismatch {
+		t.Errorf("Expected the patch of the outdated version refused, got %v", err)
+	}
+	if _, err := pCmd.PatchProduct(ctx, id, models.Product{}, []string{"Id"}, nil); err == nil {
+		t.Errorf("Expected the unknown field refused")
+	}
+	if affected, err := pCmd.PatchProduct(ctx, id, models.Product{}, nil, nil); err != nil || affected != 1 {
+		t.Errorf("Expected the empty patch accepted, got %d: %v", affected, err)
+	}
+	// The create and the patch may share their millisecond, the order of the history does not tell them apart
+	history, _, _ := pCmd.FetchProductHistory(ctx, id, 10, 0)
+	updates := 0
+	for _, change := range history {
+		if change.DBAction.String == "update" && strings.Contains(change.DBAfter.String, `"Price":3.00`) {
+			updates++
+		}
+	}
+	if len(history) != 2 || updates != 1 {
+		t.Errorf("Expected only the patch recorded, got %v", history)
+	}
+
+	optionID, _ := pCmd.AddNewProductOption(ctx, id, models.ProductOption{Name: "red", Description: "colour"})
+	if affected, err := pCmd.PatchProductOption(ctx, id, optionID, models.ProductOption{Description: "color"}, []string{"Description"}, &current); err != nil || affected != 1 {
+		t.Fatalf("Expected the option patched, got %d: %v", affected, err)
+	}
+	if options, _ := pCmd.FetchAllProductOptions(ctx, id, optionID); len(options) != 1 || options[0].DBName.String != "red" || options[0].DBDescription.String != "color" {
+		t.Errorf("Wrong patched option %v", options)
+	}
+
+	pCmd.DeleteProduct(ctx, id, nil)
+	if affected, err := pCmd.PatchProduct(ctx, id, models.Product{Name: "deleted"}, []string{"Name"}, nil); err != nil || affected != 0 {
+		t.Errorf("Expected the deleted product unknown, got %d: %v", affected, err)
+	}
+
+}
+
+func TestApplyBatch(t *testing.T) {
+
+	ctx := context.Background()
+	defer pCmd.PurgeDeleted(ctx, time.Now())
+	existing, _ := pCmd.AddNewProduct(ctx, models.Product{Name: "batched", Price: models.Money{Amount: 100}})
+	upserted := "0a1b2c3d-0000-4000-8000-000000000001"
+	unknown := "0a1b2c3d-0000-4000-8000-00000000ffff"
+
+	product := func(name string) *models.Product {
+		return &models.Product{Name: name, Description: "batch", Price: models.Money{Amount: 200}, DeliveryPrice: models.Money{Amount: 20}}
+	}
+
+	// The refused operation rolls back the atomic batch, even the operations before it
+	outcomes, err := pCmd.ApplyBatch(ctx, []models.BatchOperation{
+		{Op: models.BatchCreate, Product: product("rolled back")},
+		{Op: models.BatchDelete, ID: unknown},
+		{Op: models.BatchUpsert, ID: existing, Product: product("never")},
+	}, true, 0)
+	if err != nil || len(outcomes) != 3 {
+		t.Fatalf("Expected the outcomes of the atomic batch, got %v: %v", outcomes, err)
+	}
+	if outcomes[0].Err != productServiceCmds.ErrBatchRolledBack || outcomes[1].Err != productServiceCmds.ErrUnknownProduct || outcomes[2].Err != productServiceCmds.ErrBatchRolledBack {
+		t.Errorf("Wrong outcomes of the atomic batch %v", outcomes)
+	}
+	if result, _ := pCmd.FetchAllProducts(ctx, "rolled back", ""); len(result) != 0 {
+		t.Errorf("Expected the created product rolled back, got %v", result)
+	}
+
+	// In chunks of 2, a refused operation does not stop the others
+	outcomes, err = pCmd.ApplyBatch(ctx, []models.BatchOperation{
+		{Op: models.BatchCreate, Product: &models.Product{Name: "batch options", Price: models.Money{Amount: 300}, Options: []models.ProductOption{{Name: "red"}, {Name: "blue"}}}},
+		{Op: models.BatchUpsert, ID: strings.ToUpper(upserted), Product: product("upserted")},
+		{Op: models.BatchUpsert, ID: existing, Product: product("batch updated")},
+		{Op: models.BatchDelete, ID: unknown},
+		{Op: models.BatchCreate, ProductID: existing, Option: &models.ProductOption{Name: "green"}},
+	}, false, 2)
+	if err != nil || len(outcomes) != 5 {
+		t.Fatalf("Expected the outcomes of the best effort batch, got %v: %v", outcomes, err)
+	}
+	if !outcomes[0].Created || len(outcomes[0].OptionIDs) != 2 || !outcomes[1].Created || outcomes[1].ID != upserted || outcomes[2].Created || outcomes[2].Err != nil {
+		t.Errorf("Wrong outcomes of the applied operations %v", outcomes)
+	}
+	if outcomes[3].Err != productServiceCmds.ErrUnknownProduct || !outcomes[4].Created {
+		t.Errorf("Wrong outcomes of the last chunk %v", outcomes)
+	}
+	if result, _ := pCmd.FetchAllProducts(ctx, "", existing); len(result) != 1 || result[0].DBName.String != "batch updated" || result[0].DBVersion.Int64 != 2 {
+		t.Errorf("Expected the upsert to update the product, got %v", result)
+	}
+	if history, _, _ := pCmd.FetchProductHistory(ctx, outcomes[0].ID, 10, 0); len(history) != 3 {
+		t.Errorf("Expected the product and its options recorded, got %v", history)
+	}
+
+	// The option upserted must belong to the product, and the products in the trash are not changed
+	optionID := outcomes[0].OptionIDs[0]
+	pCmd.DeleteProduct(ctx, upserted, nil)
+	outcomes, _ = pCmd.ApplyBatch(ctx, []models.BatchOperation{
+		{Op: models.BatchUpsert, ID: optionID, ProductID: outcomes[0].ID, Option: &models.ProductOption{Name: "purple"}},
+		{Op: models.BatchUpsert, ID: optionID, ProductID: existing, Option: &models.ProductOption{Name: "stolen"}},
+		{Op: models.BatchUpsert, ID: upserted, Product: product("in the trash")},
+		{Op: models.BatchDelete, ID: unknown, ProductID: existing},
+		{Op: models.BatchDelete, ID: existing},
+	}, false, 0)
+	if outcomes[0].Err != nil || outcomes[1].Err != productServiceCmds.ErrIDTaken || outcomes[2].Err != productServiceCmds.ErrInTrash {
+		t.Errorf("Wrong outcomes of the upserts %v", outcomes)
+	}
+	if outcomes[3].Err != productServiceCmds.ErrUnknownProductOption || outcomes[4].Err != nil {
+		t.Errorf("Wrong outcomes of the deletes %v", outcomes)
+	}
+	if options, _ := pCmd.FetchAllProductOptions(ctx, existing, ""); len(options) != 0 {
+		t.Errorf("Expected the options deleted with the product, got %v", options)
+	}
+
+	// The upserts only apply to the version their fields were checked against, 0 when the row did not exist
+	checked, stale := int64(1), int64(0)
+	created, _ := pCmd.AddNewProduct(ctx, models.Product{Name: "checked", Price: models.Money{Amount: 100}})
+	defer pCmd.DeleteProduct(ctx, created, nil)
+	outcomes, _ = pCmd.ApplyBatch(ctx, []models.BatchOperation{
+		{Op: models.BatchUpsert, ID: created, Product: product("stale"), Version: &stale},
+		{Op: models.BatchUpsert, ID: unknown, Product: product("gone"), Version: &checked},
+		{Op: models.BatchUpsert, ID: created, Product: product("checked"), Version: &checked},
+		{Op: models.BatchUpsert, ID: created, Product: product("twice"), Version: &checked},
+	}, false, 0)
+	if outcomes[0].Err != productServiceCmds.ErrVersionMismatch || outcomes[1].Err != productServiceCmds.ErrVersionMismatch || outcomes[2].Err != nil || outcomes[3].Err != productServiceCmds.ErrVersionMismatch {
+		t.Errorf("Wrong outcomes of the checked upserts %v", outcomes)
+	}
+
+}
+
+func TestTenants(t *testing.T) {
+
+	ctx := context.Background()
+	acme := xeroHelper.WithTenant(ctx, "acme")
+	if err := pCmd.AddNewTenant(ctx, models.Tenant{ID: "acme", Name: "Acme", MaxProducts: 1, MaxOptions: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer pCmd.DeleteTenant(ctx, "acme")
+	if err := pCmd.AddNewTenant(ctx, models.Tenant{ID: "ACME", Name: "Acme"}); err != productServiceCmds.ErrTenantExists {
+		t.Errorf("Expected the tenant to exist, got %v", err)
+	}
+
+	// The quotas count the live products and options of the tenant only
+	id, err := pCmd.AddNewProduct(acme, models.Product{Name: "acme", Price: models.Money{Amount: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pCmd.AddNewProduct(acme, models.Product{Name: "over", Price: models.Money{Amount: 100}}); err != productServiceCmds.ErrQuotaExceeded {
+		t.Errorf("Expected the products quota exceeded, got %v", err)
+	}
+	if _, err := pCmd.AddNewProductOption(acme, id, models.ProductOption{Name: "red"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pCmd.AddNewProductOption(acme, id, models.ProductOption{Name: "blue"}); err != productServiceCmds.ErrQuotaExceeded {
+		t.Errorf("Expected the options quota exceeded, got %v", err)
+	}
+	if products, options, _ := pCmd.CountCatalogue(acme); products != 1 || options != 1 {
+		t.Errorf("Expected the catalogue of the tenant counted, got %d %d", products, options)
+	}
+
+	// The product of the tenant is unknown to the other tenants, even with its id
+	if result, _ := pCmd.FetchAllProducts(ctx, "", id); len(result) != 0 {
+		t.Errorf("Expected the product of another tenant unknown, got %v", result)
+	}
+	if affectedRows, _ := pCmd.UpdateProduct(ctx, models.Product{Name: "stolen", Price: models.Money{Amount: 1}}, id, nil); affectedRows != 0 {
+		t.Errorf("Expected the product of another tenant not updated")
+	}
+	if affectedRows, _ := pCmd.DeleteProduct(ctx, id, nil); affectedRows != 0 {
+		t.Errorf("Expected the product of another tenant not deleted")
+	}
+	if _, err := pCmd.AddNewProductOption(ctx, id, models.ProductOption{Name: "green"}); err != productServiceCmds.ErrUnknownProduct {
+		t.Errorf("Expected no option added to the product of another tenant, got %v", err)
+	}
+	if err := pCmd.SetProductPrice(ctx, id, models.ProductPrice{Currency: "EUR", Price: models.Money{Amount: 1}}); err != productServiceCmds.ErrUnknownProduct {
+		t.Errorf("Expected no price set on the product of another tenant, got %v", err)
+	}
+	if history, total, _ := pCmd.FetchProductHistory(ctx, id, 10, 0); total != 0 || len(history) != 0 {
+		t.Errorf("Expected the history of another tenant hidden, got %v", history)
+	}
+	acmeOptions, _ := pCmd.FetchAllProductOptions(acme, id, "")
+	own, _ := pCmd.AddNewProduct(ctx, models.Product{Name: "own", Price: models.Money{Amount: 1}})
+	defer pCmd.DeleteProduct(ctx, own, nil)
+	outcomes, _ := pCmd.ApplyBatch(ctx, []models.BatchOperation{
+		{Op: models.BatchUpsert, ID: id, Product: &models.Product{Name: "stolen", Price: models.Money{Amount: 1}}},
+		{Op: models.BatchUpsert, ID: acmeOptions[0].DBID.String, ProductID: own, Option: &models.ProductOption{Name: "stolen"}},
+	}, false, 0)
+	if len(outcomes) != 2 || outcomes[0].Err != productServiceCmds.ErrIDTaken || outcomes[1].Err != productServiceCmds.ErrIDTaken {
+		t.Errorf("Expected the ids of another tenant taken, got %v", outcomes)
+	}
+	if result, _ := pCmd.FetchAllProducts(acme, "", id); len(result) != 1 || result[0].DBName.String == "stolen" {
+		t.Errorf("Expected the product of another tenant unchanged, got %v", result)
+	}
+
+	// The slugs of the categories are unique per tenant
+	categoryID, err := pCmd.AddNewCategory(ctx, models.Category{Slug: "shared", Name: "Shared"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pCmd.DeleteCategory(ctx, categoryID, models.OrphansRefuse)
+	if _, err := pCmd.AddNewCategory(acme, models.Category{Slug: "shared", Name: "Shared"}); err != nil {
+		t.Errorf("Expected the slug free in another tenant, got %v", err)
+	}
+	if err := pCmd.AddProductCategory(ctx, id, categoryID); err != productServiceCmds.ErrUnknownProduct {
+		t.Errorf("Expected the product of another tenant not categorised, got %v", err)
+	}
+	if err := pCmd.AddProductCategory(acme, id, categoryID); err != productServiceCmds.ErrUnknownProduct {
+		t.Errorf("Expected the category of another tenant unknown, got %v", err)
+	}
+
+	// Removing the tenant removes its catalogue, the default tenant cannot be removed
+	if _, err := pCmd.DeleteTenant(ctx, xeroHelper.DefaultTenant); err != productServiceCmds.ErrDefaultTenant {
+		t.Errorf("Expected the default tenant kept, got %v", err)
+	}
+	if affectedRows, err := pCmd.DeleteTenant(ctx, "acme"); affectedRows != 1 || err != nil {
+		t.Errorf("Expected the tenant removed, got %d %v", affectedRows, err)
+	}
+	var rows int
+	pCmd.DB.RW(ctx).QueryRow("SELECT (SELECT COUNT(*) FROM Products WHERE TenantId='acme') + (SELECT COUNT(*) FROM ProductOptions WHERE TenantId='acme') + (SELECT COUNT(*) FROM Categories WHERE TenantId='acme') + (SELECT COUNT(*) FROM AuditLog WHERE TenantId='acme')").Scan(&rows)
+	if rows != 0 {
+		t.Errorf("Expected the catalogue of the tenant removed, %d rows left", rows)
+	}
+	if result, _ := pCmd.FetchCategory(ctx, categoryID, ""); len(result) != 1 {
+		t.Errorf("Expected the catalogue of the other tenants kept, got %v", result)
+	}
+	if _, err := pCmd.AddNewProduct(acme, models.Product{Name: "removed", Price: models.Money{Amount: 100}}); err != productServiceCmds.ErrUnknownTenant {
+		t.Errorf("Expected the removed tenant unknown, got %v", err)
+	}
+
+}
+
+// The search needs the sqlite driver built with FTS5, run the tests with -tags sqlite_fts5
+func TestSearchProducts(t *testing.T) {
+
+	ctx := context.Background()
+	if !pCmd.DB.SearchIndex {
+		t.Skip("The sqlite driver is built without FTS5")
+	}
+
+	id, _, err := pCmd.AddNewProductWithOptions(ctx, models.Product{
+		Name:          "Apple iPhone",
+		Description:   "Smart phone with a case",
+		Price:         models.Money{Amount: 1000},
+		DeliveryPrice: models.Money{Amount: 100},
+		Options:       []models.ProductOption{{Name: "color", Description: "Midnight"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pCmd.DeleteProduct(ctx, id, nil)
+	other, _ := pCmd.AddNewProduct(ctx, models.Product{Name: "Phone case", Description: "A case for a phone", Price: models.Money{Amount: 100}})
+	defer pCmd.DeleteProduct(ctx, other, nil)
+
+	search := func(q string) ([]models.DBProductSearch, int64) {
+		query, err := models.ParseSearchQuery(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, total, err := pCmd.SearchProducts(ctx, query, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result, total
+	}
+
+	// Prefix, phrase and the words of the options
+	if result, total := search("iph*"); total != 1 || result[0].DBID.String != id || result[0].DBSnippet.String != "Apple <mark>iPhone</mark>" {
+		t.Errorf("Wrong prefix search %v", result)
+	}
+	if result, total := search(`"phone with"`); total != 1 || result[0].DBID.String != id {
+		t.Errorf("Wrong phrase search %v", result)
+	}
+	if _, total := search("midnight"); total != 1 {
+		t.Errorf("Expected the option to match")
+	}
+
+	// A match in the name ranks first
+	if result, total := search("case"); total != 2 || result[0].DBID.String != other || result[0].DBScore.Float64 <= result[1].DBScore.Float64 {
+		t.Errorf("Wrong ranking %v", result)
+	}
+
+	// The triggers follow the changes of the products and the options
+	pCmd.UpdateProduct(ctx, models.Product{Name: "Pixel", Description: "Smart phone", Price: models.Money{Amount: 1000}}, id, nil)
+	if _, total := search("iphone"); total != 0 {
+		t.Errorf("Expected the old name gone from the index")
+	}
+	options, _ := pCmd.FetchAllProductOptions(ctx, id, "")
+	pCmd.DeleteProductOption(ctx, id, options[0].DBID.String, nil)
+	if _, total := search("midnight"); total != 0 {
+		t.Errorf("Expected the deleted option gone from the index")
+	}
+	pCmd.RestoreProductOption(ctx, id, options[0].DBID.String)
+	if _, total := search("midnight"); total != 1 {
+		t.Errorf("Expected the restored option back in the index")
+	}
+	pCmd.DeleteProduct(ctx, id, nil)
+	if _, total := search("pixel"); total != 0 {
+		t.Errorf("Expected the deleted product out of the search")
+	}
+	pCmd.PurgeDeleted(ctx, time.Now())
+	var indexed int
+	pCmd.DB.RW(ctx).QueryRow("SELECT COUNT(*) FROM ProductSearch WHERE ProductId=?", id).Scan(&indexed)
+	if indexed != 0 {
+		t.Errorf("Expected the purged product gone from the index")
+	}
+
+	// The index is built once, it is only built again when a trigger is missing
+	pCmd.DB.RW(ctx).Exec("INSERT INTO ProductSearch (ProductId, Name, Description, Options) VALUES ('stale', 'stale', '', '')")
+	if ok, err := pCmd.DB.EnsureSearchIndex(ctx, true); !ok || err != nil {
+		t.Errorf("Expected the index kept, got %v %v", ok, err)
+	}
+	pCmd.DB.RW(ctx).QueryRow("SELECT COUNT(*) FROM ProductSearch WHERE ProductId='stale'").Scan(&indexed)
+	if indexed != 1 {
+		t.Errorf("Expected the index not rebuilt at every start")
+	}
+	pCmd.DB.RW(ctx).Exec("DROP TRIGGER product_search_insert")
+	if ok, _ := pCmd.DB.EnsureSearchIndex(ctx, false); ok {
+		t.Errorf("Expected the index without its triggers unavailable")
+	}
+	if ok, err := pCmd.DB.EnsureSearchIndex(ctx, true); !ok || err != nil {
+		t.Errorf("Expected the index rebuilt, got %v %v", ok, err)
+	}
+	pCmd.DB.RW(ctx).QueryRow("SELECT COUNT(*) FROM ProductSearch WHERE ProductId='stale'").Scan(&indexed)
+	if indexed != 0 {
+		t.Errorf("Expected the rebuilt index to hold the products only")
+	}
+
+}
+
+func TestCloseDB(t *testing.T) {
+
+	ctx := context.Background()
+
+	if err := pCmd.DB.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// The pools are opened again on their next use
+	if _, err := pCmd.FetchAllProducts(ctx, "", ""); err != nil {
+		t.Errorf("Expected the pool to be opened again, got %v", err)
+	}
+
+}
+
+func TestHealthChecks(t *testing.T) {
+
+	ctx := context.Background()
+
+	for _, label := range []string{"readwrite-db", "readonly-db"} {
+		if err := pCmd.DB.Ping(ctx, label); err != nil {
+			t.Errorf("Expected %s to be reachable, got %v", label, err)
+		}
+	}
+	if err := pCmd.DB.Ping(ctx, "unknown-db"); err == nil {
+		t.Errorf("Expected an unknown connection error")
+	}
+
+	if err := pCmd.DB.CheckTables(ctx, "readwrite-db"); err != nil {
+		t.Errorf("Expected the tables to exist, got %v", err)
+	}
+
+}
+
+func TestDBStatsCollector(t *testing.T) {
+
+	// Both the pools are open, each reports its 8 statistics
+	pCmd.DB.RW(context.Background())
+	pCmd.DB.RO(context.Background())
+	if count := testutil.CollectAndCount(pCmd.DB.StatsCollector()); count != 16 {
+		t.Errorf("Expected the statistics of 2 pools, got %d metrics", count)
+	}
+
+	all, _ := pCmd.FetchAllProducts(context.Background(), "", "")
+	products, _, err := pCmd.CountCatalogue(context.Background())
+	if err != nil || products != int64(len(all)) {
+		t.Errorf("Wrong catalogue count %d: %v", products, err)
+	}
+
+}
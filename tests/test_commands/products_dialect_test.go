@@ -0,0 +1,102 @@
+package test_commands
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/techievee/xero/database"
+	"github.com/techievee/xero/database/dialect"
+	productServiceCmds "github.com/techievee/xero/productService/commands"
+	"github.com/techievee/xero/productService/models"
+	"github.com/techievee/xero/xeroLog/debugcore"
+)
+
+// recordingDriver stands in for a server database, it records the statements it receives
+// Queries return no rows and every exec affects one row
+type recordingDriver struct {
+	lock       sync.Mutex
+	statements []string
+}
+
+func (d *recordingDriver) Open(string) (driver.Conn, error) { return &recordingConn{d}, nil }
+
+func (d *recordingDriver) record(query string) {
+	d.lock.Lock()
+	d.statements = append(d.statements, query)
+	d.lock.Unlock()
+}
+
+type recordingConn struct{ d *recordingDriver }
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.record(query)
+	return &recordingStmt{}, nil
+}
+func (c *recordingConn) Close() error              { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *recordingConn) Commit() error             { return nil }
+func (c *recordingConn) Rollback() error           { return nil }
+
+type recordingStmt struct{}
+
+func (s *recordingStmt) Close() error  { return nil }
+func (s *recordingStmt) NumInput() int { return -1 }
+func (s *recordingStmt) Exec([]driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+func (s *recordingStmt) Query([]driver.Value) (driver.Rows, error) { return &recordingRows{}, nil }
+
+type recordingRows struct{}
+
+func (r *recordingRows) Columns() []string {
+	return []string{"Id", "Name", "Description", "PriceMinor", "DeliveryPriceMinor", "Currency"}
+}
+func (r *recordingRows) Close() error              { return nil }
+func (r *recordingRows) Next([]driver.Value) error { return io.EOF }
+
+var recorder = &recordingDriver{}
+
+func init() {
+	sql.Register("recording", recorder)
+}
+
+// Test that the commands send the statements in the syntax of a postgres server
+func TestCommandsPostgresDialect(t *testing.T) {
+
+	pool, err := sql.Open("recording", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	cmd := &productServiceCmds.ProductsCmds{
+		DB: &database.DB{
+			RW:      func(context.Context, ...string) *sql.DB { return pool },
+			RO:      func(context.Context) *sql.DB { return pool },
+			Dialect: dialect.Postgres,
+		},
+		Logger: &debugcore.NoOpsLogger{},
+	}
+
+	ctx := context.Background()
+	cmd.FetchAllProducts(ctx, "", "ID")
+	cmd.FetchProductsPage(ctx, models.ProductFilter{Name: "phone"}, models.PageRequest{Limit: 10})
+	cmd.UpdateProduct(ctx, models.Product{Name: "phone"}, "ID", nil)
+	cmd.DeleteProductOption(ctx, "ID", "OPTION", nil)
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	if len(recorder.statements) == 0 {
+		t.Fatal("Expected the statements to be recorded")
+	}
+	for _, stmt := range recorder.statements {
+		if strings.Contains(stmt, "?") || strings.Contains(stmt, "COLLATE NOCASE") {
+			t.Errorf("Statement not rewritten for postgres: %s", stmt)
+		}
+	}
+}
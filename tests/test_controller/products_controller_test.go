@@ -2,20 +2,25 @@ package test_controller
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
 	"github.com/spf13/viper"
 
+	"github.com/techievee/xero/apiServer"
 	"github.com/techievee/xero/database"
 	productServiceCmds "github.com/techievee/xero/productService/commands"
 	productServiceCtl "github.com/techievee/xero/productService/controller"
 	"github.com/techievee/xero/productService/models"
+	xError "github.com/techievee/xero/xeroErrors"
 	"github.com/techievee/xero/xeroHelper"
 	"github.com/techievee/xero/xeroLog/debugcore"
 )
@@ -70,8 +75,8 @@ func TestMain(m *testing.M) {
 	p1 := models.Product{
 		Name:          "Name P1",
 		Description:   "Description P1",
-		Price:         10.5,
-		DeliveryPrice: 1.5,
+		Price:         models.Money{Amount: 1050},
+		DeliveryPrice: models.Money{Amount: 150},
 	}
 	uuid, _ = pCmd.AddNewProduct(context.Background(), p1)
 
@@ -79,8 +84,8 @@ func TestMain(m *testing.M) {
 	p2 := models.Product{
 		Name:          "Name P2",
 		Description:   "Description P2",
-		Price:         10.5,
-		DeliveryPrice: 1.5,
+		Price:         models.Money{Amount: 1050},
+		DeliveryPrice: models.Money{Amount: 150},
 	}
 	p2_uuid, _ = pCmd.AddNewProduct(context.Background(), p2)
 
@@ -115,7 +120,9 @@ func TestAddNewProduct(t *testing.T) {
 	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	responseRecorder := httptest.NewRecorder()
 	c := e.NewContext(request, responseRecorder)
-	pCtl.AddNewProduct(c)
+	if err := pCtl.AddNewProduct(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 	if responseRecorder.Code != http.StatusCreated {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusCreated, responseRecorder.Code)
 		t.Fail()
@@ -142,7 +149,9 @@ func TestAddNewProductInvalidPrice(t *testing.T) {
 	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	responseRecorder := httptest.NewRecorder()
 	c := e.NewContext(request, responseRecorder)
-	pCtl.AddNewProduct(c)
+	if err := pCtl.AddNewProduct(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 	if responseRecorder.Code != http.StatusBadRequest {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusBadRequest, responseRecorder.Code)
 		t.Fail()
@@ -152,6 +161,171 @@ func TestAddNewProductInvalidPrice(t *testing.T) {
 
 }
 
+func TestAddNewProductTooLong(t *testing.T) {
+
+	productJson :=
+		`
+		{
+		  "Name": "iPhone SE Second Gen",
+		  "Description": "Second Gen Version with a longer text.",
+		  "Price": 0,
+		  "DeliveryPrice": 1.99
+		}
+		`
+
+	e := echo.New()
+	request := httptest.NewRequest(http.MethodPost, "/api/products", strings.NewReader(productJson))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	responseRecorder := httptest.NewRecorder()
+	c := e.NewContext(request, responseRecorder)
+	if err := pCtl.AddNewProduct(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
+	if responseRecorder.Code != http.StatusBadRequest {
+		t.Logf("Expected : %d\n got:%d\n", http.StatusBadRequest, responseRecorder.Code)
+		t.Fail()
+	}
+
+	// Every invalid field is reported, not only the first one
+	problem := xError.Problem{}
+	json.Unmarshal(responseRecorder.Body.Bytes(), &problem)
+	expected := []xError.FieldError{
+		{Pointer: "/Name", Code: xError.FieldTooLong},
+		{Pointer: "/Description", Code: xError.FieldTooLong},
+		{Pointer: "/Price", Code: xError.FieldNotPositive},
+	}
+	if len(problem.Errors) != len(expected) {
+		t.Fatalf("Expected %d field errors, got %v", len(expected), responseRecorder.Body.String())
+	}
+	for i := range expected {
+		if problem.Errors[i].Pointer != expected[i].Pointer || problem.Errors[i].Code != expected[i].Code {
+			t.Logf("Expected %v, got %v", expected[i], problem.Errors[i])
+			t.Fail()
+		}
+	}
+
+}
+
+func TestProblemResponse(t *testing.T) {
+
+	e := echo.New()
+	request := httptest.NewRequest(http.MethodGet, "/api/products/not-an-id", nil)
+	responseRecorder := httptest.NewRecorder()
+	c := e.NewContext(request, responseRecorder)
+	c.SetParamNames("id")
+	c.SetParamValues("not-an-id")
+	responseRecorder.Header().Set(echo.HeaderXRequestID, "req-1234")
+	if err := pCtl.ShowProduct(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
+
+	if contentType := responseRecorder.Header().Get(echo.HeaderContentType); contentType != xError.ProblemContentType {
+		t.Logf("Expected : %s\n got:%s\n", xError.ProblemContentType, contentType)
+		t.Fail()
+	}
+
+	problem := xError.Problem{}
+	if err := json.Unmarshal(responseRecorder.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("Expected a problem document, got %v", responseRecorder.Body.String())
+	}
+	if problem.Type != "/problems/invalid_product_id" || problem.Status != http.StatusBadRequest ||
+		problem.Instance != "/api/products/not-an-id" || problem.RequestID != "req-1234" {
+		t.Logf("Unexpected problem: %+v", problem)
+		t.Fail()
+	}
+
+}
+
+func TestAddNewProductWithOptions(t *testing.T) {
+
+	productJson :=
+		`
+		{
+		  "Name": "iPhone 11",
+		  "Description": "With its options.",
+		  "Price": 1229.99,
+		  "DeliveryPrice": 1.99,
+		  "Options": [
+		    {"Name": "Color", "Description": "Black"},
+		    {"Name": "Storage", "Description": "128GB"}
+		  ]
+		}
+		`
+
+	e := echo.New()
+	request := httptest.NewRequest(http.MethodPost, "/api/products", strings.NewReader(productJson))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	responseRecorder := httptest.NewRecorder()
+	c := e.NewContext(request, responseRecorder)
+	if err := pCtl.AddNewProduct(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
+	if responseRecorder.Code != http.StatusCreated {
+		t.Logf("Expected : %d\n got:%d\n", http.StatusCreated, responseRecorder.Code)
+		t.Fail()
+	}
+
+	created := models.CreatedProduct{}
+	json.Unmarshal(responseRecorder.Body.Bytes(), &created)
+	defer pCmd.DeleteProduct(context.Background(), created.ID, nil)
+	if !xeroHelper.ValidateUUID(created.ID) || len(created.OptionIDs) != 2 {
+		t.Logf("Expected all the generated ids, got %v", responseRecorder.Body.String())
+		t.Fail()
+	}
+
+	options, _ := pCmd.FetchAllProductOptions(context.Background(), created.ID, "")
+	if len(options) != 2 {
+		t.Logf("Expected 2 options, got %d", len(options))
+		t.Fail()
+	}
+
+}
+
+func TestAddNewProductWithInvalidOptions(t *testing.T) {
+
+	productJson :=
+		`
+		{
+		  "Name": "iPhone 11 Pro",
+		  "Description": "With an invalid option.",
+		  "Price": 1229.99,
+		  "DeliveryPrice": 1.99,
+		  "Options": [
+		    {"Name": "Color", "Description": "Black"},
+		    {"Name": "", "Description": "128GB"}
+		  ]
+		}
+		`
+
+	e := echo.New()
+	request := httptest.NewRequest(http.MethodPost, "/api/products", strings.NewReader(productJson))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	responseRecorder := httptest.NewRecorder()
+	c := e.NewContext(request, responseRecorder)
+	if err := pCtl.AddNewProduct(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
+	if responseRecorder.Code != http.StatusBadRequest {
+		t.Logf("Expected : %d\n got:%d\n", http.StatusBadRequest, responseRecorder.Code)
+		t.Fail()
+	}
+
+	// The failure is reported on the field of the option
+	problem := xError.Problem{}
+	json.Unmarshal(responseRecorder.Body.Bytes(), &problem)
+	if len(problem.Errors) != 1 || problem.Errors[0].Pointer != "/Options/1/Name" || problem.Errors[0].Code != xError.FieldRequired {
+		t.Logf("Expected the option name to be required, got %v", responseRecorder.Body.String())
+		t.Fail()
+	}
+
+	// Nothing is created when one option is invalid
+	if prod, _ := pCmd.FetchAllProducts(context.Background(), "iPhone 11 Pro", ""); len(prod) != 0 {
+		t.Logf("Expected no product, got %d", len(prod))
+		t.Fail()
+	}
+
+}
+
 func TestShowAllProducts(t *testing.T) {
 
 	e := echo.New()
@@ -159,7 +333,9 @@ func TestShowAllProducts(t *testing.T) {
 	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	responseRecorder := httptest.NewRecorder()
 	c := e.NewContext(request, responseRecorder)
-	pCtl.ShowProducts(c)
+	if err := pCtl.ShowProducts(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 	if responseRecorder.Code != http.StatusOK {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusOK, responseRecorder.Code)
 		t.Fail()
@@ -176,7 +352,70 @@ func TestShowAllProductsWithName(t *testing.T) {
 	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	responseRecorder := httptest.NewRecorder()
 	c := e.NewContext(request, responseRecorder)
-	pCtl.ShowProducts(c)
+	if err := pCtl.ShowProducts(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
+	if responseRecorder.Code != http.StatusOK {
+		t.Logf("Expected : %d\n got:%d\n", http.StatusOK, responseRecorder.Code)
+		t.Fail()
+	}
+	body := responseRecorder.Body.String()
+	t.Logf("Output: %v", body)
+
+}
+
+func TestShowAllProductsPaged(t *testing.T) {
+
+	e := echo.New()
+	request := httptest.NewRequest(http.MethodGet, "/api/products?limit=1", strings.NewReader(""))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	responseRecorder := httptest.NewRecorder()
+	c := e.NewContext(request, responseRecorder)
+	if err := pCtl.ShowProducts(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
+	if responseRecorder.Code != http.StatusOK {
+		t.Logf("Expected : %d\n got:%d\n", http.StatusOK, responseRecorder.Code)
+		t.Fail()
+	}
+	body := responseRecorder.Body.String()
+	if !strings.Contains(body, `"NextCursor"`) {
+		t.Logf("Expected next cursor in %v", body)
+		t.Fail()
+	}
+	t.Logf("Output: %v", body)
+
+}
+
+func TestShowAllProductsInvalidPage(t *testing.T) {
+
+	e := echo.New()
+	request := httptest.NewRequest(http.MethodGet, "/api/products?offset=1&cursor=abc", strings.NewReader(""))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	responseRecorder := httptest.NewRecorder()
+	c := e.NewContext(request, responseRecorder)
+	if err := pCtl.ShowProducts(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
+	if responseRecorder.Code != http.StatusBadRequest {
+		t.Logf("Expected : %d\n got:%d\n", http.StatusBadRequest, responseRecorder.Code)
+		t.Fail()
+	}
+	body := responseRecorder.Body.String()
+	t.Logf("Output: %v", body)
+
+}
+
+func TestShowAllProductsSorted(t *testing.T) {
+
+	e := echo.New()
+	request := httptest.NewRequest(http.MethodGet, "/api/products?sort=-price,name&minPrice=1&maxDeliveryPrice=5", strings.NewReader(""))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	responseRecorder := httptest.NewRecorder()
+	c := e.NewContext(request, responseRecorder)
+	if err := pCtl.ShowProducts(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 	if responseRecorder.Code != http.StatusOK {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusOK, responseRecorder.Code)
 		t.Fail()
@@ -186,6 +425,29 @@ func TestShowAllProductsWithName(t *testing.T) {
 
 }
 
+func TestShowAllProductsUnknownSortField(t *testing.T) {
+
+	e := echo.New()
+	request := httptest.NewRequest(http.MethodGet, "/api/products?sort=colour", strings.NewReader(""))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	responseRecorder := httptest.NewRecorder()
+	c := e.NewContext(request, responseRecorder)
+	if err := pCtl.ShowProducts(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
+	if responseRecorder.Code != http.StatusBadRequest {
+		t.Logf("Expected : %d\n got:%d\n", http.StatusBadRequest, responseRecorder.Code)
+		t.Fail()
+	}
+	body := responseRecorder.Body.String()
+	if !strings.Contains(body, `"type":"/problems/unknown_field"`) {
+		t.Logf("Expected unknown field error in %v", body)
+		t.Fail()
+	}
+	t.Logf("Output: %v", body)
+
+}
+
 func TestShowProductWithID(t *testing.T) {
 
 	e := echo.New()
@@ -196,7 +458,9 @@ func TestShowProductWithID(t *testing.T) {
 	c.SetPath("/api/products/:id")
 	c.SetParamNames("id")
 	c.SetParamValues(uuid)
-	pCtl.ShowProduct(c)
+	if err := pCtl.ShowProduct(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 	if responseRecorder.Code != http.StatusOK {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusOK, responseRecorder.Code)
 		t.Fail()
@@ -204,6 +468,46 @@ func TestShowProductWithID(t *testing.T) {
 	body := responseRecorder.Body.String()
 	t.Logf("Output: %v", body)
 
+	// The prices are exact numbers in their currency
+	if !strings.Contains(body, `"Price":10.50,"DeliveryPrice":1.50,"Currency":"NZD"`) {
+		t.Logf("Expected the exact prices in %v", body)
+		t.Fail()
+	}
+
+}
+
+func TestAddNewProductPricePrecision(t *testing.T) {
+
+	productJson :=
+		`
+		{
+		  "Name": "iPhone SE",
+		  "Description": "Second Gen Version.",
+		  "Price": 1229.999,
+		  "DeliveryPrice": 1.99
+		}
+		`
+
+	e := echo.New()
+	request := httptest.NewRequest(http.MethodPost, "/api/products", strings.NewReader(productJson))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	responseRecorder := httptest.NewRecorder()
+	c := e.NewContext(request, responseRecorder)
+	if err := pCtl.AddNewProduct(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
+	if responseRecorder.Code != http.StatusBadRequest {
+		t.Logf("Expected : %d\n got:%d\n", http.StatusBadRequest, responseRecorder.Code)
+		t.Fail()
+	}
+
+	problem := xError.Problem{}
+	json.Unmarshal(responseRecorder.Body.Bytes(), &problem)
+	if len(problem.Errors) != 1 || problem.Errors[0].Pointer != "/Price" || problem.Errors[0].Code != xError.FieldInvalidType {
+		t.Logf("Expected the price to be rejected, got %v", responseRecorder.Body.String())
+		t.Fail()
+	}
+
 }
 
 func TestShowAllProductWithName(t *testing.T) {
@@ -215,7 +519,9 @@ func TestShowAllProductWithName(t *testing.T) {
 	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	responseRecorder := httptest.NewRecorder()
 	c := e.NewContext(request, responseRecorder)
-	pCtl.ShowProducts(c)
+	if err := pCtl.ShowProducts(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 	if responseRecorder.Code != http.StatusOK {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusOK, responseRecorder.Code)
 		t.Fail()
@@ -230,7 +536,7 @@ func TestUpdateProductsWithID(t *testing.T) {
 	productJson :=
 		`
 		{
-		  "Name": "iPhone SE- Updated",
+		  "Name": "iPhone SE Updated",
 		  "Description": "Updated Second Gen Version.",
 		  "Price": 1229.99,
 		  "DeliveryPrice": 1.99
@@ -245,7 +551,9 @@ func TestUpdateProductsWithID(t *testing.T) {
 	c.SetPath("/api/products/:id")
 	c.SetParamNames("id")
 	c.SetParamValues(uuid)
-	pCtl.UpdateProduct(c)
+	if err := pCtl.UpdateProduct(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 	if responseRecorder.Code != http.StatusOK {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusOK, responseRecorder.Code)
 		t.Fail()
@@ -260,7 +568,7 @@ func TestUpdateProductsWithIDInvalidID(t *testing.T) {
 	productJson :=
 		`
 		{
-		  "Name": "iPhone SE- Updated",
+		  "Name": "iPhone SE Updated",
 		  "Description": "Updated Second Gen Version.",
 		  "Price": 1229.99,
 		  "DeliveryPrice": 1.99
@@ -275,7 +583,9 @@ func TestUpdateProductsWithIDInvalidID(t *testing.T) {
 	c.SetPath("/api/products/:id")
 	c.SetParamNames("id")
 	c.SetParamValues("69d6c863-18e4-4f21-8f46-9cc5128a84c4")
-	pCtl.UpdateProduct(c)
+	if err := pCtl.UpdateProduct(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 	if responseRecorder.Code != http.StatusBadRequest {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusBadRequest, responseRecorder.Code)
 		t.Fail()
@@ -285,12 +595,51 @@ func TestUpdateProductsWithIDInvalidID(t *testing.T) {
 
 }
 
+func TestUpdateProductKeepsCurrency(t *testing.T) {
+
+	ctx := context.Background()
+	id, _ := pCmd.AddNewProduct(ctx, models.Product{Name: "Priced", Price: models.Money{Amount: 100}, Currency: "AUD"})
+	defer pCmd.PurgeDeleted(ctx, time.Now())
+
+	put := func(product string) *httptest.ResponseRecorder {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodPut, "/api/products/", strings.NewReader(product))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		responseRecorder := httptest.NewRecorder()
+		c := e.NewContext(request, responseRecorder)
+		c.SetPath("/api/products/:id")
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		if err := pCtl.UpdateProduct(c); err != nil {
+			apiServer.HTTPErrorHandler(err, c)
+		}
+		return responseRecorder
+	}
+
+	// The default currency only applies on creation, an update without currency keeps the currency of the product
+	if rec := put(`{"Name": "Priced", "Description": "No currency", "Price": 2}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the update without currency, got %d %v", rec.Code, rec.Body.String())
+	}
+	if result, _ := pCmd.FetchAllProducts(ctx, "", id); len(result) != 1 || result[0].DBCurrency.String != "AUD" || result[0].DBPrice.Int64 != 200 {
+		t.Errorf("Expected the currency kept, got %v", result)
+	}
+	if rec := put(`{"Name": "Priced", "Description": "In NZD", "Price": 2, "Currency": "NZD"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the update of the currency, got %d %v", rec.Code, rec.Body.String())
+	}
+	if result, _ := pCmd.FetchAllProducts(ctx, "", id); len(result) != 1 || result[0].DBCurrency.String != "NZD" {
+		t.Errorf("Expected the currency changed, got %v", result)
+	}
+
+	pCmd.DeleteProduct(ctx, id, nil)
+
+}
+
 func TestShowProductWithName(t *testing.T) {
 
 	productJson :=
 		`
 		{
-		  "Name": "iPhone SE- Updated",
+		  "Name": "iPhone SE Updated",
 		  "Description": "Updated Second Gen Version.",
 		  "Price": 1229.99,
 		  "DeliveryPrice": 1.99
@@ -305,7 +654,9 @@ func TestShowProductWithName(t *testing.T) {
 	c.SetPath("/api/products/:id")
 	c.SetParamNames("id")
 	c.SetParamValues("69d6c863-18e4-4f21-8f46-9cc5128a84c4")
-	pCtl.UpdateProduct(c)
+	if err := pCtl.UpdateProduct(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 	if responseRecorder.Code != http.StatusBadRequest {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusBadRequest, responseRecorder.Code)
 		t.Fail()
@@ -325,7 +676,9 @@ func TestDeleteProduct(t *testing.T) {
 	c.SetPath("/api/products/:id")
 	c.SetParamNames("id")
 	c.SetParamValues(p2_uuid)
-	pCtl.DeleteProduct(c)
+	if err := pCtl.DeleteProduct(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 	if responseRecorder.Code != http.StatusOK {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusOK, responseRecorder.Code)
 		t.Fail()
@@ -354,7 +707,9 @@ func TestAddNewAndUpdateProductOption(t *testing.T) {
 	c := e.NewContext(request, responseRecorder)
 	c.SetParamNames("id")
 	c.SetParamValues(uuid)
-	pCtl.AddNewProductOption(c)
+	if err := pCtl.AddNewProductOption(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 	if responseRecorder.Code != http.StatusCreated {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusCreated, responseRecorder.Code)
 		t.Fail()
@@ -379,7 +734,9 @@ func TestAddNewAndUpdateProductOption(t *testing.T) {
 	optionId := strings.Trim(body, "/n")
 	optionId = strings.Trim(body, `"`)
 	c.SetParamValues(uuid, optionId)
-	pCtl.AddNewProductOption(c)
+	if err := pCtl.AddNewProductOption(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 	if responseRecorder.Code != http.StatusCreated {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusCreated, responseRecorder.Code)
 		t.Fail()
@@ -406,7 +763,9 @@ func TestAddNewProductInvalidOption(t *testing.T) {
 	c := e.NewContext(request, responseRecorder)
 	c.SetParamNames("id")
 	c.SetParamValues("deed6cfc-9cd8-41fc-b8c0-038f4c1c79cf")
-	pCtl.AddNewProductOption(c)
+	if err := pCtl.AddNewProductOption(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 	if responseRecorder.Code != http.StatusBadRequest {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusBadRequest, responseRecorder.Code)
 		t.Fail()
@@ -425,7 +784,9 @@ func TestShowAllProductOptions(t *testing.T) {
 	c := e.NewContext(request, responseRecorder)
 	c.SetParamNames("id")
 	c.SetParamValues(uuid)
-	pCtl.ShowProductOptions(c)
+	if err := pCtl.ShowProductOptions(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 
 	if responseRecorder.Code != http.StatusOK {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusOK, responseRecorder.Code)
@@ -445,7 +806,9 @@ func TestShowProductOptions(t *testing.T) {
 	c := e.NewContext(request, responseRecorder)
 	c.SetParamNames("id", "optionId")
 	c.SetParamValues(uuid, po_uuid)
-	pCtl.ShowProductOption(c)
+	if err := pCtl.ShowProductOption(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 
 	if responseRecorder.Code != http.StatusOK {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusOK, responseRecorder.Code)
@@ -465,7 +828,9 @@ func TestDeleteProductOption(t *testing.T) {
 	c := e.NewContext(request, responseRecorder)
 	c.SetParamNames("id", "optionId")
 	c.SetParamValues(uuid, po_uuid)
-	pCtl.DeleteProductOption(c)
+	if err := pCtl.DeleteProductOption(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
 	if responseRecorder.Code != http.StatusOK {
 		t.Logf("Expected : %d\n got:%d\n", http.StatusOK, responseRecorder.Code)
 		t.Fail()
@@ -474,3 +839,931 @@ func TestDeleteProductOption(t *testing.T) {
 	t.Logf("Output: %v", body)
 
 }
+
+//-- TEST PRODUCT PRICES
+
+func TestProductPrices(t *testing.T) {
+
+	e := echo.New()
+	call := func(method string, target string, body string, handler echo.HandlerFunc, params ...string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		responseRecorder := httptest.NewRecorder()
+		c := e.NewContext(request, responseRecorder)
+		c.SetParamNames("id", "currency")
+		c.SetParamValues(params...)
+		if err := handler(c); err != nil {
+			apiServer.HTTPErrorHandler(err, c)
+		}
+		return responseRecorder
+	}
+
+	// The price in AUD is added next to the base price in NZD
+	rec := call(http.MethodPut, "/api/products/:id/prices/aud", `{"Price": 11.25, "DeliveryPrice": 2}`, pCtl.SetProductPrice, uuid, "aud")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected : %d\n got:%d %v\n", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	defer pCmd.DeleteAllProductPrices(context.Background(), uuid)
+
+	rec = call(http.MethodGet, "/api/products/:id/prices", "", pCtl.ShowProductPrices, uuid)
+	prices := models.ProductPrices{}
+	json.Unmarshal(rec.Body.Bytes(), &prices)
+	if prices.BaseCurrency != "NZD" || prices.Items == nil || len(*prices.Items) != 2 || (*prices.Items)[1].Currency != "AUD" || (*prices.Items)[1].Price.Amount != 1125 {
+		t.Errorf("Wrong prices %v", rec.Body.String())
+	}
+
+	// The product is shown in the requested currency
+	rec = call(http.MethodGet, "/api/products/:id?currency=AUD", "", pCtl.ShowProduct, uuid)
+	if !strings.Contains(rec.Body.String(), `"Price":11.25,"DeliveryPrice":2.00,"Currency":"AUD"`) {
+		t.Errorf("Expected the price in AUD, got %v", rec.Body.String())
+	}
+	rec = call(http.MethodGet, "/api/products/:id?currency=USD", "", pCtl.ShowProduct, uuid)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "currency_not_available") {
+		t.Errorf("Expected no price in USD, got %v", rec.Body.String())
+	}
+
+	// The listing falls back to the base price of the products without a price in the currency
+	rec = call(http.MethodGet, "/api/products?currency=AUD", "", pCtl.ShowProducts)
+	if !strings.Contains(rec.Body.String(), `"Currency":"AUD"`) || !strings.Contains(rec.Body.String(), `"Currency":"NZD"`) {
+		t.Errorf("Expected the prices in AUD and NZD, got %v", rec.Body.String())
+	}
+
+	// The base price is only changed with the product
+	rec = call(http.MethodPut, "/api/products/:id/prices/NZD", `{"Price": 11.25}`, pCtl.SetProductPrice, uuid, "NZD")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected : %d\n got:%d\n", http.StatusBadRequest, rec.Code)
+	}
+
+	rec = call(http.MethodDelete, "/api/products/:id/prices/AUD", "", pCtl.DeleteProductPrice, uuid, "AUD")
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected : %d\n got:%d\n", http.StatusOK, rec.Code)
+	}
+	rec = call(http.MethodDelete, "/api/products/:id/prices/AUD", "", pCtl.DeleteProductPrice, uuid, "AUD")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected : %d\n got:%d\n", http.StatusBadRequest, rec.Code)
+	}
+
+}
+
+func TestOptionStock(t *testing.T) {
+
+	e := echo.New()
+	call := func(method string, target string, body string, handler echo.HandlerFunc, params ...string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		responseRecorder := httptest.NewRecorder()
+		c := e.NewContext(request, responseRecorder)
+		c.SetParamNames("id", "optionId", "reservationId")
+		c.SetParamValues(params...)
+		if err := handler(c); err != nil {
+			apiServer.HTTPErrorHandler(err, c)
+		}
+		return responseRecorder
+	}
+
+	optionId, _ := pCmd.AddNewProductOption(context.Background(), uuid, models.ProductOption{Name: "size", Description: "Large"})
+	defer pCmd.DeleteProductOption(context.Background(), uuid, optionId, nil)
+
+	rec := call(http.MethodPut, "/api/products/:id/options/:optionId/stock", `{"OnHand": 3}`, pCtl.SetStock, uuid, optionId)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"OnHand":3,"Reserved":0,"Available":3,"Version":1`) {
+		t.Fatalf("Wrong stock %d %v", rec.Code, rec.Body.String())
+	}
+
+	// The version of an older read is refused
+	rec = call(http.MethodPut, "/api/products/:id/options/:optionId/stock", `{"OnHand": 5, "Version": 0}`, pCtl.SetStock, uuid, optionId)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "/problems/stock_conflict") {
+		t.Errorf("Expected the stock conflict, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	rec = call(http.MethodPost, "/api/products/:id/options/:optionId/stock/reserve", `{"Quantity": 2}`, pCtl.ReserveStock, uuid, optionId)
+	reservation := models.StockReservation{}
+	json.Unmarshal(rec.Body.Bytes(), &reservation)
+	if rec.Code != http.StatusCreated || reservation.Status != models.ReservationReserved || reservation.Quantity != 2 {
+		t.Fatalf("Wrong reservation %d %v", rec.Code, rec.Body.String())
+	}
+
+	rec = call(http.MethodPost, "/api/products/:id/options/:optionId/stock/reserve", `{"Quantity": 2}`, pCtl.ReserveStock, uuid, optionId)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "/problems/insufficient_stock") {
+		t.Errorf("Expected the insufficient stock, got %d %v", rec.Code, rec.Body.String())
+	}
+	rec = call(http.MethodPost, "/api/products/:id/options/:optionId/stock/reserve", `{"Quantity": 0}`, pCtl.ReserveStock, uuid, optionId)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"pointer":"/Quantity"`) {
+		t.Errorf("Expected the invalid quantity, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	rec = call(http.MethodPost, "/api/products/:id/options/:optionId/stock/reservations/:reservationId/commit", "", pCtl.CommitStockReservation, uuid, optionId, reservation.ID)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"Status":"committed"`) {
+		t.Errorf("Wrong committed reservation %d %v", rec.Code, rec.Body.String())
+	}
+	rec = call(http.MethodPost, "/api/products/:id/options/:optionId/stock/reservations/:reservationId/release", "", pCtl.ReleaseStockReservation, uuid, optionId, reservation.ID)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected : %d\n got:%d\n", http.StatusConflict, rec.Code)
+	}
+
+	rec = call(http.MethodGet, "/api/products/:id/options/:optionId/stock", "", pCtl.ShowStock, uuid, optionId)
+	if !strings.Contains(rec.Body.String(), `"OnHand":1,"Reserved":0,"Available":1`) {
+		t.Errorf("Wrong stock after the commit %v", rec.Body.String())
+	}
+
+	// The option must belong to the product
+	rec = call(http.MethodGet, "/api/products/:id/options/:optionId/stock", "", pCtl.ShowStock, p2_uuid, optionId)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "unknown_product_option_id") {
+		t.Errorf("Expected the unknown option, got %d %v", rec.Code, rec.Body.String())
+	}
+
+}
+
+func TestCategories(t *testing.T) {
+
+	e := echo.New()
+	call := func(method string, target string, body string, handler echo.HandlerFunc, params ...string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		responseRecorder := httptest.NewRecorder()
+		c := e.NewContext(request, responseRecorder)
+		c.SetParamNames("id", "categoryId")
+		c.SetParamValues(params...)
+		if err := handler(c); err != nil {
+			apiServer.HTTPErrorHandler(err, c)
+		}
+		return responseRecorder
+	}
+	add := func(body string) string {
+		rec := call(http.MethodPost, "/api/categories", body, pCtl.AddNewCategory)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Expected : %d\n got:%d %v\n", http.StatusCreated, rec.Code, rec.Body.String())
+		}
+		return strings.Trim(strings.TrimSpace(rec.Body.String()), `"`)
+	}
+
+	// Electronics > Phones > Smartphones, the slug is derived from the name
+	electronics := add(`{"Name": "Electronics"}`)
+	defer pCmd.DeleteCategory(context.Background(), electronics, models.OrphansCascade)
+	phones := add(`{"Name": "Phones", "ParentId": "` + electronics + `"}`)
+	smartphones := add(`{"Name": "Smart Phones", "ParentId": "` + phones + `", "SortOrder": 1}`)
+
+	rec := call(http.MethodPost, "/api/categories", `{"Name": "Phones"}`, pCtl.AddNewCategory)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "slug_taken") {
+		t.Errorf("Expected the slug taken, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	rec = call(http.MethodGet, "/api/categories", "", pCtl.ShowCategories)
+	categories := models.Categories{}
+	json.Unmarshal(rec.Body.Bytes(), &categories)
+	if len(*categories.Items) != 1 || (*categories.Items)[0].Children[0].Children[0].Slug != "smart-phones" {
+		t.Errorf("Wrong tree %v", rec.Body.String())
+	}
+
+	// A category cannot move under its own subtree
+	rec = call(http.MethodPut, "/api/categories/:id", `{"Name": "Electronics", "ParentId": "`+smartphones+`"}`, pCtl.UpdateCategory, electronics)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"pointer":"/ParentId"`) {
+		t.Errorf("Expected the cycle refused, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	// The products of the subtree are listed with includeDescendants
+	rec = call(http.MethodPut, "/api/products/:id/categories/:categoryId", "", pCtl.AddProductCategory, uuid, smartphones)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected : %d\n got:%d %v\n", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	rec = call(http.MethodGet, "/api/products?category=phones", "", pCtl.ShowProducts)
+	if !strings.Contains(rec.Body.String(), `"Total":0`) {
+		t.Errorf("Expected no product directly in phones, got %v", rec.Body.String())
+	}
+	rec = call(http.MethodGet, "/api/products?category=phones&includeDescendants=true", "", pCtl.ShowProducts)
+	if !strings.Contains(rec.Body.String(), `"Total":1`) || !strings.Contains(rec.Body.String(), uuid) {
+		t.Errorf("Expected the product of smartphones, got %v", rec.Body.String())
+	}
+	rec = call(http.MethodGet, "/api/products?category=unknown", "", pCtl.ShowProducts)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "unknown_category") {
+		t.Errorf("Expected the unknown category, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	// The children are only deleted or moved when asked for
+	rec = call(http.MethodDelete, "/api/categories/:id", "", pCtl.DeleteCategory, phones)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "category_has_children") {
+		t.Errorf("Expected the children refused, got %d %v", rec.Code, rec.Body.String())
+	}
+	rec = call(http.MethodDelete, "/api/categories/:id?orphans=reparent", "", pCtl.DeleteCategory, phones)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected : %d\n got:%d %v\n", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	rec = call(http.MethodGet, "/api/categories/:id", "", pCtl.ShowCategory, electronics)
+	category := models.Category{}
+	json.Unmarshal(rec.Body.Bytes(), &category)
+	if len(category.Children) != 1 || category.Children[0].ID != smartphones {
+		t.Errorf("Expected smartphones moved under electronics, got %v", rec.Body.String())
+	}
+
+	rec = call(http.MethodDelete, "/api/categories/:id?orphans=cascade", "", pCtl.DeleteCategory, electronics)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected : %d\n got:%d %v\n", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	rec = call(http.MethodGet, "/api/products/:id/categories", "", pCtl.ShowProductCategories, uuid)
+	if !strings.Contains(rec.Body.String(), `"Items":[]`) {
+		t.Errorf("Expected the links deleted with the categories, got %v", rec.Body.String())
+	}
+	if products, _ := pCmd.FetchAllProducts(context.Background(), "", uuid); len(products) != 1 {
+		t.Errorf("Expected the product kept")
+	}
+
+}
+
+func TestSearchProducts(t *testing.T) {
+
+	e := echo.New()
+	search := func(target string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		responseRecorder := httptest.NewRecorder()
+		c := e.NewContext(request, responseRecorder)
+		if err := pCtl.SearchProducts(c); err != nil {
+			apiServer.HTTPErrorHandler(err, c)
+		}
+		return responseRecorder
+	}
+
+	rec := search("/api/products/search?q=%22%22")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_search") {
+		t.Errorf("Expected the empty search refused, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	// Without FTS5 in the driver, the search tells it is not available
+	id, _ := pCmd.AddNewProduct(context.Background(), models.Product{Name: "Garden Sprinkler", Description: "Waters the lawn", Price: models.Money{Amount: 100}})
+	defer pCmd.DeleteProduct(context.Background(), id, nil)
+
+	rec = search("/api/products/search?q=sprink*+lawn")
+	if !pCmd.DB.SearchIndex {
+		if rec.Code != http.StatusNotImplemented || !strings.Contains(rec.Body.String(), "search_unavailable") {
+			t.Errorf("Expected the search unavailable, got %d %v", rec.Code, rec.Body.String())
+		}
+		return
+	}
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"Snippet":"Garden \u003cmark\u003eSprinkler\u003c/mark\u003e"`) {
+		t.Errorf("Expected the sprinkler, got %d %v", rec.Code, rec.Body.String())
+	}
+
+}
+
+func TestTrash(t *testing.T) {
+
+	e := echo.New()
+	call := func(method string, target string, handler echo.HandlerFunc, params ...string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, nil)
+		responseRecorder := httptest.NewRecorder()
+		c := e.NewContext(request, responseRecorder)
+		c.SetParamNames("id", "optionId")
+		c.SetParamValues(params...)
+		if err := handler(c); err != nil {
+			apiServer.HTTPErrorHandler(err, c)
+		}
+		return responseRecorder
+	}
+
+	ctx := context.Background()
+	id, optionIDs, _ := pCmd.AddNewProductWithOptions(ctx, models.Product{Name: "Trash", Price: models.Money{Amount: 100}, Options: []models.ProductOption{{Name: "size"}}})
+	defer pCmd.PurgeDeleted(ctx, time.Now())
+
+	// The deleted product is only found in the trash
+	if rec := call(http.MethodDelete, "/api/products/:id", pCtl.DeleteProduct, id); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the product deleted, got %d %v", rec.Code, rec.Body.String())
+	}
+	if rec := call(http.MethodGet, "/api/products/:id", pCtl.ShowProduct, id); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected the deleted product unknown, got %d", rec.Code)
+	}
+	rec := call(http.MethodGet, "/api/products/trash?limit=1", pCtl.ShowTrash)
+	trash := models.Products{}
+	json.Unmarshal(rec.Body.Bytes(), &trash)
+	if rec.Code != http.StatusOK || len(*trash.Items) != 1 || (*trash.Items)[0].ID != id || (*trash.Items)[0].DeletedAt == nil {
+		t.Errorf("Wrong trash %d %v", rec.Code, rec.Body.String())
+	}
+	if rec := call(http.MethodGet, "/api/products/trash?cursor=x", pCtl.ShowTrash); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "unknown_field") {
+		t.Errorf("Expected the cursor refused, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	// Restored once, with its option
+	if rec := call(http.MethodPost, "/api/products/:id/restore", pCtl.RestoreProduct, id); rec.Code != http.StatusOK {
+		t.Errorf("Expected the product restored, got %d %v", rec.Code, rec.Body.String())
+	}
+	if rec := call(http.MethodPost, "/api/products/:id/restore", pCtl.RestoreProduct, id); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a live product not restored, got %d", rec.Code)
+	}
+	if rec := call(http.MethodGet, "/api/products/:id/options/:optionId", pCtl.ShowProductOption, id, optionIDs[0]); rec.Code != http.StatusOK {
+		t.Errorf("Expected the option restored with the product, got %d", rec.Code)
+	}
+
+	// Same for the options
+	call(http.MethodDelete, "/api/products/:id/options/:optionId", pCtl.DeleteProductOption, id, optionIDs[0])
+	rec = call(http.MethodGet, "/api/products/:id/options/trash", pCtl.ShowProductOptionsTrash, id)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), optionIDs[0]) {
+		t.Errorf("Wrong options trash %d %v", rec.Code, rec.Body.String())
+	}
+	if rec := call(http.MethodPost, "/api/products/:id/options/:optionId/restore", pCtl.RestoreProductOption, id, optionIDs[0]); rec.Code != http.StatusOK {
+		t.Errorf("Expected the option restored, got %d %v", rec.Code, rec.Body.String())
+	}
+	if rec := call(http.MethodPost, "/api/products/:id/options/:optionId/restore", pCtl.RestoreProductOption, id, optionIDs[0]); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a live option not restored, got %d", rec.Code)
+	}
+	pCmd.DeleteProduct(ctx, id, nil)
+
+}
+
+func TestProductHistory(t *testing.T) {
+
+	e := echo.New()
+	call := func(method string, target string, handler echo.HandlerFunc, params ...string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, nil)
+		responseRecorder := httptest.NewRecorder()
+		c := e.NewContext(request, responseRecorder)
+		c.SetParamNames("id")
+		c.SetParamValues(params...)
+		if err := handler(c); err != nil {
+			apiServer.HTTPErrorHandler(err, c)
+		}
+		return responseRecorder
+	}
+
+	ctx := context.Background()
+	id, _ := pCmd.AddNewProduct(ctx, models.Product{Name: "History", Price: models.Money{Amount: 100}})
+	defer pCmd.PurgeDeleted(ctx, time.Now())
+
+	// The request id and the actor come from the middlewares of the server
+	time.Sleep(2 * time.Millisecond)
+	deleted := call(http.MethodDelete, "/api/products/:id", middleware.RequestID()(apiServer.ActorMiddleware(pCtl.DeleteProduct)), id)
+	if deleted.Code != http.StatusOK {
+		t.Fatalf("Expected the product deleted, got %d %v", deleted.Code, deleted.Body.String())
+	}
+
+	rec := call(http.MethodGet, "/api/products/:id/history?limit=1", pCtl.ShowProductHistory, id)
+	history := models.AuditLog{}
+	json.Unmarshal(rec.Body.Bytes(), &history)
+	if rec.Code != http.StatusOK || history.Total != 2 || len(*history.Items) != 1 {
+		t.Fatalf("Wrong history %d %v", rec.Code, rec.Body.String())
+	}
+	entry := (*history.Items)[0]
+	if entry.Action != models.AuditDelete || entry.Actor != xeroHelper.AnonymousActor || entry.RequestID == "" || entry.RequestID != deleted.Header().Get(echo.HeaderXRequestID) {
+		t.Errorf("Wrong entry of the deletion %+v", entry)
+	}
+	if !strings.Contains(string(entry.After), `"DeletedAt"`) || strings.Contains(string(entry.Before), `"DeletedAt"`) {
+		t.Errorf("Expected the deletion in the states, got %s %s", entry.Before, entry.After)
+	}
+
+	rec = call(http.MethodGet, "/api/products/:id/history?limit=1&offset=1", pCtl.ShowProductHistory, id)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"Action":"create"`) || !strings.Contains(rec.Body.String(), `"Before":null`) {
+		t.Errorf("Wrong second page %d %v", rec.Code, rec.Body.String())
+	}
+
+	for _, test := range []struct {
+		target string
+		id     string
+		want   string
+	}{
+		{"/api/products/:id/history", "history", "invalid_product_id"},
+		{"/api/products/:id/history", "01234567-89ab-cdef-0123-456789abcdef", "unknown_product_id"},
+		{"/api/products/:id/history?cursor=x", id, "unknown_field"},
+		{"/api/products/:id/history?limit=0", id, "invalid_page"},
+	} {
+		if rec := call(http.MethodGet, test.target, pCtl.ShowProductHistory, test.id); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), test.want) {
+			t.Errorf("Expected %s for %s %s, got %d %v", test.want, test.target, test.id, rec.Code, rec.Body.String())
+		}
+	}
+
+}
+
+func TestProductETag(t *testing.T) {
+
+	e := echo.New()
+	call := func(method string, target string, body string, header map[string]string, handler echo.HandlerFunc, params ...string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		for name, value := range header {
+			request.Header.Set(name, value)
+		}
+		responseRecorder := httptest.NewRecorder()
+		c := e.NewContext(request, responseRecorder)
+		c.SetParamNames("id", "optionId")
+		c.SetParamValues(params...)
+		if err := handler(c); err != nil {
+			apiServer.HTTPErrorHandler(err, c)
+		}
+		return responseRecorder
+	}
+
+	ctx := context.Background()
+	id, _ := pCmd.AddNewProduct(ctx, models.Product{Name: "Tagged", Price: models.Money{Amount: 100}})
+	defer pCmd.PurgeDeleted(ctx, time.Now())
+
+	rec := call(http.MethodGet, "/api/products/:id", "", nil, pCtl.ShowProduct, id)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"1"` {
+		t.Fatalf("Wrong tag of the new product %d %q", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	// The GET compares weakly and answers without the body when the client has the current version
+	for _, tag := range []string{`"1"`, `W/"1"`, `"0", "1"`, `*`} {
+		rec = call(http.MethodGet, "/api/products/:id", "", map[string]string{"If-None-Match": tag}, pCtl.ShowProduct, id)
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("ETag") != `"1"` {
+			t.Errorf("Expected not modified for %s, got %d %v", tag, rec.Code, rec.Body.String())
+		}
+	}
+
+	product := `{"Name": "Tagged", "Description": "Tagged product", "Price": 2, "DeliveryPrice": 1}`
+	rec = call(http.MethodPut, "/api/products/:id", product, map[string]string{"If-Match": `"1"`}, pCtl.UpdateProduct, id)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the update of the current version, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	// The version the client has is now outdated, a weak tag never matches
+	for _, tag := range []string{`"1"`, `W/"2"`, `2`} {
+		rec = call(http.MethodPut, "/api/products/:id", product, map[string]string{"If-Match": tag}, pCtl.UpdateProduct, id)
+		if rec.Code != http.StatusPreconditionFailed || !strings.Contains(rec.Body.String(), "/problems/precondition_failed") {
+			t.Errorf("Expected the precondition failed for %s, got %d %v", tag, rec.Code, rec.Body.String())
+		}
+	}
+	rec = call(http.MethodGet, "/api/products/:id", "", map[string]string{"If-None-Match": `"1"`}, pCtl.ShowProduct, id)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"2"` {
+		t.Errorf("Expected the changed product, got %d %q", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	// The prices are part of the product, the product in another currency has its own tag
+	pCmd.SetProductPrice(ctx, id, models.ProductPrice{Currency: "AUD", Price: models.Money{Amount: 300}})
+	rec = call(http.MethodGet, "/api/products/:id?currency=AUD", "", nil, pCtl.ShowProduct, id)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"3-AUD"` {
+		t.Errorf("Wrong tag of the product in AUD %d %q", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	optionId, _ := pCmd.AddNewProductOption(ctx, id, models.ProductOption{Name: "size", Description: "Large"})
+	rec = call(http.MethodGet, "/api/products/:id/options/:optionId", "", nil, pCtl.ShowProductOption, id, optionId)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"1"` {
+		t.Fatalf("Wrong tag of the new option %d %q", rec.Code, rec.Header().Get("ETag"))
+	}
+	rec = call(http.MethodGet, "/api/products/:id/options/:optionId", "", map[string]string{"If-None-Match": `"1"`}, pCtl.ShowProductOption, id, optionId)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("Expected the option not modified, got %d %v", rec.Code, rec.Body.String())
+	}
+	rec = call(http.MethodPut, "/api/products/:id/options/:optionId", `{"Name": "size", "Description": "Small"}`, map[string]string{"If-Match": `"1"`}, pCtl.UpdateProductOption, id, optionId)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the update of the current option, got %d %v", rec.Code, rec.Body.String())
+	}
+	rec = call(http.MethodDelete, "/api/products/:id/options/:optionId", "", map[string]string{"If-Match": `"1"`}, pCtl.DeleteProductOption, id, optionId)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected the delete of the outdated option refused, got %d %v", rec.Code, rec.Body.String())
+	}
+	rec = call(http.MethodDelete, "/api/products/:id/options/:optionId", "", map[string]string{"If-Match": `"0", "2"`}, pCtl.DeleteProductOption, id, optionId)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected the delete of the current option, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	// Any version matches *, the deletion is not based on a version
+	rec = call(http.MethodDelete, "/api/products/:id", "", map[string]string{"If-Match": `*`}, pCtl.DeleteProduct, id)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected the delete of the product, got %d %v", rec.Code, rec.Body.String())
+	}
+
+}
+
+func TestPatchProduct(t *testing.T) {
+
+	e := echo.New()
+	call := func(contentType string, body string, header map[string]string, handler echo.HandlerFunc, params ...string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPatch, "/api/products/:id", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, contentType)
+		for name, value := range header {
+			request.Header.Set(name, value)
+		}
+		responseRecorder := httptest.NewRecorder()
+		c := e.NewContext(request, responseRecorder)
+		c.SetParamNames("id", "optionId")
+		c.SetParamValues(params...)
+		if err := handler(c); err != nil {
+			apiServer.HTTPErrorHandler(err, c)
+		}
+		return responseRecorder
+	}
+	product := func(id string) models.DBProducts {
+		result, _ := pCmd.FetchAllProducts(context.Background(), "", id)
+		if len(result) != 1 {
+			t.Fatalf("Expected the product, got %v", result)
+		}
+		return result[0]
+	}
+
+	ctx := context.Background()
+	id, _ := pCmd.AddNewProduct(ctx, models.Product{Name: "Patched", Description: "Before the patch", Price: models.Money{Amount: 100}, DeliveryPrice: models.Money{Amount: 10}})
+	defer pCmd.PurgeDeleted(ctx, time.Now())
+
+	// Only the price changes, the other fields are kept
+	rec := call("application/merge-patch+json", `{"Price": 12.50}`, nil, pCtl.PatchProduct, id)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the merge patch applied, got %d %v", rec.Code, rec.Body.String())
+	}
+	if p := product(id); p.DBPrice.Int64 != 1250 || p.DBName.String != "Patched" || p.DBDeliveryPrice.Int64 != 10 || p.DBVersion.Int64 != 2 {
+		t.Errorf("Wrong product after the merge patch %v", p)
+	}
+
+	// A patch changing nothing writes nothing
+	rec = call("application/json", `{"Name": "Patched"}`, nil, pCtl.PatchProduct, id)
+	if rec.Code != http.StatusOK || product(id).DBVersion.Int64 != 2 {
+		t.Errorf("Expected the unchanged product, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	// The test compares the value as the API returns it, the prices with their 2 decimal places
+	patch := `[{"op": "test", "path": "/Price", "value": 12.50}, {"op": "replace", "path": "/Description", "value": "After the patch"}]`
+	rec = call("application/json-patch+json", patch, map[string]string{"If-Match": `"2"`}, pCtl.PatchProduct, id)
+	if rec.Code != http.StatusOK || product(id).DBDescription.String != "After the patch" {
+		t.Fatalf("Expected the JSON patch applied, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	// Removing the currency keeps the currency of the product, the default currency only applies on creation
+	pCmd.UpdateProduct(ctx, models.Product{Name: "Patched", Description: "After the patch", Price: models.Money{Amount: 1250}, DeliveryPrice: models.Money{Amount: 10}, Currency: "AUD"}, id, nil)
+	for _, body := range []string{`{"Currency": null}`, `{"Currency": ""}`} {
+		rec = call("application/merge-patch+json", body, nil, pCtl.PatchProduct, id)
+		if p := product(id); rec.Code != http.StatusOK || p.DBCurrency.String != "AUD" || p.DBVersion.Int64 != 4 {
+			t.Errorf("Expected the currency kept by %s, got %d %v %v", body, rec.Code, rec.Body.String(), p)
+		}
+	}
+
+	for _, test := range []struct {
+		contentType string
+		body        string
+		header      map[string]string
+		code        int
+		want        string
+	}{
+		{"application/json-patch+json", patch, map[string]string{"If-Match": `"2"`}, http.StatusPreconditionFailed, "/problems/precondition_failed"},
+		{"application/json-patch+json", `[{"op": "test", "path": "/Price", "value": 1}]`, nil, http.StatusConflict, "/problems/patch_test_failed"},
+		{"application/json-patch+json", `[{"op": "remove", "path": "/Missing"}]`, nil, http.StatusBadRequest, "/problems/invalid_request"},
+		{"application/json-patch+json", `{"Price": 1}`, nil, http.StatusBadRequest, "/problems/invalid_request"},
+		{"application/merge-patch+json", `[1]`, nil, http.StatusBadRequest, "/problems/invalid_request"},
+		{"application/merge-patch+json", `{"Name": null}`, nil, http.StatusBadRequest, `"pointer":"/Name","code":"required"`},
+		{"application/merge-patch+json", `{"Price": 1.001}`, nil, http.StatusBadRequest, `"pointer":"/Price"`},
+		{"application/merge-patch+json", `{"Id": "01234567-89ab-cdef-0123-456789abcdef"}`, nil, http.StatusBadRequest, `"pointer":"/Id","code":"not_allowed"`},
+		{"application/merge-patch+json", `{"Options": [{"Name": "red"}]}`, nil, http.StatusBadRequest, `"pointer":"/Options"`},
+		{"text/plain", `{"Price": 1}`, nil, http.StatusUnsupportedMediaType, "/problems/unsupported_patch"},
+	} {
+		if rec := call(test.contentType, test.body, test.header, pCtl.PatchProduct, id); rec.Code != test.code || !strings.Contains(rec.Body.String(), test.want) {
+			t.Errorf("Expected %d %s for %s %s, got %d %v", test.code, test.want, test.contentType, test.body, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := call("application/merge-patch+json", `{"Price": 1}`, nil, pCtl.PatchProduct, "01234567-89ab-cdef-0123-456789abcdef"); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "unknown_product_id") {
+		t.Errorf("Expected the unknown product, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	optionId, _ := pCmd.AddNewProductOption(ctx, id, models.ProductOption{Name: "size", Description: "Large"})
+	rec = call("application/merge-patch+json", `{"Description": "Small"}`, nil, pCtl.PatchProductOption, id, optionId)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the option patched, got %d %v", rec.Code, rec.Body.String())
+	}
+	if options, _ := pCmd.FetchAllProductOptions(ctx, id, optionId); len(options) != 1 || options[0].DBName.String != "size" || options[0].DBDescription.String != "Small" {
+		t.Errorf("Wrong option after the patch %v", options)
+	}
+	rec = call("application/json-patch+json", `[{"op": "replace", "path": "/Name", "value": "much too long"}]`, nil, pCtl.PatchProductOption, id, optionId)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"pointer":"/Name","code":"too_long"`) {
+		t.Errorf("Expected the patched option invalid, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	pCmd.DeleteProduct(ctx, id, nil)
+
+}
+
+func TestBatchProducts(t *testing.T) {
+
+	e := echo.New()
+	call := func(ctl *productServiceCtl.ProductsCtl, body string) (*httptest.ResponseRecorder, models.BatchResults) {
+		request := httptest.NewRequest(http.MethodPost, "/api/products/batch", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		responseRecorder := httptest.NewRecorder()
+		c := e.NewContext(request, responseRecorder)
+		if err := ctl.BatchProducts(c); err != nil {
+			apiServer.HTTPErrorHandler(err, c)
+		}
+		results := models.BatchResults{}
+		json.Unmarshal(responseRecorder.Body.Bytes(), &results)
+		return responseRecorder, results
+	}
+
+	ctx := context.Background()
+	defer pCmd.PurgeDeleted(ctx, time.Now())
+	id, _ := pCmd.AddNewProduct(ctx, models.Product{Name: "batched", Price: models.Money{Amount: 100}})
+
+	// Every operation is validated, the field pointers are the ones of the body of the batch
+	rec, results := call(pCtl, `{"Mode": "best_effort", "Operations": [
+		{"Op": "create", "Product": {"Name": "batch created", "Description": "batch", "Price": 10.50, "Options": [{"Name": "red", "Description": "colour"}]}},
+		{"Op": "upsert", "Id": "`+id+`", "Product": {"Name": "batch upserted", "Description": "batch", "Price": 0}},
+		{"Op": "move", "Id": "`+id+`"},
+		{"Op": "delete", "Id": "not-a-uuid"},
+		{"Op": "create", "Id": "`+id+`", "ProductId": "`+id+`", "Option": {"Name": "blue", "Description": "colour"}},
+		{"Op": "create", "ProductId": "`+id+`", "Option": {"Name": "blue", "Description": "colour"}}]}`)
+	if rec.Code != http.StatusOK || results.Succeeded != 2 || results.Failed != 4 || len(results.Results) != 6 {
+		t.Fatalf("Expected the best effort batch applied, got %d %v", rec.Code, rec.Body.String())
+	}
+	for i, want := range []struct {
+		status int
+		text   string
+	}{
+		{http.StatusCreated, `"OptionIds":["`},
+		{http.StatusBadRequest, `"pointer":"/Operations/1/Product/Price","code":"not_positive"`},
+		{http.StatusBadRequest, `"pointer":"/Operations/2/Op"`},
+		{http.StatusBadRequest, "/problems/invalid_product_id"},
+		{http.StatusBadRequest, `"pointer":"/Operations/4/Id","code":"not_allowed"`},
+		{http.StatusCreated, `"Id":"`},
+	} {
+		result, _ := json.Marshal(results.Results[i])
+		if results.Results[i].Index != i || results.Results[i].Status != want.status || !strings.Contains(string(result), want.text) {
+			t.Errorf("Expected %d %s for the operation %d, got %s", want.status, want.text, i, result)
+		}
+	}
+	if result, _ := pCmd.FetchAllProducts(ctx, "", results.Results[0].ID); len(result) != 1 || result[0].DBPrice.Int64 != 1050 || result[0].DBCurrency.String != "NZD" {
+		t.Errorf("Expected the product created in the default currency, got %v", result)
+	}
+
+	// An invalid operation fails the atomic batch before anything is applied
+	rec, results = call(pCtl, `{"Operations": [
+		{"Op": "delete", "Id": "`+id+`"},
+		{"Op": "upsert", "Id": "`+id+`", "Product": {"Name": "batch upserted", "Price": 1, "Options": []}}]}`)
+	if rec.Code != http.StatusBadRequest || results.Failed != 2 || results.Results[0].Status != http.StatusFailedDependency || !strings.Contains(rec.Body.String(), `"pointer":"/Operations/1/Product/Options"`) {
+		t.Errorf("Expected the atomic batch refused, got %d %v", rec.Code, rec.Body.String())
+	}
+	if result, _ := pCmd.FetchAllProducts(ctx, "", id); len(result) != 1 {
+		t.Errorf("Expected the product kept, got %v", result)
+	}
+
+	limited := &productServiceCtl.ProductsCtl{ServiceCommands: pCmd, Logger: &debugcore.NoOpsLogger{}, BatchMaxOperations: 1}
+	for _, test := range []struct {
+		ctl  *productServiceCtl.ProductsCtl
+		body string
+		code int
+		want string
+	}{
+		{pCtl, `{"Mode": "eventually"}`, http.StatusBadRequest, `"pointer":"/Mode"`},
+		{pCtl, `{"Operations": []}`, http.StatusBadRequest, `"pointer":"/Operations","code":"required"`},
+		{pCtl, `{"Operations": {}}`, http.StatusBadRequest, "/problems/invalid_request"},
+		{limited, `{"Operations": [{"Op": "delete"}, {"Op": "delete"}]}`, http.StatusBadRequest, `"code":"too_long"`},
+	} {
+		if rec, _ := call(test.ctl, test.body); rec.Code != test.code || !strings.Contains(rec.Body.String(), test.want) {
+			t.Errorf("Expected %d %s for %s, got %d %v", test.code, test.want, test.body, rec.Code, rec.Body.String())
+		}
+	}
+
+}
+
+func TestCatalogueImportExport(t *testing.T) {
+
+	e := echo.New()
+	export := func(query string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/api/products/export?"+query, nil)
+		responseRecorder := httptest.NewRecorder()
+		c := e.NewContext(request, responseRecorder)
+		if err := pCtl.ExportProducts(c); err != nil {
+			apiServer.HTTPErrorHandler(err, c)
+		}
+		return responseRecorder
+	}
+	importLines := func(query string, contentType string, body string) (*httptest.ResponseRecorder, models.ImportResults) {
+		request := httptest.NewRequest(http.MethodPost, "/api/products/import?"+query, strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, contentType)
+		responseRecorder := httptest.NewRecorder()
+		c := e.NewContext(request, responseRecorder)
+		if err := pCtl.ImportProducts(c); err != nil {
+			apiServer.HTTPErrorHandler(err, c)
+		}
+		results := models.ImportResults{}
+		json.Unmarshal(responseRecorder.Body.Bytes(), &results)
+		return responseRecorder, results
+	}
+
+	ctx := context.Background()
+	defer pCmd.PurgeDeleted(ctx, time.Now())
+	id, _ := pCmd.AddNewProduct(ctx, models.Product{Name: "exported", Description: "catalogue, with a comma", Price: models.Money{Amount: 1250}})
+	optionID, _ := pCmd.AddNewProductOption(ctx, id, models.ProductOption{Name: "red", Description: "colour"})
+	pCmd.AddNewProductOption(ctx, id, models.ProductOption{Name: "blue", Description: "colour"})
+
+	// The CSV flattens the options, one line per option
+	rec := export("")
+	if rec.Code != http.StatusOK || rec.Header().Get(echo.HeaderContentType) != "text/csv; charset=UTF-8" || !strings.HasPrefix(rec.Body.String(), "Id,Name,Description,Price,DeliveryPrice,Currency,OptionId,OptionName,OptionDescription\n") {
+		t.Fatalf("Expected the CSV export, got %d %v", rec.Code, rec.Body.String())
+	}
+	if want := id + `,exported,"catalogue, with a comma",12.50,0.00,NZD,` + optionID + ",red,colour\n"; !strings.Contains(rec.Body.String(), want) || strings.Count(rec.Body.String(), id) != 2 {
+		t.Errorf("Expected the product with its options flattened, got %v", rec.Body.String())
+	}
+
+	// The NDJSON nests the options in the line of their product
+	rec = export("format=ndjson")
+	if rec.Code != http.StatusOK || rec.Header().Get(echo.HeaderContentType) != "application/x-ndjson" || strings.Count(rec.Body.String(), id) != 1 {
+		t.Fatalf("Expected the NDJSON export, got %d %v", rec.Code, rec.Body.String())
+	}
+	for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+		row := models.CatalogueRow{}
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			t.Fatalf("Expected one product per line, got %v", line)
+		}
+		if row.ID == id && (len(row.Options) != 2 || row.Price.Amount != 1250) {
+			t.Errorf("Expected the options nested, got %v", line)
+		}
+	}
+
+	for _, query := range []string{"format=xlsx", "options=columns"} {
+		if rec := export(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s refused, got %d %v", query, rec.Code, rec.Body.String())
+		}
+	}
+
+	// Every line is validated, an invalid line fails the import before anything is applied
+	csvBody := "Id,Name,Description,Price,OptionId,OptionName,OptionDescription\n" +
+		id + ",imported,catalogue,13.75," + optionID + ",dark red,colour\n" +
+		"\n" +
+		",created,catalogue,abc,,,\n" +
+		",created,catalogue,1,,green,\n" +
+		"not-a-uuid,created,catalogue,1,,,\n" +
+		id + ",imported,catalogue,13.75,,green,colour\n" +
+		id + ",other,catalogue,14.00," + optionID + ",dark red,colour\n"
+	rec, results := importLines("", "text/csv", csvBody)
+	if rec.Code != http.StatusBadRequest || results.Lines != 6 || results.Failed != 5 || results.Operations != 0 {
+		t.Fatalf("Expected the import refused, got %d %v", rec.Code, rec.Body.String())
+	}
+	for i, want := range []struct {
+		line int
+		text string
+	}{
+		{4, `"pointer":"/Price"`},
+		{5, `"pointer":"/OptionDescription","code":"required"`},
+		{6, "/problems/invalid_product_id"},
+		{7, `"pointer":"/OptionId","code":"required"`},
+		{8, `"pointer":"/Name","code":"invalid","message":"The product has another Name on the line 2"`},
+	} {
+		problem, _ := json.Marshal(results.Errors[i].Error)
+		if results.Errors[i].Line != want.line || !strings.Contains(string(problem), want.text) {
+			t.Errorf("Expected %s on the line %d, got %d %s", want.text, want.line, results.Errors[i].Line, problem)
+		}
+	}
+	if !strings.Contains(rec.Body.String(), `"pointer":"/Price","code":"invalid"`) {
+		t.Errorf("Expected every product field that differs from the first line, got %v", rec.Body.String())
+	}
+
+	// A dry run validates the lines only, an Id upserts the product and its options
+	addedID := "0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+	csvBody = "Id,Name,Description,Price,OptionId,OptionName,OptionDescription\n" +
+		id + ",imported,catalogue,13.75," + optionID + ",dark red,colour\n" +
+		id + ",imported,catalogue,13.75," + addedID + ",green,colour\n" +
+		",imported new,catalogue,1.00,,green,colour\n"
+	rec, results = importLines("dryRun=true", "text/csv; charset=utf-8", csvBody)
+	if rec.Code != http.StatusOK || !results.DryRun || results.Operations != 4 || results.Created != 0 {
+		t.Fatalf("Expected the dry run validated, got %d %v", rec.Code, rec.Body.String())
+	}
+	if result, _ := pCmd.FetchAllProducts(ctx, "", id); len(result) != 1 || result[0].DBName.String != "exported" {
+		t.Errorf("Expected the dry run applied nothing, got %v", result)
+	}
+
+	rec, results = importLines("", "text/csv", csvBody)
+	if rec.Code != http.StatusOK || results.Created != 3 || results.Updated != 2 || results.Failed != 0 {
+		t.Fatalf("Expected the import applied, got %d %v", rec.Code, rec.Body.String())
+	}
+	if result, _ := pCmd.FetchAllProducts(ctx, "", id); len(result) != 1 || result[0].DBName.String != "imported" || result[0].DBPrice.Int64 != 1375 {
+		t.Errorf("Expected the product upserted by the first line of its id, got %v", result)
+	}
+	if result, _ := pCmd.FetchAllProductOptions(ctx, id, ""); len(result) != 3 {
+		t.Errorf("Expected the option upserted and one added, got %v", result)
+	}
+	if result, _ := pCmd.FetchAllProducts(ctx, "imported new", ""); len(result) != 1 {
+		t.Errorf("Expected the product created, got %v", result)
+	}
+
+	// A re-import of a product updates its options without adding them again
+	csvBody = "Id,Name,Description,Price,OptionId,OptionName,OptionDescription\n" +
+		id + ",imported,catalogue,13.75," + optionID + ",dark red,colour\n" +
+		id + ",imported,catalogue,13.75," + addedID + ",green,colour\n"
+	if rec, results = importLines("", "text/csv", csvBody); rec.Code != http.StatusOK || results.Created != 0 || results.Updated != 3 {
+		t.Fatalf("Expected the import applied, got %d %v", rec.Code, rec.Body.String())
+	}
+	if result, _ := pCmd.FetchAllProductOptions(ctx, id, ""); len(result) != 3 {
+		t.Errorf("Expected the options of the product kept, got %v", result)
+	}
+
+	// The NDJSON lines are numbered with the blank lines
+	rec, results = importLines("", "application/x-ndjson", `{"Id": "`+id+`", "Name": "imported", "Description": "catalogue", "Price": 13.75}`+"\n\n"+
+		`{"Name": "new", "Description": "catalogue", "Price": 1, "Options": [{"Id": "`+optionID+`", "Name": "red", "Description": "colour"}]}`+"\n"+
+		`{"Name": "new", "Colour": "red"}`+"\n")
+	if rec.Code != http.StatusBadRequest || results.Failed != 2 || results.Errors[0].Line != 3 || results.Errors[1].Line != 4 ||
+		!strings.Contains(rec.Body.String(), `"pointer":"/Options/0/Id"`) || !strings.Contains(rec.Body.String(), "/problems/invalid_request") {
+		t.Errorf("Expected the NDJSON lines refused, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	for _, test := range []struct {
+		query       string
+		contentType string
+		body        string
+		code        int
+		want        string
+	}{
+		{"", echo.MIMEApplicationJSON, `[]`, http.StatusUnsupportedMediaType, "/problems/unsupported_import"},
+		{"dryRun=maybe", "text/csv", "Name,Price\n", http.StatusBadRequest, "/problems/invalid_dry_run"},
+		{"", "text/csv", "Name,Colour\n", http.StatusBadRequest, "/problems/invalid_header"},
+		{"", "text/csv", "Name,Price,Options,OptionName\n", http.StatusBadRequest, "/problems/invalid_header"},
+		{"", "text/csv", "", http.StatusBadRequest, "/problems/invalid_request"},
+		{"", "text/csv", "Name,Price\nfew\n", http.StatusBadRequest, `"Line":2`},
+		{"", "text/csv", "Name,Description,Price\n\"two\nlines\",catalogue,1\nfew\n", http.StatusBadRequest, `"Line":4`},
+	} {
+		if rec, _ := importLines(test.query, test.contentType, test.body); rec.Code != test.code || !strings.Contains(rec.Body.String(), test.want) {
+			t.Errorf("Expected %d %s for %q, got %d %v", test.code, test.want, test.body, rec.Code, rec.Body.String())
+		}
+	}
+
+}
+
+func TestFieldRestrictions(t *testing.T) {
+
+	e := echo.New()
+	editor := xeroHelper.Principal{Subject: "editor", Roles: []string{"editor"},
+		Fields: map[string][]string{"product": {"Name", "Description"}, "product_option": {"Name", "Description"}}}
+	pricing := xeroHelper.Principal{Subject: "pricing", Roles: []string{"pricing-manager"},
+		Fields: map[string][]string{"product": {"Price", "DeliveryPrice", "Currency"}}}
+	call := func(principal xeroHelper.Principal, method string, contentType string, body string, handler echo.HandlerFunc, names []string, params ...string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, "/api/products", strings.NewReader(body))
+		request = request.WithContext(xeroHelper.WithPrincipal(request.Context(), principal))
+		request.Header.Set(echo.HeaderContentType, contentType)
+		responseRecorder := httptest.NewRecorder()
+		c := e.NewContext(request, responseRecorder)
+		c.SetParamNames(names...)
+		c.SetParamValues(params...)
+		if err := handler(c); err != nil {
+			apiServer.HTTPErrorHandler(err, c)
+		}
+		return responseRecorder
+	}
+	price := func(id string) int64 {
+		result, _ := pCmd.FetchAllProducts(context.Background(), "", id)
+		if len(result) != 1 {
+			t.Fatalf("Expected the product, got %v", result)
+		}
+		return result[0].DBPrice.Int64
+	}
+
+	ctx := context.Background()
+	defer pCmd.PurgeDeleted(ctx, time.Now())
+	id, _ := pCmd.AddNewProduct(ctx, models.Product{Name: "restricted", Description: "fields", Price: models.Money{Amount: 100}})
+	optionID, _ := pCmd.AddNewProductOption(ctx, id, models.ProductOption{Name: "red", Description: "colour"})
+
+	// The editor changes the name but not the price, the pricing manager the price but not the name
+	for _, test := range []struct {
+		principal   xeroHelper.Principal
+		method      string
+		contentType string
+		body        string
+		handler     echo.HandlerFunc
+		code        int
+	}{
+		{editor, http.MethodPut, echo.MIMEApplicationJSON, `{"Name": "renamed", "Description": "fields", "Price": 1.00}`, pCtl.UpdateProduct, http.StatusOK},
+		{editor, http.MethodPut, echo.MIMEApplicationJSON, `{"Name": "renamed", "Description": "fields", "Price": 2.00}`, pCtl.UpdateProduct, http.StatusForbidden},
+		{editor, http.MethodPatch, "application/merge-patch+json", `{"DeliveryPrice": 1}`, pCtl.PatchProduct, http.StatusForbidden},
+		{pricing, http.MethodPatch, "application/merge-patch+json", `{"Name": "priced"}`, pCtl.PatchProduct, http.StatusForbidden},
+		{pricing, http.MethodPut, echo.MIMEApplicationJSON, `{"Name": "renamed", "Description": "fields", "Price": 3.00}`, pCtl.UpdateProduct, http.StatusOK},
+	} {
+		if rec := call(test.principal, test.method, test.contentType, test.body, test.handler, []string{"id"}, id); rec.Code != test.code {
+			t.Errorf("Expected %d for %s %s %s, got %d %v", test.code, test.principal.Subject, test.method, test.body, rec.Code, rec.Body.String())
+		} else if test.code == http.StatusForbidden && !strings.Contains(rec.Body.String(), "/problems/access_denied") {
+			t.Errorf("Expected the access denied problem, got %v", rec.Body.String())
+		}
+	}
+	if price(id) != 300 {
+		t.Errorf("Expected the price of the pricing manager, got %d", price(id))
+	}
+
+	// The options have no field for the pricing manager
+	if rec := call(pricing, http.MethodPut, echo.MIMEApplicationJSON, `{"Name": "blue", "Description": "colour"}`, pCtl.UpdateProductOption, []string{"id", "optionId"}, id, optionID); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected the option change refused, got %d %v", rec.Code, rec.Body.String())
+	}
+	if rec := call(editor, http.MethodPut, echo.MIMEApplicationJSON, `{"Name": "blue", "Description": "colour"}`, pCtl.UpdateProductOption, []string{"id", "optionId"}, id, optionID); rec.Code != http.StatusOK {
+		t.Errorf("Expected the option changed, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	// The upserts of the batches and of the imports are checked the same, the creations only check the prices
+	rec := call(editor, http.MethodPost, echo.MIMEApplicationJSON, `{"Mode": "best_effort", "Operations": [
+		{"Op": "upsert", "Id": "`+id+`", "Product": {"Name": "renamed", "Description": "fields", "Price": 9}},
+		{"Op": "create", "Product": {"Name": "created", "Description": "fields", "Price": 9}},
+		{"Op": "upsert", "Id": "0a1b2c3d-0000-4000-8000-00000000aaaa", "Product": {"Name": "created", "Description": "fields", "Price": 9}},
+		{"Op": "create", "ProductId": "`+id+`", "Option": {"Name": "green", "Description": "colour"}}]}`, pCtl.BatchProducts, nil)
+	results := models.BatchResults{}
+	json.Unmarshal(rec.Body.Bytes(), &results)
+	if rec.Code != http.StatusOK || results.Failed != 3 || results.Results[0].Status != http.StatusForbidden || results.Results[1].Status != http.StatusForbidden ||
+		results.Results[2].Status != http.StatusForbidden || results.Results[3].Status != http.StatusCreated {
+		t.Errorf("Expected the price changes refused, got %d %v", rec.Code, rec.Body.String())
+	}
+	rec = call(pricing, http.MethodPost, echo.MIMEApplicationJSON, `{"Operations": [{"Op": "create", "Product": {"Name": "created", "Description": "fields", "Price": 9}}]}`, pCtl.BatchProducts, nil)
+	json.Unmarshal(rec.Body.Bytes(), &results)
+	if rec.Code != http.StatusOK || results.Results[0].Status != http.StatusCreated {
+		t.Errorf("Expected the product of the pricing manager created, got %d %v", rec.Code, rec.Body.String())
+	}
+	pCmd.DeleteProduct(ctx, results.Results[0].ID, nil)
+	if rec := call(editor, http.MethodPost, echo.MIMEApplicationJSON, `{"Name": "created", "Description": "fields", "Price": 9}`, pCtl.AddNewProduct, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected the priced product of the editor refused, got %d %v", rec.Code, rec.Body.String())
+	}
+	rec = call(editor, http.MethodPost, "text/csv", "Id,Name,Description,Price\n"+id+",renamed,fields,9\n", pCtl.ImportProducts, nil)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"Line":2`) || !strings.Contains(rec.Body.String(), "/problems/access_denied") {
+		t.Errorf("Expected the import refused, got %d %v", rec.Code, rec.Body.String())
+	}
+	rec = call(editor, http.MethodPost, "text/csv", "Name,Description,Price\ncreated,fields,9\n", pCtl.ImportProducts, nil)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "/problems/access_denied") {
+		t.Errorf("Expected the imported product refused, got %d %v", rec.Code, rec.Body.String())
+	}
+	if price(id) != 300 {
+		t.Errorf("Expected the price kept, got %d", price(id))
+	}
+
+}
@@ -0,0 +1,756 @@
+package test_memory
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+
+	"github.com/techievee/xero/apiServer"
+	"github.com/techievee/xero/productService"
+	productServiceCmds "github.com/techievee/xero/productService/commands"
+	productServiceCtl "github.com/techievee/xero/productService/controller"
+	"github.com/techievee/xero/productService/models"
+	"github.com/techievee/xero/xeroHelper"
+	"github.com/techievee/xero/xeroLog/debugcore"
+)
+
+// The in memory catalogue needs no config and no database file
+var pCmd *productServiceCmds.MemoryCmds
+var pCtl *productServiceCtl.ProductsCtl
+var uuid, po_uuid string
+
+func TestMain(m *testing.M) {
+
+	pCmd = productServiceCmds.NewMemoryCmds(&debugcore.NoOpsLogger{})
+	pCtl = &productServiceCtl.ProductsCtl{
+		ServiceCommands: pCmd,
+		Logger:          &debugcore.NoOpsLogger{},
+	}
+
+	// Create one product and one option for testing
+	uuid, _ = pCmd.AddNewProduct(context.Background(), models.Product{
+		Name:          "Name P1",
+		Description:   "Description P1",
+		Price:         models.Money{Amount: 1050},
+		DeliveryPrice: models.Money{Amount: 150},
+	})
+	po_uuid, _ = pCmd.AddNewProductOption(context.Background(), uuid, models.ProductOption{
+		Name:        "color",
+		Description: "Black",
+	})
+
+	c := m.Run()
+	os.Exit(c)
+}
+
+// Test the in memory commands
+
+func TestMemoryCommands(t *testing.T) {
+
+	ctx := context.Background()
+
+	id, err := pCmd.AddNewProduct(ctx, models.Product{Name: "memory", Description: "memory", Price: models.Money{Amount: 200}, DeliveryPrice: models.Money{Amount: 100}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Lookups by id are case insensitive
+	prod, err := pCmd.FetchAllProducts(ctx, "", strings.ToUpper(id))
+	if err != nil || len(prod) != 1 {
+		t.Errorf("Wrong number of records %d", len(prod))
+	}
+
+	updateCount, err := pCmd.UpdateProduct(ctx, models.Product{Name: "memory updated", Description: "memory", Price: models.Money{Amount: 300}}, id, nil)
+	if err != nil || updateCount != 1 {
+		t.Errorf("Not Updated")
+	}
+
+	prod, _ = pCmd.FetchAllProducts(ctx, "UPDATED", "")
+	if len(prod) != 1 {
+		t.Errorf("Wrong number of records %d", len(prod))
+	}
+
+	optionID, err := pCmd.AddNewProductOption(ctx, id, models.ProductOption{Name: "size", Description: "Large"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if count, _ := pCmd.UpdateProductOption(ctx, uuid, optionID, models.ProductOption{Name: "size"}, nil); count != 0 {
+		t.Errorf("Updated the option of another product")
+	}
+
+	// Deleting the product deletes its options
+	if count, _ := pCmd.DeleteProduct(ctx, id, nil); count != 1 {
+		t.Errorf("Not deleted the product")
+	}
+	if options, _ := pCmd.FetchAllProductOptions(ctx, id, ""); len(options) != 0 {
+		t.Errorf("Wrong number of options %d", len(options))
+	}
+
+}
+
+func TestMemoryAddNewProductWithOptions(t *testing.T) {
+
+	ctx := context.Background()
+
+	id, optionIDs, err := pCmd.AddNewProductWithOptions(ctx, models.Product{
+		Name:    "memory options",
+		Price:   models.Money{Amount: 100},
+		Options: []models.ProductOption{{Name: "color", Description: "Black"}, {Name: "size", Description: "Large"}},
+	})
+	if err != nil || len(optionIDs) != 2 {
+		t.Errorf("Wrong number of option ids %d: %v", len(optionIDs), err)
+		return
+	}
+	defer pCmd.DeleteProduct(ctx, id, nil)
+
+	if options, _ := pCmd.FetchAllProductOptions(ctx, id, ""); len(options) != 2 || options[1].DBID.String != optionIDs[1] {
+		t.Errorf("Wrong options %v", options)
+	}
+
+}
+
+func TestMemoryProductPrices(t *testing.T) {
+
+	ctx := context.Background()
+
+	id, _ := pCmd.AddNewProduct(ctx, models.Product{Name: "memory prices", Price: models.Money{Amount: 100}})
+	pCmd.SetProductPrice(ctx, id, models.ProductPrice{Currency: "USD", Price: models.Money{Amount: 60}})
+	pCmd.SetProductPrice(ctx, id, models.ProductPrice{Currency: "AUD", Price: models.Money{Amount: 90}})
+	pCmd.SetProductPrice(ctx, id, models.ProductPrice{Currency: "AUD", Price: models.Money{Amount: 95}})
+
+	if prices, _ := pCmd.FetchProductPrices(ctx, id, ""); len(prices) != 2 || prices[0].DBCurrency.String != "AUD" || prices[0].DBPrice.Int64 != 95 {
+		t.Errorf("Wrong prices %v", prices)
+	}
+	if prices, _ := pCmd.FetchPricesInCurrency(ctx, "USD", []string{strings.ToUpper(id), uuid}); len(prices) != 1 {
+		t.Errorf("Wrong prices in USD %v", prices)
+	}
+
+	// The prices are deleted when the product is purged from the trash
+	pCmd.DeleteProduct(ctx, id, nil)
+	pCmd.PurgeDeleted(ctx, time.Now())
+	if prices, _ := pCmd.FetchProductPrices(ctx, id, ""); len(prices) != 0 {
+		t.Errorf("Expected no prices, got %v", prices)
+	}
+
+}
+
+func TestMemoryStock(t *testing.T) {
+
+	ctx := context.Background()
+
+	id, optionIDs, _ := pCmd.AddNewProductWithOptions(ctx, models.Product{
+		Name:    "memory stock",
+		Price:   models.Money{Amount: 100},
+		Options: []models.ProductOption{{Name: "color", Description: "Black"}},
+	})
+	defer pCmd.DeleteProduct(ctx, id, nil)
+
+	if stock, err := pCmd.FetchStock(ctx, id, optionIDs[0]); err != nil || stock.DBOnHand.Int64 != 0 {
+		t.Errorf("Expected no stock, got %v: %v", stock, err)
+	}
+	if _, err := pCmd.SetStock(ctx, id, optionIDs[0], 5, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	reservations := make(chan string, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r, err := pCmd.ReserveStock(ctx, id, optionIDs[0], 1); err == nil {
+				reservations <- r.DBID.String
+			}
+		}()
+	}
+	wg.Wait()
+	close(reservations)
+	if len(reservations) != 5 {
+		t.Errorf("Expected 5 reservations, got %d", len(reservations))
+	}
+
+	reservationID := <-reservations
+	pCmd.CommitStockReservation(ctx, id, optionIDs[0], reservationID)
+	if _, err := pCmd.CommitStockReservation(ctx, id, optionIDs[0], reservationID); err != productServiceCmds.ErrReservationClosed {
+		t.Errorf("Expected the reservation closed, got %v", err)
+	}
+	if stock, _ := pCmd.FetchStock(ctx, id, optionIDs[0]); stock.DBOnHand.Int64 != 4 || stock.DBReserved.Int64 != 4 || stock.DBVersion.Int64 != 7 {
+		t.Errorf("Wrong stock %v", stock)
+	}
+
+	// The stock is looked up under the product of the option
+	if _, err := pCmd.FetchStock(ctx, uuid, optionIDs[0]); err != productServiceCmds.ErrUnknownProductOption {
+		t.Errorf("Expected the unknown option, got %v", err)
+	}
+
+}
+
+func TestMemoryCategories(t *testing.T) {
+
+	ctx := context.Background()
+
+	root, _ := pCmd.AddNewCategory(ctx, models.Category{Name: "Root", Slug: "root"})
+	child, _ := pCmd.AddNewCategory(ctx, models.Category{Name: "Child", Slug: "child", ParentID: root})
+	leaf, _ := pCmd.AddNewCategory(ctx, models.Category{Name: "Leaf", Slug: "leaf", ParentID: child})
+	pCmd.AddProductCategory(ctx, uuid, leaf)
+
+	all, _ := pCmd.FetchCategories(ctx)
+	filter := models.ProductFilter{CategoryIDs: models.CategoryDescendants(all, root)}
+	if products, total, _ := pCmd.FetchProductsPage(ctx, filter, models.PageRequest{Limit: 10}); total != 1 || products[0].DBID.String != uuid {
+		t.Errorf("Expected the product of the leaf, got %v", products)
+	}
+
+	if _, err := pCmd.DeleteCategory(ctx, root, models.OrphansRefuse); err != productServiceCmds.ErrCategoryHasChildren {
+		t.Errorf("Expected the children refused, got %v", err)
+	}
+	if deleted, _ := pCmd.DeleteCategory(ctx, child, models.OrphansReparent); deleted != 1 {
+		t.Errorf("Expected one category deleted, got %d", deleted)
+	}
+	if leafs, _ := pCmd.FetchCategory(ctx, "", "leaf"); len(leafs) != 1 || leafs[0].DBParentID.String != root {
+		t.Errorf("Expected the leaf moved under the root, got %v", leafs)
+	}
+	if deleted, _ := pCmd.DeleteCategory(ctx, root, models.OrphansCascade); deleted != 2 {
+		t.Errorf("Expected the subtree deleted, got %d", deleted)
+	}
+	if categories, _ := pCmd.FetchProductCategories(ctx, uuid); len(categories) != 0 {
+		t.Errorf("Expected the links deleted, got %v", categories)
+	}
+
+}
+
+func TestMemoryTrash(t *testing.T) {
+
+	ctx := context.Background()
+
+	id, optionIDs, _ := pCmd.AddNewProductWithOptions(ctx, models.Product{
+		Name:    "memory trash",
+		Price:   models.Money{Amount: 100},
+		Options: []models.ProductOption{{Name: "kept"}, {Name: "earlier"}},
+	})
+	pCmd.DeleteProductOption(ctx, id, optionIDs[1], nil)
+	time.Sleep(2 * time.Millisecond)
+	pCmd.DeleteProduct(ctx, id, nil)
+
+	if products, _ := pCmd.FetchAllProducts(ctx, "", id); len(products) != 0 {
+		t.Errorf("Expected the product hidden, got %v", products)
+	}
+	if deleted, _, _ := pCmd.FetchDeletedProducts(ctx, 10, 0); len(deleted) == 0 || deleted[0].DBID.String != id {
+		t.Errorf("Wrong trash %v", deleted)
+	}
+	if affected, _ := pCmd.RestoreProduct(ctx, id); affected != 1 {
+		t.Fatalf("Expected the product restored")
+	}
+	if options, _ := pCmd.FetchAllProductOptions(ctx, id, ""); len(options) != 1 || options[0].DBID.String != optionIDs[0] {
+		t.Errorf("Expected only the option deleted with the product restored, got %v", options)
+	}
+
+	// The purge only takes the rows deleted for longer than the retention
+	config := viper.New()
+	config.Set("app.trash.retention_days", 1)
+	ps := productService.NewProductServiceWithRepository(config, pCmd, nil, &debugcore.NoOpsLogger{})
+	if products, options, _ := ps.PurgeTrash(ctx); products != 0 || options != 0 {
+		t.Errorf("Expected nothing purged, got %d %d", products, options)
+	}
+	if _, options, _ := pCmd.PurgeDeleted(ctx, time.Now()); options != 1 {
+		t.Errorf("Expected the option purged, got %d", options)
+	}
+	if options, _ := pCmd.FetchDeletedProductOptions(ctx, id); len(options) != 0 {
+		t.Errorf("Expected the trash empty, got %v", options)
+	}
+	pCmd.DeleteProduct(ctx, id, nil)
+	pCmd.PurgeDeleted(ctx, time.Now())
+
+}
+
+func TestMemoryProductHistory(t *testing.T) {
+
+	ctx := xeroHelper.WithActor(context.Background(), xeroHelper.Actor{Name: "auditor", RequestID: "request-1"})
+
+	id, optionIDs, _ := pCmd.AddNewProductWithOptions(ctx, models.Product{
+		Name:    "memory history",
+		Price:   models.Money{Amount: 100},
+		Options: []models.ProductOption{{Name: "red"}},
+	})
+	time.Sleep(2 * time.Millisecond)
+	pCmd.UpdateProduct(ctx, models.Product{Name: "memory history", Price: models.Money{Amount: 250}}, id, nil)
+	time.Sleep(2 * time.Millisecond)
+	pCmd.SetStock(ctx, id, optionIDs[0], 5, nil)
+
+	history, total, err := pCmd.FetchProductHistory(ctx, strings.ToUpper(id), 2, 0)
+	if err != nil || total != 4 || len(history) != 2 {
+		t.Fatalf("Wrong history %v %d: %v", history, total, err)
+	}
+	if history[0].DBEntity.String != models.AuditStock || history[0].DBEntityID.String != optionIDs[0] || history[0].DBAction.String != models.AuditUpdate {
+		t.Errorf("Wrong stock entry %v", history[0])
+	}
+	update := models.NewAuditEntry(history[1])
+	if update.Action != models.AuditUpdate || update.Actor != "auditor" || update.RequestID != "request-1" || !strings.Contains(string(update.Before), `"Price":1.00`) {
+		t.Errorf("Wrong update entry %+v", update)
+	}
+
+	// Same as the database, the history outlives the product
+	pCmd.DeleteProduct(ctx, id, nil)
+	pCmd.PurgeDeleted(context.Background(), time.Now())
+	if _, total, _ := pCmd.FetchProductHistory(ctx, id, 10, 0); total != 8 {
+		t.Errorf("Expected the delete and the purge recorded, got %d entries", total)
+	}
+
+}
+
+func TestMemoryProductVersion(t *testing.T) {
+
+	ctx := context.Background()
+	id, _ := pCmd.AddNewProduct(ctx, models.Product{Name: "memory versioned", Price: models.Money{Amount: 100}})
+	defer pCmd.PurgeDeleted(ctx, time.Now())
+
+	// Same as the database, every change of the product and of its prices increments its version
+	current := int64(1)
+	if affected, err := pCmd.UpdateProduct(ctx, models.Product{Name: "memory versioned"}, id, &current); err != nil || affected != 1 {
+		t.Fatalf("Expected the update of the current version, got %d: %v", affected, err)
+	}
+	if _, err := pCmd.UpdateProduct(ctx, models.Product{Name: "outdated"}, id, &current); err != productServiceCmds.ErrVersionMismatch {
+		t.Errorf("Expected the update of the outdated version refused, got %v", err)
+	}
+	pCmd.SetProductPrice(ctx, id, models.ProductPrice{Currency: "USD", Price: models.Money{Amount: 90}})
+	if result, _ := pCmd.FetchAllProducts(ctx, "", id); len(result) != 1 || result[0].DBVersion.Int64 != 3 {
+		t.Errorf("Expected the version changed by the price, got %v", result)
+	}
+
+	optionID, _ := pCmd.AddNewProductOption(ctx, id, models.ProductOption{Name: "red"})
+	if _, err := pCmd.UpdateProductOption(ctx, id, optionID, models.ProductOption{Name: "blue"}, &current); err != nil {
+		t.Fatalf("Expected the update of the current option, got %v", err)
+	}
+	if _, err := pCmd.DeleteProductOption(ctx, id, optionID, &current); err != productServiceCmds.ErrVersionMismatch {
+		t.Errorf("Expected the delete of the outdated option refused, got %v", err)
+	}
+	if options, _ := pCmd.FetchAllProductOptions(ctx, id, optionID); len(options) != 1 || options[0].DBVersion.Int64 != 2 {
+		t.Errorf("Wrong version of the option %v", options)
+	}
+
+	if _, err := pCmd.DeleteProduct(ctx, id, &current); err != productServiceCmds.ErrVersionMismatch {
+		t.Errorf("Expected the delete of the outdated product refused, got %v", err)
+	}
+	if affected, err := pCmd.DeleteProduct(ctx, id, nil); err != nil || affected != 1 {
+		t.Errorf("Expected the delete of the product, got %d: %v", affected, err)
+	}
+
+}
+
+func TestMemoryPatchProduct(t *testing.T) {
+
+	ctx := context.Background()
+	id, optionIDs, _ := pCmd.AddNewProductWithOptions(ctx, models.Product{
+		Name:    "memory patched",
+		Price:   models.Money{Amount: 100},
+		Options: []models.ProductOption{{Name: "red"}},
+	})
+	defer pCmd.PurgeDeleted(ctx, time.Now())
+
+	// Same as the database, only the fields patched change
+	if affected, err := pCmd.PatchProduct(ctx, id, models.Product{Price: models.Money{Amount: 300}}, []string{"Price"}, nil); err != nil || affected != 1 {
+		t.Fatalf("Expected the product patched, got %d: %v", affected, err)
+	}
+	if result, _ := pCmd.FetchAllProducts(ctx, "", id); len(result) != 1 || result[0].DBName.String != "memory patched" || result[0].DBPrice.Int64 != 300 || result[0].DBVersion.Int64 != 2 {
+		t.Errorf("Wrong patched product %v", result)
+	}
+	stale := int64(1)
+	if _, err := pCmd.PatchProductOption(ctx, id, optionIDs[0], models.ProductOption{Name: "blue"}, []string{"Name"}, &stale); err != nil {
+		t.Errorf("Expected the option patched, got %v", err)
+	}
+	if _, err := pCmd.PatchProductOption(ctx, id, optionIDs[0], models.ProductOption{Name: "green"}, []string{"Name"}, &stale); err != productServiceCmds.ErrVersionMismatch {
+		t.Errorf("Expected the patch of the outdated option refused, got %v", err)
+	}
+	if _, err := pCmd.PatchProductOption(ctx, id, optionIDs[0], models.ProductOption{}, []string{"Stock"}, nil); err == nil {
+		t.Errorf("Expected the unknown field refused")
+	}
+
+	pCmd.DeleteProduct(ctx, id, nil)
+
+}
+
+func TestMemoryBatchProducts(t *testing.T) {
+
+	catalogue := productServiceCmds.NewMemoryCmds(&debugcore.NoOpsLogger{})
+	restAPI := apiServer.NewRestAPI("test", viper.New(), &debugcore.NoOpsLogger{})
+	productService.NewProductServiceWithRepository(viper.New(), catalogue, restAPI, &debugcore.NoOpsLogger{}).SetupService()
+	existing, _ := catalogue.AddNewProduct(context.Background(), models.Product{Name: "memory batch", Price: models.Money{Amount: 100}})
+
+	post := func(path string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		responseRecorder := httptest.NewRecorder()
+		restAPI.EchoFramework.ServeHTTP(responseRecorder, request)
+		return responseRecorder
+	}
+
+	// Same as the database, the refused operation rolls back the atomic batch, the batch is served on the :batch action
+	rec := post("/api/products:batch", `{"Operations": [
+		{"Op": "create", "Product": {"Name": "rolled back", "Description": "batch", "Price": 2}},
+		{"Op": "delete", "Id": "0a1b2c3d-0000-4000-8000-00000000ffff"}]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "/problems/not_applied") || !strings.Contains(rec.Body.String(), "/problems/unknown_product_id") {
+		t.Errorf("Expected the atomic batch rolled back, got %d %v", rec.Code, rec.Body.String())
+	}
+	if result, _ := catalogue.FetchAllProducts(context.Background(), "rolled back", ""); len(result) != 0 {
+		t.Errorf("Expected the created product undone, got %v", result)
+	}
+
+	rec = post("/api/products/batch", `{"Mode": "best_effort", "Operations": [
+		{"Op": "upsert", "Id": "`+existing+`", "Product": {"Name": "memory upsert", "Description": "batch", "Price": 3}},
+		{"Op": "create", "ProductId": "`+existing+`", "Option": {"Name": "red", "Description": "colour"}},
+		{"Op": "delete", "Id": "0a1b2c3d-0000-4000-8000-00000000ffff"}]}`)
+	results := models.BatchResults{}
+	json.Unmarshal(rec.Body.Bytes(), &results)
+	if rec.Code != http.StatusOK || results.Succeeded != 2 || results.Failed != 1 || results.Results[1].Status != http.StatusCreated {
+		t.Errorf("Expected the best effort batch applied, got %d %v", rec.Code, rec.Body.String())
+	}
+	if result, _ := catalogue.FetchAllProducts(context.Background(), "", existing); len(result) != 1 || result[0].DBName.String != "memory upsert" {
+		t.Errorf("Expected the product upserted, got %v", result)
+	}
+
+	// Only the batch action is served, no other path after /api/products
+	if rec := post("/api/products:import", `{}`); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the unknown action not found, got %d", rec.Code)
+	}
+	if rec := post("/api/products:batch/x", `{}`); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the path after the batch action not found, got %d", rec.Code)
+	}
+
+	// Same as the database, the upserts only apply to the version they were checked against
+	checked := int64(2)
+	outcomes, _ := catalogue.ApplyBatch(context.Background(), []models.BatchOperation{
+		{Op: models.BatchUpsert, ID: existing, Product: &models.Product{Name: "checked", Price: models.Money{Amount: 3}}, Version: &checked},
+		{Op: models.BatchUpsert, ID: existing, Product: &models.Product{Name: "stale", Price: models.Money{Amount: 3}}, Version: &checked},
+		{Op: models.BatchUpsert, ID: "0a1b2c3d-0000-4000-8000-00000000ffff", Product: &models.Product{Name: "gone", Price: models.Money{Amount: 3}}, Version: &checked},
+	}, false, 0)
+	if outcomes[0].Err != nil || outcomes[1].Err != productServiceCmds.ErrVersionMismatch || outcomes[2].Err != productServiceCmds.ErrVersionMismatch {
+		t.Errorf("Wrong outcomes of the checked upserts %v", outcomes)
+	}
+
+}
+
+func TestMemoryCatalogueImportExport(t *testing.T) {
+
+	catalogue := productServiceCmds.NewMemoryCmds(&debugcore.NoOpsLogger{})
+	restAPI := apiServer.NewRestAPI("test", viper.New(), &debugcore.NoOpsLogger{})
+	productService.NewProductServiceWithRepository(viper.New(), catalogue, restAPI, &debugcore.NoOpsLogger{}).SetupService()
+	id, _, _ := catalogue.AddNewProductWithOptions(context.Background(), models.Product{Name: "memory export", Description: "catalogue", Price: models.Money{Amount: 100},
+		Options: []models.ProductOption{{Name: "red", Description: "colour"}}})
+
+	serve := func(method string, path string, contentType string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, contentType)
+		responseRecorder := httptest.NewRecorder()
+		restAPI.EchoFramework.ServeHTTP(responseRecorder, request)
+		return responseRecorder
+	}
+
+	// The export imports back as is, the products and the options keep their ids
+	rec := serve(http.MethodGet, "/api/products/export?format=csv&options=nested", "", "")
+	if rec.Code != http.StatusOK || strings.Count(rec.Body.String(), id) != 1 {
+		t.Fatalf("Expected the nested CSV export, got %d %v", rec.Code, rec.Body.String())
+	}
+	exported := strings.Replace(rec.Body.String(), "memory export", "memory import", 1)
+	rec = serve(http.MethodPost, "/api/products/import", "text/csv", exported)
+	results := models.ImportResults{}
+	json.Unmarshal(rec.Body.Bytes(), &results)
+	if rec.Code != http.StatusOK || results.Lines != 1 || results.Updated != 2 || results.Created != 0 {
+		t.Errorf("Expected the export upserted, got %d %v", rec.Code, rec.Body.String())
+	}
+	if result, _ := catalogue.FetchAllProducts(context.Background(), "", ""); len(result) != 1 || result[0].DBName.String != "memory import" {
+		t.Errorf("Expected the product upserted, got %v", result)
+	}
+	if result, _ := catalogue.FetchAllProductOptions(context.Background(), id, ""); len(result) != 1 {
+		t.Errorf("Expected the option upserted, got %v", result)
+	}
+
+	// The export reads the catalogue by pages, every product is exported once
+	for i := 0; i < 600; i++ {
+		catalogue.AddNewProduct(context.Background(), models.Product{Name: "paged", Price: models.Money{Amount: 100}})
+	}
+	rec = serve(http.MethodGet, "/api/products/export?format=ndjson", "", "")
+	if lines := strings.Count(rec.Body.String(), "\n"); rec.Code != http.StatusOK || lines != 601 || strings.Count(rec.Body.String(), id) != 1 {
+		t.Errorf("Expected the catalogue exported over the pages, got %d %d lines", rec.Code, lines)
+	}
+
+}
+
+func TestMemorySearchProducts(t *testing.T) {
+
+	ctx := context.Background()
+
+	id, _, _ := pCmd.AddNewProductWithOptions(ctx, models.Product{
+		Name:        "Apple iPhone",
+		Description: "Smart phone with a case",
+		Price:       models.Money{Amount: 100},
+		Options:     []models.ProductOption{{Name: "color", Description: "Midnight"}},
+	})
+	defer pCmd.DeleteProduct(ctx, id, nil)
+
+	search := func(q string) []models.DBProductSearch {
+		query, _ := models.ParseSearchQuery(q)
+		result, _, _ := pCmd.SearchProducts(ctx, query, 10, 0)
+		return result
+	}
+
+	if result := search("iph*"); len(result) != 1 || result[0].DBSnippet.String != "Apple <mark>iPhone</mark>" {
+		t.Errorf("Wrong prefix search %v", result)
+	}
+	if result := search(`"phone with" midnight`); len(result) != 1 || result[0].DBSnippet.String != "Smart <mark>phone</mark> <mark>with</mark> a case" {
+		t.Errorf("Wrong phrase search %v", result)
+	}
+	if result := search(`"with phone"`); len(result) != 0 {
+		t.Errorf("Expected the words of the phrase in order, got %v", result)
+	}
+
+}
+
+func TestMemoryProductsPage(t *testing.T) {
+
+	ctx := context.Background()
+
+	prices := map[string]int64{"page a": 3000, "page b": 1000, "page c": 2000, "page d": 2000}
+	var ids []string
+	for name, price := range prices {
+		id, _ := pCmd.AddNewProduct(ctx, models.Product{Name: name, Description: "paging", Price: models.Money{Amount: price}})
+		ids = append(ids, id)
+	}
+	defer func() {
+		for _, id := range ids {
+			pCmd.DeleteProduct(ctx, id, nil)
+		}
+	}()
+
+	sort, _ := models.ParseProductSort("-price,name")
+	filter := models.ProductFilter{Name: "page", Sort: sort}
+
+	page1, total, err := pCmd.FetchProductsPage(ctx, filter, models.PageRequest{Limit: 2})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if total != 4 || len(page1) != 3 || page1[0].DBName.String != "page a" || page1[1].DBName.String != "page c" {
+		t.Errorf("Wrong first page %d", total)
+		return
+	}
+
+	cursor := &models.Cursor{Sort: models.SortKey(sort), Values: page1[1].SortValues(sort), ID: page1[1].DBID.String}
+	page2, _, _ := pCmd.FetchProductsPage(ctx, filter, models.PageRequest{Limit: 2, Cursor: cursor})
+	if len(page2) != 2 || page2[0].DBName.String != "page d" || page2[1].DBName.String != "page b" {
+		t.Errorf("Wrong second page %d", len(page2))
+	}
+
+	page3, _, _ := pCmd.FetchProductsPage(ctx, filter, models.PageRequest{Limit: 2, Offset: 3})
+	if len(page3) != 1 || page3[0].DBName.String != "page b" {
+		t.Errorf("Wrong last page %d", len(page3))
+	}
+
+}
+
+// Test the controllers on top of the in memory catalogue
+
+func TestMemoryShowAllProducts(t *testing.T) {
+
+	e := echo.New()
+	request := httptest.NewRequest(http.MethodGet, "/api/products?name=p1", strings.NewReader(""))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	responseRecorder := httptest.NewRecorder()
+	c := e.NewContext(request, responseRecorder)
+	if err := pCtl.ShowProducts(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
+	if responseRecorder.Code != http.StatusOK {
+		t.Logf("Expected : %d\n got:%d\n", http.StatusOK, responseRecorder.Code)
+		t.Fail()
+	}
+
+	products := models.Products{}
+	json.Unmarshal(responseRecorder.Body.Bytes(), &products)
+	if products.Total != 1 || len(*products.Items) != 1 || (*products.Items)[0].ID != uuid {
+		t.Logf("Expected the test product, got %v", responseRecorder.Body.String())
+		t.Fail()
+	}
+
+}
+
+func TestMemoryAddNewProduct(t *testing.T) {
+
+	productJson :=
+		`
+		{
+		  "Name": "iPhone SE",
+		  "Description": "Updated Second Gen Version.",
+		  "Price": 1229.99,
+		  "DeliveryPrice": 1.99
+		}
+		`
+
+	e := echo.New()
+	request := httptest.NewRequest(http.MethodPost, "/api/products", strings.NewReader(productJson))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	responseRecorder := httptest.NewRecorder()
+	c := e.NewContext(request, responseRecorder)
+	if err := pCtl.AddNewProduct(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
+	if responseRecorder.Code != http.StatusCreated {
+		t.Logf("Expected : %d\n got:%d\n", http.StatusCreated, responseRecorder.Code)
+		t.Fail()
+	}
+	body := responseRecorder.Body.String()
+	t.Logf("Output: %v", body)
+
+}
+
+func TestMemoryShowProductOption(t *testing.T) {
+
+	e := echo.New()
+	request := httptest.NewRequest(http.MethodGet, "/api/products/:id/options/:optionId", strings.NewReader(""))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	responseRecorder := httptest.NewRecorder()
+	c := e.NewContext(request, responseRecorder)
+	c.SetParamNames("id", "optionId")
+	c.SetParamValues(uuid, po_uuid)
+	if err := pCtl.ShowProductOption(c); err != nil {
+		apiServer.HTTPErrorHandler(err, c)
+	}
+
+	if responseRecorder.Code != http.StatusOK {
+		t.Logf("Expected : %d\n got:%d\n", http.StatusOK, responseRecorder.Code)
+		t.Fail()
+	}
+	body := responseRecorder.Body.String()
+	t.Logf("Output: %v", body)
+
+}
+
+func TestMemoryCatalogueMetrics(t *testing.T) {
+
+	catalogue := productServiceCmds.NewMemoryCmds(&debugcore.NoOpsLogger{})
+	catalogue.AddNewProductWithOptions(context.Background(), models.Product{
+		Name:    "metrics",
+		Options: []models.ProductOption{{Name: "color"}, {Name: "size"}},
+	})
+
+	restAPI := apiServer.NewRestAPI("test", viper.New(), &debugcore.NoOpsLogger{})
+	ps := productService.NewProductServiceWithRepository(viper.New(), catalogue, restAPI, &debugcore.NoOpsLogger{})
+	ps.SetupService()
+
+	responseRecorder := httptest.NewRecorder()
+	restAPI.EchoFramework.ServeHTTP(responseRecorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := responseRecorder.Body.String()
+	if !strings.Contains(body, "xero_catalogue_products 1") || !strings.Contains(body, "xero_catalogue_product_options 2") {
+		t.Errorf("Expected the catalogue gauges, got %v", body)
+	}
+
+}
+
+func TestMemoryTenants(t *testing.T) {
+
+	catalogue := productServiceCmds.NewMemoryCmds(&debugcore.NoOpsLogger{})
+	config := viper.New()
+	config.Set("app.auth.enabled", true)
+	config.Set("app.auth.api_keys", []map[string]interface{}{
+		{"name": "platform", "key": "platform-key", "scopes": []string{"products:read", "products:write", "tenants:admin"}},
+	})
+	config.Set("app.tenancy.enabled", true)
+	config.Set("app.tenancy.domain", "shop.example")
+	restAPI := apiServer.NewRestAPI("test", config, &debugcore.NoOpsLogger{})
+	if err := restAPI.LoadAuth(); err != nil {
+		t.Fatal(err)
+	}
+	if err := restAPI.LoadTenancy(); err != nil {
+		t.Fatal(err)
+	}
+	productService.NewProductServiceWithRepository(viper.New(), catalogue, restAPI, &debugcore.NoOpsLogger{}).SetupService()
+	catalogue.AddNewProduct(context.Background(), models.Product{Name: "default", Price: models.Money{Amount: 100}})
+
+	call := func(method string, path string, tenant string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set(apiServer.HeaderAPIKey, "platform-key")
+		if tenant != "" {
+			request.Header.Set(apiServer.HeaderTenant, tenant)
+		}
+		responseRecorder := httptest.NewRecorder()
+		restAPI.EchoFramework.ServeHTTP(responseRecorder, request)
+		return responseRecorder
+	}
+
+	// Onboarding
+	if rec := call(http.MethodPost, "/api/tenants", "", `{"Id": "acme", "Name": "Acme", "MaxProducts": 1}`); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected the tenant created, got %d %v", rec.Code, rec.Body.String())
+	}
+	if rec := call(http.MethodPost, "/api/tenants", "", `{"Id": "acme", "Name": "Acme"}`); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "/problems/tenant_exists") {
+		t.Errorf("Expected the tenant to exist, got %d %v", rec.Code, rec.Body.String())
+	}
+	if rec := call(http.MethodPost, "/api/tenants", "", `{"Id": "Not a subdomain", "Name": "Acme"}`); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "/Id") {
+		t.Errorf("Expected the invalid id refused, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	// The quota of the tenant
+	rec := call(http.MethodPost, "/api/products", "acme", `{"Name": "acme", "Description": "tenant", "Price": 1}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected the product created, got %d %v", rec.Code, rec.Body.String())
+	}
+	var id string
+	json.Unmarshal(rec.Body.Bytes(), &id)
+	if rec := call(http.MethodPost, "/api/products", "acme", `{"Name": "over", "Description": "tenant", "Price": 1}`); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "/problems/quota_exceeded") {
+		t.Errorf("Expected the quota exceeded, got %d %v", rec.Code, rec.Body.String())
+	}
+
+	// The tenant is the header or the subdomain, the product is unknown to the other tenants
+	if rec := call(http.MethodGet, "/api/products/"+id, "default", ""); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "/problems/unknown_product_id") {
+		t.Errorf("Expected the product of another tenant unknown, got %d %v", rec.Code, rec.Body.String())
+	}
+	if rec := call(http.MethodPost, "/api/products/batch", "default", `{"Operations": [{"Op": "upsert", "Id": "`+id+`", "Product": {"Name": "stolen", "Description": "tenant", "Price": 1}}]}`); rec.Code != http.StatusConflict ||
+		!strings.Contains(rec.Body.String(), "/problems/id_taken") || strings.Contains(rec.Body.String(), "tenant") {
+		t.Errorf("Expected the upsert with the id of another tenant taken, got %d %v", rec.Code, rec.Body.String())
+	}
+	request := httptest.NewRequest(http.MethodGet, "/api/products/"+id, nil)
+	request.Header.Set(apiServer.HeaderAPIKey, "platform-key")
+	request.Host = "acme.shop.example:8080"
+	responseRecorder := httptest.NewRecorder()
+	restAPI.EchoFramework.ServeHTTP(responseRecorder, request)
+	if responseRecorder.Code != http.StatusOK {
+		t.Errorf("Expected the product of the subdomain, got %d %v", responseRecorder.Code, responseRecorder.Body.String())
+	}
+	for tenant, problem := range map[string]string{"": "missing_tenant", "other": "unknown_tenant_id", "Not_valid!": "invalid_tenant_id"} {
+		if rec := call(http.MethodGet, "/api/products", tenant, ""); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "/problems/"+problem) {
+			t.Errorf("Expected %s for the tenant %q, got %d %v", problem, tenant, rec.Code, rec.Body.String())
+		}
+	}
+
+	// The usage of the tenant and the gauges of every tenant
+	tenant := models.Tenant{}
+	json.Unmarshal(call(http.MethodGet, "/api/tenants/acme", "", "").Body.Bytes(), &tenant)
+	if tenant.Usage == nil || tenant.Usage.Products != 1 || tenant.MaxProducts != 1 {
+		t.Errorf("Expected the usage of the tenant, got %v", tenant)
+	}
+	if body := call(http.MethodGet, "/metrics", "", "").Body.String(); !strings.Contains(body, "xero_catalogue_products 2") {
+		t.Errorf("Expected the gauges of every tenant, got %v", body)
+	}
+
+	// Removal
+	if rec := call(http.MethodDelete, "/api/tenants/default", "", ""); rec.Code != http.StatusConflict {
+		t.Errorf("Expected the default tenant kept, got %d %v", rec.Code, rec.Body.String())
+	}
+	if rec := call(http.MethodDelete, "/api/tenants/acme", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected the tenant removed, got %d %v", rec.Code, rec.Body.String())
+	}
+	if rec := call(http.MethodGet, "/api/products/"+id, "acme", ""); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "/problems/unknown_tenant_id") {
+		t.Errorf("Expected the removed tenant unknown, got %d %v", rec.Code, rec.Body.String())
+	}
+
+}
@@ -142,6 +142,34 @@ func XeroNotFoundError(resourceType string) Error {
 	return New(http.StatusNotFound, resourceType+"_unavailable", Failed)
 }
 
+// XeroConflictError
+// returns 409 Conflict
+// The request cannot be applied to the current state of the resource, e.g the stock changed or ran out
+func XeroConflictError(desc string, message ...interface{}) Error {
+	return New(http.StatusConflict, desc, Failed, message...)
+}
+
+// XeroPreconditionFailedError
+// returns 412 Precondition Failed
+// The precondition of the request does not hold anymore, e.g the resource changed since the version of If-Match
+func XeroPreconditionFailedError(desc string, message ...interface{}) Error {
+	return New(http.StatusPreconditionFailed, desc, Failed, message...)
+}
+
+// XeroUnsupportedMediaTypeError
+// returns 415 Unsupported Media Type
+// The body of the request is in a format the endpoint does not accept, e.g a PATCH body that is not a patch
+func XeroUnsupportedMediaTypeError(desc string, message ...interface{}) Error {
+	return New(http.StatusUnsupportedMediaType, desc, Failed, message...)
+}
+
+// XeroNotImplementedError
+// returns 501 Not Implemented
+// The feature is not available in this deployment, e.g the database cannot support it
+func XeroNotImplementedError(desc string, message ...interface{}) Error {
+	return New(http.StatusNotImplemented, desc, Failed, message...)
+}
+
 // XeroBadRequestError returns 400 bad request error.
 // The interface contains array
 // The first argument is description
@@ -31,3 +31,47 @@ func TestNewUnexpectedGenericError(t *testing.T) {
 		})
 	}
 }
+
+func TestProblem(t *testing.T) {
+
+	e := XeroUnknownIDError("product_option")
+	e.RequestID = "req-1"
+	p := e.Problem("/api/products/1/options/2")
+
+	if p.Type != "/problems/unknown_product_option_id" || p.Title != "Bad Request" || p.Status != http.StatusBadRequest {
+		t.Errorf("Wrong problem %+v", p)
+	}
+	if p.Detail != "No product option with this id" || p.Instance != "/api/products/1/options/2" || p.RequestID != "req-1" || p.Retryable {
+		t.Errorf("Wrong problem %+v", p)
+	}
+
+	// Without a message the detail is left out, the unexpected errors can be retried
+	if p := XeroForbiddenError().Problem(""); p.Detail != "" || p.Status != http.StatusForbidden {
+		t.Errorf("Wrong problem %+v", p)
+	}
+	if p := NewUnexpectedGenericError(errors.New("database error")).Problem(""); !p.Retryable || p.Detail != "database error" {
+		t.Errorf("Wrong problem %+v", p)
+	}
+}
+
+func TestValidationProblem(t *testing.T) {
+
+	errs := NewErrorCollection()
+	errs.AddError(NewFieldError("/Name", FieldTooLong, "Name must be at most 17 characters"))
+	nested := NewErrorCollection()
+	nested.AddError(NewFieldError("/Options/0/Name", FieldRequired, "Name is required"))
+	errs.AddErrorCollection(nested)
+
+	p := XeroValidationError(errs).Problem("/api/products")
+	if p.Type != "/problems/validation_failed" || p.Status != http.StatusBadRequest || len(p.Errors) != 2 {
+		t.Fatalf("Wrong problem %+v", p)
+	}
+	if p.Errors[0].Pointer != "/Name" || p.Errors[0].Code != FieldTooLong || p.Errors[1].Pointer != "/Options/0/Name" {
+		t.Errorf("Wrong field errors %+v", p.Errors)
+	}
+
+	// The other errors have no field errors
+	if p := XeroInvalidRequestError("bad json").Problem(""); p.Errors != nil {
+		t.Errorf("Wrong problem %+v", p)
+	}
+}
@@ -0,0 +1,75 @@
+package xeroErrors
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ProblemContentType is the media type of the RFC 7807 error responses
+const ProblemContentType = "application/problem+json"
+
+// ProblemTypeBase prefixes the code of the error to build the type URI of the problem
+// The type is relative by default, deployments publishing the problem documentation can set an absolute base
+var ProblemTypeBase = "/problems/"
+
+// Problem is the RFC 7807 representation of an Error, sent as application/problem+json
+// request_id, retryable, errors and traceback are extension members
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Retryable bool         `json:"retryable"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	Traceback []string     `json:"traceback,omitempty"`
+}
+
+// Problem returns the RFC 7807 representation of the error, instance is the URI of the failed request
+func (e Error) Problem(instance string) Problem {
+
+	code := e.Code
+	if code == 0 {
+		code = http.StatusInternalServerError
+	}
+
+	p := Problem{
+		Type:      ProblemTypeBase + strings.TrimPrefix(e.Err, "errors."),
+		Title:     http.StatusText(code),
+		Status:    code,
+		Instance:  instance,
+		RequestID: e.RequestID,
+		Retryable: e.Status == Retry,
+		Errors:    FieldErrors(e),
+		Traceback: e.Traceback,
+	}
+
+	// Without a message, the error only has its code which is already the type
+	if detail := fmt.Sprintf("%v", e.Message); e.Message != nil && detail != e.Err {
+		p.Detail = detail
+	}
+
+	return p
+}
+
+// XeroInvalidRequestError
+// returns 400 when the body of the request cannot be parsed or is not valid
+func XeroInvalidRequestError(message interface{}) Error {
+	return New(http.StatusBadRequest, "invalid_request", Failed, message)
+}
+
+// XeroInvalidIDError
+// returns 400 when the id of the resource in the path is not a valid UUID
+func XeroInvalidIDError(resourceType string) Error {
+	return New(http.StatusBadRequest, "invalid_"+resourceType+"_id", Failed,
+		"A valid "+strings.Replace(resourceType, "_", " ", -1)+" id is required")
+}
+
+// XeroUnknownIDError
+// returns 400 when the id of the resource in the path is valid, but there is no such resource
+func XeroUnknownIDError(resourceType string) Error {
+	return New(http.StatusBadRequest, "unknown_"+resourceType+"_id", Failed,
+		"No "+strings.Replace(resourceType, "_", " ", -1)+" with this id")
+}
@@ -0,0 +1,67 @@
+package xeroErrors
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Codes of the field errors, they are stable and can be matched by the clients
+const (
+	FieldRequired    = "required"
+	FieldTooLong     = "too_long"
+	FieldNotPositive = "not_positive"
+	FieldNegative    = "negative"
+	FieldNotAllowed  = "not_allowed"
+	FieldInvalid     = "invalid"
+	FieldInvalidType = "invalid_type"
+)
+
+// FieldError is the validation failure of a single field of the request body
+// Pointer is the RFC 6901 JSON pointer of the field in the body, e.g /Options/0/Name
+type FieldError struct {
+	Pointer string `json:"pointer"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error makes it compatible with `error` interface.
+func (f FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", f.Pointer, f.Message)
+}
+
+// NewFieldError returns the failure of the field at pointer
+func NewFieldError(pointer string, code string, message string) FieldError {
+	return FieldError{Pointer: pointer, Code: code, Message: message}
+}
+
+// FieldErrors returns the field errors held by err, the nested collections are flattened
+func FieldErrors(err error) []FieldError {
+
+	var fields []FieldError
+
+	switch v := err.(type) {
+	case FieldError:
+		fields = append(fields, v)
+	case *ErrorCollection:
+		v.lock.RLock()
+		defer v.lock.RUnlock()
+		for _, inner := range v.Errors {
+			fields = append(fields, FieldErrors(inner)...)
+		}
+	case Error:
+		if v.Inner != nil {
+			fields = append(fields, FieldErrors(v.Inner)...)
+		}
+	}
+
+	return fields
+}
+
+// XeroValidationError
+// returns 400 when one or more fields of the request body are not valid
+// The field errors of err are sent in the errors member of the problem
+func XeroValidationError(err error) Error {
+	e := New(http.StatusBadRequest, "validation_failed", Failed, "One or more fields are not valid")
+	e.Inner = err
+	return e
+}
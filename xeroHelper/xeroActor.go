@@ -0,0 +1,33 @@
+package xeroHelper
+
+import (
+	"context"
+)
+
+const (
+	// AnonymousActor makes the requests that are not authenticated
+	AnonymousActor = "anonymous"
+	// SystemActor makes the changes that do not come from a request, like the background jobs
+	SystemActor = "system"
+)
+
+// Actor is who makes a change and the request the change comes from, both are recorded along with the change
+type Actor struct {
+	Name      string
+	RequestID string
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying the actor
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor carried by ctx, SystemActor when there is none
+func ActorFrom(ctx context.Context) Actor {
+	if actor, ok := ctx.Value(actorKey{}).(Actor); ok && actor.Name != "" {
+		return actor
+	}
+	return Actor{Name: SystemActor}
+}
@@ -29,6 +29,7 @@ func ParseFlags(configPath string) {
 		"stderrthreshold": "INFO",
 		"logtostderr":     "true",
 		"cnf":             configPath,
+		"migrate":         "",
 	}
 
 	for k, v := range flags {
@@ -0,0 +1,52 @@
+package xeroHelper
+
+import (
+	"context"
+)
+
+// Principal is the authenticated caller of a request, Subject is the name of its API key or the subject of its token
+type Principal struct {
+	Subject string
+	Scopes  []string
+	Roles   []string
+	// Tenant the principal is bound to, empty when it may act for any tenant
+	Tenant string
+	// Fields the principal may change by type of resource, "*" is any field, nil when no policy restricts the fields
+	Fields map[string][]string
+}
+
+type principalKey struct{}
+
+// HasScope tells if the principal was granted the scope
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// MayChange tells if the principal may change the field of the type of resource
+func (p Principal) MayChange(resourceType string, field string) bool {
+	if p.Fields == nil {
+		return true
+	}
+	for _, f := range p.Fields[resourceType] {
+		if f == "*" || f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal carried by ctx, false when the request is not authenticated
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
@@ -0,0 +1,32 @@
+package xeroHelper
+
+import (
+	"context"
+	"regexp"
+)
+
+// DefaultTenant owns the catalogue when the tenancy is disabled, along with the rows created before the tenancy
+const DefaultTenant = "default"
+
+// Lower case letters, digits and dashes, so the id of a tenant can be the label of its subdomain
+var tenantPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+type tenantKey struct{}
+
+// ValidateTenantID tells if the id can name a tenant
+func ValidateTenantID(id string) bool {
+	return tenantPattern.MatchString(id)
+}
+
+// WithTenant returns a copy of ctx carrying the tenant, every read and change of the catalogue made with ctx is of the tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant carried by ctx, DefaultTenant when there is none
+func TenantFrom(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}